@@ -19,12 +19,19 @@ package main
 
 import (
 	"crypto/ecdsa"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/ethereumproject/go-ethereum/crypto"
+	"github.com/ethereumproject/go-ethereum/logger"
 	"github.com/ethereumproject/go-ethereum/logger/glog"
 	"github.com/ethereumproject/go-ethereum/p2p/discover"
 	"github.com/ethereumproject/go-ethereum/p2p/nat"
@@ -41,6 +48,9 @@ var (
 	nodeKeyHex  = flag.String("nodekeyhex", "", "private key as hex (for testing)")
 	natdesc     = flag.String("nat", "none", "port mapping mechanism (any|none|upnp|pmp|extip:<IP>)")
 	versionFlag = flag.Bool("version", false, "Prints the revision identifier and exit immediatily.")
+	metricsAddr = flag.String("metrics-addr", "", "HTTP listen address for discovery statistics (disabled if empty)")
+	writeAddr   = flag.String("writeaddress", "", "write the enode URL to the given file once listening (- for stdout)")
+	writeExit   = flag.Bool("writeaddress-exit", false, "exit after writing the enode URL with -writeaddress")
 )
 
 // onlyDoGenKey exits 0 if successful.
@@ -61,6 +71,46 @@ func onlyDoGenKey() {
 	os.Exit(0)
 }
 
+// writeEnode writes the enode URL of n to the named file, or to stdout if name is "-".
+func writeEnode(name string, n *discover.Node) error {
+	url := n.String() + "\n"
+	if name == "-" {
+		_, err := os.Stdout.WriteString(url)
+		return err
+	}
+	return ioutil.WriteFile(name, []byte(url), 0644)
+}
+
+// stats is the JSON document served on the -metrics-addr endpoint.
+type stats struct {
+	TableSize int     `json:"tableSize"`
+	Pings     uint64  `json:"pings"`
+	Pongs     uint64  `json:"pongs"`
+	Findnodes uint64  `json:"findnodes"`
+	Neighbors uint64  `json:"neighbors"`
+	Uptime    float64 `json:"uptime"` // seconds
+}
+
+// serveStats serves the discovery statistics of tab as JSON on addr.
+func serveStats(addr string, tab *discover.Table, start time.Time) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		s := tab.Stats()
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(stats{
+			TableSize: s.TableSize,
+			Pings:     s.Pings,
+			Pongs:     s.Pongs,
+			Findnodes: s.Findnodes,
+			Neighbors: s.Neighbors,
+			Uptime:    time.Since(start).Seconds(),
+		})
+		if err != nil {
+			glog.V(logger.Warn).Warnf("Could not write stats to %v: %v", r.RemoteAddr, err)
+		}
+	}
+	log.Fatal(http.ListenAndServe(addr, http.HandlerFunc(handler)))
+}
+
 func main() {
 	flag.Var(glog.GetVerbosity(), "verbosity", "log verbosity (0-9)")
 	flag.Var(glog.GetVModule(), "vmodule", "log verbosity pattern")
@@ -77,6 +127,10 @@ func main() {
 		onlyDoGenKey()
 	}
 
+	if *writeExit && *writeAddr == "" {
+		log.Fatal("Option -writeaddress-exit requires -writeaddress")
+	}
+
 	natm, err := nat.Parse(*natdesc)
 	if err != nil {
 		log.Fatalf("nat: %s", err)
@@ -108,8 +162,26 @@ func main() {
 		}
 	}
 
-	if _, err := discover.ListenUDP(nodeKey, *listenAddr, natm, ""); err != nil {
+	tab, err := discover.ListenUDP(nodeKey, *listenAddr, natm, "")
+	if err != nil {
 		log.Fatal(err)
 	}
-	select {}
+	if *writeAddr != "" {
+		if err := writeEnode(*writeAddr, tab.Self()); err != nil {
+			log.Fatalf("writeaddress: %v", err)
+		}
+		if *writeExit {
+			tab.Close()
+			return
+		}
+	}
+	if *metricsAddr != "" {
+		go serveStats(*metricsAddr, tab, time.Now())
+	}
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+	sig := <-sigc
+	glog.V(logger.Warn).Warnf("Got %v, shutting down...", sig)
+	tab.Close()
 }
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+
+	"github.com/ethereumproject/go-ethereum/core/vm"
+)
+
+// defaultGasTable returns the gas table used unless overridden by the gastable flag.
+func defaultGasTable() *vm.GasTable {
+	return &vm.GasTable{
+		ExtcodeSize:     big.NewInt(700),
+		ExtcodeCopy:     big.NewInt(700),
+		Balance:         big.NewInt(400),
+		SLoad:           big.NewInt(200),
+		Calls:           big.NewInt(700),
+		Suicide:         big.NewInt(5000),
+		ExpByte:         big.NewInt(10),
+		CreateBySuicide: big.NewInt(25000),
+	}
+}
+
+// parseGasTable returns the default gas table with the costs given as a JSON object
+// overridden, e.g. {"SLoad": 800, "Calls": 1000}. The value is either the JSON
+// itself or the name of a file containing it. Field names are those of vm.GasTable.
+func parseGasTable(value string) (*vm.GasTable, error) {
+	data := []byte(value)
+	if !strings.HasPrefix(strings.TrimSpace(value), "{") {
+		var err error
+		if data, err = ioutil.ReadFile(value); err != nil {
+			return nil, err
+		}
+	}
+	table := defaultGasTable()
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(table); err != nil {
+		return nil, err
+	}
+	costs := map[string]*big.Int{
+		"ExtcodeSize":     table.ExtcodeSize,
+		"ExtcodeCopy":     table.ExtcodeCopy,
+		"Balance":         table.Balance,
+		"SLoad":           table.SLoad,
+		"Calls":           table.Calls,
+		"Suicide":         table.Suicide,
+		"ExpByte":         table.ExpByte,
+		"CreateBySuicide": table.CreateBySuicide,
+	}
+	for name, cost := range costs {
+		if name == "CreateBySuicide" && cost == nil {
+			continue // nil disables the EIP150 call and suicide rules
+		}
+		if cost == nil || cost.Sign() < 0 {
+			return nil, fmt.Errorf("%s: cost must be a non-negative number", name)
+		}
+	}
+	return table, nil
+}
@@ -18,12 +18,15 @@
 package main
 
 import (
+	"encoding/hex"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"math/big"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"time"
 
 	"gopkg.in/urfave/cli.v1"
@@ -94,6 +97,22 @@ var (
 		Name:  "create",
 		Usage: "indicates the action should be create rather than call",
 	}
+	CodeFileFlag = cli.StringFlag{
+		Name:  "codefile",
+		Usage: "file containing the EVM code, either hex encoded or raw binary",
+	}
+	InputFileFlag = cli.StringFlag{
+		Name:  "inputfile",
+		Usage: "file containing the input for the EVM, either hex encoded or raw binary",
+	}
+	ProfileFlag = cli.BoolFlag{
+		Name:  "profile",
+		Usage: "display the number of executions and gas used per opcode",
+	}
+	GasTableFlag = cli.StringFlag{
+		Name:  "gastable",
+		Usage: `JSON object, or file containing it, overriding opcode costs of the gas table, e.g. '{"SLoad": 800}'`,
+	}
 )
 
 var app *cli.App
@@ -112,11 +131,15 @@ func init() {
 		DisableJitFlag,
 		SysStatFlag,
 		CodeFlag,
+		CodeFileFlag,
 		GasFlag,
 		PriceFlag,
 		ValueFlag,
 		DumpFlag,
 		InputFlag,
+		InputFileFlag,
+		ProfileFlag,
+		GasTableFlag,
 	}
 }
 
@@ -132,7 +155,20 @@ func run(ctx *cli.Context) error {
 	if valueFlag == nil {
 		log.Fatalf("malformed %s flag value %q", ValueFlag.Name, ctx.GlobalString(ValueFlag.Name))
 	}
-	vmenv := NewEnv(statedb, common.StringToAddress("evmuser"), valueFlag)
+	gasTable := defaultGasTable()
+	if value := ctx.GlobalString(GasTableFlag.Name); value != "" {
+		var err error
+		if gasTable, err = parseGasTable(value); err != nil {
+			log.Fatalf("%s: %v", GasTableFlag.Name, err)
+		}
+	}
+	vmenv := NewEnv(statedb, common.StringToAddress("evmuser"), valueFlag, gasTable)
+
+	var prof *opProfile
+	if ctx.GlobalBool(ProfileFlag.Name) {
+		prof = newOpProfile()
+		vmenv.evm.SetOpHook(prof.record)
+	}
 
 	tstart := time.Now()
 
@@ -150,15 +186,16 @@ func run(ctx *cli.Context) error {
 		log.Fatalf("malformed %s flag value %q", PriceFlag.Name, ctx.GlobalString(PriceFlag.Name))
 	}
 
+	code := flagBytes(ctx, CodeFlag, CodeFileFlag)
+	input := flagBytes(ctx, InputFlag, InputFileFlag)
+
 	if ctx.GlobalBool(CreateFlag.Name) {
-		input := append(common.Hex2Bytes(ctx.GlobalString(CodeFlag.Name)), common.Hex2Bytes(ctx.GlobalString(InputFlag.Name))...)
-		ret, _, err = vmenv.Create(sender, input, gasFlag, priceFlag, valueFlag)
+		ret, _, err = vmenv.Create(sender, append(code, input...), gasFlag, priceFlag, valueFlag)
 	} else {
 		receiver := statedb.CreateAccount(common.StringToAddress("receiver"))
 
-		code := common.Hex2Bytes(ctx.GlobalString(CodeFlag.Name))
 		receiver.SetCode(crypto.Keccak256Hash(code), code)
-		ret, err = vmenv.Call(sender, receiver.Address(), common.Hex2Bytes(ctx.GlobalString(InputFlag.Name)), gasFlag, priceFlag, valueFlag)
+		ret, err = vmenv.Call(sender, receiver.Address(), input, gasFlag, priceFlag, valueFlag)
 	}
 	vmdone := time.Since(tstart)
 
@@ -180,6 +217,10 @@ num gc:     %d
 `, mem.Alloc, mem.TotalAlloc, mem.Mallocs, mem.HeapAlloc, mem.HeapObjects, mem.NumGC)
 	}
 
+	if prof != nil {
+		prof.print(os.Stdout)
+	}
+
 	fmt.Printf("OUT: 0x%x", ret)
 	if err != nil {
 		fmt.Printf(" error: %v", err)
@@ -188,6 +229,41 @@ num gc:     %d
 	return nil
 }
 
+// flagBytes returns the bytes given either hex encoded by the value flag or
+// read from the file named by the file flag. Setting both is a fatal error.
+func flagBytes(ctx *cli.Context, valueFlag, fileFlag cli.StringFlag) []byte {
+	value, file := ctx.GlobalString(valueFlag.Name), ctx.GlobalString(fileFlag.Name)
+	if file == "" {
+		return common.Hex2Bytes(value)
+	}
+	if value != "" {
+		log.Fatalf("flags %s and %s are mutually exclusive", valueFlag.Name, fileFlag.Name)
+	}
+	data, err := readHexOrBinary(file)
+	if err != nil {
+		log.Fatalf("%s: %v", fileFlag.Name, err)
+	}
+	return data
+}
+
+// readHexOrBinary reads the named file. If its content, ignoring surrounding
+// whitespace and an optional 0x prefix, is valid hex it is decoded, otherwise
+// the raw content is returned.
+func readHexOrBinary(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	text := strings.TrimSpace(string(data))
+	if strings.HasPrefix(text, "0x") || strings.HasPrefix(text, "0X") {
+		text = text[2:]
+	}
+	if decoded, err := hex.DecodeString(text); err == nil {
+		return decoded, nil
+	}
+	return data, nil
+}
+
 func main() {
 	if err := app.Run(os.Args); err != nil {
 		fmt.Fprintln(os.Stderr, err)
@@ -207,15 +283,17 @@ type VMEnv struct {
 	Gas        *big.Int
 	time       *big.Int
 
-	evm *vm.EVM
+	evm     *vm.EVM
+	ruleSet ruleSet
 }
 
-func NewEnv(state *state.StateDB, transactor common.Address, value *big.Int) *VMEnv {
+func NewEnv(state *state.StateDB, transactor common.Address, value *big.Int, gasTable *vm.GasTable) *VMEnv {
 	env := &VMEnv{
 		state:      state,
 		transactor: &transactor,
 		value:      value,
 		time:       big.NewInt(time.Now().Unix()),
+		ruleSet:    ruleSet{gasTable: gasTable},
 	}
 
 	env.evm = vm.New(env)
@@ -223,7 +301,10 @@ func NewEnv(state *state.StateDB, transactor common.Address, value *big.Int) *VM
 }
 
 // ruleSet implements vm.RuleSet and will always default to the homestead rule set.
-type ruleSet struct{}
+// Its gas table can be overridden with the gastable flag.
+type ruleSet struct {
+	gasTable *vm.GasTable
+}
 
 func (ruleSet) IsHomestead(*big.Int) bool { return true }
 
@@ -232,20 +313,11 @@ func (ruleSet) IsAtlantis(*big.Int) bool {
 	return true
 }
 
-func (ruleSet) GasTable(*big.Int) *vm.GasTable {
-	return &vm.GasTable{
-		ExtcodeSize:     big.NewInt(700),
-		ExtcodeCopy:     big.NewInt(700),
-		Balance:         big.NewInt(400),
-		SLoad:           big.NewInt(200),
-		Calls:           big.NewInt(700),
-		Suicide:         big.NewInt(5000),
-		ExpByte:         big.NewInt(10),
-		CreateBySuicide: big.NewInt(25000),
-	}
+func (r ruleSet) GasTable(*big.Int) *vm.GasTable {
+	return r.gasTable
 }
 
-func (self *VMEnv) RuleSet() vm.RuleSet       { return ruleSet{} }
+func (self *VMEnv) RuleSet() vm.RuleSet       { return self.ruleSet }
 func (self *VMEnv) Vm() vm.Vm                 { return self.evm }
 func (self *VMEnv) Db() vm.Database           { return self.state }
 func (self *VMEnv) SnapshotDatabase() int     { return self.state.Snapshot() }
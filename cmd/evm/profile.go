@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/ethereumproject/go-ethereum/core/vm"
+)
+
+// opStat holds the execution count and total gas charged for a single opcode.
+type opStat struct {
+	op    vm.OpCode
+	count uint64
+	gas   *big.Int
+}
+
+// opProfile aggregates per-opcode statistics over an EVM run.
+// Gas charged for CALL and CREATE instructions includes the gas forwarded to
+// the callee, so nested executions are counted both on their own opcodes and
+// on the calling instruction.
+type opProfile struct {
+	stats map[vm.OpCode]*opStat
+}
+
+func newOpProfile() *opProfile {
+	return &opProfile{stats: make(map[vm.OpCode]*opStat)}
+}
+
+// record is a vm.OpHook.
+func (p *opProfile) record(op vm.OpCode, cost *big.Int) {
+	s, ok := p.stats[op]
+	if !ok {
+		s = &opStat{op: op, gas: new(big.Int)}
+		p.stats[op] = s
+	}
+	s.count++
+	s.gas.Add(s.gas, cost)
+}
+
+// sorted returns the collected statistics ordered by total gas, highest first.
+func (p *opProfile) sorted() []*opStat {
+	list := make([]*opStat, 0, len(p.stats))
+	for _, s := range p.stats {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if c := list[i].gas.Cmp(list[j].gas); c != 0 {
+			return c > 0
+		}
+		return list[i].op < list[j].op
+	})
+	return list
+}
+
+// print writes the profile as a table to w.
+func (p *opProfile) print(w io.Writer) {
+	total := new(big.Int)
+	for _, s := range p.stats {
+		total.Add(total, s.gas)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "OPCODE\tCOUNT\tGAS\t%\t")
+	for _, s := range p.sorted() {
+		pct := 0.0
+		if total.Sign() > 0 {
+			pct, _ = new(big.Rat).SetFrac(new(big.Int).Mul(s.gas, big.NewInt(100)), total).Float64()
+		}
+		fmt.Fprintf(tw, "%v\t%d\t%v\t%.2f\t\n", s.op, s.count, s.gas, pct)
+	}
+	fmt.Fprintf(tw, "TOTAL\t\t%v\t\t\n", total)
+	tw.Flush()
+}
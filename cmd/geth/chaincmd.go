@@ -28,8 +28,8 @@ import (
 
 	"github.com/ethereumproject/go-ethereum/common"
 	"github.com/ethereumproject/go-ethereum/core"
-	"github.com/ethereumproject/go-ethereum/core/state"
 	"github.com/ethereumproject/go-ethereum/core/types"
+	"github.com/ethereumproject/go-ethereum/eth"
 	"github.com/ethereumproject/go-ethereum/logger/glog"
 	"gopkg.in/urfave/cli.v1"
 )
@@ -39,6 +39,29 @@ var (
 		Action: importChain,
 		Name:   "import",
 		Usage:  `Import a blockchain file`,
+		Description: `
+	Requires a first argument of the file to import.
+
+	With --trusted, the proof-of-work of the imported blocks is not verified.
+	Headers, bodies and state transitions are still validated. Only use it
+	for files exported by a node you trust, such as your own.
+
+	Blocks are inserted in batches of --batch-size. Smaller batches use less
+	memory, larger ones import faster on machines which can afford them.
+		`,
+		Flags: []cli.Flag{
+			importCommandTrustedFlag,
+			importCommandBatchSizeFlag,
+		},
+	}
+	importCommandBatchSizeFlag = cli.IntFlag{
+		Name:  "batch-size",
+		Usage: "Number of blocks to insert at once",
+		Value: eth.DefaultImportBatchSize,
+	}
+	importCommandTrustedFlag = cli.BoolFlag{
+		Name:  "trusted",
+		Usage: "Skip proof-of-work verification of the imported blocks",
 	}
 	exportCommand = cli.Command{
 		Action: exportChain,
@@ -49,7 +72,46 @@ var (
 	Optional second and third arguments control the first and
 	last block to write. In this mode, the file will be appended
 	if already existing.
+
+	Use --format=json to write newline-delimited JSON blocks with full
+	transactions instead of RLP. JSON exports cannot be imported.
+
+	The export is gzip compressed if --gzip is set or the file name ends
+	in .gz. Import detects compressed files automatically.
 		`,
+		Flags: []cli.Flag{
+			exportCommandFormatFlag,
+			exportCommandGzipFlag,
+		},
+	}
+	exportCommandGzipFlag = cli.BoolFlag{
+		Name:  "gzip",
+		Usage: "Gzip compress the export (implied by a .gz file extension)",
+	}
+	exportCommandFormatFlag = cli.StringFlag{
+		Name:  "format",
+		Usage: "Export format, either 'rlp' or 'json'",
+		Value: "rlp",
+	}
+	exportCheckpointsCommand = cli.Command{
+		Action: exportCheckpoints,
+		Name:   "export-checkpoints",
+		Usage:  "Export trusted checkpoints of the canonical chain into a JSON file",
+		Description: `
+	Requires a first argument of the file to write to.
+
+	The number, hash and total difficulty of every --interval-th canonical
+	block are written. Nodes started with --checkpoints=<file> cross-check
+	imported headers against them.
+		`,
+		Flags: []cli.Flag{
+			exportCheckpointsCommandIntervalFlag,
+		},
+	}
+	exportCheckpointsCommandIntervalFlag = cli.IntFlag{
+		Name:  "interval",
+		Usage: "Number of blocks between checkpoints",
+		Value: 100000,
 	}
 	upgradedbCommand = cli.Command{
 		Action:  upgradeDB,
@@ -114,17 +176,109 @@ var (
 		to recover all consistent and healthy block data. It will remove invalid or
 		corrupt block data that may have been caused by hard killing, system failure,
 		space limitations, or attack.
+
+		Use --start and --increment to target recovery near a suspected corruption height
+		instead of scanning from genesis. Note that blocks below --start are not checked, so a
+		start value that is too large may skip real corruption. When an unhealthy or missing
+		block is found, recovery falls back to checking block-by-block (increment=1) from
+		the last healthy checkpoint.
+		`,
+		Flags: []cli.Flag{
+			recoverCommandStartFlag,
+			recoverCommandIncrementFlag,
+		},
+	}
+	verifyCommand = cli.Command{
+		Action: verifyChaindata,
+		Name:   "verify",
+		Usage:  "Verify the integrity of stored blockchain data",
+		Description: `
+		Verify walks the canonical chain between --from and --to and checks each block's
+		transaction and uncle roots, total difficulty continuity, and state presence.
+		It reports the first offending block and the reason it failed.
+
+		Unlike recover, verify does not modify the chain database. It can be used to
+		confirm corruption before deciding to recover or resync.
+		`,
+		Flags: []cli.Flag{
+			verifyCommandFromFlag,
+			verifyCommandToFlag,
+		},
+	}
+	fixReceiptsCommand = cli.Command{
+		Action: fixReceipts,
+		Name:   "fix-receipts",
+		Usage:  "Recompute the derived fields of stored receipts",
+		Description: `
+		Fix-receipts walks the canonical chain between --from and --to, recomputes the
+		non-consensus fields of each block's stored receipts (transaction hash, gas used,
+		contract address, and log block number, hash and index) and rewrites them.
+
+		Some older databases stored receipts without these fields, which causes logs to be
+		served with a zero block number. The command is idempotent and can be run in
+		several sessions over consecutive ranges.
+		`,
+		Flags: []cli.Flag{
+			fixReceiptsCommandFromFlag,
+			fixReceiptsCommandToFlag,
+		},
+	}
+	stateRootCommand = cli.Command{
+		Action: stateRoot,
+		Name:   "stateroot",
+		Usage:  "Print the state root of a block and check its state is loadable",
+		Description: `
+		Stateroot loads the block with the given number or hash, opens its state and
+		prints the state root. It is a quick spot-check that the state of a block is
+		present in the database, e.g. after restoring a snapshot. The time taken to open
+		the state is reported as a hint of disk health.
+
+		Use "$ geth stateroot 0" to check the genesis state.
 		`,
 	}
+	fixReceiptsCommandFromFlag = cli.IntFlag{
+		Name:  "from",
+		Usage: "Block number at which to begin rewriting receipts",
+		Value: 0,
+	}
+	fixReceiptsCommandToFlag = cli.IntFlag{
+		Name:  "to",
+		Usage: "Block number at which to end rewriting receipts (default: current head)",
+		Value: -1,
+	}
+	verifyCommandFromFlag = cli.IntFlag{
+		Name:  "from",
+		Usage: "Block number at which to begin verification",
+		Value: 0,
+	}
+	verifyCommandToFlag = cli.IntFlag{
+		Name:  "to",
+		Usage: "Block number at which to end verification (default: current head)",
+		Value: -1,
+	}
+	recoverCommandStartFlag = cli.IntFlag{
+		Name:  "start",
+		Usage: "Block number at which to begin recovery checks",
+		Value: 1,
+	}
+	recoverCommandIncrementFlag = cli.IntFlag{
+		Name:  "increment",
+		Usage: "Approximate (randomized) block interval between recovery checks",
+		Value: 2048,
+	}
 )
 
 func importChain(ctx *cli.Context) error {
 	if len(ctx.Args()) != 1 {
 		log.Fatal("This command requires an argument.")
 	}
+	batchSize := ctx.Int(importCommandBatchSizeFlag.Name)
+	if batchSize <= 0 {
+		log.Fatalf("%s: must be positive, got %d", importCommandBatchSizeFlag.Name, batchSize)
+	}
 	chain, chainDb := MakeChain(ctx)
 	start := time.Now()
-	err := ImportChain(chain, ctx.Args().First())
+	err := ImportChain(chain, ctx.Args().First(), ctx.Bool(importCommandTrustedFlag.Name), batchSize)
 	chainDb.Close()
 	if err != nil {
 		log.Fatal("Import error: ", err)
@@ -137,12 +291,21 @@ func exportChain(ctx *cli.Context) error {
 	if len(ctx.Args()) < 1 {
 		log.Fatal("This command requires an argument.")
 	}
+	format := ctx.String(exportCommandFormatFlag.Name)
+	if format != "rlp" && format != "json" {
+		log.Fatalf("Unknown export format '%s', want 'rlp' or 'json'", format)
+	}
+	compress := ctx.Bool(exportCommandGzipFlag.Name)
 	chain, _ := MakeChain(ctx)
 	start := time.Now()
 
 	fp := ctx.Args().First()
 	if len(ctx.Args()) < 3 {
-		if err := ExportChain(chain, fp); err != nil {
+		if format == "json" {
+			if err := ExportChainJSON(chain, fp, 0, chain.CurrentBlock().NumberU64(), false, compress); err != nil {
+				log.Fatal(err)
+			}
+		} else if err := ExportChain(chain, fp, compress); err != nil {
 			log.Fatal(err)
 		}
 	} else {
@@ -155,7 +318,12 @@ func exportChain(ctx *cli.Context) error {
 		if err != nil {
 			log.Fatal("export paramater: ", err)
 		}
-		if err = ExportAppendChain(chain, fp, first, last); err != nil {
+		if format == "json" {
+			err = ExportChainJSON(chain, fp, first, last, true, compress)
+		} else {
+			err = ExportAppendChain(chain, fp, first, last, compress)
+		}
+		if err != nil {
 			log.Fatal(err)
 		}
 	}
@@ -164,6 +332,28 @@ func exportChain(ctx *cli.Context) error {
 	return nil
 }
 
+func exportCheckpoints(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		log.Fatal("This command requires an argument.")
+	}
+	interval := ctx.Int(exportCheckpointsCommandIntervalFlag.Name)
+	if interval <= 0 {
+		log.Fatalf("%s: must be positive, got %d", exportCheckpointsCommandIntervalFlag.Name, interval)
+	}
+	chain, chainDb := MakeChain(ctx)
+	defer chainDb.Close()
+
+	f, err := os.Create(ctx.Args().First())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	if err := chain.ExportCheckpoints(f, uint64(interval)); err != nil {
+		log.Fatal(err)
+	}
+	return nil
+}
+
 func upgradeDB(ctx *cli.Context) error {
 	glog.Infoln("Upgrading blockchain database")
 
@@ -176,7 +366,7 @@ func upgradeDB(ctx *cli.Context) error {
 	// Export the current chain.
 	filename := fmt.Sprintf("blockchain_%d_%s.chain", bcVersion, time.Now().Format("20060102_150405"))
 	exportFile := filepath.Join(ctx.GlobalString(DataDirFlag.Name), filename)
-	if err := ExportChain(chain, exportFile); err != nil {
+	if err := ExportChain(chain, exportFile, false); err != nil {
 		log.Fatal("Unable to export chain for reimport ", err)
 	}
 	chainDb.Close()
@@ -185,7 +375,8 @@ func upgradeDB(ctx *cli.Context) error {
 	// Import the chain file.
 	chain, chainDb = MakeChain(ctx)
 	core.WriteBlockChainVersion(chainDb, core.BlockChainVersion)
-	err := ImportChain(chain, exportFile)
+	// The file was exported from this database above, its blocks need no PoW verification.
+	err := ImportChain(chain, exportFile, true, eth.DefaultImportBatchSize)
 	chainDb.Close()
 	if err != nil {
 		log.Fatalf("Import error %v (a backup is made in %s, use the import command to import it)", err, exportFile)
@@ -260,7 +451,7 @@ func dump(ctx *cli.Context) error {
 			out.WriteString("{}\n")
 			log.Fatal("block not found")
 		} else {
-			state, err := state.New(block.Root(), state.NewDatabase(chainDb))
+			state, err := chain.StateAt(block.Root())
 			if err != nil {
 				return fmt.Errorf("could not create new state: %v", err)
 			}
@@ -18,6 +18,8 @@ package main
 
 import (
 	"bufio"
+	"compress/gzip"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -37,6 +39,7 @@ import (
 	"github.com/ethereumproject/go-ethereum/core/state"
 	"github.com/ethereumproject/go-ethereum/core/types"
 	"github.com/ethereumproject/go-ethereum/eth"
+	"github.com/ethereumproject/go-ethereum/ethdb"
 	"github.com/ethereumproject/go-ethereum/event"
 	"github.com/ethereumproject/go-ethereum/logger"
 	"github.com/ethereumproject/go-ethereum/logger/glog"
@@ -47,10 +50,6 @@ import (
 	"math"
 )
 
-const (
-	importBatchSize = 2500
-)
-
 // Fatalf formats a message to standard error and exits the program.
 // The message is also printed to standard output if standard error
 // is redirected to a different file.
@@ -167,8 +166,12 @@ func StartNode(stack *node.Node) {
 	}()
 }
 
-// Chain imports a blockchain.
-func ImportChain(chain *core.BlockChain, fn string) error {
+// ImportChain imports a blockchain, inserting batchSize blocks at a time.
+// If trusted is set, the proof-of-work of the imported blocks is not verified.
+func ImportChain(chain *core.BlockChain, fn string, trusted bool, batchSize int) error {
+	if batchSize <= 0 {
+		return fmt.Errorf("invalid batch size %d, must be positive", batchSize)
+	}
 	// Watch for Ctrl-C while the import is running.
 	// If a signal is received, the import will stop at the next batch.
 	interrupt := make(chan os.Signal, 1)
@@ -192,15 +195,24 @@ func ImportChain(chain *core.BlockChain, fn string) error {
 	}
 
 	glog.D(logger.Error).Infoln("Importing blockchain ", fn)
+	insert := chain.InsertChain
+	if trusted {
+		glog.D(logger.Warn).Warnln("Trusted import: skipping proof-of-work verification")
+		insert = chain.InsertChainTrusted
+	}
 	fh, err := os.Open(fn)
 	if err != nil {
 		return err
 	}
 	defer fh.Close()
-	stream := rlp.NewStream(fh, 0)
+	in, err := core.NewChainReader(fh)
+	if err != nil {
+		return err
+	}
+	stream := rlp.NewStream(in, 0)
 
 	// Run actual the import.
-	blocks := make(types.Blocks, importBatchSize)
+	blocks := make(types.Blocks, batchSize)
 	n := 0
 	for batch := 0; ; batch++ {
 		// Load a batch of RLP blocks.
@@ -208,7 +220,7 @@ func ImportChain(chain *core.BlockChain, fn string) error {
 			return fmt.Errorf("interrupted")
 		}
 		i := 0
-		for ; i < importBatchSize; i++ {
+		for ; i < batchSize; i++ {
 			var b types.Block
 			if err := stream.Decode(&b); err == io.EOF {
 				break
@@ -236,14 +248,22 @@ func ImportChain(chain *core.BlockChain, fn string) error {
 			continue
 		}
 
-		if res := chain.InsertChain(blocks[:i]); res.Error != nil {
+		if res := insert(blocks[:i]); res.Error != nil {
 			return fmt.Errorf("invalid block %d: %v", n, res.Error)
 		}
 	}
 	return nil
 }
 
+// hasAllBlocks reports whether all blocks of an import batch are already present.
+// When resuming an interrupted import, batches below the current block are skipped
+// after a single canonical hash lookup. Only a batch spanning the end of the previous
+// import is checked block by block, and the check stops at the first missing block.
 func hasAllBlocks(chain *core.BlockChain, bs []*types.Block) bool {
+	last := bs[len(bs)-1]
+	if chain.HasCanonicalBlock(last.Hash(), last.NumberU64()) {
+		return true
+	}
 	for _, b := range bs {
 		if !chain.HasBlock(b.Hash()) {
 			return false
@@ -252,21 +272,32 @@ func hasAllBlocks(chain *core.BlockChain, bs []*types.Block) bool {
 	return true
 }
 
-func ExportChain(blockchain *core.BlockChain, fn string) error {
+// compressExport reports whether an export to the given file should be gzip
+// compressed, either on request or because of a ".gz" file extension.
+func compressExport(fn string, compress bool) bool {
+	return compress || strings.HasSuffix(fn, ".gz")
+}
+
+func ExportChain(blockchain *core.BlockChain, fn string, compress bool) error {
 	glog.D(logger.Warn).Infoln("Exporting blockchain to", fn, "(this may take a while)...")
 	fh, err := os.OpenFile(fn, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.ModePerm)
 	if err != nil {
 		return err
 	}
 	defer fh.Close()
-	if err := blockchain.Export(fh); err != nil {
+	if compressExport(fn, compress) {
+		err = blockchain.ExportGzip(fh)
+	} else {
+		err = blockchain.Export(fh)
+	}
+	if err != nil {
 		return err
 	}
 	glog.D(logger.Error).Infoln("Exported blockchain to ", fn)
 	return nil
 }
 
-func ExportAppendChain(blockchain *core.BlockChain, fn string, first uint64, last uint64) error {
+func ExportAppendChain(blockchain *core.BlockChain, fn string, first uint64, last uint64, compress bool) error {
 	glog.D(logger.Warn).Infoln("Exporting blockchain to ", fn)
 	// TODO verify mode perms
 	fh, err := os.OpenFile(fn, os.O_CREATE|os.O_APPEND|os.O_WRONLY, os.ModePerm)
@@ -274,9 +305,51 @@ func ExportAppendChain(blockchain *core.BlockChain, fn string, first uint64, las
 		return err
 	}
 	defer fh.Close()
-	if err := blockchain.ExportN(fh, first, last); err != nil {
+	if compressExport(fn, compress) {
+		err = blockchain.ExportNGzip(fh, first, last)
+	} else {
+		err = blockchain.ExportN(fh, first, last)
+	}
+	if err != nil {
+		return err
+	}
+	glog.D(logger.Error).Infoln("Exported blockchain to ", fn)
+	return nil
+}
+
+// ExportChainJSON writes blocks first through last to the given file as
+// newline-delimited JSON, appending to or truncating an existing file.
+func ExportChainJSON(blockchain *core.BlockChain, fn string, first uint64, last uint64, appendFile bool, compress bool) error {
+	glog.D(logger.Warn).Infoln("Exporting blockchain as JSON to", fn, "(this may take a while)...")
+	mode := os.O_TRUNC
+	if appendFile {
+		mode = os.O_APPEND
+	}
+	fh, err := os.OpenFile(fn, os.O_CREATE|os.O_WRONLY|mode, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	var w io.Writer = fh
+	var zw *gzip.Writer
+	if compressExport(fn, compress) {
+		zw = gzip.NewWriter(fh)
+		w = zw
+	}
+	bw := bufio.NewWriter(w)
+	if err := eth.ExportJSONN(blockchain, bw, first, last); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
 		return err
 	}
+	// Closing the gzip writer flushes the compressed data and writes the footer.
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return err
+		}
+	}
 	glog.D(logger.Error).Infoln("Exported blockchain to ", fn)
 	return nil
 }
@@ -792,7 +865,28 @@ func gpubench(ctx *cli.Context) error {
 	return nil
 }
 
+// versionInfo is the JSON output of the version command.
+type versionInfo struct {
+	Version          string `json:"version"`
+	GitCommit        string `json:"gitCommit"`
+	GoVersion        string `json:"goVersion"`
+	OS               string `json:"os"`
+	Arch             string `json:"arch"`
+	SputnikVMEnabled bool   `json:"sputnikVM"`
+}
+
 func version(ctx *cli.Context) error {
+	if ctx.Bool(versionCommandJSONFlag.Name) {
+		cid := common.GetClientSessionIdentity()
+		return json.NewEncoder(os.Stdout).Encode(versionInfo{
+			Version:          Version,
+			GitCommit:        GitCommit,
+			GoVersion:        cid.Goversion,
+			OS:               cid.Goos,
+			Arch:             cid.Goarch,
+			SputnikVMEnabled: core.SputnikVMExists,
+		})
+	}
 	fmt.Println("Geth Classic")
 	fmt.Println("Version:", Version)
 	fmt.Println("Protocol Versions:", eth.ProtocolVersions)
@@ -878,13 +972,11 @@ func makeMLogDocumentation(ctx *cli.Context) error {
 	return nil
 }
 
-func recoverChaindata(ctx *cli.Context) error {
-
-	// Congruent to MakeChain(), but uses special NewBlockChainDryrun. Avoids a one-off function in flags.go.
-	var err error
+// mustMakeDryrunChain is congruent to MakeChain(), but uses special NewBlockChainDryrun.
+// Avoids a one-off function in flags.go.
+func mustMakeDryrunChain(ctx *cli.Context) (*core.BlockChain, ethdb.Database) {
 	sconf := mustMakeSufficientChainConfig(ctx)
 	bcdb := MakeChainDatabase(ctx)
-	defer bcdb.Close()
 
 	pow := pow.PoW(core.FakePow{})
 	if !ctx.GlobalBool(aliasableName(FakePoWFlag.Name, ctx)) {
@@ -897,6 +989,123 @@ func recoverChaindata(ctx *cli.Context) error {
 	if err != nil {
 		glog.Fatal("Could not start chain manager: ", err)
 	}
+	return bc, bcdb
+}
+
+func verifyChaindata(ctx *cli.Context) error {
+	bc, bcdb := mustMakeDryrunChain(ctx)
+	defer bcdb.Close()
+
+	if err := bc.LoadLastState(true); err != nil {
+		glog.V(logger.Error).Errorf("Error while loading blockchain: %v", err)
+		// but do not return
+	}
+
+	from := ctx.Int(verifyCommandFromFlag.Name)
+	if from < 0 {
+		return fmt.Errorf("invalid --%s: %d (must be >= 0)", verifyCommandFromFlag.Name, from)
+	}
+	to := ctx.Int(verifyCommandToFlag.Name)
+	if to < 0 {
+		current := bc.CurrentBlock()
+		if current == nil {
+			return errors.New("no current block; specify --" + verifyCommandToFlag.Name)
+		}
+		to = int(current.NumberU64())
+	}
+	if to < from {
+		return fmt.Errorf("invalid range: --%s=%d > --%s=%d", verifyCommandFromFlag.Name, from, verifyCommandToFlag.Name, to)
+	}
+
+	glog.D(logger.Error).Infof("Verifying blocks #%d - #%d...\n", from, to)
+	start := time.Now()
+	block, err := bc.VerifyRange(uint64(from), uint64(to))
+	if err != nil {
+		if block != nil {
+			glog.D(logger.Error).Errorf("Invalid block #%d [%x]: %v\n", block.NumberU64(), block.Hash(), err)
+		} else {
+			glog.D(logger.Error).Errorf("Invalid chain: %v\n", err)
+		}
+		return err
+	}
+	glog.D(logger.Error).Infof("Verified blocks #%d - #%d in %v: OK\n", from, to, time.Since(start))
+	return nil
+}
+
+func fixReceipts(ctx *cli.Context) error {
+	bc, chainDb := MakeChain(ctx)
+	defer chainDb.Close()
+
+	from := ctx.Int(fixReceiptsCommandFromFlag.Name)
+	if from < 0 {
+		return fmt.Errorf("invalid --%s: %d (must be >= 0)", fixReceiptsCommandFromFlag.Name, from)
+	}
+	to := ctx.Int(fixReceiptsCommandToFlag.Name)
+	if to < 0 {
+		to = int(bc.CurrentBlock().NumberU64())
+	}
+	if to < from {
+		return fmt.Errorf("invalid range: --%s=%d > --%s=%d", fixReceiptsCommandFromFlag.Name, from, fixReceiptsCommandToFlag.Name, to)
+	}
+
+	glog.D(logger.Error).Infof("Rewriting receipts of blocks #%d - #%d...\n", from, to)
+	start := time.Now()
+	fixed, err := bc.RederiveReceipts(uint64(from), uint64(to))
+	if err != nil {
+		glog.D(logger.Error).Errorf("Rewrote receipts of %d blocks before failing: %v\n", fixed, err)
+		return err
+	}
+	glog.D(logger.Error).Infof("Rewrote receipts of %d blocks in %v\n", fixed, time.Since(start))
+	return nil
+}
+
+func stateRoot(ctx *cli.Context) error {
+	arg := ctx.Args().First()
+	if len(arg) == 0 {
+		return fmt.Errorf("%v: use: $ geth stateroot [blockHash|blockNum]", ErrInvalidFlag)
+	}
+
+	bc, chainDb := MakeChain(ctx)
+	defer chainDb.Close()
+
+	var block *types.Block
+	if hashish(arg) {
+		block = bc.GetBlock(common.HexToHash(arg))
+	} else {
+		num, err := strconv.ParseUint(arg, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid block number '%s': %v", arg, err)
+		}
+		block = bc.GetBlockByNumber(num)
+	}
+	if block == nil {
+		return fmt.Errorf("block not found: %s", arg)
+	}
+	fmt.Printf("Block #%d [%x]\n", block.NumberU64(), block.Hash())
+	fmt.Printf("State root: %x\n", block.Root())
+
+	start := time.Now()
+	if _, err := bc.StateAt(block.Root()); err != nil {
+		fmt.Printf("State failed to load after %v: %v\n", time.Since(start), err)
+		return err
+	}
+	fmt.Printf("State loaded in %v: OK\n", time.Since(start))
+	return nil
+}
+
+func recoverChaindata(ctx *cli.Context) error {
+
+	start := ctx.Int(recoverCommandStartFlag.Name)
+	if start < 1 {
+		return fmt.Errorf("invalid --%s: %d (must be >= 1)", recoverCommandStartFlag.Name, start)
+	}
+	increment := ctx.Int(recoverCommandIncrementFlag.Name)
+	if increment < 1 {
+		return fmt.Errorf("invalid --%s: %d (must be >= 1)", recoverCommandIncrementFlag.Name, increment)
+	}
+
+	bc, bcdb := mustMakeDryrunChain(ctx)
+	defer bcdb.Close()
 
 	if blockchainLoadError := bc.LoadLastState(true); blockchainLoadError != nil {
 		glog.V(logger.Error).Errorf("Error while loading blockchain: %v", blockchainLoadError)
@@ -907,6 +1116,13 @@ func recoverChaindata(ctx *cli.Context) error {
 	currentBlock := bc.CurrentBlock()
 	currentFastBlock := bc.CurrentFastBlock()
 
+	if currentBlock == nil || uint64(start) > currentBlock.NumberU64() {
+		return fmt.Errorf("invalid --%s: %d (must not be above the current block)", recoverCommandStartFlag.Name, start)
+	}
+	if bc.GetBlockByNumber(uint64(start)) == nil {
+		return fmt.Errorf("invalid --%s: block #%d not found", recoverCommandStartFlag.Name, start)
+	}
+
 	glog.D(logger.Error).Infoln("Current status (before recovery attempt):")
 	if header != nil {
 		glog.D(logger.Error).Infof("Last header: #%d\n", header.Number.Uint64())
@@ -926,8 +1142,11 @@ func recoverChaindata(ctx *cli.Context) error {
 
 	glog.D(logger.Error).Infoln(glog.Separator("-"))
 
-	glog.D(logger.Error).Infoln("Checking db validity and recoverable data...")
-	checkpoint := bc.Recovery(1, 2048)
+	glog.D(logger.Error).Infof("Checking db validity and recoverable data from block #%d (increment=%d)...\n", start, increment)
+	checkpoint := bc.Recovery(start, increment)
+	if checkpoint == 0 {
+		return fmt.Errorf("no healthy block found from #%d, refusing to reset the chain", start)
+	}
 	glog.D(logger.Error).Infof("Found last recoverable checkpoint=#%d\n", checkpoint)
 
 	glog.D(logger.Error).Infoln(glog.Separator("-"))
@@ -417,23 +417,27 @@ func MakeAddress(accman *accounts.Manager, account string) (accounts.Account, er
 	return accman.AccountByIndex(index)
 }
 
-// MakeEtherbase retrieves the etherbase either from the directly specified
-// command line flags or from the keystore if CLI indexed.
+// MakeEtherbase returns the address given by the --etherbase flag or, if the flag is not
+// set, by the ETHERBASE environment variable. Both default to the first account.
 func MakeEtherbase(accman *accounts.Manager, ctx *cli.Context) common.Address {
-	accounts := accman.Accounts()
-	if !ctx.GlobalIsSet(aliasableName(EtherbaseFlag.Name, ctx)) && len(accounts) == 0 {
-		glog.V(logger.Warn).Warnf("No etherbase set and no accounts found as default")
-		glog.D(logger.Warn).Warnf("No etherbase set and no accounts found as default")
-		return common.Address{}
+	option := aliasableName(EtherbaseFlag.Name, ctx)
+	etherbase := ctx.GlobalString(option)
+	if !ctx.GlobalIsSet(option) {
+		if env := strings.TrimSpace(os.Getenv(etherbaseEnvVar)); env != "" {
+			option, etherbase = "$"+etherbaseEnvVar, env
+		} else if len(accman.Accounts()) == 0 {
+			glog.V(logger.Warn).Warnf("No etherbase set and no accounts found as default")
+			glog.D(logger.Warn).Warnf("No etherbase set and no accounts found as default")
+			return common.Address{}
+		}
 	}
-	etherbase := ctx.GlobalString(aliasableName(EtherbaseFlag.Name, ctx))
 	if etherbase == "" {
 		return common.Address{}
 	}
 	// If the specified etherbase is a valid address, return it
 	account, err := MakeAddress(accman, etherbase)
 	if err != nil {
-		log.Fatalf("Option %q: %v", aliasableName(EtherbaseFlag.Name, ctx), err)
+		log.Fatalf("Option %q: %v", option, err)
 	}
 	return account.Address
 }
@@ -551,6 +555,8 @@ func mustMakeStackConf(ctx *cli.Context, name string, config *core.SufficientCha
 		WSPort:          ctx.GlobalInt(aliasableName(WSPortFlag.Name, ctx)),
 		WSOrigins:       ctx.GlobalString(aliasableName(WSAllowedOriginsFlag.Name, ctx)),
 		WSModules:       MakeRPCModules(ctx.GlobalString(aliasableName(WSApiFlag.Name, ctx))),
+
+		RPCSlowQueryThreshold: ctx.GlobalDuration(aliasableName(RPCSlowQueryFlag.Name, ctx)),
 	}
 
 	// Configure the Whisper service
@@ -576,6 +582,36 @@ func mustMakeStackConf(ctx *cli.Context, name string, config *core.SufficientCha
 	return stackConf, shhEnable
 }
 
+// mustMakeCacheConfig makes the block chain cache sizes, GC mode and state retention from the command line flags.
+func mustMakeCacheConfig(ctx *cli.Context) *core.CacheConfig {
+	gcMode, err := core.ParseGCMode(ctx.GlobalString(aliasableName(GCModeFlag.Name, ctx)))
+	if err != nil {
+		log.Fatalf("%s: %v", aliasableName(GCModeFlag.Name, ctx), err)
+	}
+	config := &core.CacheConfig{GCMode: gcMode}
+	if retention := ctx.GlobalInt(aliasableName(StateRetentionFlag.Name, ctx)); retention < core.MinStateRetention {
+		log.Fatalf("%s: must be at least %d, got %d", aliasableName(StateRetentionFlag.Name, ctx), core.MinStateRetention, retention)
+	} else {
+		config.StateRetention = retention
+	}
+	for _, limit := range []struct {
+		flag cli.IntFlag
+		val  *int
+	}{
+		{HeaderCacheFlag, &config.HeaderCacheLimit},
+		{BodyCacheFlag, &config.BodyCacheLimit},
+		{TdCacheFlag, &config.TdCacheLimit},
+		{BlockCacheFlag, &config.BlockCacheLimit},
+	} {
+		n := ctx.GlobalInt(aliasableName(limit.flag.Name, ctx))
+		if n <= 0 {
+			log.Fatalf("%s: must be positive, got %d", aliasableName(limit.flag.Name, ctx), n)
+		}
+		*limit.val = n
+	}
+	return config
+}
+
 func mustMakeEthConf(ctx *cli.Context, sconf *core.SufficientChainConfig) *eth.Config {
 
 	accman := MakeAccountManager(ctx)
@@ -592,11 +628,22 @@ func mustMakeEthConf(ctx *cli.Context, sconf *core.SufficientChainConfig) *eth.C
 		ChainConfig:             sconf.ChainConfig,
 		Genesis:                 sconf.Genesis,
 		UseAddrTxIndex:          ctx.GlobalBool(aliasableName(AddrTxIndexFlag.Name, ctx)),
+		NoFutureBlocks:          ctx.GlobalBool(aliasableName(NoFutureBlocksFlag.Name, ctx)),
+		Preimages:               ctx.GlobalBool(aliasableName(PreimagesFlag.Name, ctx)),
+		VerifyStateCommits:      ctx.GlobalBool(aliasableName(VerifyStateCommitsFlag.Name, ctx)),
+		VerifyBodies:            ctx.GlobalBool(aliasableName(VerifyBodiesFlag.Name, ctx)),
+		HeaderCheckFrequency:    ctx.GlobalInt(aliasableName(HeaderCheckFrequencyFlag.Name, ctx)),
+		HeaderForceVerify:       ctx.GlobalInt(aliasableName(HeaderForceVerifyFlag.Name, ctx)),
+		RequireReplayProtection: ctx.GlobalBool(aliasableName(RequireReplayProtectionFlag.Name, ctx)),
 		BlockChainVersion:       ctx.GlobalInt(aliasableName(BlockchainVersionFlag.Name, ctx)),
 		DatabaseCache:           ctx.GlobalInt(aliasableName(CacheFlag.Name, ctx)),
 		DatabaseHandles:         MakeDatabaseHandles(),
 		NetworkId:               sconf.Network,
 		MaxPeers:                ctx.GlobalInt(aliasableName(MaxPeersFlag.Name, ctx)),
+		MaxFetchPeers:           ctx.GlobalInt(aliasableName(MaxFetchPeersFlag.Name, ctx)),
+		SyncRTTMin:              ctx.GlobalDuration(aliasableName(SyncRTTMinFlag.Name, ctx)),
+		SyncRTTMax:              ctx.GlobalDuration(aliasableName(SyncRTTMaxFlag.Name, ctx)),
+		SyncTTLMax:              ctx.GlobalDuration(aliasableName(SyncTTLMaxFlag.Name, ctx)),
 		AccountManager:          accman,
 		Etherbase:               MakeEtherbase(accman, ctx),
 		MinerThreads:            ctx.GlobalInt(aliasableName(MinerThreadsFlag.Name, ctx)),
@@ -620,6 +667,76 @@ func mustMakeEthConf(ctx *cli.Context, sconf *core.SufficientChainConfig) *eth.C
 		ethConf.SyncMode = downloader.ForceFullSync
 	}
 
+	tieBreak, err := core.ParseTieBreakPolicy(ctx.GlobalString(aliasableName(TieBreakFlag.Name, ctx)))
+	if err != nil {
+		log.Fatalf("%s: %v", aliasableName(TieBreakFlag.Name, ctx), err)
+	}
+	ethConf.TieBreak = tieBreak
+
+	if limit := ctx.GlobalInt(aliasableName(TxPoolAccountLimitFlag.Name, ctx)); limit < 0 {
+		log.Fatalf("%s: must not be negative, got %d", aliasableName(TxPoolAccountLimitFlag.Name, ctx), limit)
+	} else {
+		ethConf.TxPoolAccountLimit = limit
+	}
+
+	if maxTime := ctx.GlobalInt(aliasableName(MaxTimeFutureBlocksFlag.Name, ctx)); maxTime < 0 {
+		log.Fatalf("%s: must not be negative, got %d", aliasableName(MaxTimeFutureBlocksFlag.Name, ctx), maxTime)
+	} else {
+		seconds := int64(maxTime)
+		ethConf.MaxTimeFutureBlocks = &seconds
+	}
+
+	if gasCap := ctx.GlobalInt(aliasableName(RPCGasCapFlag.Name, ctx)); gasCap < 0 {
+		log.Fatalf("%s: must not be negative, got %d", aliasableName(RPCGasCapFlag.Name, ctx), gasCap)
+	} else {
+		ethConf.RPCGasCap = uint64(gasCap)
+	}
+	ethConf.RPCGasCapReject = ctx.GlobalBool(aliasableName(RPCGasCapRejectFlag.Name, ctx))
+	if timeout := ctx.GlobalDuration(aliasableName(RPCCallTimeoutFlag.Name, ctx)); timeout < 0 {
+		log.Fatalf("%s: must not be negative, got %v", aliasableName(RPCCallTimeoutFlag.Name, ctx), timeout)
+	} else {
+		ethConf.RPCCallTimeout = timeout
+	}
+
+	if maxLogs := ctx.GlobalInt(aliasableName(RPCMaxLogsFlag.Name, ctx)); maxLogs < 0 {
+		log.Fatalf("%s: must not be negative, got %d", aliasableName(RPCMaxLogsFlag.Name, ctx), maxLogs)
+	} else {
+		ethConf.RPCMaxLogs = maxLogs
+	}
+
+	cacheConfig := mustMakeCacheConfig(ctx)
+	ethConf.GCMode = cacheConfig.GCMode
+	ethConf.StateRetention = cacheConfig.StateRetention
+	ethConf.HeaderCacheLimit = cacheConfig.HeaderCacheLimit
+	ethConf.BodyCacheLimit = cacheConfig.BodyCacheLimit
+	ethConf.TdCacheLimit = cacheConfig.TdCacheLimit
+	ethConf.BlockCacheLimit = cacheConfig.BlockCacheLimit
+
+	if levels := ctx.GlobalString(aliasableName(MipmapLevelsFlag.Name, ctx)); levels != "" {
+		for _, s := range strings.Split(levels, ",") {
+			level, err := strconv.ParseUint(strings.TrimSpace(s), 10, 64)
+			if err != nil {
+				log.Fatalf("%s: invalid level %q", aliasableName(MipmapLevelsFlag.Name, ctx), s)
+			}
+			ethConf.MipmapLevels = append(ethConf.MipmapLevels, level)
+		}
+		if err := core.ValidateMipmapLevels(ethConf.MipmapLevels); err != nil {
+			log.Fatalf("%s: %v", aliasableName(MipmapLevelsFlag.Name, ctx), err)
+		}
+	}
+
+	if path := ctx.GlobalString(aliasableName(CheckpointsFlag.Name, ctx)); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatalf("%s: %v", aliasableName(CheckpointsFlag.Name, ctx), err)
+		}
+		ethConf.Checkpoints, err = core.ReadCheckpoints(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("%s: %v", aliasableName(CheckpointsFlag.Name, ctx), err)
+		}
+	}
+
 	if _, ok := ethConf.GasPrice.SetString(ctx.GlobalString(aliasableName(GasPriceFlag.Name, ctx)), 0); !ok {
 		log.Fatalf("malformed %s flag value %q", aliasableName(GasPriceFlag.Name, ctx), ctx.GlobalString(aliasableName(GasPriceFlag.Name, ctx)))
 	}
@@ -629,6 +746,12 @@ func mustMakeEthConf(ctx *cli.Context, sconf *core.SufficientChainConfig) *eth.C
 	if _, ok := ethConf.GpoMaxGasPrice.SetString(ctx.GlobalString(aliasableName(GpoMaxGasPriceFlag.Name, ctx)), 0); !ok {
 		log.Fatalf("malformed %s flag value %q", aliasableName(GpoMaxGasPriceFlag.Name, ctx), ctx.GlobalString(aliasableName(GpoMaxGasPriceFlag.Name, ctx)))
 	}
+	if margin := ctx.GlobalString(aliasableName(SyncPeerTDMarginFlag.Name, ctx)); margin != "" {
+		ethConf.SyncPeerTDMargin = new(big.Int)
+		if _, ok := ethConf.SyncPeerTDMargin.SetString(margin, 0); !ok || ethConf.SyncPeerTDMargin.Sign() < 0 {
+			log.Fatalf("malformed %s flag value %q", aliasableName(SyncPeerTDMarginFlag.Name, ctx), margin)
+		}
+	}
 
 	switch sconf.Consensus {
 	case "ethash-test":
@@ -657,9 +780,17 @@ func mustMakeSufficientChainConfig(ctx *cli.Context) *core.SufficientChainConfig
 		return c
 	}
 
+	genesis := mustReadGenesisSpec(ctx)
+
 	config := &core.SufficientChainConfig{}
 	defer func() {
 		// Allow flags to override external config file.
+		if genesis != nil {
+			config.Genesis = genesis.GenesisDump
+			if genesis.Config != nil {
+				config.ChainConfig = genesis.Config
+			}
+		}
 		if ctx.GlobalBool(aliasableName(DevModeFlag.Name, ctx)) {
 			config.Consensus = "ethash-test"
 		}
@@ -684,6 +815,11 @@ func mustMakeSufficientChainConfig(ctx *cli.Context) *core.SufficientChainConfig
 
 	// If chain identity is either of defaults (via config file or flag), use defaults.
 	if core.ChainIdentitiesMain[chainIdentity] || core.ChainIdentitiesMorden[chainIdentity] {
+		// A custom genesis would otherwise use the network id, bootnodes and data dir of the default chain.
+		if genesis != nil {
+			glog.Fatalf("--%s cannot be used with the default %q chain, set a custom one with --%s",
+				aliasableName(GenesisFlag.Name, ctx), chainIdentity, aliasableName(ChainIdentityFlag.Name, ctx))
+		}
 		// Initialise chain configuration before handling migrations or setting up node.
 		config.Identity = chainIdentity
 		config.Name = mustMakeChainConfigNameDefaulty(ctx)
@@ -704,6 +840,22 @@ func mustMakeSufficientChainConfig(ctx *cli.Context) *core.SufficientChainConfig
 	chainDir := MustMakeChainDataDir(ctx)
 	defaultChainConfigPath := filepath.Join(chainDir, "chain.json")
 	if _, de := os.Stat(defaultChainConfigPath); de != nil && os.IsNotExist(de) {
+		// A genesis file with a chain configuration is enough to define a private network,
+		// persisted as chain.json so that later runs don't need --genesis.
+		if genesis != nil && genesis.Config != nil {
+			if !ctx.GlobalIsSet(aliasableName(NetworkIdFlag.Name, ctx)) {
+				glog.Fatalf("--%s for a custom chain requires --%s",
+					aliasableName(GenesisFlag.Name, ctx), aliasableName(NetworkIdFlag.Name, ctx))
+			}
+			config.Identity = chainIdentity
+			config.Name = chainIdentity
+			config.Network = ctx.GlobalInt(aliasableName(NetworkIdFlag.Name, ctx))
+			config.Consensus = "ethash"
+			config.Genesis = genesis.GenesisDump
+			config.ChainConfig = genesis.Config
+			mustWriteGenesisChainConfig(config, chainDir, defaultChainConfigPath)
+			return config
+		}
 		glog.Fatalf(`%v: %v
 		It looks like you haven't set up your custom chain yet...
 		Here's a possible workflow for that:
@@ -735,6 +887,37 @@ func mustMakeSufficientChainConfig(ctx *cli.Context) *core.SufficientChainConfig
 	return config
 }
 
+// mustWriteGenesisChainConfig writes the chain configuration made from a genesis
+// specification to path, inlining any allocation file.
+func mustWriteGenesisChainConfig(config *core.SufficientChainConfig, chainDir, path string) {
+	if err := os.MkdirAll(chainDir, 0755); err != nil {
+		glog.Fatalf("cannot create chain data dir: %v: %v", chainDir, err)
+	}
+	persisted := *config
+	genesis := *config.Genesis
+	genesis.AllocFile = ""
+	persisted.Genesis = &genesis
+	if err := persisted.WriteToJSONFile(path); err != nil {
+		glog.Fatalf("cannot write chain configuration: %v", err)
+	}
+	glog.V(logger.Info).Infof("Wrote chain configuration for %q to %s", config.Identity, path)
+	glog.D(logger.Warn).Infof("Wrote chain configuration for %q to %s", config.Identity, path)
+}
+
+// mustReadGenesisSpec reads the genesis specification file given with --genesis,
+// returning nil if the flag is not set.
+func mustReadGenesisSpec(ctx *cli.Context) *core.GenesisSpec {
+	path := ctx.GlobalString(aliasableName(GenesisFlag.Name, ctx))
+	if path == "" {
+		return nil
+	}
+	spec, err := core.ReadGenesisSpecFromFile(path)
+	if err != nil {
+		glog.Fatalf("%s: %v", aliasableName(GenesisFlag.Name, ctx), err)
+	}
+	return spec
+}
+
 func logChainConfiguration(ctx *cli.Context, config *core.SufficientChainConfig) {
 	chainIdentity := mustMakeChainIdentity(ctx)
 	chainIsCustom := !(core.ChainIdentitiesMain[chainIdentity] || core.ChainIdentitiesMorden[chainIdentity])
@@ -846,10 +1029,17 @@ func MakeChain(ctx *cli.Context) (chain *core.BlockChain, chainDb ethdb.Database
 		glog.D(logger.Warn).Warnln("Consensus: fake")
 	}
 
-	chain, err = core.NewBlockChain(chainDb, sconf.ChainConfig, pow, new(event.TypeMux))
+	chain, err = core.NewBlockChain(chainDb, sconf.ChainConfig, pow, new(event.TypeMux), mustMakeCacheConfig(ctx))
 	if err != nil {
 		glog.Fatal("Could not start chainmanager: ", err)
 	}
+	chain.SetStateCommitVerification(ctx.GlobalBool(aliasableName(VerifyStateCommitsFlag.Name, ctx)))
+	chain.SetBodyVerification(ctx.GlobalBool(aliasableName(VerifyBodiesFlag.Name, ctx)))
+	tieBreak, err := core.ParseTieBreakPolicy(ctx.GlobalString(aliasableName(TieBreakFlag.Name, ctx)))
+	if err != nil {
+		glog.Fatalf("%s: %v", aliasableName(TieBreakFlag.Name, ctx), err)
+	}
+	chain.SetTieBreakPolicy(tieBreak)
 	return chain, chainDb
 }
 
@@ -333,3 +333,69 @@ func TestMakeAddress(t *testing.T) {
 		t.Fatalf("want: %v, got: %v", wantAccount, gotAccount)
 	}
 }
+
+func TestMakeEtherbaseFromEnv(t *testing.T) {
+	am, err := accounts.NewManager(filepath.Join("accounts", "testdata", "keystore"), accounts.LightScryptN, accounts.LightScryptP, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	envAddr := common.HexToAddress("0x7ef5a6135f1fd6a02593eedc869c6d41d934aef8")
+	flagAddr := common.HexToAddress("0xf466859ead1932d743d622cb74fc058882e8648a")
+
+	os.Setenv(etherbaseEnvVar, envAddr.Hex())
+	defer os.Unsetenv(etherbaseEnvVar)
+
+	for _, test := range []struct {
+		args []string
+		want common.Address
+	}{
+		{nil, envAddr},
+		{[]string{"--etherbase", flagAddr.Hex()}, flagAddr},
+	} {
+		setupFlags(t)
+		set.String(EtherbaseFlag.Name, EtherbaseFlag.Value, "")
+		if e := set.Parse(test.args); e != nil {
+			t.Fatal(e)
+		}
+		context = cli.NewContext(app, set, nil)
+		if got := MakeEtherbase(am, context); got != test.want {
+			t.Errorf("%v: want: %x, got: %x", test.args, test.want, got)
+		}
+	}
+}
+
+func TestMustWriteGenesisChainConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geth-genesis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	genesis := *core.DefaultConfigMorden.Genesis
+	genesis.AllocFile = "alloc.csv" // already read into Alloc
+	config := &core.SufficientChainConfig{
+		Identity:    "private",
+		Name:        "private",
+		Network:     42,
+		Consensus:   "ethash",
+		Genesis:     &genesis,
+		ChainConfig: core.DefaultConfigMorden.ChainConfig,
+	}
+	chainDir := filepath.Join(dir, "private")
+	path := filepath.Join(chainDir, "chain.json")
+	mustWriteGenesisChainConfig(config, chainDir, path)
+
+	got, err := core.ReadExternalChainConfigFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Identity != config.Identity || got.Network != config.Network {
+		t.Errorf("got identity %q network %d, want %q %d", got.Identity, got.Network, config.Identity, config.Network)
+	}
+	if !reflect.DeepEqual(got.Genesis.Alloc, genesis.Alloc) {
+		t.Error("genesis alloc differs")
+	}
+	if genesis.AllocFile != "alloc.csv" {
+		t.Error("given genesis modified")
+	}
+}
@@ -7,15 +7,21 @@ import (
 	"strings"
 
 	"path/filepath"
+	"time"
 
 	"github.com/ethereumproject/go-ethereum/common"
 	"github.com/ethereumproject/go-ethereum/core"
 	"github.com/ethereumproject/go-ethereum/eth"
+	"github.com/ethereumproject/go-ethereum/eth/filters"
 	"github.com/ethereumproject/go-ethereum/logger/glog"
 	"github.com/ethereumproject/go-ethereum/rpc"
 	"gopkg.in/urfave/cli.v1"
 )
 
+// etherbaseEnvVar names the environment variable read for the etherbase if
+// --etherbase is not given, for deployments configured through the environment.
+const etherbaseEnvVar = "ETHERBASE"
+
 // These are all the command line flags we support.
 // If you add to this list, please remember to include the
 // flag in the appropriate command definition.
@@ -53,6 +59,10 @@ var (
 		Usage: `Chain identifier (default='mainnet', test='morden') or path to JSON chain configuration file (eg './path/to/chain.json').`,
 		Value: core.DefaultConfigMainnet.Identity,
 	}
+	GenesisFlag = cli.StringFlag{
+		Name:  "genesis",
+		Usage: "JSON genesis file (alloc, difficulty, gasLimit, ...; optional chain \"config\") to start a private network from; requires a custom --chain and --network-id, refused if the database has another genesis",
+	}
 	NetworkIdFlag = cli.IntFlag{
 		Name:  "network-id, networkid",
 		Usage: "Network identifier (integer: 1=Homestead, 2=Morden)",
@@ -102,6 +112,88 @@ var (
 		Name:  "light-kdf,lightkdf",
 		Usage: "Reduce key-derivation RAM & CPU usage at some expense of KDF strength",
 	}
+	MaxTimeFutureBlocksFlag = cli.IntFlag{
+		Name:  "max-time-future-blocks",
+		Usage: "Seconds a block's timestamp may be ahead of local time before the block is rejected",
+		Value: core.DefaultMaxTimeFutureBlocks,
+	}
+	NoFutureBlocksFlag = cli.BoolFlag{
+		Name:  "no-future-blocks",
+		Usage: "Reject blocks with a timestamp ahead of local time instead of queueing them (for test networks with skewed clocks)",
+	}
+	PreimagesFlag = cli.BoolFlag{
+		Name:  "preimages",
+		Usage: "Record the SHA3 preimages of keys hashed during block import (see debug_preimage)",
+	}
+	TieBreakFlag = cli.StringFlag{
+		Name:  "tie-break",
+		Usage: `Choice between chains of equal total difficulty ("random", "lower-number" or "first-seen"); keep "random" on public PoW networks`,
+		Value: "random",
+	}
+	GCModeFlag = cli.StringFlag{
+		Name:  "gcmode",
+		Usage: `Block states kept in the database ("archive" keeps all, "full" keeps only recent ones); blocks forking off before the retained states are refused`,
+		Value: "archive",
+	}
+	StateRetentionFlag = cli.IntFlag{
+		Name:  "state-retention",
+		Usage: "Number of most recent block states kept with --gcmode=full (min 2)",
+		Value: core.DefaultStateRetention,
+	}
+	HeaderCacheFlag = cli.IntFlag{
+		Name:  "cache-headers",
+		Usage: "Number of most recent block headers cached in memory",
+		Value: core.DefaultCacheConfig().HeaderCacheLimit,
+	}
+	BodyCacheFlag = cli.IntFlag{
+		Name:  "cache-bodies",
+		Usage: "Number of most recent block bodies cached in memory",
+		Value: core.DefaultCacheConfig().BodyCacheLimit,
+	}
+	TdCacheFlag = cli.IntFlag{
+		Name:  "cache-tds",
+		Usage: "Number of most recent block total difficulties cached in memory",
+		Value: core.DefaultCacheConfig().TdCacheLimit,
+	}
+	BlockCacheFlag = cli.IntFlag{
+		Name:  "cache-blocks",
+		Usage: "Number of most recent entire blocks cached in memory",
+		Value: core.DefaultCacheConfig().BlockCacheLimit,
+	}
+	MipmapLevelsFlag = cli.StringFlag{
+		Name:  "mipmap-levels",
+		Usage: "Comma separated block ranges of the log bloom index, coarsest first (default 1000000,500000,100000,50000,1000); changing them reindexes the chain on startup",
+	}
+	CheckpointsFlag = cli.StringFlag{
+		Name:  "checkpoints",
+		Usage: "JSON file of trusted checkpoints (see export-checkpoints) imported headers are cross-checked against by number and hash; on startup the local chain must match them, including total difficulty",
+	}
+	HeaderCheckFrequencyFlag = cli.IntFlag{
+		Name:  "header-check-frequency",
+		Usage: "Average interval between headers whose PoW is verified during fast sync (at most 1024); raise only with trusted peers",
+		Value: core.DefaultHeaderCheckFrequency,
+	}
+	HeaderForceVerifyFlag = cli.IntFlag{
+		Name:  "header-force-verify",
+		Usage: "Number of headers before the fast sync pivot which are always verified",
+		Value: core.DefaultHeaderForceVerify,
+	}
+	VerifyStateCommitsFlag = cli.BoolFlag{
+		Name:  "verify-state-commits",
+		Usage: "Reopen the state of each block from the database after import to catch commit errors early (slow)",
+	}
+	VerifyBodiesFlag = cli.BoolFlag{
+		Name:  "verify-bodies",
+		Usage: "Check block bodies read from the database against their header to catch disk corruption (slow)",
+	}
+	RequireReplayProtectionFlag = cli.BoolFlag{
+		Name:  "require-replay-protection",
+		Usage: "Reject transactions entering the transaction pool which are not EIP-155 replay-protected",
+	}
+	TxPoolAccountLimitFlag = cli.IntFlag{
+		Name:  "txpool-account-limit",
+		Usage: "Maximum number of pending and queued transactions per account in the transaction pool (0 = unlimited)",
+	}
 	AddrTxIndexFlag = cli.BoolFlag{
 		Name:  "atxi,add-tx-index",
 		Usage: "Toggle indexes for transactions by address. Pre-existing chaindata can be indexed with command 'atxi-build'",
@@ -142,7 +234,7 @@ var (
 	}
 	EtherbaseFlag = cli.StringFlag{
 		Name:  "etherbase",
-		Usage: "Public address for block mining rewards (default = first account created)",
+		Usage: "Public address for block mining rewards (default = $" + etherbaseEnvVar + " or first account created)",
 		Value: "0",
 	}
 	GasPriceFlag = cli.StringFlag{
@@ -285,6 +377,30 @@ var (
 		Usage: "API's offered over the HTTP-RPC interface",
 		Value: rpc.DefaultHTTPApis,
 	}
+	RPCGasCapFlag = cli.IntFlag{
+		Name:  "rpc-gascap",
+		Usage: "Maximum gas used by eth_call, eth_estimateGas and eth_traceCall (0 = unlimited)",
+		Value: 0,
+	}
+	RPCMaxLogsFlag = cli.IntFlag{
+		Name:  "rpc-max-logs",
+		Usage: "Maximum number of logs returned by eth_getLogs and eth_getFilterLogs, larger queries fail (0 = unlimited)",
+		Value: filters.DefaultMaxLogs,
+	}
+	RPCCallTimeoutFlag = cli.DurationFlag{
+		Name:  "rpc-call-timeout",
+		Usage: "Maximum EVM execution time of eth_call, eth_estimateGas and eth_traceCall (0 = unlimited)",
+		Value: eth.DefaultCallTimeout,
+	}
+	RPCGasCapRejectFlag = cli.BoolFlag{
+		Name:  "rpc-gascap-reject",
+		Usage: "Reject calls requesting more gas than --rpc-gascap instead of lowering their gas",
+	}
+	RPCSlowQueryFlag = cli.DurationFlag{
+		Name:  "rpc-slow-query",
+		Usage: "Log RPC calls taking longer than this duration, with their parameter types (0 = disabled)",
+		Value: 0,
+	}
 	IPCDisabledFlag = cli.BoolFlag{
 		Name:  "ipc-disable,ipcdisable",
 		Usage: "Disable the IPC-RPC server",
@@ -340,6 +456,31 @@ var (
 		Usage: "Maximum number of network peers (network disabled if set to 0)",
 		Value: 25,
 	}
+	MaxFetchPeersFlag = cli.IntFlag{
+		Name:  "max-fetch-peers",
+		Usage: "Maximum number of peers concurrently used for fetching blocks during sync (0 = unlimited)",
+		Value: 0,
+	}
+	SyncRTTMinFlag = cli.DurationFlag{
+		Name:  "sync-rtt-min",
+		Usage: "Minimum round-trip time targeted by sync requests",
+		Value: 2 * time.Second,
+	}
+	SyncRTTMaxFlag = cli.DurationFlag{
+		Name:  "sync-rtt-max",
+		Usage: "Maximum round-trip time targeted by sync requests (raise on high latency links)",
+		Value: 20 * time.Second,
+	}
+	SyncTTLMaxFlag = cli.DurationFlag{
+		Name:  "sync-ttl-max",
+		Usage: "Maximum time allowed for a single sync request before timing out (not below --sync-rtt-max)",
+		Value: time.Minute,
+	}
+	SyncPeerTDMarginFlag = cli.StringFlag{
+		Name:  "sync-peer-td-margin",
+		Usage: "Refuse to sync from peers whose total difficulty is more than this below that of our header chain (empty = any peer)",
+		Value: "",
+	}
 	MaxPendingPeersFlag = cli.IntFlag{
 		Name:  "max-pend-peers,maxpendpeers",
 		Usage: "Maximum number of pending connection attempts (defaults used if set to 0)",
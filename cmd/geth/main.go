@@ -39,6 +39,10 @@ import (
 // as in: go build -ldflags "-X main.Version="`git describe --tags`
 var Version = "source"
 
+// GitCommit is the git commit the binary was built from, if known. It can be set with
+// the linker as in: go build -ldflags "-X main.GitCommit="`git rev-parse HEAD`
+var GitCommit = ""
+
 func init() {
 	rand.Seed(time.Now().UTC().UnixNano())
 	common.SetClientVersion(Version)
@@ -83,7 +87,16 @@ var versionCommand = cli.Command{
 	Usage:  "Print ethereum version numbers",
 	Description: `
 	The output of this command is supposed to be machine-readable.
+	Use --json to print the build metadata as a single JSON object.
 			`,
+	Flags: []cli.Flag{
+		versionCommandJSONFlag,
+	},
+}
+
+var versionCommandJSONFlag = cli.BoolFlag{
+	Name:  "json",
+	Usage: "Print the version and build metadata as JSON",
 }
 
 var makeMlogDocCommand = cli.Command{
@@ -116,11 +129,15 @@ func makeCLIApp() (app *cli.App) {
 	app.Commands = []cli.Command{
 		importCommand,
 		exportCommand,
+		exportCheckpointsCommand,
 		dumpChainConfigCommand,
 		upgradedbCommand,
 		dumpCommand,
 		rollbackCommand,
 		recoverCommand,
+		verifyCommand,
+		fixReceiptsCommand,
+		stateRootCommand,
 		resetCommand,
 		monitorCommand,
 		accountCommand,
@@ -151,16 +168,40 @@ func makeCLIApp() (app *cli.App) {
 		DocRootFlag,
 		KeyStoreDirFlag,
 		ChainIdentityFlag,
+		GenesisFlag,
 		BlockchainVersionFlag,
 		FastSyncFlag,
 		SlowSyncFlag,
+		MaxTimeFutureBlocksFlag,
+		NoFutureBlocksFlag,
+		PreimagesFlag,
+		VerifyStateCommitsFlag,
+		VerifyBodiesFlag,
+		TieBreakFlag,
+		GCModeFlag,
+		StateRetentionFlag,
+		MipmapLevelsFlag,
+		CheckpointsFlag,
+		HeaderCheckFrequencyFlag,
+		HeaderForceVerifyFlag,
+		RequireReplayProtectionFlag,
+		TxPoolAccountLimitFlag,
 		AddrTxIndexFlag,
 		AddrTxIndexAutoBuildFlag,
 		CacheFlag,
+		HeaderCacheFlag,
+		BodyCacheFlag,
+		TdCacheFlag,
+		BlockCacheFlag,
 		LightKDFFlag,
 		JSpathFlag,
 		ListenPortFlag,
 		MaxPeersFlag,
+		MaxFetchPeersFlag,
+		SyncRTTMinFlag,
+		SyncRTTMaxFlag,
+		SyncTTLMaxFlag,
+		SyncPeerTDMarginFlag,
 		MaxPendingPeersFlag,
 		EtherbaseFlag,
 		GasPriceFlag,
@@ -178,6 +219,11 @@ func makeCLIApp() (app *cli.App) {
 		RPCListenAddrFlag,
 		RPCPortFlag,
 		RPCApiFlag,
+		RPCGasCapFlag,
+		RPCMaxLogsFlag,
+		RPCSlowQueryFlag,
+		RPCGasCapRejectFlag,
+		RPCCallTimeoutFlag,
 		WSEnabledFlag,
 		WSListenAddrFlag,
 		WSPortFlag,
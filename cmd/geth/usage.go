@@ -64,21 +64,44 @@ var AppHelpFlagAndCommandGroups = []flagGroup{
 		Commands: []cli.Command{
 			importCommand,
 			exportCommand,
+			exportCheckpointsCommand,
 			dumpChainConfigCommand,
 			dumpCommand,
 			rollbackCommand,
 			recoverCommand,
+			verifyCommand,
+			fixReceiptsCommand,
+			stateRootCommand,
 			resetCommand,
 		},
 		Flags: []cli.Flag{
 			DataDirFlag,
 			ChainIdentityFlag,
+			GenesisFlag,
 			NetworkIdFlag,
 			DevModeFlag,
 			NodeNameFlag,
 			FastSyncFlag,
 			SlowSyncFlag,
+			MaxTimeFutureBlocksFlag,
+			NoFutureBlocksFlag,
+			PreimagesFlag,
+			VerifyStateCommitsFlag,
+			VerifyBodiesFlag,
+			TieBreakFlag,
+			GCModeFlag,
+			StateRetentionFlag,
+			MipmapLevelsFlag,
+			CheckpointsFlag,
+			HeaderCheckFrequencyFlag,
+			HeaderForceVerifyFlag,
+			RequireReplayProtectionFlag,
+			TxPoolAccountLimitFlag,
 			CacheFlag,
+			HeaderCacheFlag,
+			BodyCacheFlag,
+			TdCacheFlag,
+			BlockCacheFlag,
 			LightKDFFlag,
 			SputnikVMFlag,
 			BlockchainVersionFlag,
@@ -113,6 +136,11 @@ var AppHelpFlagAndCommandGroups = []flagGroup{
 			RPCListenAddrFlag,
 			RPCPortFlag,
 			RPCApiFlag,
+			RPCGasCapFlag,
+			RPCGasCapRejectFlag,
+			RPCCallTimeoutFlag,
+			RPCMaxLogsFlag,
+			RPCSlowQueryFlag,
 			WSEnabledFlag,
 			WSListenAddrFlag,
 			WSPortFlag,
@@ -133,6 +161,11 @@ var AppHelpFlagAndCommandGroups = []flagGroup{
 			BootnodesFlag,
 			ListenPortFlag,
 			MaxPeersFlag,
+			MaxFetchPeersFlag,
+			SyncRTTMinFlag,
+			SyncRTTMaxFlag,
+			SyncTTLMaxFlag,
+			SyncPeerTDMarginFlag,
 			MaxPendingPeersFlag,
 			NATFlag,
 			NoDiscoverFlag,
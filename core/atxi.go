@@ -395,6 +395,82 @@ func GetAddrTxs(db ethdb.Database, address common.Address, blockStartN uint64, b
 	return
 }
 
+// MaxAddrTxsPageSize is the maximum number of transactions returned by GetAddrTxsPage.
+const MaxAddrTxsPageSize = 1000
+
+// AddrTx is an indexed transaction of an account address.
+type AddrTx struct {
+	BlockNumber uint64
+	Hash        common.Hash
+}
+
+// GetAddrTxsPage gets a page of at most limit indexed transactions for the given account address.
+// Transactions are returned in index order, which is not block order because block numbers are
+// little endian encoded in the index keys; pages are stable as long as no transactions are indexed
+// for the address. Iteration stops as soon as the page is filled, so only offset+limit matching
+// entries are read. A blockEndN of 0 means no upper bound.
+// A limit which is not positive or exceeds MaxAddrTxsPageSize is set to MaxAddrTxsPageSize.
+// 'next' is the offset of the following page, or -1 if there are no more transactions.
+func GetAddrTxsPage(db ethdb.Database, address common.Address, blockStartN uint64, blockEndN uint64, direction string, offset int, limit int) (txs []AddrTx, next int, err error) {
+	if len(direction) > 0 && !strings.Contains("btf", direction[:1]) {
+		return nil, -1, fmt.Errorf("%v: %s", errAtxiInvalidUse, "direction param must be empty string or [b|t|f] prefix (eg. both, to, or from)")
+	}
+	if offset < 0 {
+		return nil, -1, fmt.Errorf("%v: %s", errAtxiInvalidUse, "offset must not be negative")
+	}
+	if limit <= 0 || limit > MaxAddrTxsPageSize {
+		limit = MaxAddrTxsPageSize
+	}
+	ldb, ok := db.(*ethdb.LDBDatabase)
+	if !ok {
+		return nil, -1, errors.New("internal interface error; could not cast eth db to level db")
+	}
+	var wantDirectionB byte = 'b'
+	if len(direction) > 0 {
+		wantDirectionB = direction[0]
+	}
+
+	txs = []AddrTx{}
+	next = -1
+	matched := 0
+	it := ldb.NewIteratorRange(ethdb.NewBytesPrefix(formatAddrTxIterator(address)))
+	defer it.Release()
+	for it.Next() {
+		addr, blockNum, torf, kindof, txh := resolveAddrTxBytes(it.Key())
+		bn := binary.LittleEndian.Uint64(blockNum)
+		if bn < blockStartN || (blockEndN > 0 && bn > blockEndN) {
+			continue
+		}
+		if wantDirectionB != 'b' && wantDirectionB != torf[0] {
+			continue
+		}
+		// A transaction from an address to itself is indexed in both directions;
+		// count it once, by its 'from' key.
+		if wantDirectionB == 'b' && torf[0] == 't' {
+			self, err := ldb.Has(formatAddrTxBytesIndex(addr, blockNum, []byte("f"), kindof, txh))
+			if err != nil {
+				return nil, -1, err
+			}
+			if self {
+				continue
+			}
+		}
+		matched++
+		if matched <= offset {
+			continue
+		}
+		if len(txs) == limit {
+			next = offset + limit
+			break
+		}
+		txs = append(txs, AddrTx{BlockNumber: bn, Hash: common.BytesToHash(txh)})
+	}
+	if err = it.Error(); err != nil {
+		return nil, -1, err
+	}
+	return txs, next, nil
+}
+
 // RmAddrTx removes all atxi indexes for a given tx in case of a transaction removal, eg.
 // in the case of chain reorg.
 // It isn't an elegant function, but not a top priority for optimization because of
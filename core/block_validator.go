@@ -24,9 +24,11 @@ import (
 	"github.com/ethereumproject/go-ethereum/common"
 	"github.com/ethereumproject/go-ethereum/core/state"
 	"github.com/ethereumproject/go-ethereum/core/types"
+	"github.com/ethereumproject/go-ethereum/logger"
 	"github.com/ethereumproject/go-ethereum/logger/glog"
 	"github.com/ethereumproject/go-ethereum/params"
 	"github.com/ethereumproject/go-ethereum/pow"
+	"github.com/ethereumproject/go-ethereum/trie"
 	"gopkg.in/fatih/set.v0"
 )
 
@@ -78,7 +80,7 @@ func NewBlockValidator(config *ChainConfig, blockchain *BlockChain, pow pow.PoW)
 // false positives where a header is present but the state is not.
 func (v *BlockValidator) ValidateBlock(block *types.Block) error {
 	if v.bc.HasBlock(block.Hash()) {
-		if _, err := state.New(block.Root(), state.NewDatabase(v.bc.chainDb)); err == nil {
+		if _, err := state.New(block.Root(), state.NewDatabase(v.bc.stateDatabase())); err == nil {
 			return &KnownBlockError{block.Number(), block.Hash()}
 		}
 	}
@@ -86,7 +88,14 @@ func (v *BlockValidator) ValidateBlock(block *types.Block) error {
 	if parent == nil {
 		return ParentError(block.ParentHash())
 	}
-	if _, err := state.New(parent.Root(), state.NewDatabase(v.bc.chainDb)); err != nil {
+	if _, err := state.New(parent.Root(), state.NewDatabase(v.bc.stateDatabase())); err != nil {
+		if missing, ok := err.(*trie.MissingNodeError); ok && v.bc.gcDb != nil {
+			// In GCModeFull the parent state was pruned: the block forks off the chain
+			// before the retention window, which is not supported.
+			glog.V(logger.Warn).Warnf("Refusing block #%d [%x…]: fork deeper than the %d most recent block states kept in gc mode %v",
+				block.NumberU64(), block.Hash().Bytes()[:4], v.bc.cacheConfig.StateRetention, GCModeFull)
+			return &StateUnavailableErr{Root: parent.Root(), Err: missing}
+		}
 		return ParentError(block.ParentHash())
 	}
 
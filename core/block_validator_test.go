@@ -96,7 +96,7 @@ func proc(t testing.TB) (Validator, *BlockChain) {
 	}
 
 	var mux event.TypeMux
-	blockchain, err := NewBlockChain(db, testChainConfig(), pow, &mux)
+	blockchain, err := NewBlockChain(db, testChainConfig(), pow, &mux, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
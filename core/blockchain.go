@@ -18,6 +18,8 @@
 package core
 
 import (
+	"bufio"
+	"compress/gzip"
 	"errors"
 	"fmt"
 	"io"
@@ -43,6 +45,7 @@ import (
 	"github.com/ethereumproject/go-ethereum/event"
 	"github.com/ethereumproject/go-ethereum/logger"
 	"github.com/ethereumproject/go-ethereum/logger/glog"
+	"github.com/ethereumproject/go-ethereum/metrics"
 	"github.com/ethereumproject/go-ethereum/pow"
 	"github.com/ethereumproject/go-ethereum/rlp"
 	"github.com/ethereumproject/go-ethereum/trie"
@@ -53,20 +56,91 @@ var (
 	ErrNoGenesis = errors.New("Genesis not found in chain")
 	errNilBlock  = errors.New("nil block")
 	errNilHeader = errors.New("nil header")
+
+	ErrNegativeMaxTimeFutureBlocks = errors.New("max time for future blocks cannot be negative")
+
+	// ErrHeaderVerificationBounds is returned when setting header verification values out of bounds.
+	ErrHeaderVerificationBounds = fmt.Errorf("header check frequency must be within [1, %d] and force-verify window at least %d", MaxHeaderCheckFrequency, MinHeaderForceVerify)
+
+	// ErrReadOnlyChain is returned by methods modifying a read-only blockchain.
+	ErrReadOnlyChain = errors.New("blockchain is read-only")
 )
 
 const (
-	headerCacheLimit    = 512
-	bodyCacheLimit      = 256
-	tdCacheLimit        = 1024
-	blockCacheLimit     = 256
-	maxFutureBlocks     = 256
-	maxTimeFutureBlocks = 30
+	headerCacheLimit = 512
+	bodyCacheLimit   = 256
+	tdCacheLimit     = 1024
+	blockCacheLimit  = 256
+	maxFutureBlocks  = 256
+	badBlockLimit    = 10
+	// DefaultMaxTimeFutureBlocks is the default number of seconds a block's timestamp may be
+	// ahead of local time before InsertChain rejects it instead of queueing it as a future block.
+	DefaultMaxTimeFutureBlocks = 30
+	// DefaultHeaderCheckFrequency is the default average interval between headers whose
+	// proof-of-work is verified when importing headers during fast sync.
+	DefaultHeaderCheckFrequency = 100
+	// MaxHeaderCheckFrequency bounds the header verification interval.
+	MaxHeaderCheckFrequency = 1024
+	// DefaultHeaderForceVerify is the default number of headers before the fast sync pivot
+	// (and all those after it) which are fully verified.
+	DefaultHeaderForceVerify = 24
+	// MinHeaderForceVerify bounds the force-verify window so the pivot is always verified.
+	MinHeaderForceVerify = 1
 	// must be bumped when consensus algorithm is changed, this forces the upgradedb
 	// command to be run (forces the blocks to be imported again using the new algorithm)
 	BlockChainVersion = 3
 )
 
+// CacheConfig holds the sizes, in number of entries, of the in-memory lru caches
+// kept by the block and header chains, and how block states are retained.
+// Zero or negative values use the defaults.
+type CacheConfig struct {
+	HeaderCacheLimit int // Most recent block headers
+	BodyCacheLimit   int // Most recent block bodies (both decoded and RLP encoded)
+	TdCacheLimit     int // Most recent block total difficulties
+	BlockCacheLimit  int // Most recent entire blocks
+
+	GCMode         GCMode // Which block states are written to the database
+	StateRetention int    // Most recent block states kept in GCModeFull
+}
+
+// DefaultCacheConfig returns the cache sizes used when no CacheConfig is given.
+func DefaultCacheConfig() *CacheConfig {
+	return &CacheConfig{
+		HeaderCacheLimit: headerCacheLimit,
+		BodyCacheLimit:   bodyCacheLimit,
+		TdCacheLimit:     tdCacheLimit,
+		BlockCacheLimit:  blockCacheLimit,
+		StateRetention:   DefaultStateRetention,
+	}
+}
+
+// withDefaults returns a copy of the config with unset sizes replaced by their defaults.
+// It is safe to call on a nil config.
+func (c *CacheConfig) withDefaults() *CacheConfig {
+	cfg := DefaultCacheConfig()
+	if c == nil {
+		return cfg
+	}
+	if c.HeaderCacheLimit > 0 {
+		cfg.HeaderCacheLimit = c.HeaderCacheLimit
+	}
+	if c.BodyCacheLimit > 0 {
+		cfg.BodyCacheLimit = c.BodyCacheLimit
+	}
+	if c.TdCacheLimit > 0 {
+		cfg.TdCacheLimit = c.TdCacheLimit
+	}
+	if c.BlockCacheLimit > 0 {
+		cfg.BlockCacheLimit = c.BlockCacheLimit
+	}
+	cfg.GCMode = c.GCMode
+	if c.StateRetention >= MinStateRetention {
+		cfg.StateRetention = c.StateRetention
+	}
+	return cfg
+}
+
 // BlockChain represents the canonical chain given a database with a genesis
 // block. The Blockchain manages chain imports, reverts, chain reorganisations.
 //
@@ -82,10 +156,12 @@ const (
 // included in the canonical one where as GetBlockByNumber always represents the
 // canonical chain.
 type BlockChain struct {
-	config *ChainConfig // chain & network configuration
+	config      *ChainConfig // chain & network configuration
+	cacheConfig *CacheConfig // sizes of the block and header chain caches
 
 	hc           *HeaderChain
 	chainDb      ethdb.Database
+	gcDb         *stateGCDatabase // holds recent block states in GCModeFull, nil otherwise
 	eventMux     *event.TypeMux
 	genesisBlock *types.Block
 
@@ -101,6 +177,7 @@ type BlockChain struct {
 	bodyRLPCache *lru.Cache     // Cache for the most recent block bodies in RLP encoded format
 	blockCache   *lru.Cache     // Cache for the most recent entire blocks
 	futureBlocks *lru.Cache     // future blocks are blocks added for later processing
+	badBlocks    *lru.Cache     // Bad blocks rejected by InsertChain, see BadBlocks
 
 	quit    chan struct{} // blockchain quit channel
 	running int32         // running must be called atomically
@@ -112,25 +189,55 @@ type BlockChain struct {
 	processor Processor // block processor interface
 	validator Validator // block and state validator interface
 
+	processorFactory ProcessorFactory // optionally selects the processor per block, see SetProcessorFactory
+
+	// maxTimeFutureBlocks must be accessed atomically
+	maxTimeFutureBlocks int64 // seconds a block may be in the future before being rejected
+	// noFutureBlocks must be accessed atomically
+	noFutureBlocks int32 // 1 if blocks in the future are rejected instead of queued
+	// recordPreimages must be accessed atomically
+	recordPreimages int32 // 1 if SHA3 preimages seen during block processing are written to the database
+	// verifyStateCommits must be accessed atomically
+	verifyStateCommits int32 // 1 if the committed state of each imported block is reopened from the database
+	// verifyBodies must be accessed atomically
+	verifyBodies int32 // 1 if block bodies read from the database are checked against their header
+	// headerCheckFreq and headerForceVerify must be accessed atomically
+	headerCheckFreq   int32 // average interval between verified headers during fast sync
+	headerForceVerify int32 // number of headers before the fast sync pivot which are always verified
+
 	atxi *AtxiT
+
+	mipmapLevels atomic.Value // []uint64 levels of the log bloom bins, see SetMipmapLevels
+
+	readOnly bool // set by NewBlockChainReadOnly, rejects all modifications
 }
 
+// ChainInsertResult reports the outcome of InsertChain. If Aborted is set, the
+// insertion was interrupted by a shutdown before the block at Index, which is
+// not a validation failure.
 type ChainInsertResult struct {
 	ChainInsertEvent
-	Index int
-	Error error
+	Index   int
+	Error   error
+	Aborted bool
 }
 
+// ReceiptChainInsertResult reports the outcome of InsertReceiptChain. If Aborted
+// is set, the insertion was interrupted by a shutdown.
 type ReceiptChainInsertResult struct {
 	ReceiptChainInsertEvent
-	Index int
-	Error error
+	Index   int
+	Error   error
+	Aborted bool
 }
 
+// HeaderChainInsertResult reports the outcome of InsertHeaderChain. If Aborted
+// is set, the insertion was interrupted by a shutdown before the header at Index.
 type HeaderChainInsertResult struct {
 	HeaderChainInsertEvent
-	Index int
-	Error error
+	Index   int
+	Error   error
+	Aborted bool
 }
 
 func (bc *BlockChain) GetHeaderByHash(h common.Hash) *types.Header {
@@ -143,15 +250,18 @@ func (bc *BlockChain) GetBlockByHash(h common.Hash) *types.Block {
 
 // NewBlockChain returns a fully initialised block chain using information
 // available in the database. It initialises the default Ethereum Validator and
-// Processor.
-func NewBlockChain(chainDb ethdb.Database, config *ChainConfig, pow pow.PoW, mux *event.TypeMux) (*BlockChain, error) {
-	bodyCache, _ := lru.New(bodyCacheLimit)
-	bodyRLPCache, _ := lru.New(bodyCacheLimit)
-	blockCache, _ := lru.New(blockCacheLimit)
+// Processor. A nil cacheConfig uses the default cache sizes.
+func NewBlockChain(chainDb ethdb.Database, config *ChainConfig, pow pow.PoW, mux *event.TypeMux, cacheConfig *CacheConfig) (*BlockChain, error) {
+	cacheConfig = cacheConfig.withDefaults()
+	bodyCache, _ := lru.New(cacheConfig.BodyCacheLimit)
+	bodyRLPCache, _ := lru.New(cacheConfig.BodyCacheLimit)
+	blockCache, _ := lru.New(cacheConfig.BlockCacheLimit)
 	futureBlocks, _ := lru.New(maxFutureBlocks)
+	badBlocks, _ := lru.New(badBlockLimit)
 
 	bc := &BlockChain{
 		config:       config,
+		cacheConfig:  cacheConfig,
 		chainDb:      chainDb,
 		eventMux:     mux,
 		quit:         make(chan struct{}),
@@ -159,14 +269,19 @@ func NewBlockChain(chainDb ethdb.Database, config *ChainConfig, pow pow.PoW, mux
 		bodyRLPCache: bodyRLPCache,
 		blockCache:   blockCache,
 		futureBlocks: futureBlocks,
+		badBlocks:    badBlocks,
 		pow:          pow,
+
+		maxTimeFutureBlocks: DefaultMaxTimeFutureBlocks,
+		headerCheckFreq:     DefaultHeaderCheckFrequency,
+		headerForceVerify:   DefaultHeaderForceVerify,
 	}
 	bc.SetValidator(NewBlockValidator(config, bc, pow))
 	bc.SetProcessor(NewStateProcessor(config, bc))
 
 	gv := func() HeaderValidator { return bc.Validator() }
 	var err error
-	bc.hc, err = NewHeaderChain(chainDb, config, mux, gv, bc.getProcInterrupt)
+	bc.hc, err = NewHeaderChain(chainDb, config, mux, gv, bc.getProcInterrupt, bc.cacheConfig)
 	if err != nil {
 		return nil, err
 	}
@@ -176,6 +291,14 @@ func NewBlockChain(chainDb ethdb.Database, config *ChainConfig, pow pow.PoW, mux
 		return nil, ErrNoGenesis
 	}
 
+	if cacheConfig.GCMode == GCModeFull {
+		// Once states have been pruned the database stays pruned, whatever mode it is reopened in.
+		if err := WriteStatePruned(chainDb); err != nil {
+			return nil, err
+		}
+		bc.gcDb = newStateGCDatabase(chainDb)
+	}
+
 	if err := bc.LoadLastState(false); err != nil {
 		return nil, err
 	}
@@ -183,6 +306,7 @@ func NewBlockChain(chainDb ethdb.Database, config *ChainConfig, pow pow.PoW, mux
 	for i := range config.BadHashes {
 		if header := bc.GetHeader(config.BadHashes[i].Hash); header != nil && header.Number.Cmp(config.BadHashes[i].Block) == 0 {
 			glog.V(logger.Error).Infof("Found bad hash, rewinding chain to block #%d [%s]", header.Number, header.ParentHash.Hex())
+			bc.hc.postBadFork(header, ErrHashKnownBad)
 			bc.SetHead(header.Number.Uint64() - 1)
 			glog.V(logger.Error).Infoln("Chain rewind was successful, resuming normal operation")
 		}
@@ -193,13 +317,16 @@ func NewBlockChain(chainDb ethdb.Database, config *ChainConfig, pow pow.PoW, mux
 }
 
 func NewBlockChainDryrun(chainDb ethdb.Database, config *ChainConfig, pow pow.PoW, mux *event.TypeMux) (*BlockChain, error) {
-	bodyCache, _ := lru.New(bodyCacheLimit)
-	bodyRLPCache, _ := lru.New(bodyCacheLimit)
-	blockCache, _ := lru.New(blockCacheLimit)
+	cacheConfig := DefaultCacheConfig()
+	bodyCache, _ := lru.New(cacheConfig.BodyCacheLimit)
+	bodyRLPCache, _ := lru.New(cacheConfig.BodyCacheLimit)
+	blockCache, _ := lru.New(cacheConfig.BlockCacheLimit)
 	futureBlocks, _ := lru.New(maxFutureBlocks)
+	badBlocks, _ := lru.New(badBlockLimit)
 
 	bc := &BlockChain{
 		config:       config,
+		cacheConfig:  cacheConfig,
 		chainDb:      chainDb,
 		eventMux:     mux,
 		quit:         make(chan struct{}),
@@ -207,14 +334,19 @@ func NewBlockChainDryrun(chainDb ethdb.Database, config *ChainConfig, pow pow.Po
 		bodyRLPCache: bodyRLPCache,
 		blockCache:   blockCache,
 		futureBlocks: futureBlocks,
+		badBlocks:    badBlocks,
 		pow:          pow,
+
+		maxTimeFutureBlocks: DefaultMaxTimeFutureBlocks,
+		headerCheckFreq:     DefaultHeaderCheckFrequency,
+		headerForceVerify:   DefaultHeaderForceVerify,
 	}
 	bc.SetValidator(NewBlockValidator(config, bc, pow))
 	bc.SetProcessor(NewStateProcessor(config, bc))
 
 	gv := func() HeaderValidator { return bc.Validator() }
 	var err error
-	bc.hc, err = NewHeaderChain(chainDb, config, mux, gv, bc.getProcInterrupt)
+	bc.hc, err = NewHeaderChain(chainDb, config, mux, gv, bc.getProcInterrupt, bc.cacheConfig)
 	if err != nil {
 		return nil, err
 	}
@@ -231,6 +363,7 @@ func NewBlockChainDryrun(chainDb ethdb.Database, config *ChainConfig, pow pow.Po
 	for i := range config.BadHashes {
 		if header := bc.GetHeader(config.BadHashes[i].Hash); header != nil && header.Number.Cmp(config.BadHashes[i].Block) == 0 {
 			glog.V(logger.Error).Infof("Found bad hash, rewinding chain to block #%d [%s]", header.Number, header.ParentHash.Hex())
+			bc.hc.postBadFork(header, ErrHashKnownBad)
 			bc.SetHead(header.Number.Uint64() - 1)
 			glog.V(logger.Error).Infoln("Chain rewind was successful, resuming normal operation")
 		}
@@ -240,11 +373,204 @@ func NewBlockChainDryrun(chainDb ethdb.Database, config *ChainConfig, pow pow.Po
 	return bc, nil
 }
 
+// NewBlockChainReadOnly returns a blockchain which serves reads from chainDb but never
+// modifies it. The last known head is loaded without any validation or recovery, bad hashes
+// are not rewound, and no background processing is started. Methods which would modify
+// the chain return ErrReadOnlyChain (or panic with it if they have no error result), and
+// any other attempted write is rejected by the database with ethdb.ErrReadOnly.
+// Together with ethdb.NewLDBDatabaseReadOnly, it allows several processes to query one datadir.
+func NewBlockChainReadOnly(chainDb ethdb.Database, config *ChainConfig, mux *event.TypeMux) (*BlockChain, error) {
+	cacheConfig := DefaultCacheConfig()
+	bodyCache, _ := lru.New(cacheConfig.BodyCacheLimit)
+	bodyRLPCache, _ := lru.New(cacheConfig.BodyCacheLimit)
+	blockCache, _ := lru.New(cacheConfig.BlockCacheLimit)
+	futureBlocks, _ := lru.New(maxFutureBlocks)
+	badBlocks, _ := lru.New(badBlockLimit)
+
+	if _, ok := chainDb.(*ethdb.ReadOnlyDatabase); !ok {
+		chainDb = ethdb.NewReadOnlyDatabase(chainDb)
+	}
+	bc := &BlockChain{
+		config:       config,
+		cacheConfig:  cacheConfig,
+		chainDb:      chainDb,
+		eventMux:     mux,
+		quit:         make(chan struct{}),
+		bodyCache:    bodyCache,
+		bodyRLPCache: bodyRLPCache,
+		blockCache:   blockCache,
+		futureBlocks: futureBlocks,
+		badBlocks:    badBlocks,
+		pow:          FakePow{},
+		readOnly:     true,
+
+		maxTimeFutureBlocks: DefaultMaxTimeFutureBlocks,
+		headerCheckFreq:     DefaultHeaderCheckFrequency,
+		headerForceVerify:   DefaultHeaderForceVerify,
+	}
+	bc.SetValidator(NewBlockValidator(config, bc, bc.pow))
+	bc.SetProcessor(NewStateProcessor(config, bc))
+
+	gv := func() HeaderValidator { return bc.Validator() }
+	var err error
+	bc.hc, err = NewHeaderChain(chainDb, config, mux, gv, bc.getProcInterrupt, bc.cacheConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	bc.genesisBlock = bc.GetBlockByNumber(0)
+	if bc.genesisBlock == nil {
+		return nil, ErrNoGenesis
+	}
+	if err := bc.loadLastStateReadOnly(); err != nil {
+		return nil, err
+	}
+	return bc, nil
+}
+
+// loadLastStateReadOnly loads the last known head header, block and fast block as stored
+// in the database, without validating or repairing them.
+func (bc *BlockChain) loadLastStateReadOnly() error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	head := GetHeadBlockHash(bc.chainDb)
+	if head == (common.Hash{}) {
+		return errors.New("empty HeadBlockHash")
+	}
+	bc.currentBlock = bc.GetBlock(head)
+	if bc.currentBlock == nil {
+		return fmt.Errorf("head block [%x…] not found", head[:4])
+	}
+	if hash := GetHeadHeaderHash(bc.chainDb); hash != (common.Hash{}) {
+		if header := bc.GetHeader(hash); header != nil {
+			bc.hc.currentHeader = header
+			bc.hc.currentHeaderHash = hash
+		}
+	}
+	bc.currentFastBlock = bc.currentBlock
+	if hash := GetHeadFastBlockHash(bc.chainDb); hash != (common.Hash{}) {
+		if block := bc.GetBlock(hash); block != nil {
+			bc.currentFastBlock = block
+		}
+	}
+	return nil
+}
+
+// ReadOnly returns whether the blockchain was opened with NewBlockChainReadOnly.
+func (bc *BlockChain) ReadOnly() bool {
+	return bc.readOnly
+}
+
 // GetEventMux returns the blockchain's event mux
 func (bc *BlockChain) GetEventMux() *event.TypeMux {
 	return bc.eventMux
 }
 
+// subscribeEvent subscribes to events of type ev on the blockchain's event mux and forwards
+// their data with send until the returned unsubscribe function is called or the mux is stopped.
+// The send function must return false if quit was closed before its value could be delivered.
+func (bc *BlockChain) subscribeEvent(ev interface{}, send func(data interface{}, quit <-chan struct{}) bool, done func()) (unsubscribe func()) {
+	sub := bc.eventMux.Subscribe(ev)
+	quit := make(chan struct{})
+	var once sync.Once
+
+	go func() {
+		defer done()
+		for e := range sub.Chan() {
+			if !send(e.Data, quit) {
+				return
+			}
+		}
+	}()
+
+	return func() {
+		once.Do(func() {
+			close(quit)
+			sub.Unsubscribe()
+		})
+	}
+}
+
+// SubscribeChainEvent returns a channel receiving ChainEvents and a function to cancel the subscription.
+// The channel is closed once the subscription is cancelled or the event mux is stopped.
+func (bc *BlockChain) SubscribeChainEvent() (<-chan ChainEvent, func()) {
+	ch := make(chan ChainEvent)
+	unsub := bc.subscribeEvent(ChainEvent{}, func(data interface{}, quit <-chan struct{}) bool {
+		select {
+		case ch <- data.(ChainEvent):
+			return true
+		case <-quit:
+			return false
+		}
+	}, func() { close(ch) })
+	return ch, unsub
+}
+
+// SubscribeChainHeadEvent returns a channel receiving ChainHeadEvents and a function to cancel the subscription.
+// The channel is closed once the subscription is cancelled or the event mux is stopped.
+func (bc *BlockChain) SubscribeChainHeadEvent() (<-chan ChainHeadEvent, func()) {
+	ch := make(chan ChainHeadEvent)
+	unsub := bc.subscribeEvent(ChainHeadEvent{}, func(data interface{}, quit <-chan struct{}) bool {
+		select {
+		case ch <- data.(ChainHeadEvent):
+			return true
+		case <-quit:
+			return false
+		}
+	}, func() { close(ch) })
+	return ch, unsub
+}
+
+// SubscribeChainSideEvent returns a channel receiving ChainSideEvents, which are posted for blocks
+// imported to a non-canonical chain (eg. during a reorg), and a function to cancel the subscription.
+// The channel is closed once the subscription is cancelled or the event mux is stopped.
+func (bc *BlockChain) SubscribeChainSideEvent() (<-chan ChainSideEvent, func()) {
+	ch := make(chan ChainSideEvent)
+	unsub := bc.subscribeEvent(ChainSideEvent{}, func(data interface{}, quit <-chan struct{}) bool {
+		select {
+		case ch <- data.(ChainSideEvent):
+			return true
+		case <-quit:
+			return false
+		}
+	}, func() { close(ch) })
+	return ch, unsub
+}
+
+// SubscribeRemovedLogsEvent returns a channel receiving RemovedLogsEvents, which are posted with the
+// logs of blocks dropped from the canonical chain by a reorg, and a function to cancel the subscription.
+// The channel is closed once the subscription is cancelled or the event mux is stopped.
+func (bc *BlockChain) SubscribeRemovedLogsEvent() (<-chan RemovedLogsEvent, func()) {
+	ch := make(chan RemovedLogsEvent)
+	unsub := bc.subscribeEvent(RemovedLogsEvent{}, func(data interface{}, quit <-chan struct{}) bool {
+		select {
+		case ch <- data.(RemovedLogsEvent):
+			return true
+		case <-quit:
+			return false
+		}
+	}, func() { close(ch) })
+	return ch, unsub
+}
+
+// SubscribeRemovedTransactionEvent returns a channel receiving RemovedTransactionEvents, which are posted
+// with the transactions dropped from the canonical chain by a reorg and not included in the new one,
+// and a function to cancel the subscription.
+// The channel is closed once the subscription is cancelled or the event mux is stopped.
+func (bc *BlockChain) SubscribeRemovedTransactionEvent() (<-chan RemovedTransactionEvent, func()) {
+	ch := make(chan RemovedTransactionEvent)
+	unsub := bc.subscribeEvent(RemovedTransactionEvent{}, func(data interface{}, quit <-chan struct{}) bool {
+		select {
+		case ch <- data.(RemovedTransactionEvent):
+			return true
+		case <-quit:
+			return false
+		}
+	}, func() { close(ch) })
+	return ch, unsub
+}
+
 // SetAtxi sets the db and in-use var for atx indexing.
 func (bc *BlockChain) SetAtxi(a *AtxiT) {
 	bc.atxi = a
@@ -255,6 +581,233 @@ func (bc *BlockChain) GetAtxi() *AtxiT {
 	return bc.atxi
 }
 
+// SetMaxTimeFutureBlocks sets the number of seconds a block's timestamp may be ahead of
+// local time before InsertChain rejects it outright.
+func (bc *BlockChain) SetMaxTimeFutureBlocks(seconds int64) error {
+	if seconds < 0 {
+		return ErrNegativeMaxTimeFutureBlocks
+	}
+	atomic.StoreInt64(&bc.maxTimeFutureBlocks, seconds)
+	return nil
+}
+
+// MaxTimeFutureBlocks returns the number of seconds a block's timestamp may be ahead of local time.
+func (bc *BlockChain) MaxTimeFutureBlocks() int64 {
+	return atomic.LoadInt64(&bc.maxTimeFutureBlocks)
+}
+
+// SetFutureBlocks sets whether InsertChain queues blocks whose timestamp is ahead of local
+// time, by at most MaxTimeFutureBlocks seconds, for import once their time has come.
+// If disabled, such blocks are rejected immediately with a *FutureBlockErr. Queueing
+// is enabled by default; disabling it is intended for test networks with skewed clocks.
+func (bc *BlockChain) SetFutureBlocks(enabled bool) {
+	var v int32
+	if !enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&bc.noFutureBlocks, v)
+}
+
+// FutureBlocks returns whether blocks ahead of local time are queued, see SetFutureBlocks.
+func (bc *BlockChain) FutureBlocks() bool {
+	return atomic.LoadInt32(&bc.noFutureBlocks) == 0
+}
+
+// SetHeaderVerification sets how headers are verified during fast sync. The proof-of-work
+// of one in every checkFreq headers is verified on average, and of every one of the
+// forceVerify headers before the sync pivot and all headers after it.
+//
+// Raising checkFreq above the default reduces verification overhead, but lets a malicious
+// peer feed more forged headers before being detected; the pivot state is still checked.
+// It should only be raised on private networks where all peers are trusted.
+func (bc *BlockChain) SetHeaderVerification(checkFreq, forceVerify int) error {
+	if checkFreq < 1 || checkFreq > MaxHeaderCheckFrequency || forceVerify < MinHeaderForceVerify {
+		return ErrHeaderVerificationBounds
+	}
+	atomic.StoreInt32(&bc.headerCheckFreq, int32(checkFreq))
+	atomic.StoreInt32(&bc.headerForceVerify, int32(forceVerify))
+	return nil
+}
+
+// HeaderVerification returns the header verification frequency and force-verify window
+// used during fast sync.
+func (bc *BlockChain) HeaderVerification() (checkFreq, forceVerify int) {
+	return int(atomic.LoadInt32(&bc.headerCheckFreq)), int(atomic.LoadInt32(&bc.headerForceVerify))
+}
+
+// SetMipmapLevels sets the levels of the log bloom bins written for imported blocks.
+// Bins written with other levels are not converted: the bins of all existing blocks
+// must be rewritten after changing the levels of a database.
+func (bc *BlockChain) SetMipmapLevels(levels []uint64) error {
+	if err := ValidateMipmapLevels(levels); err != nil {
+		return err
+	}
+	bc.mipmapLevels.Store(levels)
+	return nil
+}
+
+// MipmapLevels returns the levels of the log bloom bins, DefaultMIPMapLevels unless
+// set with SetMipmapLevels.
+func (bc *BlockChain) MipmapLevels() []uint64 {
+	if levels, ok := bc.mipmapLevels.Load().([]uint64); ok {
+		return levels
+	}
+	return DefaultMIPMapLevels
+}
+
+// SetPreimageRecording sets whether the SHA3 preimages seen while processing blocks
+// in InsertChain are written to the chain database.
+func (bc *BlockChain) SetPreimageRecording(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&bc.recordPreimages, v)
+}
+
+// PreimageRecording returns whether SHA3 preimages are recorded during block processing.
+func (bc *BlockChain) PreimageRecording() bool {
+	return atomic.LoadInt32(&bc.recordPreimages) == 1
+}
+
+// SetStateCommitVerification sets whether InsertChain reopens the state of each block
+// from the database right after committing it, failing the import if it cannot be loaded.
+// This is a debugging aid which slows down block import.
+func (bc *BlockChain) SetStateCommitVerification(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&bc.verifyStateCommits, v)
+}
+
+// StateCommitVerification returns whether committed block states are verified during import.
+func (bc *BlockChain) StateCommitVerification() bool {
+	return atomic.LoadInt32(&bc.verifyStateCommits) == 1
+}
+
+// SetBodyVerification sets whether block bodies read from the database are checked
+// against the transaction and uncle roots of their header, to catch silent disk
+// corruption. A body failing the check is logged and treated as missing. This is a
+// debugging aid which slows down block retrieval.
+func (bc *BlockChain) SetBodyVerification(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&bc.verifyBodies, v)
+}
+
+// BodyVerification returns whether block bodies are verified when read from the database.
+func (bc *BlockChain) BodyVerification() bool {
+	return atomic.LoadInt32(&bc.verifyBodies) == 1
+}
+
+// CommitState commits the state of the block with the given number, which was not imported
+// with InsertChain, eg. a mined block. In GCModeFull the state is retained like the states of
+// imported blocks.
+func (bc *BlockChain) CommitState(number uint64, statedb *state.StateDB) (common.Hash, error) {
+	bc.chainmu.Lock()
+	defer bc.chainmu.Unlock()
+
+	return bc.commitState(number, statedb)
+}
+
+// commitState commits the state of the block with the given number to the state database
+// and retains it. The caller must hold chainmu.
+func (bc *BlockChain) commitState(number uint64, statedb *state.StateDB) (common.Hash, error) {
+	root, err := statedb.CommitTo(bc.stateDatabase(), false)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return root, bc.retainState(number, root)
+}
+
+// retainState records the committed state of the block with the given number in GCModeFull.
+// The state of one in every CacheConfig.StateRetention blocks is written to the database,
+// and states older than the retention window are dropped from memory.
+func (bc *BlockChain) retainState(number uint64, root common.Hash) error {
+	if bc.gcDb == nil {
+		return nil
+	}
+	bc.gcDb.reference(root, number)
+
+	retention := uint64(bc.cacheConfig.StateRetention)
+	if number%retention != 0 {
+		return nil
+	}
+	if err := bc.gcDb.flush(root); err != nil {
+		return err
+	}
+	if number < retention {
+		return nil
+	}
+	if err := bc.gcDb.prune(number - retention); err != nil {
+		return err
+	}
+	if glog.V(logger.Debug) {
+		nodes, states := bc.gcDb.size()
+		glog.Infof("Wrote state of block #%d to database, %d states (%d nodes) held in memory", number, states, nodes)
+	}
+	return nil
+}
+
+// verifyBody checks that the transactions and uncles of a block body match the
+// roots in the block header.
+func verifyBody(header *types.Header, body *types.Body) error {
+	if hash := types.DeriveSha(types.Transactions(body.Transactions)); hash != header.TxHash {
+		return fmt.Errorf("transaction root mismatch: have %x, header %x", hash, header.TxHash)
+	}
+	if hash := types.CalcUncleHash(body.Uncles); hash != header.UncleHash {
+		return fmt.Errorf("uncle root mismatch: have %x, header %x", hash, header.UncleHash)
+	}
+	return nil
+}
+
+// checkStoredBody reports whether a body read from the database passes body
+// verification, logging the failure otherwise. It always passes if verification
+// is disabled.
+func (bc *BlockChain) checkStoredBody(hash common.Hash, header *types.Header, body *types.Body) bool {
+	if !bc.BodyVerification() {
+		return true
+	}
+	if header == nil {
+		header = bc.GetHeader(hash)
+	}
+	if header == nil {
+		glog.V(logger.Error).Errorf("Found block body without header: hash=%x", hash)
+		return false
+	}
+	if err := verifyBody(header, body); err != nil {
+		glog.V(logger.Error).Errorf("Found corrupt block body: number=%d, hash=%x: %v", header.Number, hash, err)
+		return false
+	}
+	return true
+}
+
+// verifyStateCommit checks that the committed state root matches the block and
+// that the state can be opened from the database, bypassing the state cache.
+func (bc *BlockChain) verifyStateCommit(block *types.Block, root common.Hash) error {
+	if root != block.Root() {
+		return fmt.Errorf("committed state root %x does not match block root %x", root, block.Root())
+	}
+	if _, err := state.New(root, state.NewDatabase(bc.stateDatabase())); err != nil {
+		return fmt.Errorf("committed state %x cannot be loaded: %v", root, err)
+	}
+	return nil
+}
+
+// SetTieBreakPolicy sets how blocks and headers with the same total difficulty as the
+// current head are chosen. The default, TieBreakRandom, should be kept on public PoW networks.
+func (bc *BlockChain) SetTieBreakPolicy(policy TieBreakPolicy) {
+	bc.hc.SetTieBreakPolicy(policy)
+}
+
+// TieBreakPolicy returns how blocks with the same total difficulty as the current head are chosen.
+func (bc *BlockChain) TieBreakPolicy() TieBreakPolicy {
+	return bc.hc.TieBreakPolicy()
+}
+
 func (bc *BlockChain) getProcInterrupt() bool {
 	return atomic.LoadInt32(&bc.procInterrupt) == 1
 }
@@ -327,6 +880,12 @@ func (bc *BlockChain) blockIsInvalid(b *types.Block) error {
 					return fmt.Errorf("invalid TD=%v for block #%d", td, b.NumberU64())
 				}
 				pTd := bc.GetTd(b.ParentHash())
+				if pTd == nil {
+					if bc.blockIsGenesis(b) {
+						return nil
+					}
+					return fmt.Errorf("missing parent TD for block #%d", b.NumberU64())
+				}
 				externTd := new(big.Int).Add(pTd, b.Difficulty())
 				if gotTd := bc.GetTd(b.Hash()); gotTd != nil && externTd.Cmp(gotTd) != 0 {
 					return fmt.Errorf("invalid TD=%v (want=%v) for block #%d", b.Difficulty(), gotTd, b.NumberU64())
@@ -441,6 +1000,11 @@ func (bc *BlockChain) blockIsInvalid(b *types.Block) error {
 		return e
 	}
 
+	// In a pruned database most blocks have no state, so its absence says nothing.
+	if IsStatePruned(bc.chainDb) {
+		return nil
+	}
+
 	// Separate checks for fast/full blocks.
 	//
 	// Assume state is not missing.
@@ -455,10 +1019,40 @@ func (bc *BlockChain) blockIsInvalid(b *types.Block) error {
 	return fullBlockCheck(b)
 }
 
+// VerifyRange walks the canonical chain from block 'from' to block 'to' (inclusive) and runs
+// the same health checks used by Recovery on each block: transaction and uncle roots, total
+// difficulty continuity, and state presence. It does not modify the chain.
+// It returns the first offending block and the reason it failed, or nil, nil if every block in
+// the range is healthy. If a canonical block is missing, the returned block is nil.
+func (bc *BlockChain) VerifyRange(from, to uint64) (*types.Block, error) {
+	if from > to {
+		return nil, fmt.Errorf("invalid range: from=%d > to=%d", from, to)
+	}
+	for n := from; n <= to; n++ {
+		b := bc.GetBlockByNumber(n)
+		if b == nil {
+			return nil, fmt.Errorf("missing canonical block #%d", n)
+		}
+		if td := bc.GetTd(b.Hash()); td == nil {
+			return b, fmt.Errorf("missing TD for block #%d", n)
+		}
+		if err := bc.blockIsInvalid(b); err != nil {
+			return b, err
+		}
+	}
+	return nil, nil
+}
+
 // Recovery progressively validates the health of stored blockchain data.
 // Soft resets should only be called in case of probable corrupted or invalid stored data,
 // and which are invalid for known or expected reasons.
 // It requires that the blockchain state be loaded so that cached head values are available, eg CurrentBlock(), etc.
+//
+// Checks begin at block 'from' and step by approximately 'increment' blocks; increments > 1 are randomized.
+// Blocks below 'from' are assumed to be healthy, so a 'from' value that is too large may skip real corruption.
+// Once an unhealthy or missing block is found, checks resume block-by-block (increment=1) from the block
+// after the last healthy checkpoint.
+// The returned checkpoint is always a block which passed the checks, or 0 if none did.
 func (bc *BlockChain) Recovery(from int, increment int) (checkpoint uint64) {
 
 	// Function for random dynamic incremental stepping through recoverable blocks.
@@ -474,8 +1068,21 @@ func (bc *BlockChain) Recovery(from int, increment int) (checkpoint uint64) {
 		return i - int(ri)
 	}
 
-	if from > 1 {
-		checkpoint = uint64(from)
+	// honeIn checks block-by-block from the block after the last healthy checkpoint up to
+	// the failing block i, and returns the highest healthy checkpoint found.
+	honeIn := func(i int) uint64 {
+		next := from
+		if checkpoint > 0 {
+			next = int(checkpoint) + 1
+		}
+		if next >= i {
+			return checkpoint
+		}
+		glog.V(logger.Debug).Warnf("Retrying recovery beginning from #%d, incrementing +%d", next, 1)
+		if c := bc.Recovery(next, 1); c > checkpoint {
+			return c
+		}
+		return checkpoint
 	}
 
 	// Hold setting if should randomize incrementing.
@@ -513,9 +1120,9 @@ func (bc *BlockChain) Recovery(from int, increment int) (checkpoint uint64) {
 		// If block does not exist in db.
 		if checkpointBlockNext == nil {
 			// Traverse in small steps (increment =1) from last known big step (increment >1) checkpoint.
-			if increment > 1 && i-increment > 1 {
-				glog.V(logger.Debug).Warnf("Reached nil block #%d, retrying recovery beginning from #%d, incrementing +%d", i, i-increment, 1)
-				return bc.Recovery(i-increment, 1) // hone in
+			if increment > 1 {
+				glog.V(logger.Debug).Warnf("Reached nil block #%d", i)
+				return honeIn(i)
 			}
 			glog.V(logger.Debug).Warnf("No block data available for block #%d", uint64(i))
 			break
@@ -548,7 +1155,7 @@ func (bc *BlockChain) Recovery(from int, increment int) (checkpoint uint64) {
 		if increment == 1 {
 			break
 		}
-		return bc.Recovery(i-increment, 1)
+		return honeIn(i)
 	}
 	if checkpoint > 0 {
 		glog.V(logger.Warn).Warnf("Found recoverable blockchain data through block #%d", checkpoint)
@@ -608,6 +1215,21 @@ func (bc *BlockChain) LoadLastState(dryrun bool) error {
 		return errors.New("nil currentBlock")
 	}
 
+	// In a pruned database only some block states were written, the state of the head
+	// block may have been lost on an unclean shutdown. Resume from the last block with state;
+	// the blocks above it are kept and reprocessed.
+	if IsStatePruned(bc.chainDb) && !bc.HasBlockAndState(currentBlock.Hash()) {
+		if ancestor := bc.stateAncestor(currentBlock); ancestor != nil {
+			glog.V(logger.Warn).Infof("Head block #%d [%x…] has no state, rewinding to #%d [%x…]", currentBlock.Number(), currentBlock.Hash().Bytes()[:4], ancestor.Number(), ancestor.Hash().Bytes()[:4])
+			currentBlock = ancestor
+			if !dryrun {
+				if err := WriteHeadBlockHash(bc.chainDb, currentBlock.Hash()); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
 	// If currentBlock (fullblock) is not genesis, check that it is valid
 	// and that it has a state associated with it.
 	if currentBlock.Number().Cmp(new(big.Int)) > 0 {
@@ -725,7 +1347,7 @@ func (bc *BlockChain) LoadLastState(dryrun bool) error {
 	}
 
 	// Initialize a statedb cache to ensure singleton account bloom filter generation
-	statedb, err := state.New(bc.currentBlock.Root(), state.NewDatabase(bc.chainDb))
+	statedb, err := state.New(bc.currentBlock.Root(), state.NewDatabase(bc.stateDatabase()))
 	if err != nil {
 		return err
 	}
@@ -759,6 +1381,9 @@ func (bc *BlockChain) LoadLastState(dryrun bool) error {
 // it removes all stored blockchain data n -> *anyexistingblockdata*
 // TODO: possibly replace with kv database iterator
 func (bc *BlockChain) PurgeAbove(n uint64) {
+	if bc.readOnly {
+		panic(ErrReadOnlyChain)
+	}
 	bc.mu.Lock()
 
 	delFn := func(hash common.Hash) {
@@ -768,11 +1393,108 @@ func (bc *BlockChain) PurgeAbove(n uint64) {
 	bc.mu.Unlock()
 }
 
+// pruneBatchSize is the number of side chain bodies PruneSideChains deletes per
+// acquisition of the chain insertion lock.
+const pruneBatchSize = 1024
+
+// PruneSideChains deletes the stored bodies of non-canonical blocks numbered below
+// belowNumber and returns the number of bodies deleted. Headers, total difficulties
+// and receipts are kept. belowNumber is capped at the lower of the current block and
+// current fast block, whose ancestors are all canonical, so no body they depend on is deleted.
+//
+// The database is scanned without holding the chain locks. The bodies found are deleted
+// in batches, each under the insertion lock, skipping blocks which became canonical.
+func (bc *BlockChain) PruneSideChains(belowNumber uint64) (int, error) {
+	if bc.readOnly {
+		return 0, ErrReadOnlyChain
+	}
+	bc.mu.RLock()
+	currentBlock, currentFastBlock := bc.currentBlock, bc.currentFastBlock
+	bc.mu.RUnlock()
+
+	if n := currentBlock.NumberU64(); belowNumber > n {
+		belowNumber = n
+	}
+	if n := currentFastBlock.NumberU64(); belowNumber > n {
+		belowNumber = n
+	}
+	protected := map[common.Hash]bool{
+		currentBlock.Hash():     true,
+		currentFastBlock.Hash(): true,
+	}
+
+	type sideBlock struct {
+		hash   common.Hash
+		number uint64
+	}
+	var candidates []sideBlock
+	visit := func(key []byte) {
+		hash, ok := bodyKeyHash(key)
+		if !ok || protected[hash] {
+			return
+		}
+		header := bc.GetHeader(hash)
+		if header == nil {
+			return
+		}
+		number := header.Number.Uint64()
+		if number < belowNumber && GetCanonicalHash(bc.chainDb, number) != hash {
+			candidates = append(candidates, sideBlock{hash, number})
+		}
+	}
+	switch db := bc.chainDb.(type) {
+	case *ethdb.LDBDatabase:
+		it := db.NewIteratorRange(ethdb.NewBytesPrefix(blockPrefix))
+		for it.Next() {
+			visit(it.Key())
+		}
+		it.Release()
+		if err := it.Error(); err != nil {
+			return 0, err
+		}
+	case *ethdb.MemDatabase:
+		for _, key := range db.Keys() {
+			visit(key)
+		}
+	default:
+		return 0, fmt.Errorf("pruning not supported for database type %T", bc.chainDb)
+	}
+
+	pruned := 0
+	for len(candidates) > 0 {
+		batch := candidates
+		if len(batch) > pruneBatchSize {
+			batch = batch[:pruneBatchSize]
+		}
+		candidates = candidates[len(batch):]
+
+		bc.chainmu.Lock()
+		for _, block := range batch {
+			// The block may have become canonical in a reorganisation since the scan.
+			if GetCanonicalHash(bc.chainDb, block.number) == block.hash {
+				continue
+			}
+			DeleteBody(bc.chainDb, block.hash)
+			bc.bodyCache.Remove(block.hash)
+			bc.bodyRLPCache.Remove(block.hash)
+			bc.blockCache.Remove(block.hash)
+			pruned++
+		}
+		bc.chainmu.Unlock()
+	}
+
+	glog.V(logger.Info).Infof("Pruned %d side chain block bodies below #%d", pruned, belowNumber)
+	return pruned, nil
+}
+
 // SetHead rewinds the local chain to a new head. In the case of headers, everything
 // above the new head will be deleted and the new one set. In the case of blocks
 // though, the head may be further rewound if block bodies are missing (non-archive
 // nodes after a fast sync).
 func (bc *BlockChain) SetHead(head uint64) error {
+	if bc.readOnly {
+		return ErrReadOnlyChain
+	}
 	glog.V(logger.Warn).Infof("Setting blockchain head, target: %v", head)
 
 	bc.mu.Lock()
@@ -794,9 +1516,14 @@ func (bc *BlockChain) SetHead(head uint64) error {
 		bc.currentBlock = bc.GetBlock(currentHeader.Hash())
 	}
 	if bc.currentBlock != nil {
-		if _, err := state.New(bc.currentBlock.Root(), state.NewDatabase(bc.chainDb)); err != nil {
-			// Rewound state missing, rolled back to before pivot, reset to genesis
-			bc.currentBlock = nil
+		if _, err := state.New(bc.currentBlock.Root(), state.NewDatabase(bc.stateDatabase())); err != nil {
+			if IsStatePruned(bc.chainDb) {
+				// Rewound state pruned, roll back to the last block with state
+				bc.currentBlock = bc.stateAncestor(bc.currentBlock)
+			} else {
+				// Rewound state missing, rolled back to before pivot, reset to genesis
+				bc.currentBlock = nil
+			}
 		}
 	}
 	// Rewind the fast block in a simpleton way to the target head
@@ -873,23 +1600,124 @@ func (bc *BlockChain) SetHead(head uint64) error {
 // FastSyncCommitHead sets the current head block to the one defined by the hash
 // irrelevant what the chain contents were prior.
 func (bc *BlockChain) FastSyncCommitHead(hash common.Hash) error {
-	// Make sure that both the block as well at its state trie exists
-	block := bc.GetBlock(hash)
-	if block == nil {
-		return fmt.Errorf("non existent block [%x…]", hash[:4])
+	if bc.readOnly {
+		return ErrReadOnlyChain
 	}
-	if _, err := trie.NewSecure(block.Root(), bc.chainDb, 0); err != nil {
+	// Make sure that both the block as well at its state trie exists
+	block, statedb, err := bc.blockAndState(hash)
+	if err != nil {
 		return err
 	}
+	if statedb == nil {
+		return fmt.Errorf("missing state of block #%d [%x…]", block.Number(), hash[:4])
+	}
 	// If all checks out, manually set the head block
 	bc.mu.Lock()
-	bc.currentBlock = block
+	err = bc.setHeadBlock(block, statedb)
 	bc.mu.Unlock()
+	if err != nil {
+		return err
+	}
 
 	glog.V(logger.Info).Infof("committed block #%d [%x…] as new head", block.Number(), hash[:4])
 	return nil
 }
 
+// blockAndState returns the stored block with the given hash and its state, which is
+// nil if the state is not available.
+func (bc *BlockChain) blockAndState(hash common.Hash) (*types.Block, *state.StateDB, error) {
+	block := bc.GetBlock(hash)
+	if block == nil {
+		return nil, nil, fmt.Errorf("non existent block [%x…]", hash[:4])
+	}
+	statedb, err := state.New(block.Root(), state.NewDatabase(bc.stateDatabase()))
+	if err != nil {
+		return block, nil, nil
+	}
+	return block, statedb, nil
+}
+
+// setHeadBlock writes the head block pointer and makes the block, whose state is statedb,
+// the current block. It assumes that the chain manager mutex is held.
+func (bc *BlockChain) setHeadBlock(block *types.Block, statedb *state.StateDB) error {
+	if err := WriteHeadBlockHash(bc.chainDb, block.Hash()); err != nil {
+		return err
+	}
+	bc.currentBlock = block
+	bc.stateCache = statedb
+	return nil
+}
+
+// SetHeadTo moves the head of the chain to the stored canonical block with the given hash,
+// in either direction, rewriting the head header, block and fast block pointers and the atxi
+// bookmark. It can be used to recover head pointers which are behind the actual stored chain
+// data. When moving backward, the canonical numbering and the transaction and receipt lookups
+// of the blocks above the target are deleted, as by SetHead, but their headers and bodies are kept.
+// If the target block has state, both the full and fast heads are moved to it. Otherwise it must
+// be a valid fast block above the current full block, and only the fast head is moved.
+func (bc *BlockChain) SetHeadTo(hash common.Hash) error {
+	if bc.readOnly {
+		return ErrReadOnlyChain
+	}
+	bc.chainmu.Lock()
+	defer bc.chainmu.Unlock()
+
+	block, statedb, err := bc.blockAndState(hash)
+	if err != nil {
+		return err
+	}
+	number := block.NumberU64()
+	if canon := GetCanonicalHash(bc.chainDb, number); canon != hash {
+		return fmt.Errorf("block #%d [%x…] is not canonical", number, hash[:4])
+	}
+	if err := bc.blockIsInvalid(block); err != nil {
+		return fmt.Errorf("invalid block #%d [%x…]: %v", number, hash[:4], err)
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if statedb == nil && bc.currentBlock.NumberU64() > number {
+		return fmt.Errorf("block #%d [%x…] has no state and is below current full block #%d", number, hash[:4], bc.currentBlock.NumberU64())
+	}
+
+	// Moving backward, drop the canonical chain above the target
+	if number < bc.hc.CurrentHeader().Number.Uint64() {
+		for n := number + 1; ; n++ {
+			canon := GetCanonicalHash(bc.chainDb, n)
+			if canon == (common.Hash{}) {
+				break
+			}
+			if err := bc.deleteBlockTransactions(canon); err != nil {
+				return err
+			}
+			DeleteCanonicalHash(bc.chainDb, n)
+		}
+		bc.blockCache.Purge()
+	}
+
+	bc.hc.SetCurrentHeader(block.Header())
+	if statedb != nil {
+		if err := bc.setHeadBlock(block, statedb); err != nil {
+			return err
+		}
+	}
+	if err := WriteHeadFastBlockHash(bc.chainDb, hash); err != nil {
+		return err
+	}
+	bc.currentFastBlock = block
+	bc.futureBlocks.Purge()
+
+	if bc.atxi != nil && bc.atxi.AutoMode {
+		if err := bc.atxi.SetATXIBookmark(number); err != nil {
+			return err
+		}
+	}
+
+	glog.V(logger.Warn).Infof("Set head to block #%d [%x…] (full=%v)", block.Number(), hash[:4], statedb != nil)
+	return nil
+}
+
 // GasLimit returns the gas limit of the current HEAD block.
 func (bc *BlockChain) GasLimit() *big.Int {
 	bc.mu.RLock()
@@ -939,6 +1767,52 @@ func (bc *BlockChain) SetProcessor(processor Processor) {
 	bc.processor = processor
 }
 
+// BadBlock is a block rejected by InsertChain, along with the reason for its rejection.
+type BadBlock struct {
+	Header *types.Header
+	Reason error
+}
+
+// reportBadBlock records a block rejected by InsertChain as bad.
+func (bc *BlockChain) reportBadBlock(block *types.Block, err error) {
+	bc.badBlocks.Add(block.Hash(), &BadBlock{Header: block.Header(), Reason: err})
+}
+
+// BadBlocks returns the most recent blocks rejected by InsertChain during this run,
+// oldest first. Blocks rejected because they are known bad hashes of the chain
+// configuration are included.
+func (bc *BlockChain) BadBlocks() []*BadBlock {
+	var blocks []*BadBlock
+	for _, hash := range bc.badBlocks.Keys() {
+		if bad, exist := bc.badBlocks.Peek(hash); exist {
+			blocks = append(blocks, bad.(*BadBlock))
+		}
+	}
+	return blocks
+}
+
+// SetProcessorFactory sets a factory which is consulted for the processor of each block
+// inserted with InsertChain, allowing different processors to be used for different block
+// ranges, eg. to differential test VM implementations. Blocks for which the factory returns
+// nil are processed with the processor set by SetProcessor. A nil factory removes it.
+func (bc *BlockChain) SetProcessorFactory(factory ProcessorFactory) {
+	bc.procmu.Lock()
+	defer bc.procmu.Unlock()
+	bc.processorFactory = factory
+}
+
+// processorAt returns the processor to use for the block with the given number.
+func (bc *BlockChain) processorAt(number *big.Int) Processor {
+	bc.procmu.RLock()
+	defer bc.procmu.RUnlock()
+	if bc.processorFactory != nil {
+		if p := bc.processorFactory(number); p != nil {
+			return p
+		}
+	}
+	return bc.processor
+}
+
 // SetValidator sets the validator which is used to validate incoming blocks.
 func (bc *BlockChain) SetValidator(validator Validator) {
 	bc.procmu.Lock()
@@ -969,8 +1843,28 @@ func (bc *BlockChain) State() (*state.StateDB, error) {
 }
 
 // StateAt returns a new mutable state based on a particular point in time.
+// If the state is not in the database it returns a *StateUnavailableErr.
 func (bc *BlockChain) StateAt(root common.Hash) (*state.StateDB, error) {
-	return state.New(root, state.NewDatabase(bc.chainDb))
+	statedb, err := state.New(root, state.NewDatabase(bc.stateDatabase()))
+	if missing, ok := err.(*trie.MissingNodeError); ok {
+		return nil, &StateUnavailableErr{Root: root, Err: missing}
+	}
+	return statedb, err
+}
+
+func (bc *BlockChain) stateDatabase() ethdb.Database {
+	if bc.gcDb != nil {
+		return bc.gcDb
+	}
+	return bc.chainDb
+}
+
+// GCMode returns which block states are written to the database.
+func (bc *BlockChain) GCMode() GCMode {
+	if bc.gcDb != nil {
+		return GCModeFull
+	}
+	return GCModeArchive
 }
 
 // Reset purges the entire blockchain, restoring it to its genesis state.
@@ -981,6 +1875,9 @@ func (bc *BlockChain) Reset() error {
 // ResetWithGenesisBlock purges the entire blockchain, restoring it to the
 // specified genesis state.
 func (bc *BlockChain) ResetWithGenesisBlock(genesis *types.Block) error {
+	if bc.readOnly {
+		return ErrReadOnlyChain
+	}
 	// Dump the entire block chain and purge the caches
 	if err := bc.SetHead(0); err != nil {
 		return err
@@ -1038,6 +1935,36 @@ func (bc *BlockChain) ExportN(w io.Writer, first uint64, last uint64) error {
 	return nil
 }
 
+// ExportGzip writes the active chain to the given writer as a gzip compressed
+// RLP stream.
+func (bc *BlockChain) ExportGzip(w io.Writer) error {
+	return bc.ExportNGzip(w, uint64(0), bc.currentBlock.NumberU64())
+}
+
+// ExportNGzip writes a subset of the active chain to the given writer as a gzip
+// compressed RLP stream. Compressed exports appended to one another form a valid
+// multi-member gzip stream.
+func (bc *BlockChain) ExportNGzip(w io.Writer, first uint64, last uint64) error {
+	zw := gzip.NewWriter(w)
+	if err := bc.ExportN(zw, first, last); err != nil {
+		zw.Close()
+		return err
+	}
+	return zw.Close()
+}
+
+// NewChainReader returns a reader of the RLP block stream in r, as written by
+// Export or ExportGzip. A gzip compressed stream is detected by its magic bytes
+// and transparently decompressed.
+func NewChainReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(br)
+	}
+	return br, nil
+}
+
 // insert injects a new head block into the current block chain. This method
 // assumes that the block is indeed a true head. It will also reset the head
 // header and the head fast sync block to this very same block if they are older
@@ -1082,7 +2009,7 @@ func (bc *BlockChain) GetBody(hash common.Hash) *types.Body {
 		return body
 	}
 	body := GetBody(bc.chainDb, hash)
-	if body == nil {
+	if body == nil || !bc.checkStoredBody(hash, nil, body) {
 		return nil
 	}
 	// Cache the found body for next time and return
@@ -1101,6 +2028,16 @@ func (bc *BlockChain) GetBodyRLP(hash common.Hash) rlp.RawValue {
 	if len(body) == 0 {
 		return nil
 	}
+	if bc.BodyVerification() {
+		decoded := new(types.Body)
+		if err := rlp.DecodeBytes(body, decoded); err != nil {
+			glog.V(logger.Error).Errorf("Found undecodable block body: hash=%x: %v", hash, err)
+			return nil
+		}
+		if !bc.checkStoredBody(hash, nil, decoded) {
+			return nil
+		}
+	}
 	// Cache the found body for next time and return
 	bc.bodyRLPCache.Add(hash, body)
 	return body
@@ -1112,6 +2049,16 @@ func (bc *BlockChain) HasBlock(hash common.Hash) bool {
 	return bc.GetBlock(hash) != nil
 }
 
+// HasCanonicalBlock reports whether the block with the given hash and number is on the
+// canonical chain at or below the current block, which implies that it and all of its
+// ancestors are fully present. Unlike HasBlock it does not load the block.
+func (bc *BlockChain) HasCanonicalBlock(hash common.Hash, number uint64) bool {
+	if number > bc.CurrentBlock().NumberU64() {
+		return false
+	}
+	return bc.GetCanonicalHash(number) == hash
+}
+
 // HasBlockAndState checks if a block and associated state trie is fully present
 // in the database or not, caching it if present.
 func (bc *BlockChain) HasBlockAndState(hash common.Hash) bool {
@@ -1121,10 +2068,22 @@ func (bc *BlockChain) HasBlockAndState(hash common.Hash) bool {
 		return false
 	}
 	// Ensure the associated state is also present
-	_, err := state.New(block.Root(), state.NewDatabase(bc.chainDb))
+	_, err := state.New(block.Root(), state.NewDatabase(bc.stateDatabase()))
 	return err == nil
 }
 
+// stateAncestor returns the closest ancestor of the given block whose state is available,
+// or nil if there is none.
+func (bc *BlockChain) stateAncestor(block *types.Block) *types.Block {
+	for block != nil && block.NumberU64() > 0 {
+		block = bc.GetBlock(block.ParentHash())
+		if block != nil && bc.HasBlockAndState(block.Hash()) {
+			return block
+		}
+	}
+	return block
+}
+
 // GetBlock retrieves a block from the database by hash, caching it if found.
 func (bc *BlockChain) GetBlock(hash common.Hash) *types.Block {
 	// Short circuit if the block's already in the cache, retrieve otherwise
@@ -1132,7 +2091,7 @@ func (bc *BlockChain) GetBlock(hash common.Hash) *types.Block {
 		return block.(*types.Block)
 	}
 	block := GetBlock(bc.chainDb, hash)
-	if block == nil {
+	if block == nil || !bc.checkStoredBody(hash, block.Header(), block.Body()) {
 		return nil
 	}
 	// Cache the found block for next time and return
@@ -1140,16 +2099,40 @@ func (bc *BlockChain) GetBlock(hash common.Hash) *types.Block {
 	return block
 }
 
+// GetCanonicalHash returns the hash of the canonical block with the given number
+// without loading the block, or the zero hash if the number is not known.
+func (bc *BlockChain) GetCanonicalHash(number uint64) common.Hash {
+	return GetCanonicalHash(bc.chainDb, number)
+}
+
 // GetBlockByNumber retrieves a block from the database by number, caching it
 // (associated with its hash) if found.
 func (bc *BlockChain) GetBlockByNumber(number uint64) *types.Block {
-	hash := GetCanonicalHash(bc.chainDb, number)
+	hash := bc.GetCanonicalHash(number)
 	if hash == (common.Hash{}) {
 		return nil
 	}
 	return bc.GetBlock(hash)
 }
 
+// GetBlockByNumberOrHash retrieves a block by hash if hash is given, or else the canonical
+// block by number. If requireCanonical is set and the block found by hash is not canonical,
+// an error is returned. Block number tags, such as "pending", are resolved by the caller.
+// It returns nil when no block could be found.
+func (bc *BlockChain) GetBlockByNumberOrHash(number uint64, hash *common.Hash, requireCanonical bool) (*types.Block, error) {
+	if hash == nil {
+		return bc.GetBlockByNumber(number), nil
+	}
+	block := bc.GetBlock(*hash)
+	if block == nil {
+		return nil, nil
+	}
+	if requireCanonical && bc.GetCanonicalHash(block.NumberU64()) != *hash {
+		return nil, fmt.Errorf("block %x is not canonical", *hash)
+	}
+	return block, nil
+}
+
 // [deprecated by eth/62]
 // GetBlocksFromHash returns the block corresponding to hash and up to n-1 ancestors.
 func (bc *BlockChain) GetBlocksFromHash(hash common.Hash, n int) (blocks []*types.Block) {
@@ -1164,6 +2147,82 @@ func (bc *BlockChain) GetBlocksFromHash(hash common.Hash, n int) (blocks []*type
 	return
 }
 
+// AncestorHashes returns the hash of the given block followed by the hashes of up to
+// depth of its ancestors, ending early at the genesis block. Only headers are read.
+// If a parent header is missing, the hashes found so far are returned; the result is
+// nil if the given block is unknown.
+func (bc *BlockChain) AncestorHashes(hash common.Hash, depth uint64) []common.Hash {
+	header := bc.GetHeader(hash)
+	if header == nil {
+		return nil
+	}
+	hashes := []common.Hash{hash}
+	for i := uint64(0); i < depth && header.Number.Sign() > 0; i++ {
+		header = bc.GetHeader(header.ParentHash)
+		if header == nil {
+			break
+		}
+		hashes = append(hashes, header.Hash())
+	}
+	return hashes
+}
+
+// WarmCache loads the headers, total difficulties, bodies and blocks of the canonical
+// blocks from..to into their caches, so that the first queries of a known hot range
+// after a restart are served from memory. The range is capped at the current block.
+// For each cache only the highest blocks of the range which fit into it are loaded,
+// so a range larger than a cache does not evict what the warmup itself loaded.
+// It returns the number of blocks whose data was loaded.
+func (bc *BlockChain) WarmCache(from, to uint64) int {
+	if head := bc.CurrentBlock().NumberU64(); to > head {
+		to = head
+	}
+	if from > to {
+		return 0
+	}
+	// lowest returns the first block of the range which fits into a cache of the given size
+	lowest := func(limit int) uint64 {
+		if to-from >= uint64(limit) {
+			return to - uint64(limit) + 1
+		}
+		return from
+	}
+	var (
+		headerFrom = lowest(bc.cacheConfig.HeaderCacheLimit)
+		tdFrom     = lowest(bc.cacheConfig.TdCacheLimit)
+		bodyFrom   = lowest(bc.cacheConfig.BodyCacheLimit)
+		blockFrom  = lowest(bc.cacheConfig.BlockCacheLimit)
+	)
+	start := headerFrom
+	for _, n := range []uint64{tdFrom, bodyFrom, blockFrom} {
+		if n < start {
+			start = n
+		}
+	}
+	warmed := 0
+	for number := start; number <= to; number++ {
+		hash := bc.GetCanonicalHash(number)
+		if hash == (common.Hash{}) {
+			continue
+		}
+		if number >= headerFrom {
+			bc.GetHeader(hash)
+		}
+		if number >= tdFrom {
+			bc.GetTd(hash)
+		}
+		if number >= bodyFrom {
+			bc.GetBody(hash)
+			bc.GetBodyRLP(hash)
+		}
+		if number >= blockFrom {
+			bc.GetBlock(hash)
+		}
+		warmed++
+	}
+	return warmed
+}
+
 // GetUnclesInChain retrieves all the uncles from a given block backwards until
 // a specific distance is reached.
 func (bc *BlockChain) GetUnclesInChain(block *types.Block, length int) []*types.Header {
@@ -1176,7 +2235,8 @@ func (bc *BlockChain) GetUnclesInChain(block *types.Block, length int) []*types.
 }
 
 // Stop stops the blockchain service. If any imports are currently in progress
-// it will abort them using the procInterrupt.
+// it will abort them using the procInterrupt. In GCModeFull the state of the
+// current block is written to the database.
 func (bc *BlockChain) Stop() {
 	if !atomic.CompareAndSwapInt32(&bc.running, 0, 1) {
 		return
@@ -1186,6 +2246,14 @@ func (bc *BlockChain) Stop() {
 
 	bc.wg.Wait()
 
+	if bc.gcDb != nil {
+		bc.chainmu.Lock()
+		if err := bc.gcDb.flush(bc.CurrentBlock().Root()); err != nil {
+			glog.V(logger.Error).Errorf("Failed to write head state to database: %v", err)
+		}
+		bc.chainmu.Unlock()
+	}
+
 	glog.V(logger.Info).Infoln("Chain manager stopped")
 }
 
@@ -1199,7 +2267,15 @@ const (
 
 // Rollback is designed to remove a chain of links from the database that aren't
 // certain enough to be valid.
+//
+// Rollback is destructive: besides rewinding the head pointers, the transaction
+// and receipt lookups and the address-transaction indexes of the transactions of
+// the blocks rolled back from the head (fast) block are deleted, just like a
+// reorganisation does for the transactions dropped from the canonical chain.
 func (bc *BlockChain) Rollback(chain []common.Hash) {
+	if bc.readOnly {
+		panic(ErrReadOnlyChain)
+	}
 	bc.mu.Lock()
 	defer bc.mu.Unlock()
 
@@ -1209,25 +2285,163 @@ func (bc *BlockChain) Rollback(chain []common.Hash) {
 		if bc.hc.CurrentHeader().Hash() == hash {
 			bc.hc.SetCurrentHeader(bc.GetHeader(bc.hc.CurrentHeader().ParentHash))
 		}
+		rolled := false
 		if bc.currentFastBlock.Hash() == hash {
 			bc.currentFastBlock = bc.GetBlock(bc.currentFastBlock.ParentHash())
 			if err := WriteHeadFastBlockHash(bc.chainDb, bc.currentFastBlock.Hash()); err != nil {
 				glog.Fatalf("failed to write fast head block hash: %v", err)
 			}
+			rolled = true
 		}
 		if bc.currentBlock.Hash() == hash {
 			bc.currentBlock = bc.GetBlock(bc.currentBlock.ParentHash())
 			if err := WriteHeadBlockHash(bc.chainDb, bc.currentBlock.Hash()); err != nil {
 				glog.Fatalf("failed to write head block hash: %v", err)
 			}
+			rolled = true
+		}
+		if !rolled {
+			continue
+		}
+		if err := bc.deleteBlockTransactions(hash); err != nil {
+			glog.V(logger.Error).Errorf("failed to remove transactions of rolled back block %x: %v", hash, err)
+		}
+	}
+}
+
+// deleteBlockTransactions removes the transaction and receipt lookups, and the
+// address-transaction indexes if enabled, of the transactions of the block with
+// the given hash. Transactions whose lookup refers to another block, i.e. which
+// were also included in another chain, are left untouched.
+func (bc *BlockChain) deleteBlockTransactions(hash common.Hash) error {
+	block := bc.GetBlock(hash)
+	if block == nil {
+		return nil
+	}
+	for _, tx := range block.Transactions() {
+		if _, blockHash, _, _ := GetTransaction(bc.chainDb, tx.Hash()); blockHash != hash {
+			continue
+		}
+		DeleteReceipt(bc.chainDb, tx.Hash())
+		DeleteTransaction(bc.chainDb, tx.Hash())
+		if bc.atxi != nil {
+			if err := RmAddrTx(bc.atxi.Db, tx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// setReceiptsData computes all the non-consensus fields of the receipts of the given block.
+func setReceiptsData(config *ChainConfig, block *types.Block, receipts types.Receipts) {
+	signer := config.GetSigner(block.Number())
+	transactions, logIndex := block.Transactions(), uint(0)
+	for j := 0; j < len(receipts); j++ {
+		// The transaction hash can be retrieved from the transaction itself
+		receipts[j].TxHash = transactions[j].Hash()
+		tx := transactions[j]
+		from, _ := types.Sender(signer, tx)
+
+		// The contract address can be derived from the transaction itself
+		if MessageCreatesContract(transactions[j]) {
+			receipts[j].ContractAddress = crypto.CreateAddress(from, tx.Nonce())
+		}
+		// The used gas can be calculated based on previous receipts
+		if j == 0 {
+			receipts[j].GasUsed = new(big.Int).Set(receipts[j].CumulativeGasUsed)
+		} else {
+			receipts[j].GasUsed = new(big.Int).Sub(receipts[j].CumulativeGasUsed, receipts[j-1].CumulativeGasUsed)
+		}
+		// The derived log fields can simply be set from the block and transaction
+		for k := 0; k < len(receipts[j].Logs); k++ {
+			receipts[j].Logs[k].BlockNumber = block.NumberU64()
+			receipts[j].Logs[k].BlockHash = block.Hash()
+			receipts[j].Logs[k].TxHash = receipts[j].TxHash
+			receipts[j].Logs[k].TxIndex = uint(j)
+			receipts[j].Logs[k].Index = logIndex
+			logIndex++
+		}
+	}
+}
+
+// GetReceiptsByBlockHash retrieves the receipts of all transactions in the block with the
+// given hash, with their non-consensus fields (transaction hash, gas used, contract address
+// and log positions) populated. It returns nil if the block or its receipts are not known.
+func (bc *BlockChain) GetReceiptsByBlockHash(hash common.Hash) types.Receipts {
+	block := bc.GetBlock(hash)
+	if block == nil {
+		return nil
+	}
+	receipts := GetBlockReceipts(bc.chainDb, hash)
+	if receipts == nil || len(receipts) != len(block.Transactions()) {
+		return nil
+	}
+	setReceiptsData(bc.config, block, receipts)
+	return receipts
+}
+
+// GetLogsByBlockHash retrieves the logs of all transactions in the block with the given hash,
+// grouped by transaction in block order. Their derived fields are populated the same way as
+// those of the receipts returned by GetReceiptsByBlockHash, so log indexes are block-wide.
+// It returns nil if the block or its receipts are not known.
+func (bc *BlockChain) GetLogsByBlockHash(hash common.Hash) [][]*vm.Log {
+	receipts := bc.GetReceiptsByBlockHash(hash)
+	if receipts == nil {
+		return nil
+	}
+	logs := make([][]*vm.Log, len(receipts))
+	for i, receipt := range receipts {
+		logs[i] = receipt.Logs
+	}
+	return logs
+}
+
+// RederiveReceipts recomputes the non-consensus fields of the stored receipts of the
+// canonical blocks from 'from' to 'to' (inclusive) the same way InsertReceiptChain does,
+// and rewrites them. It repairs databases which stored receipts without derived fields,
+// such as the block number and index of their logs. Blocks without transactions are skipped.
+// It returns the number of blocks whose receipts were rewritten.
+func (bc *BlockChain) RederiveReceipts(from, to uint64) (int, error) {
+	if bc.readOnly {
+		return 0, ErrReadOnlyChain
+	}
+	if from > to {
+		return 0, fmt.Errorf("invalid range: from=%d > to=%d", from, to)
+	}
+	fixed := 0
+	for n := from; n <= to; n++ {
+		block := bc.GetBlockByNumber(n)
+		if block == nil {
+			return fixed, fmt.Errorf("missing canonical block #%d", n)
+		}
+		if len(block.Transactions()) == 0 {
+			continue
+		}
+		receipts := GetBlockReceipts(bc.chainDb, block.Hash())
+		if len(receipts) != len(block.Transactions()) {
+			return fixed, fmt.Errorf("block #%d [%x…] has %d receipts for %d transactions", n, block.Hash().Bytes()[:4], len(receipts), len(block.Transactions()))
+		}
+		setReceiptsData(bc.config, block, receipts)
+		if err := WriteBlockReceipts(bc.chainDb, block.Hash(), receipts); err != nil {
+			return fixed, fmt.Errorf("failed to write block receipts for #%d: %v", n, err)
+		}
+		if err := WriteReceipts(bc.chainDb, receipts); err != nil {
+			return fixed, fmt.Errorf("failed to write individual receipts for #%d: %v", n, err)
 		}
+		fixed++
 	}
+	return fixed, nil
 }
 
 // InsertReceiptChain attempts to complete an already existing header chain with
 // transaction and receipt data.
 func (bc *BlockChain) InsertReceiptChain(blockChain types.Blocks, receiptChain []types.Receipts) (res *ReceiptChainInsertResult) {
 	res = &ReceiptChainInsertResult{}
+	if bc.readOnly {
+		res.Error = ErrReadOnlyChain
+		return
+	}
 
 	bc.wg.Add(1)
 	defer bc.wg.Done()
@@ -1266,78 +2480,59 @@ func (bc *BlockChain) InsertReceiptChain(blockChain types.Blocks, receiptChain [
 				atomic.AddInt32(&stats.ignored, 1)
 				continue
 			}
-			signer := bc.config.GetSigner(block.Number())
 			// Compute all the non-consensus fields of the receipts
-			transactions, logIndex := block.Transactions(), uint(0)
-			for j := 0; j < len(receipts); j++ {
-				// The transaction hash can be retrieved from the transaction itbc
-				receipts[j].TxHash = transactions[j].Hash()
-				tx := transactions[j]
-				from, _ := types.Sender(signer, tx)
-
-				// The contract address can be derived from the transaction itbc
-				if MessageCreatesContract(transactions[j]) {
-					receipts[j].ContractAddress = crypto.CreateAddress(from, tx.Nonce())
-				}
-				// The used gas can be calculated based on previous receipts
-				if j == 0 {
-					receipts[j].GasUsed = new(big.Int).Set(receipts[j].CumulativeGasUsed)
-				} else {
-					receipts[j].GasUsed = new(big.Int).Sub(receipts[j].CumulativeGasUsed, receipts[j-1].CumulativeGasUsed)
-				}
-				// The derived log fields can simply be set from the block and transaction
-				for k := 0; k < len(receipts[j].Logs); k++ {
-					receipts[j].Logs[k].BlockNumber = block.NumberU64()
-					receipts[j].Logs[k].BlockHash = block.Hash()
-					receipts[j].Logs[k].TxHash = receipts[j].TxHash
-					receipts[j].Logs[k].TxIndex = uint(j)
-					receipts[j].Logs[k].Index = logIndex
-					logIndex++
-				}
-			}
-			// Write all the data out into the database
-			if err := WriteBody(bc.chainDb, block.Hash(), block.Body()); err != nil {
-				errs[index] = fmt.Errorf("failed to write block body: %v", err)
-				atomic.AddInt32(&failed, 1)
-				glog.Fatal(errs[index])
-				return
-			}
+			setReceiptsData(bc.config, block, receipts)
+			// Write all the data out into the database. Failures are reported instead of being
+			// fatal so the batch can be retried. The body is written last since it marks the
+			// block as known, so a block with a failed write is not skipped on retry.
 			if err := WriteBlockReceipts(bc.chainDb, block.Hash(), receipts); err != nil {
 				errs[index] = fmt.Errorf("failed to write block receipts: %v", err)
 				atomic.AddInt32(&failed, 1)
-				glog.Fatal(errs[index])
+				glog.Errorln(errs[index])
 				return
 			}
-			if err := WriteMipmapBloom(bc.chainDb, block.NumberU64(), receipts); err != nil {
+			if err := WriteMipmapBloom(bc.chainDb, bc.MipmapLevels(), block.NumberU64(), receipts); err != nil {
 				errs[index] = fmt.Errorf("failed to write log blooms: %v", err)
 				atomic.AddInt32(&failed, 1)
-				glog.Fatal(errs[index])
+				glog.Errorln(errs[index])
 				return
 			}
 			if err := WriteTransactions(bc.chainDb, block); err != nil {
 				errs[index] = fmt.Errorf("failed to write individual transactions: %v", err)
 				atomic.AddInt32(&failed, 1)
-				glog.Fatal(errs[index])
+				glog.Errorln(errs[index])
 				return
 			}
 			if err := WriteReceipts(bc.chainDb, receipts); err != nil {
 				errs[index] = fmt.Errorf("failed to write individual receipts: %v", err)
 				atomic.AddInt32(&failed, 1)
-				glog.Fatal(errs[index])
+				glog.Errorln(errs[index])
 				return
 			}
 			// Store the addr-tx indexes if enabled
 			if bc.atxi != nil {
 				if err := WriteBlockAddTxIndexes(bc.atxi.Db, block); err != nil {
-					glog.Fatalf("failed to write block add-tx indexes, err: %v", err)
+					errs[index] = fmt.Errorf("failed to write block add-tx indexes: %v", err)
+					atomic.AddInt32(&failed, 1)
+					glog.Errorln(errs[index])
+					return
 				}
-				// if buildATXI has been in use (via RPC) and is NOT finished, current < stop
-				// if buildATXI has been in use (via RPC) and IS finished, current == stop
-				// else if builtATXI has not been in use (via RPC), then current == stop == 0
-				if bc.atxi.AutoMode && bc.atxi.Progress.Current == bc.atxi.Progress.Stop {
-					if err := bc.atxi.SetATXIBookmark(block.NumberU64()); err != nil {
-						glog.Fatalln(err)
-					}
+			}
+			if err := WriteBody(bc.chainDb, block.Hash(), block.Body()); err != nil {
+				errs[index] = fmt.Errorf("failed to write block body: %v", err)
+				atomic.AddInt32(&failed, 1)
+				glog.Errorln(errs[index])
+				return
+			}
+			// if buildATXI has been in use (via RPC) and is NOT finished, current < stop
+			// if buildATXI has been in use (via RPC) and IS finished, current == stop
+			// else if builtATXI has not been in use (via RPC), then current == stop == 0
+			if bc.atxi != nil && bc.atxi.AutoMode && bc.atxi.Progress.Current == bc.atxi.Progress.Stop {
+				if err := bc.atxi.SetATXIBookmark(block.NumberU64()); err != nil {
+					errs[index] = fmt.Errorf("failed to write add-tx index bookmark: %v", err)
+					atomic.AddInt32(&failed, 1)
+					glog.Errorln(errs[index])
+					return
 				}
 			}
 			atomic.AddInt32(&stats.processed, 1)
@@ -1368,6 +2563,7 @@ func (bc *BlockChain) InsertReceiptChain(blockChain types.Blocks, receiptChain [
 	// if aborted, db could be closed and the td may not be cached so don't attempt to write bookmark
 	if atomic.LoadInt32(&bc.procInterrupt) == 1 {
 		glog.V(logger.Debug).Infoln("premature abort during receipt chain processing")
+		res.Aborted = true
 		return
 	}
 
@@ -1441,6 +2637,9 @@ func (bc *BlockChain) WriteBlockAddrTxIndexesBatch(indexDb ethdb.Database, start
 
 // WriteBlock writes the block to the chain.
 func (bc *BlockChain) WriteBlock(block *types.Block) (status WriteStatus, err error) {
+	if bc.readOnly {
+		return NonStatTy, ErrReadOnlyChain
+	}
 
 	if logger.MlogEnabled() {
 		defer func() {
@@ -1498,12 +2697,10 @@ func (bc *BlockChain) WriteBlock(block *types.Block) (status WriteStatus, err er
 	// Initialize reorg if incoming TD is greater than local.
 	reorg := tdCompare > 0
 
-	// If TDs are the same, randomize.
+	// If TDs are the same, break the tie by the configured policy, by default by number
+	// and then at random. See TieBreakPolicy for why randomness matters on PoW networks.
 	if tdCompare == 0 {
-		// Reduces the vulnerability to bcish mining.
-		// Please refer to http://www.cs.cornell.edu/~ie53/publications/btcProcFC.pdf
-		// Split same-difficulty blocks by number, then at random
-		reorg = block.NumberU64() < bc.currentBlock.NumberU64() || (block.NumberU64() == bc.currentBlock.NumberU64() && mrand.Float64() < 0.5)
+		reorg = bc.TieBreakPolicy().replaceHead(block.NumberU64(), bc.currentBlock.NumberU64())
 	}
 
 	if reorg {
@@ -1534,7 +2731,23 @@ func (bc *BlockChain) WriteBlock(block *types.Block) (status WriteStatus, err er
 // InsertChain inserts the given chain into the canonical chain or, otherwise, create a fork.
 // If the err return is not nil then chainIndex points to the cause in chain.
 func (bc *BlockChain) InsertChain(chain types.Blocks) (res *ChainInsertResult) {
-	res = &ChainInsertResult{} // initialize
+	return bc.insertChain(chain, bc.pow)
+}
+
+// InsertChainTrusted is like InsertChain, but skips the proof-of-work verification of the
+// blocks. Their headers, bodies and state transitions are still validated.
+// It must only be used for blocks whose seals are known to be valid, such as
+// those of a chain exported from this node.
+func (bc *BlockChain) InsertChainTrusted(chain types.Blocks) (res *ChainInsertResult) {
+	return bc.insertChain(chain, FakePow{})
+}
+
+func (bc *BlockChain) insertChain(chain types.Blocks, checker pow.PoW) (res *ChainInsertResult) {
+	res = &ChainInsertResult{ChainInsertEvent: ChainInsertEvent{GasUsed: new(big.Int)}} // initialize
+	if bc.readOnly {
+		res.Error = ErrReadOnlyChain
+		return
+	}
 	// Do a sanity check that the provided chain is actually ordered and linked
 	for i := 1; i < len(chain); i++ {
 		if chain[i].NumberU64() != chain[i-1].NumberU64()+1 || chain[i].ParentHash() != chain[i-1].Hash() {
@@ -1542,8 +2755,15 @@ func (bc *BlockChain) InsertChain(chain types.Blocks) (res *ChainInsertResult) {
 			glog.V(logger.Error).Infoln("Non contiguous block insert", "number", chain[i].Number(), "hash", chain[i].Hash(),
 				"parent", chain[i].ParentHash(), "prevnumber", chain[i-1].Number(), "prevhash", chain[i-1].Hash())
 
-			res.Error = fmt.Errorf("non contiguous insert: item %d is #%d [%x…], item %d is #%d [%x…] (parent [%x…])", i-1, chain[i-1].NumberU64(),
-				chain[i-1].Hash().Bytes()[:4], i, chain[i].NumberU64(), chain[i].Hash().Bytes()[:4], chain[i].ParentHash().Bytes()[:4])
+			res.Error = &NonContiguousErr{
+				PrevIndex:  i - 1,
+				Index:      i,
+				PrevNumber: chain[i-1].NumberU64(),
+				Number:     chain[i].NumberU64(),
+				PrevHash:   chain[i-1].Hash(),
+				Hash:       chain[i].Hash(),
+				ParentHash: chain[i].ParentHash(),
+			}
 			return
 		}
 	}
@@ -1567,14 +2787,16 @@ func (bc *BlockChain) InsertChain(chain types.Blocks) (res *ChainInsertResult) {
 	)
 
 	// Start the parallel nonce verifier.
-	nonceAbort, nonceResults := verifyNoncesFromBlocks(bc.pow, chain)
+	nonceAbort, nonceResults := verifyNoncesFromBlocks(checker, chain)
 	defer close(nonceAbort)
 
 	txcount := 0
+	gasUsed := new(big.Int)
 	for i, block := range chain {
 		res.Index = i
 		if atomic.LoadInt32(&bc.procInterrupt) == 1 {
 			glog.V(logger.Debug).Infoln("Premature abort during block chain processing")
+			res.Aborted = true
 			break
 		}
 
@@ -1588,12 +2810,15 @@ func (bc *BlockChain) InsertChain(chain types.Blocks) (res *ChainInsertResult) {
 				block := chain[r.index]
 				res.Index = r.index
 				res.Error = &BlockNonceErr{Hash: block.Hash(), Number: block.Number(), Nonce: block.Nonce()}
+				bc.reportBadBlock(block, res.Error)
 				return
 			}
 		}
 
-		if err := bc.config.HeaderCheck(block.Header()); err != nil {
+		if err := bc.hc.headerCheck(block.Header()); err != nil {
+			bc.hc.postBadFork(block.Header(), err)
 			res.Error = err
+			bc.reportBadBlock(block, err)
 			return
 		}
 
@@ -1609,10 +2834,14 @@ func (bc *BlockChain) InsertChain(chain types.Blocks) (res *ChainInsertResult) {
 			if err == BlockFutureErr {
 				// Allow up to MaxFuture second in the future blocks. If this limit
 				// is exceeded the chain is discarded and processed at a later time
-				// if given.
-				max := big.NewInt(time.Now().Unix() + maxTimeFutureBlocks)
-				if block.Time().Cmp(max) == 1 {
-					res.Error = fmt.Errorf("%v: BlockFutureErr, %v > %v", BlockFutureErr, block.Time(), max)
+				// if given. No future blocks are allowed if queueing them is disabled.
+				queue := bc.FutureBlocks()
+				max := big.NewInt(time.Now().Unix())
+				if queue {
+					max.Add(max, big.NewInt(bc.MaxTimeFutureBlocks()))
+				}
+				if !queue || block.Time().Cmp(max) == 1 {
+					res.Error = &FutureBlockErr{Number: block.Number(), Hash: block.Hash(), Time: block.Time(), Max: max}
 					return
 				}
 				bc.futureBlocks.Add(block.Hash(), block)
@@ -1627,6 +2856,7 @@ func (bc *BlockChain) InsertChain(chain types.Blocks) (res *ChainInsertResult) {
 			}
 
 			res.Error = err
+			bc.reportBadBlock(block, err)
 			return
 		}
 
@@ -1642,24 +2872,40 @@ func (bc *BlockChain) InsertChain(chain types.Blocks) (res *ChainInsertResult) {
 		if err != nil {
 			return
 		}
+		bc.stateCache.EnablePreimageRecording(bc.PreimageRecording())
 		// Process block using the parent state as reference point.
-		receipts, logs, usedGas, err := bc.processor.Process(block, bc.stateCache)
+		receipts, logs, usedGas, err := bc.processorAt(block.Number()).Process(block, bc.stateCache)
 		if err != nil {
 			res.Error = err
+			bc.reportBadBlock(block, err)
 			return
 		}
 		// Validate the state using the default validator
 		err = bc.Validator().ValidateState(block, bc.GetBlock(block.ParentHash()), bc.stateCache, receipts, usedGas)
 		if err != nil {
 			res.Error = err
+			bc.reportBadBlock(block, err)
 			return
 		}
 		// Write state changes to database
-		_, err = bc.stateCache.CommitTo(bc.chainDb, false)
+		root, err := bc.commitState(block.NumberU64(), bc.stateCache)
 		if err != nil {
 			res.Error = err
 			return
 		}
+		if bc.StateCommitVerification() {
+			if err := bc.verifyStateCommit(block, root); err != nil {
+				glog.V(logger.Error).Errorf("State verification failed for block #%d [%x…]: %v", block.NumberU64(), block.Hash().Bytes()[:4], err)
+				res.Error = err
+				return
+			}
+		}
+		if bc.PreimageRecording() {
+			if err := WritePreimages(bc.chainDb, block.NumberU64(), bc.stateCache.Preimages()); err != nil {
+				res.Error = err
+				return
+			}
+		}
 
 		// coalesce logs for later processing
 		coalescedLogs = append(coalescedLogs, logs...)
@@ -1695,7 +2941,7 @@ func (bc *BlockChain) InsertChain(chain types.Blocks) (res *ChainInsertResult) {
 				return
 			}
 			// Write map map bloom filters
-			if err := WriteMipmapBloom(bc.chainDb, block.NumberU64(), receipts); err != nil {
+			if err := WriteMipmapBloom(bc.chainDb, bc.MipmapLevels(), block.NumberU64(), receipts); err != nil {
 				res.Error = err
 				return
 			}
@@ -1721,6 +2967,7 @@ func (bc *BlockChain) InsertChain(chain types.Blocks) (res *ChainInsertResult) {
 			}
 			events = append(events, ChainSideEvent{block, logs})
 		}
+		gasUsed.Add(gasUsed, block.GasUsed())
 		stats.processed++
 	}
 
@@ -1729,9 +2976,15 @@ func (bc *BlockChain) InsertChain(chain types.Blocks) (res *ChainInsertResult) {
 		Queued:    stats.queued,
 		Ignored:   stats.ignored,
 		TxCount:   txcount,
+		GasUsed:   gasUsed,
 	}
 	r := &ChainInsertResult{ChainInsertEvent: ev}
 	r.Index = 0 // NOTE/FIXME?(whilei): it's kind of strange that it returns 0 when no error... why not len(blocks)-1?
+	if res.Aborted {
+		// Point to the first block not inserted due to the interrupt
+		r.Index = res.Index
+		r.Aborted = true
+	}
 	if stats.queued > 0 || stats.processed > 0 || stats.ignored > 0 {
 		elapsed := time.Since(tstart)
 		start, end := chain[0], chain[len(chain)-1]
@@ -1749,6 +3002,7 @@ func (bc *BlockChain) InsertChain(chain types.Blocks) (res *ChainInsertResult) {
 				stats.queued,
 				stats.ignored,
 				txcount,
+				gasUsed,
 				end.Number(),
 				start.Hash().Hex(),
 				end.Hash().Hex(),
@@ -1771,14 +3025,164 @@ func (bc *BlockChain) InsertChain(chain types.Blocks) (res *ChainInsertResult) {
 	return r
 }
 
+// InsertChainDryRun validates and processes the given chain exactly like InsertChain, but against a
+// throwaway copy of the blockchain whose database writes are held in memory and discarded.
+// No blocks, receipts, state, transaction or address-transaction indexes are written to the
+// chain database, and no events are posted to the blockchain's event mux.
+// It returns the ChainInsertResult that InsertChain would have returned.
+func (bc *BlockChain) InsertChainDryRun(chain types.Blocks) (res *ChainInsertResult) {
+	// Prevent concurrent writes to the underlying database while the dry run is reading from it.
+	bc.chainmu.RLock()
+	defer bc.chainmu.RUnlock()
+
+	shadow, err := bc.dryRunCopy()
+	if err != nil {
+		return &ChainInsertResult{Error: err}
+	}
+	defer shadow.Stop()
+
+	return shadow.InsertChain(chain)
+}
+
+// SimulationResult is the outcome of processing a block with SimulateBlock.
+type SimulationResult struct {
+	Receipts types.Receipts
+	Logs     vm.Logs
+	UsedGas  *big.Int
+	Root     common.Hash // state root after the block, including rewards
+}
+
+// SimulateBlock processes the transactions of the given block on top of the current head,
+// without validating the block or writing anything to the database. The block is expected
+// to be a hypothetical child of the head; its own parent hash and state root are ignored.
+// The receipts have their non-consensus fields populated.
+func (bc *BlockChain) SimulateBlock(block *types.Block) (*SimulationResult, error) {
+	bc.chainmu.RLock()
+	defer bc.chainmu.RUnlock()
+
+	// The state is never committed, so all of its changes are discarded on return.
+	statedb, err := bc.State()
+	if err != nil {
+		return nil, err
+	}
+	receipts, logs, usedGas, err := bc.processorAt(block.Number()).Process(block, statedb)
+	if err != nil {
+		return nil, err
+	}
+	setReceiptsData(bc.config, block, receipts)
+	return &SimulationResult{
+		Receipts: receipts,
+		Logs:     logs,
+		UsedGas:  usedGas,
+		Root:     statedb.IntermediateRoot(false),
+	}, nil
+}
+
+// dryRunCopy returns a copy of the blockchain at its current head which writes to an in-memory
+// overlay of the chain database. The copy has no address-transaction indexes configured,
+// an unobserved event mux, and does not run the future blocks update loop.
+func (bc *BlockChain) dryRunCopy() (*BlockChain, error) {
+	// The overlay keeps all of the copy's states, so it reads through the in-memory
+	// states of a pruning chain but does not prune itself.
+	db := ethdb.NewOverlayDatabase(bc.stateDatabase())
+	mux := new(event.TypeMux)
+
+	cacheConfig := bc.cacheConfig.withDefaults()
+	cacheConfig.GCMode = GCModeArchive
+	bodyCache, _ := lru.New(cacheConfig.BodyCacheLimit)
+	bodyRLPCache, _ := lru.New(cacheConfig.BodyCacheLimit)
+	blockCache, _ := lru.New(cacheConfig.BlockCacheLimit)
+	futureBlocks, _ := lru.New(maxFutureBlocks)
+	badBlocks, _ := lru.New(badBlockLimit)
+
+	shadow := &BlockChain{
+		config:       bc.config,
+		cacheConfig:  cacheConfig,
+		chainDb:      db,
+		eventMux:     mux,
+		quit:         make(chan struct{}),
+		bodyCache:    bodyCache,
+		bodyRLPCache: bodyRLPCache,
+		blockCache:   blockCache,
+		futureBlocks: futureBlocks,
+		badBlocks:    badBlocks,
+		pow:          bc.pow,
+
+		maxTimeFutureBlocks: bc.MaxTimeFutureBlocks(),
+		noFutureBlocks:      atomic.LoadInt32(&bc.noFutureBlocks),
+		headerCheckFreq:     atomic.LoadInt32(&bc.headerCheckFreq),
+		headerForceVerify:   atomic.LoadInt32(&bc.headerForceVerify),
+	}
+	shadow.mipmapLevels.Store(bc.MipmapLevels())
+	shadow.SetValidator(NewBlockValidator(bc.config, shadow, bc.pow))
+	shadow.SetProcessor(NewStateProcessor(bc.config, shadow))
+	bc.procmu.RLock()
+	shadow.SetProcessorFactory(bc.processorFactory)
+	bc.procmu.RUnlock()
+
+	gv := func() HeaderValidator { return shadow.Validator() }
+	var err error
+	shadow.hc, err = NewHeaderChain(db, bc.config, mux, gv, shadow.getProcInterrupt, shadow.cacheConfig)
+	if err != nil {
+		return nil, err
+	}
+	shadow.genesisBlock = bc.genesisBlock
+
+	bc.mu.RLock()
+	shadow.currentBlock = bc.currentBlock
+	shadow.currentFastBlock = bc.currentFastBlock
+	bc.mu.RUnlock()
+	shadow.hc.SetCurrentHeader(bc.CurrentHeader())
+
+	shadow.stateCache, err = state.New(shadow.currentBlock.Root(), state.NewDatabase(db))
+	if err != nil {
+		return nil, err
+	}
+	return shadow, nil
+}
+
+// FindCommonAncestor returns the most recent block both blocks descend from, the
+// block itself if one is an ancestor of the other. ErrNoCommonAncestor is returned
+// if their chains don't meet in the local database.
+func (bc *BlockChain) FindCommonAncestor(a, b common.Hash) (*types.Block, error) {
+	blockA := bc.GetBlock(a)
+	if blockA == nil {
+		return nil, fmt.Errorf("unknown block %x", a)
+	}
+	blockB := bc.GetBlock(b)
+	if blockB == nil {
+		return nil, fmt.Errorf("unknown block %x", b)
+	}
+	ancestor, _, _, err := bc.commonAncestor(blockA, blockB)
+	return ancestor, err
+}
+
+// commonAncestor walks back the chains of both blocks to the block they share,
+// returning it along with the blocks of each chain above it, highest first.
+func (bc *BlockChain) commonAncestor(a, b *types.Block) (ancestor *types.Block, aChain, bChain types.Blocks, err error) {
+	// Reduce whichever chain is higher to the height of the other
+	for ; a != nil && a.NumberU64() > b.NumberU64(); a = bc.GetBlock(a.ParentHash()) {
+		aChain = append(aChain, a)
+	}
+	for ; a != nil && b != nil && b.NumberU64() > a.NumberU64(); b = bc.GetBlock(b.ParentHash()) {
+		bChain = append(bChain, b)
+	}
+	// Then walk both back in lockstep until they meet
+	for a != nil && b != nil {
+		if a.Hash() == b.Hash() {
+			return a, aChain, bChain, nil
+		}
+		aChain, bChain = append(aChain, a), append(bChain, b)
+		a, b = bc.GetBlock(a.ParentHash()), bc.GetBlock(b.ParentHash())
+	}
+	return nil, nil, nil, ErrNoCommonAncestor
+}
+
 // reorgs takes two blocks, an old chain and a new chain and will reconstruct the blocks and inserts them
 // to be part of the new canonical chain and accumulates potential missing transactions and post an
 // event about them
 func (bc *BlockChain) reorg(oldBlock, newBlock *types.Block) error {
 	var (
-		newChain          types.Blocks
-		oldChain          types.Blocks
-		commonBlock       *types.Block
 		oldStart          = oldBlock
 		newStart          = newBlock
 		deletedTxs        types.Transactions
@@ -1798,57 +3202,52 @@ func (bc *BlockChain) reorg(oldBlock, newBlock *types.Block) error {
 		}
 	)
 
-	// first reduce whoever is higher bound
-	if oldBlock.NumberU64() > newBlock.NumberU64() {
-		// reduce old chain
-		for ; oldBlock != nil && oldBlock.NumberU64() != newBlock.NumberU64(); oldBlock = bc.GetBlock(oldBlock.ParentHash()) {
-			oldChain = append(oldChain, oldBlock)
-			deletedTxs = append(deletedTxs, oldBlock.Transactions()...)
-
-			collectLogs(oldBlock.Hash())
-		}
-	} else {
-		// reduce new chain and append new chain blocks for inserting later on
-		for ; newBlock != nil && newBlock.NumberU64() != oldBlock.NumberU64(); newBlock = bc.GetBlock(newBlock.ParentHash()) {
-			newChain = append(newChain, newBlock)
-		}
-	}
-	if oldBlock == nil {
-		return fmt.Errorf("Invalid old chain")
+	commonBlock, oldChain, newChain, err := bc.commonAncestor(oldBlock, newBlock)
+	if err != nil {
+		return err
 	}
-	if newBlock == nil {
-		return fmt.Errorf("Invalid new chain")
+	for _, block := range oldChain {
+		deletedTxs = append(deletedTxs, block.Transactions()...)
+		collectLogs(block.Hash())
 	}
 
-	numSplit := newBlock.Number()
-	for {
-		if oldBlock.Hash() == newBlock.Hash() {
-			commonBlock = oldBlock
-			break
-		}
-
-		oldChain = append(oldChain, oldBlock)
-		newChain = append(newChain, newBlock)
-		deletedTxs = append(deletedTxs, oldBlock.Transactions()...)
-		collectLogs(oldBlock.Hash())
-
-		oldBlock, newBlock = bc.GetBlock(oldBlock.ParentHash()), bc.GetBlock(newBlock.ParentHash())
-		if oldBlock == nil {
-			return fmt.Errorf("Invalid old chain")
-		}
-		if newBlock == nil {
-			return fmt.Errorf("Invalid new chain")
-		}
+	// The chains split at the height of the lower head, or below it
+	splitDepth := len(oldChain)
+	if len(newChain) < splitDepth {
+		splitDepth = len(newChain)
 	}
+	numSplit := new(big.Int).SetUint64(commonBlock.NumberU64() + uint64(splitDepth))
 
 	commonHash := commonBlock.Hash()
+	// Depth is the number of blocks removed from the canonical chain.
+	depth := len(oldChain)
+	if max := bc.config.MaxReorgDepth; max > 0 && uint64(depth) > max {
+		err := &ReorgTooDeepErr{
+			Depth:        uint64(depth),
+			Max:          max,
+			CommonNumber: commonBlock.NumberU64(),
+			CommonHash:   commonHash,
+			OldHead:      oldStart.Hash(),
+			NewHead:      newStart.Hash(),
+		}
+		glog.V(logger.Error).Errorf("Refusing chain reorganisation, operator intervention required: %v", err)
+		glog.D(logger.Error).Errorf("Refusing chain reorganisation, operator intervention required: %v", err)
+		go bc.eventMux.Post(ReorgTooDeepEvent{Err: err})
+		return err
+	}
+	if depth > 0 {
+		metrics.ChainReorgs.Mark(1)
+		metrics.ChainReorgDepth.Update(int64(depth))
+	}
 	if glog.V(logger.Debug) {
-		glog.Infof("Chain split detected @ [%s]. Reorganising chain from #%v %s to %s", commonHash.Hex(), numSplit, oldStart.Hash().Hex(), newStart.Hash().Hex())
+		glog.Infof("Chain split detected @ [%s] #%d (depth=%d). Reorganising chain from #%v %s to %s", commonHash.Hex(), commonBlock.Number(), depth, numSplit, oldStart.Hash().Hex(), newStart.Hash().Hex())
 	}
 	if logger.MlogEnabled() {
 		mlogBlockchainReorgBlocks.AssignDetails(
 			commonHash.Hex(),
 			numSplit,
+			commonBlock.Number(),
+			depth,
 			oldStart.Hash().Hex(),
 			newStart.Hash().Hex(),
 		).Send(mlogBlockchain)
@@ -1895,7 +3294,7 @@ func (bc *BlockChain) reorg(oldBlock, newBlock *types.Block) error {
 			return err
 		}
 		// Write map map bloom filters
-		if err := WriteMipmapBloom(bc.chainDb, block.NumberU64(), receipts); err != nil {
+		if err := WriteMipmapBloom(bc.chainDb, bc.MipmapLevels(), block.NumberU64(), receipts); err != nil {
 			return err
 		}
 		addedTxs = append(addedTxs, block.Transactions()...)
@@ -1958,20 +3357,36 @@ func (bc *BlockChain) update() {
 		default:
 		}
 
-		blocks := make([]*types.Block, 0, bc.futureBlocks.Len())
-		for _, hash := range bc.futureBlocks.Keys() {
-			if block, exist := bc.futureBlocks.Get(hash); exist {
-				blocks = append(blocks, block.(*types.Block))
-			}
-		}
+		bc.procFutureBlocks()
+		metrics.ChainFutureBlocks.Update(int64(bc.futureBlocks.Len()))
+	}
+}
 
-		if len(blocks) > 0 {
-			types.BlockBy(types.Number).Sort(blocks)
-			if res := bc.InsertChain(blocks); res.Error != nil {
-				log.Printf("periodic future chain update on block #%d [%s]:  %s", blocks[res.Index].Number(), blocks[res.Index].Hash().Hex(), res.Error)
-			}
+// procFutureBlocks attempts to import any queued future blocks, logging the ones which were
+// successfully imported. It returns the number of blocks processed.
+func (bc *BlockChain) procFutureBlocks() int {
+	blocks := make([]*types.Block, 0, bc.futureBlocks.Len())
+	for _, hash := range bc.futureBlocks.Keys() {
+		if block, exist := bc.futureBlocks.Get(hash); exist {
+			blocks = append(blocks, block.(*types.Block))
 		}
 	}
+	if len(blocks) == 0 {
+		return 0
+	}
+
+	types.BlockBy(types.Number).Sort(blocks)
+	res := bc.InsertChain(blocks)
+	imported := blocks
+	if res.Error != nil {
+		log.Printf("periodic future chain update on block #%d [%s]:  %s", blocks[res.Index].Number(), blocks[res.Index].Hash().Hex(), res.Error)
+		imported = blocks[:res.Index]
+	}
+	if res.Processed > 0 && len(imported) > 0 {
+		glog.V(logger.Info).Infof("imported %d previously-future block(s) (%d queued). #%d - #%d",
+			res.Processed, res.Queued, imported[0].NumberU64(), imported[len(imported)-1].NumberU64())
+	}
+	return res.Processed
 }
 
 // InsertHeaderChain attempts to insert the given header chain in to the local
@@ -1983,6 +3398,9 @@ func (bc *BlockChain) update() {
 // of the header retrieval mechanisms already need to verify nonces, as well as
 // because nonces can be verified sparsely, not needing to check each.
 func (bc *BlockChain) InsertHeaderChain(chain []*types.Header, checkFreq int) *HeaderChainInsertResult {
+	if bc.readOnly {
+		return &HeaderChainInsertResult{Error: ErrReadOnlyChain}
+	}
 	// Make sure only one thread manipulates the chain at once
 	bc.chainmu.Lock()
 	defer bc.chainmu.Unlock()
@@ -2001,6 +3419,56 @@ func (bc *BlockChain) InsertHeaderChain(chain []*types.Header, checkFreq int) *H
 	return bc.hc.InsertHeaderChain(chain, checkFreq, whFunc)
 }
 
+// InsertHeaderChainDry validates a header chain the same way InsertHeaderChain
+// does, but without persisting anything or posting events. The dry writer only
+// checks that each new header's total difficulty can be derived from a known
+// parent. If a header is invalid, the result holds its index and the error;
+// otherwise it reports how many headers would have been imported or ignored.
+// If the validation is interrupted by a shutdown, the result is Aborted with
+// ErrInterrupted as its error.
+func (bc *BlockChain) InsertHeaderChainDry(chain []*types.Header, checkFreq int) *HeaderChainInsertResult {
+	res := &HeaderChainInsertResult{}
+	if len(chain) == 0 {
+		return res
+	}
+	if i, err := bc.hc.validateHeaderChain(chain, checkFreq, false); err != nil {
+		res.Index = i
+		res.Error = err
+		res.Aborted = err == ErrInterrupted
+		return res
+	}
+
+	tds := make(map[common.Hash]*big.Int)
+	whFunc := func(header *types.Header) error {
+		ptd, ok := tds[header.ParentHash]
+		if !ok {
+			ptd = bc.hc.GetTd(header.ParentHash)
+		}
+		if ptd == nil {
+			return ParentError(header.ParentHash)
+		}
+		tds[header.Hash()] = new(big.Int).Add(ptd, header.Difficulty)
+		return nil
+	}
+	for i, header := range chain {
+		if bc.hc.HasHeader(header.Hash()) {
+			res.Ignored++
+			continue
+		}
+		if err := whFunc(header); err != nil {
+			res.Index = i
+			res.Error = err
+			return res
+		}
+		res.Processed++
+	}
+	last := chain[len(chain)-1]
+	res.LastNumber = last.Number.Uint64()
+	res.LastHash = last.Hash()
+
+	return res
+}
+
 // CurrentHeader retrieves the current head header of the canonical chain. The
 // header is retrieved from the HeaderChain's internal cache.
 func (bc *BlockChain) CurrentHeader() *types.Header {
@@ -2016,6 +3484,12 @@ func (bc *BlockChain) GetTd(hash common.Hash) *big.Int {
 	return bc.hc.GetTd(hash)
 }
 
+// GetTdByNumber retrieves the total difficulty of the canonical block with the
+// given number, or nil if not found.
+func (bc *BlockChain) GetTdByNumber(number uint64) *big.Int {
+	return bc.hc.GetTdByNumber(number)
+}
+
 // GetHeader retrieves a block header from the database by hash, caching it if
 // found.
 func (bc *BlockChain) GetHeader(hash common.Hash) *types.Header {
@@ -2034,11 +3508,41 @@ func (bc *BlockChain) GetBlockHashesFromHash(hash common.Hash, max uint64) []com
 	return bc.hc.GetBlockHashesFromHash(hash, max)
 }
 
+// GetBlockHashesFromHashStep retrieves up to max block hashes starting at a given
+// hash, taking every step'th block either towards the genesis block or, if forward
+// is set, along the canonical chain towards the head.
+func (bc *BlockChain) GetBlockHashesFromHashStep(hash common.Hash, max, step uint64, forward bool) []common.Hash {
+	return bc.hc.GetBlockHashesFromHashStep(hash, max, step, forward)
+}
+
 // GetHeaderByNumber retrieves a block header from the database by number,
 // caching it (associated with its hash) if found.
 func (bc *BlockChain) GetHeaderByNumber(number uint64) *types.Header {
 	return bc.hc.GetHeaderByNumber(number)
 }
 
+// MaxGasUsedRange is the maximum number of blocks summed by TotalGasUsedInRange.
+const MaxGasUsedRange = 100000
+
+// TotalGasUsedInRange returns the sum of the gas used by the canonical blocks
+// from first to last, inclusive. It reads headers only, at most MaxGasUsedRange.
+func (bc *BlockChain) TotalGasUsedInRange(first, last uint64) (*big.Int, error) {
+	if first > last {
+		return nil, fmt.Errorf("invalid range: first (%d) is greater than last (%d)", first, last)
+	}
+	if last-first >= MaxGasUsedRange {
+		return nil, fmt.Errorf("invalid range: %d-%d spans more than %d blocks", first, last, MaxGasUsedRange)
+	}
+	total := new(big.Int)
+	for nr := first; nr <= last; nr++ {
+		header := bc.GetHeaderByNumber(nr)
+		if header == nil {
+			return nil, fmt.Errorf("header #%d not found", nr)
+		}
+		total.Add(total, header.GasUsed)
+	}
+	return total, nil
+}
+
 // Config retrieves the blockchain's chain configuration.
 func (bc *BlockChain) Config() *ChainConfig { return bc.config }
@@ -17,12 +17,17 @@
 package core
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"math/big"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -35,6 +40,7 @@ import (
 	"github.com/ethereumproject/go-ethereum/ethdb"
 	"github.com/ethereumproject/go-ethereum/event"
 	"github.com/ethereumproject/go-ethereum/logger/glog"
+	"github.com/ethereumproject/go-ethereum/metrics"
 	"github.com/ethereumproject/go-ethereum/rlp"
 	"github.com/hashicorp/golang-lru"
 	"io/ioutil"
@@ -78,7 +84,7 @@ func theBlockChain(db ethdb.Database, t *testing.T) *BlockChain {
 	if _, err := WriteGenesisBlock(db, DefaultConfigMorden.Genesis); err != nil {
 		t.Fatal(err)
 	}
-	blockchain, err := NewBlockChain(db, testChainConfig(), pow, &eventMux)
+	blockchain, err := NewBlockChain(db, testChainConfig(), pow, &eventMux, nil)
 	if err != nil {
 		t.Error("failed creating blockchain:", err)
 		t.FailNow()
@@ -504,7 +510,7 @@ func chm(t testing.TB, genesis *types.Block, db ethdb.Database) *BlockChain {
 	}
 	valFn := func() HeaderValidator { return bc.Validator() }
 	var err error
-	bc.hc, err = NewHeaderChain(db, config, bc.eventMux, valFn, bc.getProcInterrupt)
+	bc.hc, err = NewHeaderChain(db, config, bc.eventMux, valFn, bc.getProcInterrupt, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -524,6 +530,10 @@ func chm(t testing.TB, genesis *types.Block, db ethdb.Database) *BlockChain {
 	if err != nil {
 		t.Fatal(err)
 	}
+	bc.badBlocks, err = lru.New(badBlockLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
 	bc.SetValidator(bproc{})
 	bc.SetProcessor(bproc{})
 	bc.ResetWithGenesisBlock(genesis)
@@ -616,10 +626,106 @@ func TestInsertHeaderChainBadHash(t *testing.T) {
 		},
 	}
 
-	res := bc.InsertHeaderChain(headers, 1)
+	sub := bc.eventMux.Subscribe(BadForkDetectedEvent{})
+	defer sub.Unsubscribe()
+
+	// A dry run reports the bad header without posting an event
+	res := bc.InsertHeaderChainDry(headers, 1)
+	if res.Error != ErrHashKnownBad {
+		t.Errorf("dry run: got error %#v, want %#v", res.Error, ErrHashKnownBad)
+	}
+	select {
+	case ev := <-sub.Chan():
+		t.Errorf("dry run posted %#v", ev.Data)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	res = bc.InsertHeaderChain(headers, 1)
 	if res.Error != ErrHashKnownBad {
 		t.Errorf("got error %#v, want %#v", res.Error, ErrHashKnownBad)
 	}
+	checkBadForkEvent(t, sub, headers[2], ErrHashKnownBad)
+}
+
+func TestPostBadForkOrder(t *testing.T) {
+	db, err := ethdb.NewMemDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesis, err := WriteGenesisBlock(db, DefaultConfigMorden.Genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers := makeHeaderChainWithDiff(genesis, []int{1, 1, 1, 1, 1, 1, 1, 1}, 10)
+	bc := chm(t, genesis, db)
+
+	sub := bc.eventMux.Subscribe(BadForkDetectedEvent{})
+	defer sub.Unsubscribe()
+
+	for _, header := range headers {
+		bc.hc.postBadFork(header, ErrHashKnownBad)
+	}
+	for _, header := range headers {
+		checkBadForkEvent(t, sub, header, ErrHashKnownBad)
+	}
+}
+
+// checkBadForkEvent waits for a BadForkDetectedEvent on sub and checks it matches header and err.
+func checkBadForkEvent(t *testing.T, sub event.Subscription, header *types.Header, err error) {
+	select {
+	case ev := <-sub.Chan():
+		bad := ev.Data.(BadForkDetectedEvent)
+		if bad.Number != header.Number.Uint64() || bad.Hash != header.Hash() || bad.Err != err {
+			t.Errorf("bad fork event mismatch: have #%d [%x] %v, want #%d [%x] %v", bad.Number, bad.Hash, bad.Err, header.Number, header.Hash(), err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("timed out waiting for bad fork event")
+	}
+}
+
+func TestInsertHeaderChainDry(t *testing.T) {
+	db, bc, err := newCanonical(testChainConfig(), 5, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head := bc.CurrentHeader()
+	headers := makeHeaderChain(bc.config, head, 5, db, canonicalSeed)
+
+	res := bc.InsertHeaderChainDry(headers, 1)
+	if res.Error != nil {
+		t.Fatalf("dry insert failed: %v", res.Error)
+	}
+	if res.Processed != len(headers) || res.Ignored != 0 {
+		t.Errorf("processed/ignored mismatch: have %d/%d, want %d/0", res.Processed, res.Ignored, len(headers))
+	}
+	if bc.CurrentHeader().Hash() != head.Hash() {
+		t.Errorf("head header changed: have %x, want %x", bc.CurrentHeader().Hash(), head.Hash())
+	}
+	for _, header := range headers {
+		if bc.HasHeader(header.Hash()) {
+			t.Errorf("header #%d was written", header.Number)
+		}
+	}
+
+	// A header with an unknown parent must be reported at its index
+	orphans := headers[1:]
+	res = bc.InsertHeaderChainDry(orphans, 1)
+	if res.Error == nil {
+		t.Fatal("expected error for orphaned header chain")
+	}
+	if res.Index != 0 {
+		t.Errorf("error index mismatch: have %d, want 0", res.Index)
+	}
+
+	// An interrupted validation is not reported as a success
+	atomic.StoreInt32(&bc.procInterrupt, 1)
+	res = bc.InsertHeaderChainDry(headers, 1)
+	if res.Error != ErrInterrupted || !res.Aborted {
+		t.Errorf("interrupted: got error %v, aborted %v, want %v, aborted", res.Error, res.Aborted, ErrInterrupted)
+	}
+	if res.Processed != 0 {
+		t.Errorf("interrupted: processed %d headers, want 0", res.Processed)
+	}
 }
 
 func TestInsertChainBadHash(t *testing.T) {
@@ -640,10 +746,18 @@ func TestInsertChainBadHash(t *testing.T) {
 		},
 	}
 
+	sub := bc.eventMux.Subscribe(BadForkDetectedEvent{})
+	defer sub.Unsubscribe()
+
 	res := bc.InsertChain(blocks)
 	if res.Error != ErrHashKnownBad {
 		t.Errorf("got error %#v, want %#v", res.Error, ErrHashKnownBad)
 	}
+	checkBadForkEvent(t, sub, blocks[2].Header(), ErrHashKnownBad)
+	bad := bc.BadBlocks()
+	if len(bad) != 1 || bad[0].Header.Hash() != blocks[2].Hash() || bad[0].Reason != ErrHashKnownBad {
+		t.Errorf("bad blocks: want: [%x: %v], got: %v", blocks[2].Hash(), ErrHashKnownBad, bad)
+	}
 }
 
 // Tests that bad hashes are detected on boot, and the chain rolled back to a
@@ -697,10 +811,18 @@ func testReorgBadHashes(t *testing.T, full bool) {
 		defer func() { bc.config.BadHashes = []*BadHash{} }()
 	}
 	// Create a new chain manager and check it rolled back the state
-	ncm, err := NewBlockChain(db, bc.config, FakePow{}, new(event.TypeMux))
+	mux := new(event.TypeMux)
+	sub := mux.Subscribe(BadForkDetectedEvent{})
+	defer sub.Unsubscribe()
+	ncm, err := NewBlockChain(db, bc.config, FakePow{}, mux, nil)
 	if err != nil {
 		t.Fatalf("failed to create new chain manager: %v", err)
 	}
+	if full {
+		checkBadForkEvent(t, sub, blocks[3].Header(), ErrHashKnownBad)
+	} else {
+		checkBadForkEvent(t, sub, headers[3], ErrHashKnownBad)
+	}
 	if full {
 		if ncm.CurrentBlock().Hash() != blocks[2].Header().Hash() {
 			t.Errorf("last block hash mismatch: have: %x, want %x", ncm.CurrentBlock().Hash(), blocks[2].Header().Hash())
@@ -830,7 +952,7 @@ func TestFastVsFullChains(t *testing.T) {
 	}
 	WriteGenesisBlockForTesting(archiveDb, GenesisAccount{address, funds})
 
-	archive, err := NewBlockChain(archiveDb, config, FakePow{}, new(event.TypeMux))
+	archive, err := NewBlockChain(archiveDb, config, FakePow{}, new(event.TypeMux), nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -844,7 +966,7 @@ func TestFastVsFullChains(t *testing.T) {
 		t.Fatal(err)
 	}
 	WriteGenesisBlockForTesting(fastDb, GenesisAccount{address, funds})
-	fast, err := NewBlockChain(fastDb, config, FakePow{}, new(event.TypeMux))
+	fast, err := NewBlockChain(fastDb, config, FakePow{}, new(event.TypeMux), nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -959,7 +1081,7 @@ func TestFastVsFullChainsATXI(t *testing.T) {
 			}
 		})
 
-		blockchain, err := NewBlockChain(db, config, FakePow{}, new(event.TypeMux))
+		blockchain, err := NewBlockChain(db, config, FakePow{}, new(event.TypeMux), nil)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -1072,7 +1194,7 @@ func TestRmAddrTx(t *testing.T) {
 		}
 	})
 
-	blockchain, err := NewBlockChain(db, config, FakePow{}, new(event.TypeMux))
+	blockchain, err := NewBlockChain(db, config, FakePow{}, new(event.TypeMux), nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1140,7 +1262,7 @@ func TestLightVsFastVsFullChainHeads(t *testing.T) {
 	}
 	WriteGenesisBlockForTesting(archiveDb, GenesisAccount{address, funds})
 
-	archive, err := NewBlockChain(archiveDb, testChainConfig(), FakePow{}, new(event.TypeMux))
+	archive, err := NewBlockChain(archiveDb, testChainConfig(), FakePow{}, new(event.TypeMux), nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1158,7 +1280,7 @@ func TestLightVsFastVsFullChainHeads(t *testing.T) {
 		t.Fatal(err)
 	}
 	WriteGenesisBlockForTesting(fastDb, GenesisAccount{address, funds})
-	fast, err := NewBlockChain(fastDb, testChainConfig(), FakePow{}, new(event.TypeMux))
+	fast, err := NewBlockChain(fastDb, testChainConfig(), FakePow{}, new(event.TypeMux), nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1183,7 +1305,7 @@ func TestLightVsFastVsFullChainHeads(t *testing.T) {
 		t.Fatal(err)
 	}
 	WriteGenesisBlockForTesting(lightDb, GenesisAccount{address, funds})
-	light, err := NewBlockChain(lightDb, testChainConfig(), FakePow{}, new(event.TypeMux))
+	light, err := NewBlockChain(lightDb, testChainConfig(), FakePow{}, new(event.TypeMux), nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1196,6 +1318,64 @@ func TestLightVsFastVsFullChainHeads(t *testing.T) {
 	assert(t, "light", light, height/2, 0, 0)
 }
 
+// Tests that rolling back blocks removes the transaction and receipt lookups of
+// their transactions, but leaves the ones of the remaining blocks in place.
+func TestRollbackRemovesTransactions(t *testing.T) {
+	db, err := ethdb.NewMemDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		signer  = types.NewChainIdSigner(big.NewInt(63))
+		genesis = WriteGenesisBlockForTesting(db, GenesisAccount{address, big.NewInt(1000000)})
+	)
+	chainConfig := MakeDiehardChainConfig()
+	chain, _ := GenerateChain(chainConfig, genesis, db, 4, func(i int, gen *BlockGen) {
+		tx, err := types.NewTransaction(gen.TxNonce(address), common.Address{0x01}, big.NewInt(1000), TxGas, nil, nil).WithSigner(signer).SignECDSA(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gen.AddTx(tx)
+	})
+	blockchain, err := NewBlockChain(db, chainConfig, FakePow{}, new(event.TypeMux), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := blockchain.InsertChain(chain); res.Error != nil {
+		t.Fatalf("failed to insert chain[%d]: %v", res.Index, res.Error)
+	}
+	blockchain.Rollback([]common.Hash{chain[2].Hash(), chain[3].Hash()})
+
+	if num := blockchain.CurrentBlock().NumberU64(); num != 2 {
+		t.Fatalf("head block mismatch: have #%d, want #%d", num, 2)
+	}
+	for i, block := range chain {
+		tx := block.Transactions()[0]
+		txn, _, _, _ := GetTransaction(db, tx.Hash())
+		receipt := GetReceipt(db, tx.Hash())
+		if i < 2 {
+			if txn == nil {
+				t.Errorf("block #%d: transaction %x missing after rollback", block.NumberU64(), tx.Hash())
+			}
+			if receipt == nil {
+				t.Errorf("block #%d: receipt %x missing after rollback", block.NumberU64(), tx.Hash())
+			}
+		} else {
+			if txn != nil {
+				t.Errorf("block #%d: transaction %x not removed by rollback", block.NumberU64(), tx.Hash())
+			}
+			if receipt != nil {
+				t.Errorf("block #%d: receipt %x not removed by rollback", block.NumberU64(), tx.Hash())
+			}
+		}
+	}
+}
+
 // Tests that chain reorganisations handle transaction removals and reinsertions.
 func TestChainTxReorgs(t *testing.T) {
 	db, err := ethdb.NewMemDatabase()
@@ -1298,7 +1478,7 @@ func testChainTxReorgs(t *testing.T, db ethdb.Database, withATXI bool) {
 
 	// Import the chain. This runs all block validation rules.
 	evmux := &event.TypeMux{}
-	blockchain, err := NewBlockChain(db, chainConfig, FakePow{}, evmux)
+	blockchain, err := NewBlockChain(db, chainConfig, FakePow{}, evmux, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1446,12 +1626,18 @@ func TestLogReorgs(t *testing.T) {
 	chainConfig := MakeDiehardChainConfig()
 
 	evmux := &event.TypeMux{}
-	blockchain, err := NewBlockChain(db, chainConfig, FakePow{}, evmux)
+	blockchain, err := NewBlockChain(db, chainConfig, FakePow{}, evmux, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	subs := evmux.Subscribe(RemovedLogsEvent{})
+	removedLogs, unsubLogs := blockchain.SubscribeRemovedLogsEvent()
+	defer unsubLogs()
+	removedTxs, unsubTxs := blockchain.SubscribeRemovedTransactionEvent()
+	defer unsubTxs()
+
+	var created *types.Transaction
 	chain, _ := GenerateChain(chainConfig, genesis, db, 2, func(i int, gen *BlockGen) {
 		if i == 1 {
 			tx, err := types.NewContractCreation(gen.TxNonce(addr1), new(big.Int), big.NewInt(1000000), new(big.Int), code).WithSigner(signer).SignECDSA(key1)
@@ -1459,6 +1645,7 @@ func TestLogReorgs(t *testing.T) {
 				t.Fatalf("failed to create tx: %v", err)
 			}
 			gen.AddTx(tx)
+			created = tx
 		}
 	})
 	if res := blockchain.InsertChain(chain); res.Error != nil {
@@ -1474,6 +1661,24 @@ func TestLogReorgs(t *testing.T) {
 	if len(ev.Data.(RemovedLogsEvent).Logs) == 0 {
 		t.Error("expected logs")
 	}
+
+	timeout := time.After(10 * time.Second)
+	select {
+	case ev := <-removedLogs:
+		if len(ev.Logs) == 0 {
+			t.Error("expected logs in typed removed logs event")
+		}
+	case <-timeout:
+		t.Fatal("timed out waiting for removed logs event")
+	}
+	select {
+	case ev := <-removedTxs:
+		if len(ev.Txs) != 1 || ev.Txs[0].Hash() != created.Hash() {
+			t.Errorf("removed transactions: want [%x], got %v", created.Hash(), ev.Txs)
+		}
+	case <-timeout:
+		t.Fatal("timed out waiting for removed transaction event")
+	}
 }
 
 func TestReorgSideEvent(t *testing.T) {
@@ -1502,7 +1707,7 @@ func TestReorgSideEvent(t *testing.T) {
 	chainConfig := MakeDiehardChainConfig()
 
 	evmux := &event.TypeMux{}
-	blockchain, err := NewBlockChain(db, chainConfig, FakePow{}, evmux)
+	blockchain, err := NewBlockChain(db, chainConfig, FakePow{}, evmux, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1584,7 +1789,7 @@ func TestCanonicalBlockRetrieval(t *testing.T) {
 	genesis := WriteGenesisBlockForTesting(db)
 
 	evmux := &event.TypeMux{}
-	blockchain, err := NewBlockChain(db, testChainConfig(), FakePow{}, evmux)
+	blockchain, err := NewBlockChain(db, testChainConfig(), FakePow{}, evmux, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1681,7 +1886,7 @@ func TestEIP155Transition(t *testing.T) {
 		mux event.TypeMux
 	)
 
-	blockchain, err := NewBlockChain(db, config, FakePow{}, &mux)
+	blockchain, err := NewBlockChain(db, config, FakePow{}, &mux, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1838,3 +2043,1575 @@ func TestBlockChain_BlockIsGenesis(t *testing.T) {
 		t.Errorf("expected: is not genesis block")
 	}
 }
+
+func TestBlockChain_SetMaxTimeFutureBlocks(t *testing.T) {
+	db, blockchain, err := newCanonical(MakeChainConfig(), 0, true)
+	if err != nil {
+		t.Fatalf("failed to make new canonical chain: %v", err)
+	}
+	if got := blockchain.MaxTimeFutureBlocks(); got != DefaultMaxTimeFutureBlocks {
+		t.Fatalf("default max time future blocks: want: %d, got: %d", DefaultMaxTimeFutureBlocks, got)
+	}
+	if err := blockchain.SetMaxTimeFutureBlocks(-1); err != ErrNegativeMaxTimeFutureBlocks {
+		t.Fatalf("want: %v, got: %v", ErrNegativeMaxTimeFutureBlocks, err)
+	}
+
+	// Make a block 60 seconds in the future.
+	blocks, _ := GenerateChain(MakeChainConfig(), blockchain.Genesis(), db, 1, func(i int, b *BlockGen) {
+		b.OffsetTime(time.Now().Unix() + 60 - b.header.Time.Int64())
+	})
+
+	res := blockchain.InsertChain(blocks)
+	if res.Error == nil {
+		t.Fatal("expected future block to be rejected with default max time future blocks")
+	}
+	var futureErr *FutureBlockErr
+	if !errors.As(res.Error, &futureErr) || !errors.Is(res.Error, BlockFutureErr) {
+		t.Fatalf("want: %T wrapping %v, got: %v", futureErr, BlockFutureErr, res.Error)
+	}
+	if futureErr.Time.Cmp(blocks[0].Time()) != 0 || futureErr.Hash != blocks[0].Hash() {
+		t.Errorf("future block error: want time %v hash %x, got time %v hash %x", blocks[0].Time(), blocks[0].Hash(), futureErr.Time, futureErr.Hash)
+	}
+
+	if err := blockchain.SetMaxTimeFutureBlocks(120); err != nil {
+		t.Fatal(err)
+	}
+	if res := blockchain.InsertChain(blocks); res.Error != nil {
+		t.Fatalf("expected future block to be queued, got: %v", res.Error)
+	}
+	if !blockchain.futureBlocks.Contains(blocks[0].Hash()) {
+		t.Error("expected future block to be queued")
+	}
+}
+
+func TestBlockChain_SetFutureBlocks(t *testing.T) {
+	db, blockchain, err := newCanonical(MakeChainConfig(), 0, true)
+	if err != nil {
+		t.Fatalf("failed to make new canonical chain: %v", err)
+	}
+	if !blockchain.FutureBlocks() {
+		t.Fatal("expected future blocks to be queued by default")
+	}
+	blockchain.SetFutureBlocks(false)
+	if blockchain.FutureBlocks() {
+		t.Fatal("expected future blocks to be disabled")
+	}
+
+	// Make a block 10 seconds in the future, within the default max time.
+	blocks, _ := GenerateChain(MakeChainConfig(), blockchain.Genesis(), db, 1, func(i int, b *BlockGen) {
+		b.OffsetTime(time.Now().Unix() + 10 - b.header.Time.Int64())
+	})
+	res := blockchain.InsertChain(blocks)
+	if !errors.Is(res.Error, BlockFutureErr) {
+		t.Fatalf("want: %v, got: %v", BlockFutureErr, res.Error)
+	}
+	if blockchain.futureBlocks.Contains(blocks[0].Hash()) {
+		t.Error("future block queued while disabled")
+	}
+
+	blockchain.SetFutureBlocks(true)
+	if res := blockchain.InsertChain(blocks); res.Error != nil {
+		t.Fatalf("expected future block to be queued, got: %v", res.Error)
+	}
+	if !blockchain.futureBlocks.Contains(blocks[0].Hash()) {
+		t.Error("expected future block to be queued")
+	}
+}
+
+func TestBlockChain_SetHeaderVerification(t *testing.T) {
+	_, blockchain, err := newCanonical(MakeChainConfig(), 0, true)
+	if err != nil {
+		t.Fatalf("failed to make new canonical chain: %v", err)
+	}
+	if freq, force := blockchain.HeaderVerification(); freq != DefaultHeaderCheckFrequency || force != DefaultHeaderForceVerify {
+		t.Fatalf("default header verification: want: %d, %d, got: %d, %d", DefaultHeaderCheckFrequency, DefaultHeaderForceVerify, freq, force)
+	}
+	for _, tt := range []struct{ freq, force int }{
+		{0, DefaultHeaderForceVerify},
+		{MaxHeaderCheckFrequency + 1, DefaultHeaderForceVerify},
+		{DefaultHeaderCheckFrequency, MinHeaderForceVerify - 1},
+	} {
+		if err := blockchain.SetHeaderVerification(tt.freq, tt.force); err != ErrHeaderVerificationBounds {
+			t.Errorf("%d, %d: want: %v, got: %v", tt.freq, tt.force, ErrHeaderVerificationBounds, err)
+		}
+	}
+	if err := blockchain.SetHeaderVerification(MaxHeaderCheckFrequency, MinHeaderForceVerify); err != nil {
+		t.Fatal(err)
+	}
+	if freq, force := blockchain.HeaderVerification(); freq != MaxHeaderCheckFrequency || force != MinHeaderForceVerify {
+		t.Errorf("header verification: want: %d, %d, got: %d, %d", MaxHeaderCheckFrequency, MinHeaderForceVerify, freq, force)
+	}
+}
+
+func TestBlockChain_SubscribeChainEvents(t *testing.T) {
+	db, blockchain, err := newCanonical(MakeChainConfig(), 0, true)
+	if err != nil {
+		t.Fatalf("failed to make new canonical chain: %v", err)
+	}
+
+	chainEvents, unsubChain := blockchain.SubscribeChainEvent()
+	headEvents, unsubHead := blockchain.SubscribeChainHeadEvent()
+	sideEvents, unsubSide := blockchain.SubscribeChainSideEvent()
+	defer unsubSide()
+
+	blocks := makeBlockChain(blockchain.config, blockchain.Genesis(), 3, db, canonicalSeed)
+	if res := blockchain.InsertChain(blocks); res.Error != nil {
+		t.Fatalf("failed to insert chain: %v", res.Error)
+	}
+
+	timeout := time.After(10 * time.Second)
+	for i := range blocks {
+		select {
+		case ev := <-chainEvents:
+			if ev.Hash != blocks[i].Hash() {
+				t.Errorf("chain event %d: want: %x, got: %x", i, blocks[i].Hash(), ev.Hash)
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for chain events")
+		}
+	}
+	select {
+	case ev := <-headEvents:
+		if ev.Block.Hash() != blocks[len(blocks)-1].Hash() {
+			t.Errorf("chain head event: want: %x, got: %x", blocks[len(blocks)-1].Hash(), ev.Block.Hash())
+		}
+	case <-timeout:
+		t.Fatal("timed out waiting for chain head event")
+	}
+	select {
+	case ev := <-sideEvents:
+		t.Errorf("unexpected side event: %v", ev)
+	default:
+	}
+
+	unsubChain()
+	unsubChain() // must be idempotent
+	if _, ok := <-chainEvents; ok {
+		t.Error("expected chain event channel to be closed after unsubscribe")
+	}
+	unsubHead()
+	if _, ok := <-headEvents; ok {
+		t.Error("expected chain head event channel to be closed after unsubscribe")
+	}
+}
+
+func TestInsertChainDryRun(t *testing.T) {
+	db, blockchain, err := newCanonical(MakeChainConfig(), 0, true)
+	if err != nil {
+		t.Fatalf("failed to make new canonical chain: %v", err)
+	}
+	chainEvents, unsub := blockchain.SubscribeChainEvent()
+	defer unsub()
+
+	blocks := makeBlockChain(blockchain.config, blockchain.Genesis(), 5, db, canonicalSeed)
+
+	res := blockchain.InsertChainDryRun(blocks)
+	if res.Error != nil {
+		t.Fatalf("dry run failed: %v", res.Error)
+	}
+	if res.Processed != len(blocks) {
+		t.Errorf("dry run processed: want: %d, got: %d", len(blocks), res.Processed)
+	}
+	if head := blockchain.CurrentBlock(); head.Hash() != blockchain.Genesis().Hash() {
+		t.Errorf("dry run moved head block to #%d", head.NumberU64())
+	}
+	for _, block := range blocks {
+		if blockchain.HasBlock(block.Hash()) || GetBlock(db, block.Hash()) != nil {
+			t.Errorf("dry run wrote block #%d", block.NumberU64())
+		}
+		if GetBlockReceipts(db, block.Hash()) != nil {
+			t.Errorf("dry run wrote receipts for block #%d", block.NumberU64())
+		}
+	}
+	select {
+	case ev := <-chainEvents:
+		t.Errorf("dry run posted chain event for block #%d", ev.Block.NumberU64())
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Corrupt the state root of the last block; the dry run must report the failure.
+	header := blocks[4].Header()
+	header.Root = common.Hash{}
+	bad := types.NewBlockWithHeader(header).WithBody(blocks[4].Transactions(), blocks[4].Uncles())
+	badChain := append(types.Blocks{}, blocks[:4]...)
+	badChain = append(badChain, bad)
+	if res := blockchain.InsertChainDryRun(badChain); res.Error == nil {
+		t.Error("expected dry run of invalid chain to fail")
+	} else if res.Index != 4 {
+		t.Errorf("failed block index: want: 4, got: %d", res.Index)
+	}
+
+	if res := blockchain.InsertChain(blocks); res.Error != nil {
+		t.Fatalf("failed to insert chain after dry run: %v", res.Error)
+	}
+	if head := blockchain.CurrentBlock(); head.Hash() != blocks[4].Hash() {
+		t.Errorf("head block: want: #%d, got: #%d", blocks[4].NumberU64(), head.NumberU64())
+	}
+}
+
+func TestBlockChain_CacheConfig(t *testing.T) {
+	db, err := ethdb.NewMemDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesis, err := WriteGenesisBlock(db, DefaultConfigMorden.Genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cacheConfig := &CacheConfig{BodyCacheLimit: 4, BlockCacheLimit: 2048, HeaderCacheLimit: 2}
+	blockchain, err := NewBlockChain(db, MakeChainConfig(), FakePow{}, new(event.TypeMux), cacheConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blockchain.cacheConfig.TdCacheLimit != tdCacheLimit {
+		t.Errorf("td cache limit: want: %d, got: %d", tdCacheLimit, blockchain.cacheConfig.TdCacheLimit)
+	}
+
+	blocks := makeBlockChain(MakeChainConfig(), genesis, 300, db, canonicalSeed)
+	if res := blockchain.InsertChain(blocks); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	for _, block := range blocks {
+		blockchain.GetBlock(block.Hash())
+		blockchain.GetBody(block.Hash())
+		blockchain.GetHeader(block.Hash())
+	}
+	if n := blockchain.blockCache.Len(); n < len(blocks) {
+		t.Errorf("block cache entries: want: >=%d, got: %d", len(blocks), n)
+	}
+	if n := blockchain.bodyCache.Len(); n != 4 {
+		t.Errorf("body cache entries: want: %d, got: %d", 4, n)
+	}
+	if n := blockchain.hc.headerCache.Len(); n != 2 {
+		t.Errorf("header cache entries: want: %d, got: %d", 2, n)
+	}
+
+	if err := blockchain.SetHead(100); err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range blocks[100:] {
+		if blockchain.blockCache.Contains(block.Hash()) || blockchain.bodyCache.Contains(block.Hash()) {
+			t.Fatalf("block #%d still cached after SetHead", block.NumberU64())
+		}
+	}
+	if block := blockchain.GetBlock(blocks[200].Hash()); block != nil {
+		t.Errorf("block #%d still available after SetHead", block.NumberU64())
+	}
+}
+
+func TestInsertChainNonContiguous(t *testing.T) {
+	db, blockchain, err := newCanonical(MakeChainConfig(), 0, true)
+	if err != nil {
+		t.Fatalf("failed to make new canonical chain: %v", err)
+	}
+	blocks := makeBlockChain(MakeChainConfig(), blockchain.Genesis(), 3, db, canonicalSeed)
+
+	res := blockchain.InsertChain(types.Blocks{blocks[0], blocks[2]})
+	if !errors.Is(res.Error, ErrNonContiguous) {
+		t.Fatalf("want: %v, got: %v", ErrNonContiguous, res.Error)
+	}
+	var ncErr *NonContiguousErr
+	if !errors.As(res.Error, &ncErr) {
+		t.Fatalf("want: %T, got: %T", ncErr, res.Error)
+	}
+	if ncErr.PrevIndex != 0 || ncErr.Index != 1 || ncErr.Number != blocks[2].NumberU64() || ncErr.Hash != blocks[2].Hash() {
+		t.Errorf("unexpected error fields: %+v", ncErr)
+	}
+	if blockchain.CurrentBlock().NumberU64() != 0 {
+		t.Errorf("non contiguous chain was inserted, head: #%d", blockchain.CurrentBlock().NumberU64())
+	}
+}
+
+func TestBlockChain_GetReceiptsByBlockHash(t *testing.T) {
+	db, err := ethdb.NewMemDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		funds   = big.NewInt(1000000000)
+		genesis = WriteGenesisBlockForTesting(db, GenesisAccount{address, funds})
+		signer  = types.NewChainIdSigner(big.NewInt(63))
+		config  = MakeDiehardChainConfig()
+	)
+	blocks, _ := GenerateChain(config, genesis, db, 2, func(i int, block *BlockGen) {
+		for j := 0; j < 3; j++ {
+			tx, err := types.NewTransaction(block.TxNonce(address), common.Address{0x00}, big.NewInt(1000), TxGas, nil, nil).WithSigner(signer).SignECDSA(key)
+			if err != nil {
+				panic(err)
+			}
+			block.AddTx(tx)
+		}
+	})
+	blockchain, err := NewBlockChain(db, config, FakePow{}, new(event.TypeMux), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := blockchain.InsertChain(blocks); res.Error != nil {
+		t.Fatalf("failed to process block %d: %v", res.Index, res.Error)
+	}
+
+	if receipts := blockchain.GetReceiptsByBlockHash(common.Hash{0x01}); receipts != nil {
+		t.Errorf("expected nil receipts for unknown block, got %d", len(receipts))
+	}
+	for _, block := range blocks {
+		receipts := blockchain.GetReceiptsByBlockHash(block.Hash())
+		if len(receipts) != len(block.Transactions()) {
+			t.Fatalf("block #%d: want %d receipts, got %d", block.NumberU64(), len(block.Transactions()), len(receipts))
+		}
+		for i, receipt := range receipts {
+			if want := block.Transactions()[i].Hash(); receipt.TxHash != want {
+				t.Errorf("block #%d receipt %d: tx hash mismatch: want %x, got %x", block.NumberU64(), i, want, receipt.TxHash)
+			}
+			if want := GetReceipt(db, receipt.TxHash); receipt.GasUsed.Cmp(want.GasUsed) != 0 || receipt.GasUsed.Cmp(TxGas) != 0 {
+				t.Errorf("block #%d receipt %d: gas used mismatch: want %v, got %v", block.NumberU64(), i, want.GasUsed, receipt.GasUsed)
+			}
+		}
+	}
+}
+
+func TestBlockChain_SimulateBlock(t *testing.T) {
+	key, _ := crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	addr := crypto.PubkeyToAddress(key.PublicKey)
+	signer := types.NewChainIdSigner(big.NewInt(63))
+	config := MakeDiehardChainConfig()
+
+	// Generate the block against a separate database, which receives its state.
+	db, _ := ethdb.NewMemDatabase()
+	genDb, _ := ethdb.NewMemDatabase()
+	genesis := WriteGenesisBlockForTesting(db, GenesisAccount{addr, big.NewInt(10000000000000)})
+	WriteGenesisBlockForTesting(genDb, GenesisAccount{addr, big.NewInt(10000000000000)})
+	chain, _ := GenerateChain(config, genesis, genDb, 1, func(i int, gen *BlockGen) {
+		for j := 0; j < 2; j++ {
+			tx, _ := types.NewTransaction(gen.TxNonce(addr), common.Address{0x01}, big.NewInt(1), TxGas, nil, nil).WithSigner(signer).SignECDSA(key)
+			gen.AddTx(tx)
+		}
+	})
+	block := chain[0]
+
+	bc, err := NewBlockChain(db, config, FakePow{}, &event.TypeMux{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := bc.SimulateBlock(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Root != block.Root() {
+		t.Errorf("root mismatch: have %x, want %x", result.Root, block.Root())
+	}
+	if result.UsedGas.Cmp(block.GasUsed()) != 0 {
+		t.Errorf("used gas mismatch: have %v, want %v", result.UsedGas, block.GasUsed())
+	}
+	if len(result.Receipts) != 2 {
+		t.Fatalf("want %d receipts, got %d", 2, len(result.Receipts))
+	}
+	for i, receipt := range result.Receipts {
+		if receipt.TxHash != block.Transactions()[i].Hash() {
+			t.Errorf("receipt %d: tx hash not derived", i)
+		}
+	}
+
+	// Nothing was written.
+	if head := bc.CurrentBlock(); head.Hash() != genesis.Hash() {
+		t.Errorf("head changed to #%d", head.NumberU64())
+	}
+	if _, err := state.New(block.Root(), state.NewDatabase(db)); err == nil {
+		t.Error("simulated state written to the database")
+	}
+	if statedb, _ := bc.State(); statedb.GetNonce(addr) != 0 {
+		t.Errorf("head state changed: nonce %d", statedb.GetNonce(addr))
+	}
+}
+
+func TestBlockChain_GetLogsByBlockHash(t *testing.T) {
+	key, _ := crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	addr := crypto.PubkeyToAddress(key.PublicKey)
+	signer := types.NewChainIdSigner(big.NewInt(63))
+	db, _ := ethdb.NewMemDatabase()
+	genesis := WriteGenesisBlockForTesting(db, GenesisAccount{addr, big.NewInt(10000000000000)})
+	config := MakeDiehardChainConfig()
+
+	bc, err := NewBlockChain(db, config, FakePow{}, &event.TypeMux{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, _ := GenerateChain(config, genesis, db, 1, func(i int, gen *BlockGen) {
+		for j := 0; j < 3; j++ {
+			tx, _ := types.NewTransaction(gen.TxNonce(addr), common.Address{0x01}, big.NewInt(1), TxGas, nil, nil).WithSigner(signer).SignECDSA(key)
+			gen.AddTx(tx)
+		}
+	})
+	if res := bc.InsertChain(chain); res.Error != nil {
+		t.Fatalf("failed to insert chain: %v", res.Error)
+	}
+	block := chain[0]
+
+	// Value transfers emit no logs, store receipts with 2, 0 and 1 logs.
+	receipts := GetBlockReceipts(db, block.Hash())
+	receipts[0].Logs = vm.Logs{{Address: common.Address{0x02}}, {Address: common.Address{0x03}}}
+	receipts[1].Logs = vm.Logs{}
+	receipts[2].Logs = vm.Logs{{Address: common.Address{0x04}}}
+	if err := WriteBlockReceipts(db, block.Hash(), receipts); err != nil {
+		t.Fatal(err)
+	}
+
+	if logs := bc.GetLogsByBlockHash(common.Hash{0x01}); logs != nil {
+		t.Errorf("expected nil logs for unknown block, got %d", len(logs))
+	}
+	logs := bc.GetLogsByBlockHash(block.Hash())
+	if len(logs) != 3 {
+		t.Fatalf("want logs of 3 transactions, got %d", len(logs))
+	}
+	var index uint
+	for i, txLogs := range logs {
+		if len(txLogs) != len(receipts[i].Logs) {
+			t.Fatalf("tx %d: want %d logs, got %d", i, len(receipts[i].Logs), len(txLogs))
+		}
+		for _, log := range txLogs {
+			if log.Index != index {
+				t.Errorf("tx %d: log index mismatch: have %d, want %d", i, log.Index, index)
+			}
+			if log.BlockNumber != block.NumberU64() || log.BlockHash != block.Hash() || log.TxIndex != uint(i) || log.TxHash != block.Transactions()[i].Hash() {
+				t.Errorf("tx %d: log fields not derived: %+v", i, log)
+			}
+			index++
+		}
+	}
+}
+
+func TestBlockChain_PreimageRecording(t *testing.T) {
+	key, err := crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Contract creation code storing 0x2a in memory and hashing the 32 byte word:
+	// PUSH1 0x2a PUSH1 0 MSTORE PUSH1 0x20 PUSH1 0 SHA3 POP STOP
+	code := common.FromHex("602a60005260206000205000")
+	preimage := common.LeftPadBytes([]byte{0x2a}, 32)
+	hash := crypto.Keccak256Hash(preimage)
+
+	for _, record := range []bool{false, true} {
+		gendb, _ := ethdb.NewMemDatabase()
+		db, _ := ethdb.NewMemDatabase()
+		var (
+			address = crypto.PubkeyToAddress(key.PublicKey)
+			genesis = WriteGenesisBlockForTesting(gendb, GenesisAccount{address, big.NewInt(1000000000)})
+			signer  = types.NewChainIdSigner(big.NewInt(63))
+			config  = MakeDiehardChainConfig()
+		)
+		blocks, _ := GenerateChain(config, genesis, gendb, 1, func(i int, block *BlockGen) {
+			tx, err := types.NewContractCreation(block.TxNonce(address), new(big.Int), big.NewInt(100000), new(big.Int), code).WithSigner(signer).SignECDSA(key)
+			if err != nil {
+				panic(err)
+			}
+			block.AddTx(tx)
+		})
+		WriteGenesisBlockForTesting(db, GenesisAccount{address, big.NewInt(1000000000)})
+		blockchain, err := NewBlockChain(db, config, FakePow{}, new(event.TypeMux), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blockchain.SetPreimageRecording(record)
+		if res := blockchain.InsertChain(blocks); res.Error != nil {
+			t.Fatalf("failed to process block %d: %v", res.Index, res.Error)
+		}
+
+		got := GetPreimage(db, hash)
+		if record && !bytes.Equal(got, preimage) {
+			t.Errorf("recording enabled: want preimage %x, got %x", preimage, got)
+		}
+		if !record && got != nil {
+			t.Errorf("recording disabled: want no preimage, got %x", got)
+		}
+	}
+}
+
+// failingPutDatabase is a database failing all writes of keys with the given prefix, if set.
+type failingPutDatabase struct {
+	ethdb.Database
+	prefix []byte
+}
+
+func (db *failingPutDatabase) Put(key []byte, value []byte) error {
+	if db.prefix != nil && bytes.HasPrefix(key, db.prefix) {
+		return errors.New("injected write failure")
+	}
+	return db.Database.Put(key, value)
+}
+
+// Tests that database write failures during receipt chain insertion are returned
+// instead of terminating the process, and that the batch can be retried.
+func TestInsertReceiptChainWriteFailure(t *testing.T) {
+	gendb, _ := ethdb.NewMemDatabase()
+	key, err := crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		funds   = big.NewInt(1000000000)
+		genesis = GenesisBlockForTesting(gendb, address, funds)
+		signer  = types.NewChainIdSigner(big.NewInt(63))
+		config  = MakeDiehardChainConfig()
+	)
+	blocks, receipts := GenerateChain(config, genesis, gendb, 4, func(i int, block *BlockGen) {
+		tx, err := types.NewTransaction(block.TxNonce(address), common.Address{0x00}, big.NewInt(1000), TxGas, nil, nil).WithSigner(signer).SignECDSA(key)
+		if err != nil {
+			panic(err)
+		}
+		block.AddTx(tx)
+	})
+
+	memdb, _ := ethdb.NewMemDatabase()
+	db := &failingPutDatabase{Database: memdb}
+	WriteGenesisBlockForTesting(db, GenesisAccount{address, funds})
+	fast, err := NewBlockChain(db, config, FakePow{}, new(event.TypeMux), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers := make([]*types.Header, len(blocks))
+	for i, block := range blocks {
+		headers[i] = block.Header()
+	}
+	if res := fast.InsertHeaderChain(headers, 1); res.Error != nil {
+		t.Fatalf("failed to insert header %d: %v", res.Index, res.Error)
+	}
+
+	db.prefix = blockReceiptsPrefix
+	if res := fast.InsertReceiptChain(blocks, receipts); res.Error == nil {
+		t.Fatal("expected receipt chain insertion to fail")
+	}
+	for _, block := range blocks {
+		if fast.HasBlock(block.Hash()) {
+			t.Fatalf("block #%d marked as known after failed insertion", block.NumberU64())
+		}
+	}
+
+	db.prefix = nil
+	if res := fast.InsertReceiptChain(blocks, receipts); res.Error != nil {
+		t.Fatalf("failed to retry receipt chain insertion %d: %v", res.Index, res.Error)
+	}
+	for _, block := range blocks {
+		if !fast.HasBlock(block.Hash()) {
+			t.Errorf("block #%d missing after retry", block.NumberU64())
+		}
+		if r := GetBlockReceipts(db, block.Hash()); len(r) != len(block.Transactions()) {
+			t.Errorf("block #%d: want %d receipts, got %d", block.NumberU64(), len(block.Transactions()), len(r))
+		}
+	}
+}
+
+func TestBlockChain_VerifyRange(t *testing.T) {
+	db, blockchain, err := newCanonical(testChainConfig(), 10, true)
+	if err != nil {
+		t.Fatalf("failed to make new canonical chain: %v", err)
+	}
+
+	if b, err := blockchain.VerifyRange(0, 10); err != nil {
+		t.Fatalf("unexpected invalid block: %v: %v", b, err)
+	}
+	if _, err := blockchain.VerifyRange(5, 4); err == nil {
+		t.Error("expected error for inverted range")
+	}
+	if b, err := blockchain.VerifyRange(0, 11); err == nil || b != nil {
+		t.Errorf("expected missing block error with nil block, got: %v, %v", b, err)
+	}
+
+	// Corrupt the stored body of block #6 so its uncle hash no longer matches the header.
+	corrupt := blockchain.GetBlockByNumber(6)
+	body := &types.Body{Uncles: []*types.Header{blockchain.GetBlockByNumber(5).Header()}}
+	if err := WriteBody(db, corrupt.Hash(), body); err != nil {
+		t.Fatal(err)
+	}
+	blockchain.bodyCache.Purge()
+	blockchain.bodyRLPCache.Purge()
+	blockchain.blockCache.Purge()
+
+	b, err := blockchain.VerifyRange(0, 10)
+	if err == nil {
+		t.Fatal("expected error for corrupted block")
+	}
+	if b == nil || b.Hash() != corrupt.Hash() {
+		t.Errorf("offending block: want: #%d, got: %v", corrupt.NumberU64(), b)
+	}
+	if _, err := blockchain.VerifyRange(0, 5); err != nil {
+		t.Errorf("unexpected error below corrupted block: %v", err)
+	}
+}
+
+func TestBlockChain_ProcFutureBlocks(t *testing.T) {
+	db, blockchain, err := newCanonical(MakeChainConfig(), 0, true)
+	if err != nil {
+		t.Fatalf("failed to make new canonical chain: %v", err)
+	}
+	if n := blockchain.procFutureBlocks(); n != 0 {
+		t.Fatalf("processed with no future blocks: want: 0, got: %d", n)
+	}
+
+	blocks, _ := GenerateChain(MakeChainConfig(), blockchain.Genesis(), db, 3, func(i int, b *BlockGen) {})
+	// Queue in reverse order to check blocks are sorted before import.
+	for i := len(blocks) - 1; i >= 0; i-- {
+		blockchain.futureBlocks.Add(blocks[i].Hash(), blocks[i])
+	}
+	if n := blockchain.procFutureBlocks(); n != len(blocks) {
+		t.Fatalf("processed future blocks: want: %d, got: %d", len(blocks), n)
+	}
+	if head := blockchain.CurrentBlock().Hash(); head != blocks[len(blocks)-1].Hash() {
+		t.Errorf("head: want: %x, got: %x", blocks[len(blocks)-1].Hash(), head)
+	}
+	if n := blockchain.futureBlocks.Len(); n != 0 {
+		t.Errorf("future blocks remaining: want: 0, got: %d", n)
+	}
+}
+
+func TestInsertChainGasUsed(t *testing.T) {
+	key, err := crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gendb, _ := ethdb.NewMemDatabase()
+	db, _ := ethdb.NewMemDatabase()
+	var (
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		genesis = WriteGenesisBlockForTesting(gendb, GenesisAccount{address, big.NewInt(1000000000)})
+		signer  = types.NewChainIdSigner(big.NewInt(63))
+		config  = MakeDiehardChainConfig()
+	)
+	blocks, _ := GenerateChain(config, genesis, gendb, 3, func(i int, block *BlockGen) {
+		tx, err := types.NewTransaction(block.TxNonce(address), common.Address{0x01}, big.NewInt(1000), TxGas, new(big.Int), nil).WithSigner(signer).SignECDSA(key)
+		if err != nil {
+			panic(err)
+		}
+		block.AddTx(tx)
+	})
+	WriteGenesisBlockForTesting(db, GenesisAccount{address, big.NewInt(1000000000)})
+	blockchain, err := NewBlockChain(db, config, FakePow{}, new(event.TypeMux), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := blockchain.InsertChain(blocks)
+	if res.Error != nil {
+		t.Fatalf("failed to process block %d: %v", res.Index, res.Error)
+	}
+	want := new(big.Int).Mul(TxGas, big.NewInt(int64(len(blocks))))
+	if res.GasUsed.Cmp(want) != 0 {
+		t.Errorf("gas used: want: %v, got: %v", want, res.GasUsed)
+	}
+
+	// Reinserting known blocks processes nothing.
+	res = blockchain.InsertChain(blocks)
+	if res.Error != nil {
+		t.Fatalf("failed to reprocess block %d: %v", res.Index, res.Error)
+	}
+	if res.Processed != 0 || res.GasUsed == nil || res.GasUsed.Sign() != 0 {
+		t.Errorf("known blocks: want: 0 processed and 0 gas used, got: %d processed, %v gas used", res.Processed, res.GasUsed)
+	}
+}
+
+func TestBlockChain_GetBlockHashesFromHashStep(t *testing.T) {
+	_, blockchain, err := newCanonical(testChainConfig(), 10, true)
+	if err != nil {
+		t.Fatalf("failed to make new canonical chain: %v", err)
+	}
+	hashes := func(numbers ...uint64) []common.Hash {
+		var hs []common.Hash
+		for _, n := range numbers {
+			hs = append(hs, blockchain.GetBlockByNumber(n).Hash())
+		}
+		return hs
+	}
+	origin := blockchain.GetBlockByNumber(5).Hash()
+
+	tests := []struct {
+		max, step uint64
+		forward   bool
+		want      []common.Hash
+	}{
+		{3, 1, false, hashes(4, 3, 2)},
+		{10, 1, false, hashes(4, 3, 2, 1, 0)},
+		{10, 2, false, hashes(3, 1)},
+		{10, 5, false, hashes(0)},
+		{10, 6, false, nil},
+		{3, 0, false, hashes(4, 3, 2)},
+		{3, 1, true, hashes(6, 7, 8)},
+		{10, 2, true, hashes(7, 9)},
+		{10, 5, true, hashes(10)},
+		{10, 6, true, nil},
+	}
+	for i, tt := range tests {
+		got := blockchain.GetBlockHashesFromHashStep(origin, tt.max, tt.step, tt.forward)
+		if len(got) != len(tt.want) {
+			t.Errorf("test %d: length: want: %d, got: %d", i, len(tt.want), len(got))
+			continue
+		}
+		for j := range got {
+			if got[j] != tt.want[j] {
+				t.Errorf("test %d: hash %d: want: %x, got: %x", i, j, tt.want[j], got[j])
+			}
+		}
+	}
+
+	if got := blockchain.GetBlockHashesFromHash(origin, 3); len(got) != 3 || got[0] != hashes(4)[0] {
+		t.Errorf("GetBlockHashesFromHash: want: %x, got: %x", hashes(4, 3, 2), got)
+	}
+	if got := blockchain.GetBlockHashesFromHashStep(common.Hash{0x01}, 3, 1, true); got != nil {
+		t.Errorf("unknown hash: want: nil, got: %x", got)
+	}
+}
+
+func TestBlockChain_SetHeadTo(t *testing.T) {
+	db, blockchain, err := newCanonical(testChainConfig(), 10, true)
+	if err != nil {
+		t.Fatalf("failed to make new canonical chain: %v", err)
+	}
+	head := blockchain.CurrentBlock()
+	target := blockchain.GetBlockByNumber(5)
+
+	check := func(want *types.Block) {
+		if got := blockchain.CurrentBlock().Hash(); got != want.Hash() {
+			t.Errorf("current block: want: #%d, got: #%d", want.NumberU64(), blockchain.CurrentBlock().NumberU64())
+		}
+		if got := blockchain.CurrentFastBlock().Hash(); got != want.Hash() {
+			t.Errorf("current fast block: want: #%d, got: #%d", want.NumberU64(), blockchain.CurrentFastBlock().NumberU64())
+		}
+		if got := blockchain.CurrentHeader().Hash(); got != want.Hash() {
+			t.Errorf("current header: want: #%d, got: #%d", want.NumberU64(), blockchain.CurrentHeader().Number.Uint64())
+		}
+		if got := GetHeadBlockHash(db); got != want.Hash() {
+			t.Errorf("head block hash: want: %x, got: %x", want.Hash(), got)
+		}
+	}
+
+	// Roll forward to stored canonical blocks above head pointers lagging behind.
+	blockchain.mu.Lock()
+	blockchain.hc.SetCurrentHeader(target.Header())
+	blockchain.currentBlock, blockchain.currentFastBlock = target, target
+	blockchain.mu.Unlock()
+	if err := blockchain.SetHeadTo(head.Hash()); err != nil {
+		t.Fatal(err)
+	}
+	check(head)
+	if err := blockchain.LoadLastState(true); err != nil {
+		t.Fatal(err)
+	}
+	check(head)
+
+	// Rewind, dropping the canonical chain above the new head but keeping the blocks.
+	if err := blockchain.SetHeadTo(target.Hash()); err != nil {
+		t.Fatal(err)
+	}
+	check(target)
+	if block := blockchain.GetBlockByNumber(head.NumberU64()); block != nil {
+		t.Errorf("block #%d still canonical above new head", block.NumberU64())
+	}
+	if blockchain.GetBlock(head.Hash()) == nil {
+		t.Error("block data above new head was deleted")
+	}
+	if err := blockchain.SetHeadTo(head.Hash()); err == nil {
+		t.Error("expected error for block no longer canonical")
+	}
+
+	if err := blockchain.SetHeadTo(common.Hash{0x01}); err == nil {
+		t.Error("expected error for unknown block")
+	}
+	// A side chain block is not canonical.
+	fork, _ := GenerateChain(testChainConfig(), blockchain.GetBlockByNumber(3), db, 1, func(i int, b *BlockGen) { b.SetCoinbase(common.Address{0x01}) })
+	if res := blockchain.InsertChain(fork); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if err := blockchain.SetHeadTo(fork[0].Hash()); err == nil {
+		t.Error("expected error for non canonical block")
+	}
+	check(target)
+}
+
+func TestReorgDepthMetrics(t *testing.T) {
+	db, blockchain, err := newCanonical(testChainConfig(), 3, true)
+	if err != nil {
+		t.Fatalf("failed to make new canonical chain: %v", err)
+	}
+	reorgs, depths := metrics.ChainReorgs.Count(), metrics.ChainReorgDepth.Count()
+
+	// A longer side chain from genesis replaces all 3 canonical blocks.
+	fork, _ := GenerateChain(testChainConfig(), blockchain.Genesis(), db, 4, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{0xff})
+	})
+	if res := blockchain.InsertChain(fork); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if blockchain.CurrentBlock().Hash() != fork[len(fork)-1].Hash() {
+		t.Fatal("side chain did not become canonical")
+	}
+	if n := metrics.ChainReorgs.Count() - reorgs; n != 1 {
+		t.Errorf("reorgs: want: 1, got: %d", n)
+	}
+	if n := metrics.ChainReorgDepth.Count() - depths; n != 1 {
+		t.Fatalf("reorg depth samples: want: 1, got: %d", n)
+	}
+	if max := metrics.ChainReorgDepth.Max(); max < 3 {
+		t.Errorf("reorg depth max: want: >=3, got: %d", max)
+	}
+}
+
+func TestReorgMaxDepth(t *testing.T) {
+	config := testChainConfig()
+	db, blockchain, err := newCanonical(config, 3, true)
+	if err != nil {
+		t.Fatalf("failed to make new canonical chain: %v", err)
+	}
+	blockchain.config.MaxReorgDepth = 2
+	head := blockchain.CurrentBlock()
+	sub := blockchain.eventMux.Subscribe(ReorgTooDeepEvent{})
+	defer sub.Unsubscribe()
+
+	// A longer side chain from genesis would replace all 3 canonical blocks.
+	fork, _ := GenerateChain(config, blockchain.Genesis(), db, 4, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{0xff})
+	})
+	res := blockchain.InsertChain(fork)
+	var reorgErr *ReorgTooDeepErr
+	if !errors.As(res.Error, &reorgErr) || !errors.Is(res.Error, ErrReorgTooDeep) {
+		t.Fatalf("want: %T wrapping %v, got: %v", reorgErr, ErrReorgTooDeep, res.Error)
+	}
+	if reorgErr.Depth != 3 || reorgErr.Max != 2 || reorgErr.CommonHash != blockchain.Genesis().Hash() {
+		t.Errorf("unexpected error details: %+v", reorgErr)
+	}
+	if blockchain.CurrentBlock().Hash() != head.Hash() {
+		t.Fatal("head changed by refused reorg")
+	}
+	select {
+	case ev := <-sub.Chan():
+		if ev.Data.(ReorgTooDeepEvent).Err != reorgErr {
+			t.Errorf("event error mismatch: have %v, want %v", ev.Data.(ReorgTooDeepEvent).Err, reorgErr)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no reorg event posted")
+	}
+
+	// A side chain replacing 2 blocks is within bounds.
+	fork, _ = GenerateChain(config, blockchain.GetBlockByNumber(1), db, 3, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{0xee})
+	})
+	if res := blockchain.InsertChain(fork); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if blockchain.CurrentBlock().Hash() != fork[len(fork)-1].Hash() {
+		t.Fatal("side chain did not become canonical")
+	}
+}
+
+// countingProcessor is a Processor counting the blocks it processed.
+type countingProcessor struct {
+	Processor
+	blocks []uint64
+}
+
+func (p *countingProcessor) Process(block *types.Block, statedb *state.StateDB) (types.Receipts, vm.Logs, *big.Int, error) {
+	p.blocks = append(p.blocks, block.NumberU64())
+	return p.Processor.Process(block, statedb)
+}
+
+func TestBlockChain_SetProcessorFactory(t *testing.T) {
+	db, blockchain, err := newCanonical(testChainConfig(), 0, true)
+	if err != nil {
+		t.Fatalf("failed to make new canonical chain: %v", err)
+	}
+	counter := &countingProcessor{Processor: NewStateProcessor(blockchain.Config(), blockchain)}
+	blockchain.SetProcessorFactory(func(n *big.Int) Processor {
+		if n.Uint64() >= 3 && n.Uint64() <= 4 {
+			return counter
+		}
+		return nil
+	})
+
+	blocks := makeBlockChain(testChainConfig(), blockchain.Genesis(), 6, db, canonicalSeed)
+	if res := blockchain.InsertChain(blocks); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if len(counter.blocks) != 2 || counter.blocks[0] != 3 || counter.blocks[1] != 4 {
+		t.Errorf("processed blocks: want: [3 4], got: %v", counter.blocks)
+	}
+	if head := blockchain.CurrentBlock().NumberU64(); head != 6 {
+		t.Errorf("head: want: 6, got: %d", head)
+	}
+}
+
+func TestInsertChainAborted(t *testing.T) {
+	db, bc, err := newCanonical(testChainConfig(), 2, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head := bc.CurrentBlock()
+	blocks := makeBlockChain(bc.config, head, 3, db, canonicalSeed)
+	headers := make([]*types.Header, len(blocks))
+	for i, block := range blocks {
+		headers[i] = block.Header()
+	}
+
+	atomic.StoreInt32(&bc.procInterrupt, 1)
+
+	res := bc.InsertChain(blocks)
+	if !res.Aborted || res.Error != nil || res.Index != 0 {
+		t.Errorf("block insert: want aborted at 0 without error, got: aborted=%v index=%d err=%v", res.Aborted, res.Index, res.Error)
+	}
+	if bc.CurrentBlock().Hash() != head.Hash() {
+		t.Errorf("head block changed: have %x, want %x", bc.CurrentBlock().Hash(), head.Hash())
+	}
+	hres := bc.InsertHeaderChain(headers, 1)
+	if !hres.Aborted || hres.Error != nil || hres.Index != 0 {
+		t.Errorf("header insert: want aborted at 0 without error, got: aborted=%v index=%d err=%v", hres.Aborted, hres.Index, hres.Error)
+	}
+}
+
+func TestBlockChain_ExportGzip(t *testing.T) {
+	_, bc, err := newCanonical(testChainConfig(), 5, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decode := func(r io.Reader) []common.Hash {
+		in, err := NewChainReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream := rlp.NewStream(in, 0)
+		var hashes []common.Hash
+		for {
+			block := new(types.Block)
+			if err := stream.Decode(block); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			hashes = append(hashes, block.Hash())
+		}
+		return hashes
+	}
+	var want []common.Hash
+	for i := uint64(0); i <= 5; i++ {
+		want = append(want, bc.GetBlockByNumber(i).Hash())
+	}
+
+	var plain, compressed bytes.Buffer
+	if err := bc.Export(&plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.ExportGzip(&compressed); err != nil {
+		t.Fatal(err)
+	}
+	if have := decode(&plain); !reflect.DeepEqual(have, want) {
+		t.Errorf("plain export mismatch: have %x, want %x", have, want)
+	}
+	if have := decode(&compressed); !reflect.DeepEqual(have, want) {
+		t.Errorf("compressed export mismatch: have %x, want %x", have, want)
+	}
+
+	// Appended compressed exports decode as one stream
+	var appended bytes.Buffer
+	if err := bc.ExportNGzip(&appended, 0, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.ExportNGzip(&appended, 3, 5); err != nil {
+		t.Fatal(err)
+	}
+	if have := decode(&appended); !reflect.DeepEqual(have, want) {
+		t.Errorf("appended export mismatch: have %x, want %x", have, want)
+	}
+}
+
+func TestBlockChain_GetTdByNumber(t *testing.T) {
+	db, bc, err := newCanonical(testChainConfig(), 5, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := uint64(0); i <= 5; i++ {
+		want := bc.GetTd(bc.GetBlockByNumber(i).Hash())
+		if have := bc.GetTdByNumber(i); have == nil || have.Cmp(want) != 0 {
+			t.Errorf("block #%d: td mismatch: have %v, want %v", i, have, want)
+		}
+	}
+	if td := bc.GetTdByNumber(6); td != nil {
+		t.Errorf("block #6: want nil td, got %v", td)
+	}
+
+	// A heavier fork replaces the canonical total difficulties
+	fork := makeBlockChain(bc.config, bc.genesisBlock, 7, db, forkSeed)
+	if res := bc.InsertChain(fork); res.Error != nil {
+		t.Fatalf("failed to insert fork: %v", res.Error)
+	}
+	if head := bc.CurrentBlock().Hash(); head != fork[len(fork)-1].Hash() {
+		t.Fatalf("fork not canonical: head %x, want %x", head, fork[len(fork)-1].Hash())
+	}
+	for i, block := range fork {
+		want := bc.GetTd(block.Hash())
+		if have := bc.GetTdByNumber(block.NumberU64()); have == nil || have.Cmp(want) != 0 {
+			t.Errorf("fork block %d: td mismatch: have %v, want %v", i, have, want)
+		}
+	}
+}
+
+func TestBlockChain_TotalGasUsedInRange(t *testing.T) {
+	key, _ := crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	addr := crypto.PubkeyToAddress(key.PublicKey)
+	signer := types.NewChainIdSigner(big.NewInt(63))
+	db, _ := ethdb.NewMemDatabase()
+	genesis := WriteGenesisBlockForTesting(db, GenesisAccount{addr, big.NewInt(10000000000000)})
+	config := MakeDiehardChainConfig()
+
+	bc, err := NewBlockChain(db, config, FakePow{}, &event.TypeMux{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Block i+1 carries i value transfers.
+	chain, _ := GenerateChain(config, genesis, db, 4, func(i int, gen *BlockGen) {
+		for j := 0; j < i; j++ {
+			tx, _ := types.NewTransaction(gen.TxNonce(addr), common.Address{0x01}, big.NewInt(1), TxGas, nil, nil).WithSigner(signer).SignECDSA(key)
+			gen.AddTx(tx)
+		}
+	})
+	if res := bc.InsertChain(chain); res.Error != nil {
+		t.Fatalf("failed to insert chain: %v", res.Error)
+	}
+
+	tests := []struct {
+		first, last uint64
+		want        *big.Int
+	}{
+		{0, 0, big.NewInt(0)},
+		{0, 4, new(big.Int).Mul(TxGas, big.NewInt(6))},
+		{2, 3, new(big.Int).Mul(TxGas, big.NewInt(3))},
+		{4, 4, new(big.Int).Mul(TxGas, big.NewInt(3))},
+	}
+	for _, tt := range tests {
+		have, err := bc.TotalGasUsedInRange(tt.first, tt.last)
+		if err != nil {
+			t.Errorf("range %d-%d: unexpected error: %v", tt.first, tt.last, err)
+			continue
+		}
+		if have.Cmp(tt.want) != 0 {
+			t.Errorf("range %d-%d: have %v, want %v", tt.first, tt.last, have, tt.want)
+		}
+	}
+	if _, err := bc.TotalGasUsedInRange(3, 5); err == nil {
+		t.Error("expected error for range beyond head")
+	}
+	if _, err := bc.TotalGasUsedInRange(3, 2); err == nil {
+		t.Error("expected error for inverted range")
+	}
+	if _, err := bc.TotalGasUsedInRange(0, MaxGasUsedRange); err == nil || !strings.Contains(err.Error(), "spans more than") {
+		t.Errorf("expected error for range above the cap, got %v", err)
+	}
+}
+
+func TestBlockChain_GetCanonicalHash(t *testing.T) {
+	_, bc, err := newCanonical(testChainConfig(), 3, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := uint64(0); n <= 3; n++ {
+		if have, want := bc.GetCanonicalHash(n), bc.GetBlockByNumber(n).Hash(); have != want {
+			t.Errorf("block #%d: hash mismatch: have %x, want %x", n, have, want)
+		}
+	}
+	if have := bc.GetCanonicalHash(4); have != (common.Hash{}) {
+		t.Errorf("unknown block: have %x, want zero hash", have)
+	}
+}
+
+func TestBlockChain_GetBlockByNumberOrHash(t *testing.T) {
+	db, bc, err := newCanonical(testChainConfig(), 3, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fork := makeBlockChain(bc.config, bc.Genesis(), 1, db, forkSeed)
+	if res := bc.InsertChain(fork); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	head := bc.CurrentBlock()
+	if head.NumberU64() != 3 {
+		t.Fatalf("side chain became canonical")
+	}
+	hash := func(h common.Hash) *common.Hash { return &h }
+
+	tests := []struct {
+		number           uint64
+		hash             *common.Hash
+		requireCanonical bool
+		want             common.Hash
+		wantErr          bool
+	}{
+		{0, nil, false, bc.Genesis().Hash(), false},
+		{2, nil, true, bc.GetCanonicalHash(2), false},
+		{4, nil, false, common.Hash{}, false},
+		{0, hash(head.Hash()), true, head.Hash(), false},
+		{0, hash(fork[0].Hash()), false, fork[0].Hash(), false},
+		{0, hash(fork[0].Hash()), true, common.Hash{}, true},
+		{0, hash(common.Hash{0x01}), true, common.Hash{}, false},
+	}
+	for i, tt := range tests {
+		block, err := bc.GetBlockByNumberOrHash(tt.number, tt.hash, tt.requireCanonical)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("test %d: want error: %v, got: %v", i, tt.wantErr, err)
+			continue
+		}
+		var have common.Hash
+		if block != nil {
+			have = block.Hash()
+		}
+		if have != tt.want {
+			t.Errorf("test %d: want block %x, got %x", i, tt.want, have)
+		}
+	}
+}
+
+func TestBlockChain_RederiveReceipts(t *testing.T) {
+	key, _ := crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	addr := crypto.PubkeyToAddress(key.PublicKey)
+	signer := types.NewChainIdSigner(big.NewInt(63))
+	db, _ := ethdb.NewMemDatabase()
+	genesis := WriteGenesisBlockForTesting(db, GenesisAccount{addr, big.NewInt(10000000000000)})
+	config := MakeDiehardChainConfig()
+
+	bc, err := NewBlockChain(db, config, FakePow{}, &event.TypeMux{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Block 2 is empty, blocks 1 and 3 carry two value transfers each.
+	chain, _ := GenerateChain(config, genesis, db, 3, func(i int, gen *BlockGen) {
+		if i == 1 {
+			return
+		}
+		for j := 0; j < 2; j++ {
+			tx, _ := types.NewTransaction(gen.TxNonce(addr), common.Address{0x01}, big.NewInt(1), TxGas, nil, nil).WithSigner(signer).SignECDSA(key)
+			gen.AddTx(tx)
+		}
+	})
+	if res := bc.InsertChain(chain); res.Error != nil {
+		t.Fatalf("failed to insert chain: %v", res.Error)
+	}
+
+	// Store receipts as an old database would have: without derived fields.
+	for _, block := range chain {
+		receipts := GetBlockReceipts(db, block.Hash())
+		for _, r := range receipts {
+			r.TxHash = common.Hash{}
+			r.GasUsed = new(big.Int)
+			r.Logs = vm.Logs{{Address: common.Address{0x02}}}
+		}
+		if err := WriteBlockReceipts(db, block.Hash(), receipts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fixed, err := bc.RederiveReceipts(1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fixed != 2 {
+		t.Errorf("fixed blocks mismatch: have %d, want 2", fixed)
+	}
+	for _, block := range chain {
+		for i, r := range GetBlockReceipts(db, block.Hash()) {
+			tx := block.Transactions()[i]
+			if r.TxHash != tx.Hash() {
+				t.Errorf("block #%d receipt %d: tx hash mismatch: have %x, want %x", block.NumberU64(), i, r.TxHash, tx.Hash())
+			}
+			if r.GasUsed.Cmp(TxGas) != 0 {
+				t.Errorf("block #%d receipt %d: gas used mismatch: have %v, want %v", block.NumberU64(), i, r.GasUsed, TxGas)
+			}
+			log := r.Logs[0]
+			if log.BlockNumber != block.NumberU64() || log.BlockHash != block.Hash() || log.TxIndex != uint(i) || log.Index != uint(i) {
+				t.Errorf("block #%d receipt %d: log fields not derived: %+v", block.NumberU64(), i, log)
+			}
+			if stored := GetReceipt(db, tx.Hash()); stored == nil || stored.Logs[0].BlockNumber != block.NumberU64() {
+				t.Errorf("block #%d receipt %d: individual receipt not rewritten", block.NumberU64(), i)
+			}
+		}
+	}
+
+	if _, err := bc.RederiveReceipts(3, 4); err == nil {
+		t.Error("expected error for range beyond head")
+	}
+	if _, err := bc.RederiveReceipts(3, 2); err == nil {
+		t.Error("expected error for inverted range")
+	}
+}
+
+func TestBlockChain_StateCommitVerification(t *testing.T) {
+	db, bc, err := newCanonical(testChainConfig(), 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc.SetStateCommitVerification(true)
+	if !bc.StateCommitVerification() {
+		t.Fatal("expected state commit verification to be enabled")
+	}
+	blocks := makeBlockChain(bc.config, bc.genesisBlock, 5, db, canonicalSeed)
+	if res := bc.InsertChain(blocks); res.Error != nil {
+		t.Fatalf("failed to insert chain with verification: %v", res.Error)
+	}
+
+	head := bc.CurrentBlock()
+	if err := bc.verifyStateCommit(head, head.Root()); err != nil {
+		t.Errorf("unexpected verification error: %v", err)
+	}
+	if err := bc.verifyStateCommit(head, common.Hash{0x01}); err == nil {
+		t.Error("expected error for mismatching root")
+	}
+	missing := types.NewBlockWithHeader(&types.Header{Root: common.Hash{0x01}})
+	if err := bc.verifyStateCommit(missing, missing.Root()); err == nil {
+		t.Error("expected error for missing state")
+	}
+}
+
+func TestBlockChain_BodyVerification(t *testing.T) {
+	db, bc, err := newCanonical(testChainConfig(), 5, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Overwrite the body of block #3 with one not matching its header
+	corrupt, intact := bc.GetBlockByNumber(3).Hash(), bc.GetBlockByNumber(4).Hash()
+	if err := WriteBody(db, corrupt, &types.Body{Uncles: []*types.Header{{Number: big.NewInt(2)}}}); err != nil {
+		t.Fatal(err)
+	}
+	purge := func() {
+		bc.blockCache.Purge()
+		bc.bodyCache.Purge()
+		bc.bodyRLPCache.Purge()
+	}
+	purge()
+	if bc.BodyVerification() {
+		t.Fatal("expected body verification to be disabled by default")
+	}
+	if bc.GetBlock(corrupt) == nil || bc.GetBody(corrupt) == nil || bc.GetBodyRLP(corrupt) == nil {
+		t.Fatal("corrupt body not returned without verification")
+	}
+
+	purge()
+	bc.SetBodyVerification(true)
+	if !bc.BodyVerification() {
+		t.Fatal("expected body verification to be enabled")
+	}
+	if bc.GetBlock(corrupt) != nil {
+		t.Error("corrupt block returned with verification")
+	}
+	if bc.GetBody(corrupt) != nil {
+		t.Error("corrupt body returned with verification")
+	}
+	if bc.GetBodyRLP(corrupt) != nil {
+		t.Error("corrupt body RLP returned with verification")
+	}
+	if bc.GetBlock(intact) == nil || bc.GetBody(intact) == nil || bc.GetBodyRLP(intact) == nil {
+		t.Error("intact block not returned with verification")
+	}
+}
+
+func TestBlockChain_PruneSideChains(t *testing.T) {
+	db, bc, err := newCanonical(testChainConfig(), 10, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A shorter side chain forking off block #2
+	fork := makeBlockChain(bc.config, bc.GetBlockByNumber(2), 4, db, forkSeed)
+	if res := bc.InsertChain(fork); res.Error != nil {
+		t.Fatalf("failed to insert fork: %v", res.Error)
+	}
+	for _, block := range fork {
+		if GetCanonicalHash(db, block.NumberU64()) == block.Hash() {
+			t.Fatalf("fork block #%d became canonical", block.NumberU64())
+		}
+		if GetBody(db, block.Hash()) == nil {
+			t.Fatalf("fork block #%d body missing before pruning", block.NumberU64())
+		}
+	}
+
+	// Prune side chain bodies below #5, leaving fork blocks #5 and #6
+	pruned, err := bc.PruneSideChains(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pruned != 2 {
+		t.Errorf("pruned %d bodies, want 2", pruned)
+	}
+	for _, block := range fork {
+		body := GetBody(db, block.Hash())
+		if block.NumberU64() < 5 && body != nil {
+			t.Errorf("fork block #%d body not pruned", block.NumberU64())
+		}
+		if block.NumberU64() >= 5 && body == nil {
+			t.Errorf("fork block #%d body pruned", block.NumberU64())
+		}
+		if bc.GetHeader(block.Hash()) == nil {
+			t.Errorf("fork block #%d header deleted", block.NumberU64())
+		}
+	}
+	for i := uint64(0); i <= bc.CurrentBlock().NumberU64(); i++ {
+		if bc.GetBlockByNumber(i) == nil {
+			t.Errorf("canonical block #%d missing after pruning", i)
+		}
+	}
+
+	// The prune limit is capped at the current block
+	if pruned, err = bc.PruneSideChains(100); err != nil {
+		t.Fatal(err)
+	}
+	if pruned != 2 {
+		t.Errorf("pruned %d bodies, want 2", pruned)
+	}
+}
+
+func TestBlockChain_ReadOnly(t *testing.T) {
+	db, bc, err := newCanonical(testChainConfig(), 5, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head := bc.CurrentBlock()
+	bc.Stop()
+
+	ro, err := NewBlockChainReadOnly(db, bc.config, new(event.TypeMux))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Stop()
+	if !ro.ReadOnly() {
+		t.Fatal("expected read-only blockchain")
+	}
+	if ro.CurrentBlock().Hash() != head.Hash() {
+		t.Fatalf("head mismatch: have %x, want %x", ro.CurrentBlock().Hash(), head.Hash())
+	}
+	if ro.GetBlockByNumber(3) == nil {
+		t.Error("expected canonical block #3 to be readable")
+	}
+
+	blocks := makeBlockChain(ro.config, head, 2, db, canonicalSeed)
+	if res := ro.InsertChain(blocks); res.Error != ErrReadOnlyChain {
+		t.Errorf("InsertChain: have error %v, want %v", res.Error, ErrReadOnlyChain)
+	}
+	headers := make([]*types.Header, len(blocks))
+	for i, block := range blocks {
+		headers[i] = block.Header()
+	}
+	if res := ro.InsertHeaderChain(headers, 1); res.Error != ErrReadOnlyChain {
+		t.Errorf("InsertHeaderChain: have error %v, want %v", res.Error, ErrReadOnlyChain)
+	}
+	if err := ro.SetHead(1); err != ErrReadOnlyChain {
+		t.Errorf("SetHead: have error %v, want %v", err, ErrReadOnlyChain)
+	}
+	if _, err := ro.PruneSideChains(3); err != ErrReadOnlyChain {
+		t.Errorf("PruneSideChains: have error %v, want %v", err, ErrReadOnlyChain)
+	}
+	if err := ro.chainDb.Put(headBlockKey, common.Hash{0x01}.Bytes()); err != ethdb.ErrReadOnly {
+		t.Errorf("database write: have error %v, want %v", err, ethdb.ErrReadOnly)
+	}
+	if hash := GetHeadBlockHash(db); hash != head.Hash() {
+		t.Errorf("database head changed: have %x, want %x", hash, head.Hash())
+	}
+}
+
+func TestBlockChain_InsertChainTrusted(t *testing.T) {
+	db, blockchain, err := newCanonical(testChainConfig(), 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := makeBlockChain(blockchain.config, blockchain.CurrentBlock(), 5, db, 0)
+	blockchain.pow = failPow{blocks[2].NumberU64()}
+
+	if res := blockchain.InsertChain(blocks); !IsBlockNonceErr(res.Error) {
+		t.Fatalf("InsertChain: have error %v, want nonce error", res.Error)
+	}
+	if res := blockchain.InsertChainTrusted(blocks); res.Error != nil {
+		t.Fatalf("InsertChainTrusted: unexpected error: %v", res.Error)
+	}
+	if head := blockchain.CurrentBlock(); head.Hash() != blocks[4].Hash() {
+		t.Errorf("head block: want: #%d, got: #%d", blocks[4].NumberU64(), head.NumberU64())
+	}
+}
+
+func TestBlockChain_HasCanonicalBlock(t *testing.T) {
+	db, blockchain, err := newCanonical(testChainConfig(), 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := makeBlockChain(blockchain.config, blockchain.Genesis(), 6, db, canonicalSeed)
+	fork := makeBlockChain(blockchain.config, blocks[1], 1, db, forkSeed)
+
+	if res := blockchain.InsertChain(blocks[:4]); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if res := blockchain.InsertChain(fork); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	for i, block := range blocks {
+		if have, want := blockchain.HasCanonicalBlock(block.Hash(), block.NumberU64()), i < 4; have != want {
+			t.Errorf("block #%d: have %v, want %v", block.NumberU64(), have, want)
+		}
+	}
+	for _, block := range fork {
+		if blockchain.HasCanonicalBlock(block.Hash(), block.NumberU64()) {
+			t.Errorf("side chain block #%d reported canonical", block.NumberU64())
+		}
+	}
+}
+
+func TestBlockChain_AncestorHashes(t *testing.T) {
+	db, blockchain, err := newCanonical(testChainConfig(), 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := makeBlockChain(blockchain.config, blockchain.Genesis(), 6, db, canonicalSeed)
+	if res := blockchain.InsertChain(blocks); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+
+	hashes := blockchain.AncestorHashes(blocks[5].Hash(), 3)
+	if len(hashes) != 4 {
+		t.Fatalf("hashes: want %d, got %d", 4, len(hashes))
+	}
+	for i, hash := range hashes {
+		if want := blocks[5-i].Hash(); hash != want {
+			t.Errorf("hash %d: want %x, got %x", i, want, hash)
+		}
+	}
+	hashes = blockchain.AncestorHashes(blocks[5].Hash(), 100)
+	if len(hashes) != 7 || hashes[6] != blockchain.Genesis().Hash() {
+		t.Errorf("hashes to genesis: want %d ending at genesis, got %d", 7, len(hashes))
+	}
+	if hashes := blockchain.AncestorHashes(common.Hash{1}, 3); hashes != nil {
+		t.Errorf("unknown block: got %d hashes", len(hashes))
+	}
+
+	// A header whose parent is unknown ends the list.
+	orphan := types.CopyHeader(blocks[3].Header())
+	orphan.ParentHash = common.Hash{1}
+	if err := WriteHeader(db, orphan); err != nil {
+		t.Fatal(err)
+	}
+	if hashes := blockchain.AncestorHashes(orphan.Hash(), 3); len(hashes) != 1 || hashes[0] != orphan.Hash() {
+		t.Errorf("orphan: want only its own hash, got %d hashes", len(hashes))
+	}
+}
+
+func TestBlockChain_WarmCache(t *testing.T) {
+	db, _, err := newCanonical(testChainConfig(), 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Reopen the chain with small caches, so that they start cold
+	blockchain, err := NewBlockChain(db, testChainConfig(), FakePow{}, new(event.TypeMux), &CacheConfig{
+		HeaderCacheLimit: 8,
+		TdCacheLimit:     8,
+		BodyCacheLimit:   4,
+		BlockCacheLimit:  4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := makeBlockChain(blockchain.config, blockchain.Genesis(), 16, db, canonicalSeed)
+	if res := blockchain.InsertChain(blocks); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	blockchain.hc.headerCache.Purge()
+	blockchain.hc.tdCache.Purge()
+	blockchain.bodyCache.Purge()
+	blockchain.bodyRLPCache.Purge()
+	blockchain.blockCache.Purge()
+
+	// The range ends beyond the head, and is larger than every cache
+	if warmed := blockchain.WarmCache(3, 100); warmed != 8 {
+		t.Errorf("warmed: want %d, got %d", 8, warmed)
+	}
+	for _, block := range blocks[2:] {
+		hash, number := block.Hash(), block.NumberU64()
+		if want, ok := number > 8, blockchain.hc.headerCache.Contains(hash); ok != want {
+			t.Errorf("block #%d: header cached %v, want %v", number, ok, want)
+		}
+		if want, ok := number > 8, blockchain.hc.tdCache.Contains(hash); ok != want {
+			t.Errorf("block #%d: td cached %v, want %v", number, ok, want)
+		}
+		if want, ok := number > 12, blockchain.bodyCache.Contains(hash); ok != want {
+			t.Errorf("block #%d: body cached %v, want %v", number, ok, want)
+		}
+		if want, ok := number > 12, blockchain.blockCache.Contains(hash); ok != want {
+			t.Errorf("block #%d: block cached %v, want %v", number, ok, want)
+		}
+	}
+	if warmed := blockchain.WarmCache(10, 5); warmed != 0 {
+		t.Errorf("empty range: warmed %d", warmed)
+	}
+}
+
+func TestFindCommonAncestor(t *testing.T) {
+	db, blockchain, err := newCanonical(testChainConfig(), 5, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fork := makeBlockChain(blockchain.config, blockchain.GetBlockByNumber(2), 2, db, forkSeed)
+	if res := blockchain.InsertChain(fork); res.Error != nil {
+		t.Fatalf("failed to insert fork: %v", res.Error)
+	}
+	orphan := types.NewBlockWithHeader(&types.Header{ParentHash: common.Hash{0x01}, Number: big.NewInt(3), Difficulty: big.NewInt(1)})
+	if err := WriteBlock(db, orphan); err != nil {
+		t.Fatal(err)
+	}
+	head := blockchain.CurrentBlock()
+	block2, block3 := blockchain.GetBlockByNumber(2), blockchain.GetBlockByNumber(3)
+
+	tests := []struct {
+		a, b common.Hash
+		want common.Hash
+	}{
+		{head.Hash(), fork[1].Hash(), block2.Hash()},
+		{fork[1].Hash(), head.Hash(), block2.Hash()},
+		{fork[0].Hash(), block3.Hash(), block2.Hash()},
+		{block3.Hash(), head.Hash(), block3.Hash()},
+		{head.Hash(), block3.Hash(), block3.Hash()},
+		{head.Hash(), head.Hash(), head.Hash()},
+		{blockchain.Genesis().Hash(), fork[1].Hash(), blockchain.Genesis().Hash()},
+	}
+	for i, tt := range tests {
+		ancestor, err := blockchain.FindCommonAncestor(tt.a, tt.b)
+		if err != nil {
+			t.Errorf("test %d: %v", i, err)
+			continue
+		}
+		if ancestor.Hash() != tt.want {
+			t.Errorf("test %d: ancestor mismatch: have #%d [%x…], want [%x…]", i, ancestor.NumberU64(), ancestor.Hash().Bytes()[:4], tt.want.Bytes()[:4])
+		}
+	}
+	if _, err := blockchain.FindCommonAncestor(head.Hash(), orphan.Hash()); err != ErrNoCommonAncestor {
+		t.Errorf("orphan: have %v, want %v", err, ErrNoCommonAncestor)
+	}
+	if _, err := blockchain.FindCommonAncestor(head.Hash(), common.Hash{0x02}); err == nil {
+		t.Error("unknown block: expected error")
+	}
+}
+
+// Tests that Recovery only reports blocks which passed its checks, resuming
+// block-by-block after the last healthy block once a missing one is found.
+func TestBlockChain_Recovery(t *testing.T) {
+	db, bc, err := newCanonical(testChainConfig(), 20, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Randomized increments may step over a single missing block, so drop all above #11.
+	for n := uint64(12); n <= 20; n++ {
+		DeleteBody(db, bc.GetBlockByNumber(n).Hash())
+	}
+	// Reopen the chain so the deleted body is not served from the caches.
+	bc, err = NewBlockChain(db, testChainConfig(), FakePow{}, new(event.TypeMux), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if checkpoint := bc.Recovery(1, 4); checkpoint != 11 {
+		t.Errorf("want: checkpoint #11, got: #%d", checkpoint)
+	}
+	if checkpoint := bc.Recovery(12, 4); checkpoint != 0 {
+		t.Errorf("want: no checkpoint from a missing block, got: #%d", checkpoint)
+	}
+}
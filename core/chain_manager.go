@@ -292,7 +292,7 @@ func newCanonical(config *ChainConfig, n int, full bool) (ethdb.Database, *Block
 		return nil, nil, err
 	}
 
-	blockchain, err := NewBlockChain(db, MakeChainConfig(), FakePow{}, evmux)
+	blockchain, err := NewBlockChain(db, MakeChainConfig(), FakePow{}, evmux, nil)
 	if err != nil {
 		return nil, nil, err
 	}
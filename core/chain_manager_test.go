@@ -75,7 +75,7 @@ func ExampleGenerateChain() {
 
 	// Import the chain. This runs all block validation rules.
 	evmux := &event.TypeMux{}
-	blockchain, _ := NewBlockChain(db, testChainConfig(), FakePow{}, evmux)
+	blockchain, _ := NewBlockChain(db, testChainConfig(), FakePow{}, evmux, nil)
 	if res := blockchain.InsertChain(chain); res.Error != nil {
 		fmt.Printf("insert error (block %d): %v\n", chain[res.Index].NumberU64(), res.Error)
 		return
@@ -0,0 +1,122 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/ethereumproject/go-ethereum/common"
+	"github.com/ethereumproject/go-ethereum/core/types"
+)
+
+// ErrCheckpointMismatch is returned for a header at the height of a trusted
+// checkpoint whose hash differs from the checkpoint.
+var ErrCheckpointMismatch = validateError("checkpoint hash mismatch")
+
+// Checkpoint is a trusted canonical block, identified by its number, hash and
+// total difficulty. Imported headers are only checked against the number and
+// hash; the total difficulty is checked against the local chain by
+// SetCheckpoints, as a header matching the hash has the checkpoint's ancestry
+// and so its total difficulty.
+type Checkpoint struct {
+	Number uint64      `json:"number"`
+	Hash   common.Hash `json:"hash"`
+	Td     *big.Int    `json:"td"`
+}
+
+// ReadCheckpoints decodes a JSON list of checkpoints, as written by
+// BlockChain.ExportCheckpoints.
+func ReadCheckpoints(r io.Reader) ([]Checkpoint, error) {
+	var checkpoints []Checkpoint
+	if err := json.NewDecoder(r).Decode(&checkpoints); err != nil {
+		return nil, err
+	}
+	for _, cp := range checkpoints {
+		if cp.Td == nil {
+			return nil, fmt.Errorf("checkpoint #%d: missing total difficulty", cp.Number)
+		}
+	}
+	return checkpoints, nil
+}
+
+// ExportCheckpoints writes the number, hash and total difficulty of every
+// interval-th canonical block up to the current header as a JSON list.
+func (bc *BlockChain) ExportCheckpoints(w io.Writer, interval uint64) error {
+	if interval == 0 {
+		return errors.New("checkpoint interval must be positive")
+	}
+	head := bc.CurrentHeader().Number.Uint64()
+
+	checkpoints := []Checkpoint{}
+	for number := interval; number <= head; number += interval {
+		hash := bc.GetCanonicalHash(number)
+		if hash == (common.Hash{}) {
+			return fmt.Errorf("missing canonical hash #%d", number)
+		}
+		td := bc.GetTd(hash)
+		if td == nil {
+			return fmt.Errorf("missing total difficulty #%d [%x…]", number, hash[:4])
+		}
+		checkpoints = append(checkpoints, Checkpoint{Number: number, Hash: hash, Td: td})
+	}
+	out, err := json.MarshalIndent(checkpoints, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(out, '\n'))
+	return err
+}
+
+// SetCheckpoints sets the trusted checkpoints headers are cross-checked against
+// on import. Checkpoints at or below the current header must match the hash and
+// total difficulty of the canonical chain, otherwise an error is returned and
+// the checkpoints are not used.
+func (bc *BlockChain) SetCheckpoints(checkpoints []Checkpoint) error {
+	head := bc.CurrentHeader().Number.Uint64()
+
+	hashes := make(map[uint64]common.Hash, len(checkpoints))
+	for _, cp := range checkpoints {
+		if prev, ok := hashes[cp.Number]; ok && prev != cp.Hash {
+			return fmt.Errorf("conflicting checkpoints #%d: %x and %x", cp.Number, prev, cp.Hash)
+		}
+		hashes[cp.Number] = cp.Hash
+
+		if cp.Number > head {
+			continue
+		}
+		if hash := bc.GetCanonicalHash(cp.Number); hash != cp.Hash {
+			return fmt.Errorf("checkpoint #%d: canonical hash %x, want %x", cp.Number, hash, cp.Hash)
+		}
+		if td := bc.GetTd(cp.Hash); td == nil || td.Cmp(cp.Td) != 0 {
+			return fmt.Errorf("checkpoint #%d: total difficulty %v, want %v", cp.Number, td, cp.Td)
+		}
+	}
+	bc.hc.setCheckpoints(hashes)
+	return nil
+}
+
+// setCheckpoints sets the hashes, by number, headers must match on import.
+func (hc *HeaderChain) setCheckpoints(hashes map[uint64]common.Hash) {
+	hc.checkpoints.Store(hashes)
+}
+
+// headerCheck checks the header against the fork and bad hashes of the chain
+// configuration, and against the trusted checkpoints.
+func (hc *HeaderChain) headerCheck(h *types.Header) error {
+	if err := hc.config.HeaderCheck(h); err != nil {
+		return err
+	}
+	return hc.checkpointCheck(h)
+}
+
+// checkpointCheck returns ErrCheckpointMismatch if the header is at the height
+// of a checkpoint but has a different hash.
+func (hc *HeaderChain) checkpointCheck(h *types.Header) error {
+	hashes, _ := hc.checkpoints.Load().(map[uint64]common.Hash)
+	if want, ok := hashes[h.Number.Uint64()]; ok && want != h.Hash() {
+		return ErrCheckpointMismatch
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package core
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+)
+
+func TestCheckpoints(t *testing.T) {
+	db, blockchain, err := newCanonical(testChainConfig(), 10, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := blockchain.ExportCheckpoints(&buf, 4); err != nil {
+		t.Fatal(err)
+	}
+	checkpoints, err := ReadCheckpoints(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checkpoints) != 2 || checkpoints[0].Number != 4 || checkpoints[1].Number != 8 {
+		t.Fatalf("checkpoints: want #4 and #8, got %v", checkpoints)
+	}
+	for _, cp := range checkpoints {
+		header := blockchain.GetHeaderByNumber(cp.Number)
+		if cp.Hash != header.Hash() || cp.Td.Cmp(blockchain.GetTd(header.Hash())) != 0 {
+			t.Errorf("checkpoint #%d: got %x td %v, want %x td %v", cp.Number, cp.Hash, cp.Td, header.Hash(), blockchain.GetTd(header.Hash()))
+		}
+	}
+
+	// Checkpoints not matching the canonical chain are refused
+	bad := append([]Checkpoint{}, checkpoints...)
+	bad[1].Hash[0]++
+	if err := blockchain.SetCheckpoints(bad); err == nil {
+		t.Error("checkpoint with wrong hash accepted")
+	}
+	bad = append([]Checkpoint{}, checkpoints...)
+	bad[0].Td = new(big.Int).Add(bad[0].Td, big1)
+	if err := blockchain.SetCheckpoints(bad); err == nil {
+		t.Error("checkpoint with wrong total difficulty accepted")
+	}
+	if err := blockchain.SetCheckpoints(checkpoints); err != nil {
+		t.Fatalf("failed to set checkpoints: %v", err)
+	}
+
+	// A checkpoint above the head is enforced on import
+	canonical := makeHeaderChain(blockchain.config, blockchain.CurrentHeader(), 4, db, canonicalSeed)
+	fork := makeHeaderChain(blockchain.config, blockchain.CurrentHeader(), 4, db, forkSeed)
+	checkpoints = append(checkpoints, Checkpoint{Number: canonical[1].Number.Uint64(), Hash: canonical[1].Hash(), Td: new(big.Int)})
+	if err := blockchain.SetCheckpoints(checkpoints); err != nil {
+		t.Fatalf("failed to set checkpoints: %v", err)
+	}
+	if res := blockchain.InsertHeaderChain(fork, 1); res.Error != ErrCheckpointMismatch {
+		t.Errorf("fork import: got %v, want %v", res.Error, ErrCheckpointMismatch)
+	}
+	if res := blockchain.InsertHeaderChain(canonical, 1); res.Error != nil {
+		t.Errorf("canonical import: %v", res.Error)
+	}
+}
@@ -150,6 +150,11 @@ type ChainConfig struct {
 
 	// BadHashes holds well known blocks with consensus issues. See ErrHashKnownBad.
 	BadHashes []*BadHash `json:"badHashes"`
+
+	// MaxReorgDepth is the maximum number of canonical blocks a chain reorganisation may
+	// remove. Deeper reorganisations are refused with a ReorgTooDeepErr until the operator
+	// intervenes. Zero means unlimited.
+	MaxReorgDepth uint64 `json:"maxReorgDepth,omitempty"`
 }
 
 type Fork struct {
@@ -737,54 +742,61 @@ func (o *ForkFeature) GetBigInt(name string) (*big.Int, bool) {
 	return nil, false
 }
 
-// WriteGenesisBlock writes the genesis block to the database as block number 0
-func WriteGenesisBlock(chainDb ethdb.Database, genesis *GenesisDump) (*types.Block, error) {
+// genesisBlock builds the genesis block and its state on top of chainDb without
+// writing anything to it.
+func genesisBlock(chainDb ethdb.Database, genesis *GenesisDump) (*types.Block, *state.StateDB, error) {
 	statedb, err := state.New(common.Hash{}, state.NewDatabase(chainDb))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	for addrHex, account := range genesis.Alloc {
 		var addr common.Address
 		if err := addrHex.Decode(addr[:]); err != nil {
-			return nil, fmt.Errorf("malformed addres %q: %s", addrHex, err)
+			return nil, nil, fmt.Errorf("malformed addres %q: %s", addrHex, err)
 		}
 
 		balance, ok := new(big.Int).SetString(account.Balance, 0)
 		if !ok {
-			return nil, fmt.Errorf("malformed account %q balance %q", addrHex, account.Balance)
+			return nil, nil, fmt.Errorf("malformed account %q balance %q", addrHex, account.Balance)
 		}
 		statedb.AddBalance(addr, balance)
 
 		code, err := account.Code.Bytes()
 		if err != nil {
-			return nil, fmt.Errorf("malformed account %q code: %s", addrHex, err)
+			return nil, nil, fmt.Errorf("malformed account %q code: %s", addrHex, err)
 		}
 		statedb.SetCode(addr, code)
 
 		for key, value := range account.Storage {
 			var k, v common.Hash
 			if err := key.Decode(k[:]); err != nil {
-				return nil, fmt.Errorf("malformed account %q key: %s", addrHex, err)
+				return nil, nil, fmt.Errorf("malformed account %q key: %s", addrHex, err)
 			}
 			if err := value.Decode(v[:]); err != nil {
-				return nil, fmt.Errorf("malformed account %q value: %s", addrHex, err)
+				return nil, nil, fmt.Errorf("malformed account %q value: %s", addrHex, err)
 			}
 			statedb.SetState(addr, k, v)
 		}
 	}
-	root, err := statedb.CommitTo(chainDb, false)
+	header, err := genesis.Header()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	header.Root = statedb.IntermediateRoot(false)
 
-	header, err := genesis.Header()
+	return types.NewBlock(header, nil, nil, nil), statedb, nil
+}
+
+// WriteGenesisBlock writes the genesis block to the database as block number 0
+func WriteGenesisBlock(chainDb ethdb.Database, genesis *GenesisDump) (*types.Block, error) {
+	gblock, statedb, err := genesisBlock(chainDb, genesis)
 	if err != nil {
 		return nil, err
 	}
-	header.Root = root
-
-	gblock := types.NewBlock(header, nil, nil, nil)
+	if _, err := statedb.CommitTo(chainDb, false); err != nil {
+		return nil, err
+	}
 
 	if block := GetBlock(chainDb, gblock.Hash()); block != nil {
 		glog.V(logger.Debug).Infof("Genesis block %s already exists in chain -- writing canonical number", block.Hash().Hex())
@@ -798,7 +810,7 @@ func WriteGenesisBlock(chainDb ethdb.Database, genesis *GenesisDump) (*types.Blo
 	//if err := stateBatch.Write(); err != nil {
 	//	return nil, fmt.Errorf("cannot write state: %v", err)
 	//}
-	if err := WriteTd(chainDb, gblock.Hash(), header.Difficulty); err != nil {
+	if err := WriteTd(chainDb, gblock.Hash(), gblock.Difficulty()); err != nil {
 		return nil, err
 	}
 	if err := WriteBlock(chainDb, gblock); err != nil {
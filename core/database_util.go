@@ -19,6 +19,7 @@ package core
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"github.com/ethereumproject/go-ethereum/common"
 	"github.com/ethereumproject/go-ethereum/core/types"
@@ -34,6 +35,8 @@ var (
 	headBlockKey  = []byte("LastBlock")
 	headFastKey   = []byte("LastFast")
 
+	statePrunedKey = []byte("StatePruned") // set once block states have been pruned, see GCModeFull
+
 	blockPrefix    = []byte("block-")
 	blockNumPrefix = []byte("block-num-")
 
@@ -45,8 +48,11 @@ var (
 	receiptsPrefix      = []byte("receipts-")
 	blockReceiptsPrefix = []byte("receipts-block-")
 
-	mipmapPre    = []byte("mipmap-log-bloom-")
-	MIPMapLevels = []uint64{1000000, 500000, 100000, 50000, 1000}
+	mipmapPre       = []byte("mipmap-log-bloom-")
+	mipmapLevelsKey = []byte("setting-mipmap-levels")
+
+	// DefaultMIPMapLevels are the block ranges of the log bloom bins, coarsest first.
+	DefaultMIPMapLevels = []uint64{1000000, 500000, 100000, 50000, 1000}
 
 	blockHashPrefix = []byte("block-hash-") // [deprecated by the header/block split, remove eventually]
 
@@ -272,6 +278,21 @@ func WriteHeadBlockHash(db ethdb.Database, hash common.Hash) error {
 	return nil
 }
 
+// IsStatePruned reports whether the states of old blocks may have been pruned from the database.
+func IsStatePruned(db ethdb.Database) bool {
+	ok, _ := db.Has(statePrunedKey)
+	return ok
+}
+
+// WriteStatePruned marks the database as holding only some of the block states.
+func WriteStatePruned(db ethdb.Database) error {
+	if err := db.Put(statePrunedKey, []byte{1}); err != nil {
+		glog.Fatalf("failed to store state pruning marker into database: %v", err)
+		return err
+	}
+	return nil
+}
+
 // WriteHeadFastBlockHash stores the fast head block's hash.
 func WriteHeadFastBlockHash(db ethdb.Database, hash common.Hash) error {
 	if err := db.Put(headFastKey, hash.Bytes()); err != nil {
@@ -304,7 +325,7 @@ func WriteBody(db ethdb.Database, hash common.Hash, body *types.Body) error {
 	}
 	key := append(append(blockPrefix, hash.Bytes()...), bodySuffix...)
 	if err := db.Put(key, data); err != nil {
-		glog.Fatalf("failed to store block body into database: %v", err)
+		glog.Errorf("failed to store block body into database: %v", err)
 		return err
 	}
 	glog.V(logger.Detail).Infof("stored block body [%x…]", hash.Bytes()[:4])
@@ -355,7 +376,7 @@ func WriteBlockReceipts(db ethdb.Database, hash common.Hash, receipts types.Rece
 	}
 	// Store the flattened receipt slice
 	if err := db.Put(append(blockReceiptsPrefix, hash.Bytes()...), bytes); err != nil {
-		glog.Fatalf("failed to store block receipts into database: %v", err)
+		glog.Errorf("failed to store block receipts into database: %v", err)
 		return err
 	}
 	glog.V(logger.Detail).Infof("stored block receipts [%x…]", hash.Bytes()[:4])
@@ -399,7 +420,7 @@ func WriteTransactions(db ethdb.Database, block *types.Block) error {
 	}
 	// Write the scheduled data into the database
 	if err := batch.Write(); err != nil {
-		glog.Fatalf("failed to store transactions into database: %v", err)
+		glog.Errorf("failed to store transactions into database: %v", err)
 		return err
 	}
 	return nil
@@ -422,7 +443,7 @@ func WriteReceipts(db ethdb.Database, receipts types.Receipts) error {
 	}
 	// Write the scheduled data into the database
 	if err := batch.Write(); err != nil {
-		glog.Fatalf("failed to store receipts into database: %v", err)
+		glog.Errorf("failed to store receipts into database: %v", err)
 		return err
 	}
 	return nil
@@ -443,6 +464,16 @@ func DeleteBody(db ethdb.Database, hash common.Hash) {
 	db.Delete(append(append(blockPrefix, hash.Bytes()...), bodySuffix...))
 }
 
+// bodyKeyHash returns the block hash of a block body key, and false if the key
+// is not a block body key.
+func bodyKeyHash(key []byte) (common.Hash, bool) {
+	if len(key) != len(blockPrefix)+common.HashLength+len(bodySuffix) ||
+		!bytes.HasPrefix(key, blockPrefix) || !bytes.HasSuffix(key, bodySuffix) {
+		return common.Hash{}, false
+	}
+	return common.BytesToHash(key[len(blockPrefix) : len(blockPrefix)+common.HashLength]), true
+}
+
 // DeleteTd removes all block total difficulty data associated with a hash.
 func DeleteTd(db ethdb.Database, hash common.Hash) {
 	db.Delete(append(append(blockPrefix, hash.Bytes()...), tdSuffix...))
@@ -477,6 +508,12 @@ func PreimageTable(db ethdb.Database) ethdb.Database {
 	return ethdb.NewTable(db, preimagePrefix)
 }
 
+// GetPreimage retrieves the preimage of the given SHA3 hash, or nil if it is not known.
+func GetPreimage(db ethdb.Database, hash common.Hash) []byte {
+	data, _ := PreimageTable(db).Get(hash.Bytes())
+	return data
+}
+
 // WritePreimages writes the provided set of preimages to the database. `number` is the
 // current block number, and is used for debug messages only.
 func WritePreimages(db ethdb.Database, number uint64, preimages map[common.Hash][]byte) error {
@@ -550,10 +587,10 @@ func mipmapKey(num, level uint64) []byte {
 }
 
 // WriteMapmapBloom writes each address included in the receipts' logs to the
-// MIP bloom bin.
-func WriteMipmapBloom(db ethdb.Database, number uint64, receipts types.Receipts) error {
+// MIP bloom bin of each of the given levels.
+func WriteMipmapBloom(db ethdb.Database, levels []uint64, number uint64, receipts types.Receipts) error {
 	batch := db.NewBatch()
-	for _, level := range MIPMapLevels {
+	for _, level := range levels {
 		key := mipmapKey(number, level)
 		bloomDat, _ := db.Get(key)
 		bloom := types.BytesToBloom(bloomDat)
@@ -570,8 +607,49 @@ func WriteMipmapBloom(db ethdb.Database, number uint64, receipts types.Receipts)
 	return nil
 }
 
+// ValidateMipmapLevels checks that the given mipmap levels are positive and strictly
+// descending.
+func ValidateMipmapLevels(levels []uint64) error {
+	if len(levels) == 0 {
+		return errors.New("no mipmap levels")
+	}
+	for i, level := range levels {
+		if level == 0 {
+			return errors.New("mipmap level must be positive")
+		}
+		if i > 0 && level >= levels[i-1] {
+			return fmt.Errorf("mipmap levels must be strictly descending: %d follows %d", level, levels[i-1])
+		}
+	}
+	return nil
+}
+
+// GetMipmapLevels returns the mipmap levels the bins in the database were written with,
+// or nil if they were not recorded.
+func GetMipmapLevels(db ethdb.Database) []uint64 {
+	data, _ := db.Get(mipmapLevelsKey)
+	if len(data) == 0 {
+		return nil
+	}
+	var levels []uint64
+	if err := rlp.DecodeBytes(data, &levels); err != nil {
+		glog.V(logger.Error).Infof("invalid mipmap levels RLP: %v", err)
+		return nil
+	}
+	return levels
+}
+
+// WriteMipmapLevels records the mipmap levels the bins in the database were written with.
+func WriteMipmapLevels(db ethdb.Database, levels []uint64) error {
+	data, err := rlp.EncodeToBytes(levels)
+	if err != nil {
+		return err
+	}
+	return db.Put(mipmapLevelsKey, data)
+}
+
 // GetMipmapBloom returns a bloom filter using the number and level as input
-// parameters. For available levels see MIPMapLevels.
+// parameters. For available levels see BlockChain.MipmapLevels.
 func GetMipmapBloom(db ethdb.Database, number, level uint64) types.Bloom {
 	bloomDat, _ := db.Get(mipmapKey(number, level))
 	return types.BytesToBloom(bloomDat)
@@ -23,6 +23,7 @@ import (
 	"io/ioutil"
 	"math/big"
 	"os"
+	"reflect"
 	"strconv"
 	"testing"
 
@@ -771,10 +772,10 @@ func TestMipmapBloom(t *testing.T) {
 		&vm.Log{Address: common.BytesToAddress([]byte("address1"))},
 	}
 
-	WriteMipmapBloom(db, 1, types.Receipts{receipt1})
-	WriteMipmapBloom(db, 2, types.Receipts{receipt2})
+	WriteMipmapBloom(db, DefaultMIPMapLevels, 1, types.Receipts{receipt1})
+	WriteMipmapBloom(db, DefaultMIPMapLevels, 2, types.Receipts{receipt2})
 
-	for _, level := range MIPMapLevels {
+	for _, level := range DefaultMIPMapLevels {
 		bloom := GetMipmapBloom(db, 2, level)
 		if !types.BloomLookup(bloom, []byte("address1")) {
 			t.Error("expected test to be included on level:", level)
@@ -787,13 +788,13 @@ func TestMipmapBloom(t *testing.T) {
 	receipt.Logs = vm.Logs{
 		&vm.Log{Address: common.BytesToAddress([]byte("test"))},
 	}
-	WriteMipmapBloom(db, 999, types.Receipts{receipt1})
+	WriteMipmapBloom(db, DefaultMIPMapLevels, 999, types.Receipts{receipt1})
 
 	receipt = new(types.Receipt)
 	receipt.Logs = vm.Logs{
 		&vm.Log{Address: common.BytesToAddress([]byte("test 1"))},
 	}
-	WriteMipmapBloom(db, 1000, types.Receipts{receipt})
+	WriteMipmapBloom(db, DefaultMIPMapLevels, 1000, types.Receipts{receipt})
 
 	bloom := GetMipmapBloom(db, 1000, 1000)
 	if types.BloomLookup(bloom, []byte("test")) {
@@ -845,7 +846,7 @@ func TestMipmapChain(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		WriteMipmapBloom(db, uint64(i+1), receipts)
+		WriteMipmapBloom(db, DefaultMIPMapLevels, uint64(i+1), receipts)
 	})
 	for i, block := range chain {
 		WriteBlock(db, block)
@@ -865,3 +866,115 @@ func TestMipmapChain(t *testing.T) {
 		t.Error("address was included in bloom and should not have")
 	}
 }
+
+func TestMipmapLevels(t *testing.T) {
+	for _, levels := range [][]uint64{nil, {1000, 0}, {1000, 1000}, {100, 1000}} {
+		if err := ValidateMipmapLevels(levels); err == nil {
+			t.Errorf("%v: expected error", levels)
+		}
+	}
+
+	bc := new(BlockChain)
+	if levels := bc.MipmapLevels(); !reflect.DeepEqual(levels, DefaultMIPMapLevels) {
+		t.Errorf("default levels: want %v, got %v", DefaultMIPMapLevels, levels)
+	}
+	if err := bc.SetMipmapLevels([]uint64{100, 1000}); err == nil {
+		t.Error("ascending levels set")
+	}
+	if err := bc.SetMipmapLevels([]uint64{1000, 100}); err != nil {
+		t.Fatal(err)
+	}
+	if levels := bc.MipmapLevels(); !reflect.DeepEqual(levels, []uint64{1000, 100}) {
+		t.Errorf("levels not set: %v", levels)
+	}
+
+	db, _ := ethdb.NewMemDatabase()
+	if levels := GetMipmapLevels(db); levels != nil {
+		t.Errorf("levels of empty database: %v", levels)
+	}
+	want := []uint64{10000, 100}
+	if err := WriteMipmapLevels(db, want); err != nil {
+		t.Fatal(err)
+	}
+	if levels := GetMipmapLevels(db); !reflect.DeepEqual(levels, want) {
+		t.Errorf("levels: want %v, got %v", want, levels)
+	}
+}
+
+func TestGetAddrTxsPage(t *testing.T) {
+	dbFilepath, err := ioutil.TempDir("", "geth-db-util-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dbFilepath)
+	db, _ := ethdb.NewLDBDatabase(dbFilepath, 10, 100)
+
+	key := crypto.ToECDSA(common.Hex2Bytes("123915e4d060149eb4365960e6a7a45f334393093061116b197e3240065ff2d8"))
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	to := common.BytesToAddress([]byte{0x11})
+	signer := types.NewChainIdSigner(big.NewInt(1))
+
+	// Blocks 1 to 3 each contain 2 transactions to 'to', and one to 'from' itself.
+	nonce := uint64(0)
+	for n := int64(1); n <= 3; n++ {
+		var txs []*types.Transaction
+		for _, recipient := range []common.Address{to, to, from} {
+			tx, err := types.NewTransaction(nonce, recipient, big.NewInt(1), big.NewInt(21000), big.NewInt(1), nil).WithSigner(signer).SignECDSA(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			txs = append(txs, tx)
+			nonce++
+		}
+		if err := WriteBlockAddTxIndexes(db, types.NewBlock(&types.Header{Number: big.NewInt(n)}, txs, nil, nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Page through all transactions of 'from', which includes each self-transfer only once.
+	var all []AddrTx
+	for offset := 0; offset >= 0; {
+		page, next, err := GetAddrTxsPage(db, from, 0, 0, "", offset, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) > 4 {
+			t.Fatalf("page at offset %d exceeds limit: %d", offset, len(page))
+		}
+		all = append(all, page...)
+		offset = next
+	}
+	if len(all) != 9 {
+		t.Fatalf("want: %d transactions, got: %d", 9, len(all))
+	}
+	seen := make(map[common.Hash]bool)
+	for _, tx := range all {
+		if seen[tx.Hash] {
+			t.Errorf("duplicate transaction %x", tx.Hash)
+		}
+		seen[tx.Hash] = true
+	}
+
+	// Direction and block range filters.
+	page, next, err := GetAddrTxsPage(db, to, 2, 2, "to", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 2 || next != -1 {
+		t.Errorf("want: 2 transactions and no next page, got: %d, next: %d", len(page), next)
+	}
+	for _, tx := range page {
+		if tx.BlockNumber != 2 {
+			t.Errorf("want: block 2, got: %d", tx.BlockNumber)
+		}
+	}
+	if page, _, _ := GetAddrTxsPage(db, to, 0, 0, "from", 0, 0); len(page) != 0 {
+		t.Errorf("want: no transactions from %x, got: %d", to, len(page))
+	}
+	if page, next, _ := GetAddrTxsPage(db, to, 0, 0, "", 100, 10); len(page) != 0 || next != -1 {
+		t.Errorf("want: empty last page, got: %d, next: %d", len(page), next)
+	}
+	if _, _, err := GetAddrTxsPage(db, to, 0, 0, "x", 0, 0); err == nil {
+		t.Error("want: error for invalid direction")
+	}
+}
@@ -29,8 +29,123 @@ var (
 	BlockFutureErr   = errors.New("block time is in the future")
 	BlockTSTooBigErr = errors.New("block time too big")
 	BlockEqualTSErr  = errors.New("block time stamp equal to previous")
+
+	// ErrNonContiguous is wrapped by NonContiguousErr.
+	ErrNonContiguous = errors.New("non contiguous insert")
+
+	// ErrReorgTooDeep is wrapped by ReorgTooDeepErr.
+	ErrReorgTooDeep = errors.New("chain reorganisation too deep")
+
+	// ErrStateUnavailable is wrapped by StateUnavailableErr.
+	ErrStateUnavailable = errors.New("state not available")
+
+	// ErrAccountLimit is wrapped by AccountLimitErr.
+	ErrAccountLimit = errors.New("too many transactions from account")
+
+	// ErrNoCommonAncestor is returned when the chains of two blocks don't meet in the
+	// local database.
+	ErrNoCommonAncestor = errors.New("no common ancestor")
+
+	// ErrGenesisMismatch is wrapped by GenesisMismatchErr.
+	ErrGenesisMismatch = errors.New("database already contains a different genesis block")
+
+	// ErrInterrupted is returned when header validation is interrupted by a shutdown.
+	ErrInterrupted = errors.New("interrupted by shutdown")
 )
 
+// NonContiguousErr is returned by chain insertion when the given blocks are not ordered
+// and linked by number and parent hash. It wraps ErrNonContiguous.
+type NonContiguousErr struct {
+	PrevIndex, Index   int
+	PrevNumber, Number uint64
+	PrevHash, Hash     common.Hash
+	ParentHash         common.Hash
+}
+
+func (err *NonContiguousErr) Error() string {
+	return fmt.Sprintf("%v: item %d is #%d [%x…], item %d is #%d [%x…] (parent [%x…])", ErrNonContiguous,
+		err.PrevIndex, err.PrevNumber, err.PrevHash.Bytes()[:4], err.Index, err.Number, err.Hash.Bytes()[:4], err.ParentHash.Bytes()[:4])
+}
+
+func (err *NonContiguousErr) Unwrap() error {
+	return ErrNonContiguous
+}
+
+// IsNonContiguousErr returns true for non contiguous chain insert errors.
+func IsNonContiguousErr(err error) bool {
+	return errors.Is(err, ErrNonContiguous)
+}
+
+// ReorgTooDeepErr is returned by chain insertion when switching to a heavier chain would
+// remove more canonical blocks than ChainConfig.MaxReorgDepth allows. It wraps ErrReorgTooDeep.
+type ReorgTooDeepErr struct {
+	Depth, Max   uint64
+	CommonNumber uint64
+	CommonHash   common.Hash
+	OldHead      common.Hash
+	NewHead      common.Hash
+}
+
+func (err *ReorgTooDeepErr) Error() string {
+	return fmt.Sprintf("%v: depth %d > max %d, from [%x…] to [%x…] at common ancestor #%d [%x…]", ErrReorgTooDeep,
+		err.Depth, err.Max, err.OldHead.Bytes()[:4], err.NewHead.Bytes()[:4], err.CommonNumber, err.CommonHash.Bytes()[:4])
+}
+
+func (err *ReorgTooDeepErr) Unwrap() error {
+	return ErrReorgTooDeep
+}
+
+// GenesisMismatchErr is returned by SetupGenesisBlock when the database already holds a
+// genesis block other than the one to write. It wraps ErrGenesisMismatch.
+type GenesisMismatchErr struct {
+	Stored, New common.Hash
+}
+
+func (err *GenesisMismatchErr) Error() string {
+	return fmt.Sprintf("%v: have %x, new %x", ErrGenesisMismatch, err.Stored, err.New)
+}
+
+func (err *GenesisMismatchErr) Unwrap() error {
+	return ErrGenesisMismatch
+}
+
+// StateUnavailableErr is returned when the state with the given root is not in the database,
+// for example because it was pruned in GCModeFull. It wraps ErrStateUnavailable.
+type StateUnavailableErr struct {
+	Root common.Hash
+	Err  error // the missing trie node
+}
+
+func (err *StateUnavailableErr) Error() string {
+	return fmt.Sprintf("%v: root [%x…]: %v", ErrStateUnavailable, err.Root.Bytes()[:4], err.Err)
+}
+
+func (err *StateUnavailableErr) Unwrap() error {
+	return ErrStateUnavailable
+}
+
+// FutureBlockErr is returned by chain insertion when a block's time is further in the
+// future than allowed. It wraps BlockFutureErr.
+type FutureBlockErr struct {
+	Number *big.Int
+	Hash   common.Hash
+	Time   *big.Int // block timestamp
+	Max    *big.Int // latest accepted timestamp at the time of insertion
+}
+
+func (err *FutureBlockErr) Error() string {
+	return fmt.Sprintf("%v: #%d [%x…] time %v > %v", BlockFutureErr, err.Number, err.Hash.Bytes()[:4], err.Time, err.Max)
+}
+
+func (err *FutureBlockErr) Unwrap() error {
+	return BlockFutureErr
+}
+
+// IsFutureBlockErr returns true for errors rejecting blocks too far in the future.
+func IsFutureBlockErr(err error) bool {
+	return errors.Is(err, BlockFutureErr)
+}
+
 // Parent error. In case a parent is unknown this error will be thrown
 // by the block manager
 type ParentErr struct {
@@ -185,3 +300,19 @@ func IsGasLimitErr(err error) bool {
 func (err *GasLimitErr) Error() string {
 	return fmt.Sprintf("GasLimit reached. Have %d gas, transaction requires %d", err.Have, err.Want)
 }
+
+// AccountLimitErr is returned by the transaction pool when a transaction would take the
+// number of pending and queued transactions of its sender above the limit set with
+// TxPool.SetAccountLimit. It wraps ErrAccountLimit.
+type AccountLimitErr struct {
+	Account common.Address
+	Limit   int
+}
+
+func (err *AccountLimitErr) Error() string {
+	return fmt.Sprintf("%v: %x has %d transactions in the pool", ErrAccountLimit, err.Account, err.Limit)
+}
+
+func (err *AccountLimitErr) Unwrap() error {
+	return ErrAccountLimit
+}
@@ -52,6 +52,19 @@ type RemovedTransactionEvent struct{ Txs types.Transactions }
 // RemovedLogEvent is posted when a reorg happens
 type RemovedLogsEvent struct{ Logs vm.Logs }
 
+// BadForkDetectedEvent is posted when a block or header matching a configured bad hash,
+// or contradicting a fork's required hash, is found in the database at startup or
+// offered for import. Err is ErrHashKnownBad or ErrHashKnownFork.
+type BadForkDetectedEvent struct {
+	Number uint64
+	Hash   common.Hash
+	Err    error
+}
+
+// ReorgTooDeepEvent is posted when a chain reorganisation is refused for being deeper
+// than ChainConfig.MaxReorgDepth.
+type ReorgTooDeepEvent struct{ Err *ReorgTooDeepErr }
+
 // ChainSplit is posted when a new head is detected
 type ChainSplitEvent struct {
 	Block *types.Block
@@ -80,6 +93,7 @@ type ChainInsertEvent struct {
 	Queued          int
 	Ignored         int
 	TxCount         int
+	GasUsed         *big.Int
 	LastNumber      uint64
 	LastHash        common.Hash
 	Elasped         time.Duration
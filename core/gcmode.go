@@ -0,0 +1,251 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/ethereumproject/go-ethereum/common"
+	"github.com/ethereumproject/go-ethereum/core/state"
+	"github.com/ethereumproject/go-ethereum/ethdb"
+	"github.com/ethereumproject/go-ethereum/rlp"
+	"github.com/ethereumproject/go-ethereum/trie"
+)
+
+// GCMode selects which block states are kept in the database.
+type GCMode int
+
+const (
+	// GCModeArchive commits the state of every imported block to the database.
+	GCModeArchive GCMode = iota
+	// GCModeFull keeps the states of the blocks in the retention window in memory and
+	// only writes the state of one in every CacheConfig.StateRetention blocks to the
+	// database. The states of all other blocks are discarded once they leave the window,
+	// and blocks forking off from such a block are refused with a StateUnavailableErr.
+	GCModeFull
+)
+
+// DefaultStateRetention is the default number of recent block states kept in GCModeFull.
+const DefaultStateRetention = 128
+
+// MinStateRetention bounds the retention window, see CacheConfig.StateRetention.
+const MinStateRetention = 2
+
+var gcModeNames = map[GCMode]string{
+	GCModeArchive: "archive",
+	GCModeFull:    "full",
+}
+
+func (m GCMode) String() string {
+	if name, ok := gcModeNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", int(m))
+}
+
+// ParseGCMode returns the mode with the given name, either "archive" or "full".
+func ParseGCMode(name string) (GCMode, error) {
+	for m, n := range gcModeNames {
+		if strings.EqualFold(n, name) {
+			return m, nil
+		}
+	}
+	return GCModeArchive, fmt.Errorf("unknown gc mode %q, want \"archive\" or \"full\"", name)
+}
+
+// stateGCDatabase is the database block states are committed to and read from in GCModeFull.
+// Trie nodes and contract code written to it are held in memory, while all other reads and
+// writes go to the wrapped chain database. Nodes reach the chain database only when a
+// state containing them is flushed; nodes of states which left the retention window and
+// were never flushed are dropped.
+//
+// The chain database holds every node reachable from any node it holds, so walks of the
+// in-memory nodes stop at nodes found there.
+type stateGCDatabase struct {
+	ethdb.Database
+
+	lock  sync.RWMutex
+	nodes map[common.Hash][]byte // committed nodes and code not yet flushed
+	roots map[common.Hash]uint64 // roots of the states held in memory, by block number
+}
+
+func newStateGCDatabase(db ethdb.Database) *stateGCDatabase {
+	return &stateGCDatabase{
+		Database: db,
+		nodes:    make(map[common.Hash][]byte),
+		roots:    make(map[common.Hash]uint64),
+	}
+}
+
+// Put holds trie nodes and contract code, which are keyed by their hash, in memory.
+func (db *stateGCDatabase) Put(key []byte, value []byte) error {
+	if len(key) != common.HashLength {
+		return db.Database.Put(key, value)
+	}
+	db.lock.Lock()
+	db.nodes[common.BytesToHash(key)] = common.CopyBytes(value)
+	db.lock.Unlock()
+	return nil
+}
+
+func (db *stateGCDatabase) Get(key []byte) ([]byte, error) {
+	if blob, ok := db.node(key); ok {
+		return blob, nil
+	}
+	return db.Database.Get(key)
+}
+
+func (db *stateGCDatabase) Has(key []byte) (bool, error) {
+	if _, ok := db.node(key); ok {
+		return true, nil
+	}
+	return db.Database.Has(key)
+}
+
+func (db *stateGCDatabase) node(key []byte) ([]byte, bool) {
+	if len(key) != common.HashLength {
+		return nil, false
+	}
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	blob, ok := db.nodes[common.BytesToHash(key)]
+	return blob, ok
+}
+
+// Close leaves the wrapped chain database open; it is owned by the caller.
+func (db *stateGCDatabase) Close() {}
+
+// reference records that the state with the given root, committed for the block with the
+// given number, is held in memory.
+func (db *stateGCDatabase) reference(root common.Hash, number uint64) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if _, ok := db.nodes[root]; ok {
+		db.roots[root] = number
+	}
+}
+
+// flush writes all in-memory nodes of the state with the given root to the chain database,
+// making the state available after a restart.
+func (db *stateGCDatabase) flush(root common.Hash) error {
+	var flushed []common.Hash
+	batch := db.Database.NewBatch()
+	err := db.walk(root, func(hash common.Hash, blob []byte) bool {
+		flushed = append(flushed, hash)
+		return batch.Put(hash[:], blob) == nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	db.lock.Lock()
+	for _, hash := range flushed {
+		delete(db.nodes, hash)
+	}
+	delete(db.roots, root)
+	db.lock.Unlock()
+	return nil
+}
+
+// prune forgets the states of blocks numbered below the given number and drops all
+// in-memory nodes which are not part of the remaining states.
+func (db *stateGCDatabase) prune(below uint64) error {
+	var roots []common.Hash
+	db.lock.Lock()
+	for root, number := range db.roots {
+		if number < below {
+			delete(db.roots, root)
+		} else {
+			roots = append(roots, root)
+		}
+	}
+	db.lock.Unlock()
+
+	live := make(map[common.Hash]struct{})
+	for _, root := range roots {
+		err := db.walk(root, func(hash common.Hash, blob []byte) bool {
+			if _, ok := live[hash]; ok {
+				return false
+			}
+			live[hash] = struct{}{}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+	}
+	db.lock.Lock()
+	for hash := range db.nodes {
+		if _, ok := live[hash]; !ok {
+			delete(db.nodes, hash)
+		}
+	}
+	db.lock.Unlock()
+	return nil
+}
+
+// size returns the number of nodes and states held in memory.
+func (db *stateGCDatabase) size() (nodes, roots int) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	return len(db.nodes), len(db.roots)
+}
+
+// walk calls fn for every in-memory node of the state with the given root, including the
+// nodes of storage tries and contract code. Subtrees below nodes found in the chain database
+// are skipped, and so are those below nodes for which fn returns false.
+func (db *stateGCDatabase) walk(root common.Hash, fn func(hash common.Hash, blob []byte) bool) error {
+	return db.walkTrie(root, true, fn)
+}
+
+func (db *stateGCDatabase) walkTrie(root common.Hash, accounts bool, fn func(hash common.Hash, blob []byte) bool) error {
+	blob, ok := db.node(root[:])
+	if !ok {
+		return nil
+	}
+	if !fn(root, blob) {
+		return nil
+	}
+	tr, err := trie.New(root, db)
+	if err != nil {
+		return err
+	}
+	it := tr.NodeIterator(nil)
+	// The root was visited above, step into it.
+	if !it.Next(true) {
+		return it.Error()
+	}
+	for it.Next(true) {
+		for {
+			if hash := it.Hash(); hash != (common.Hash{}) {
+				blob, ok := db.node(hash[:])
+				if !ok || !fn(hash, blob) {
+					if !it.Next(false) {
+						return it.Error()
+					}
+					continue
+				}
+			}
+			break
+		}
+		if accounts && it.Leaf() {
+			var account state.Account
+			if err := rlp.DecodeBytes(it.LeafBlob(), &account); err != nil {
+				return err
+			}
+			if err := db.walkTrie(account.Root, false, fn); err != nil {
+				return err
+			}
+			codeHash := common.BytesToHash(account.CodeHash)
+			if code, ok := db.node(codeHash[:]); ok {
+				fn(codeHash, code)
+			}
+		}
+	}
+	return it.Error()
+}
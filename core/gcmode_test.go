@@ -0,0 +1,193 @@
+package core
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ethereumproject/go-ethereum/common"
+	"github.com/ethereumproject/go-ethereum/ethdb"
+	"github.com/ethereumproject/go-ethereum/event"
+)
+
+func TestParseGCMode(t *testing.T) {
+	for _, mode := range []GCMode{GCModeArchive, GCModeFull} {
+		have, err := ParseGCMode(mode.String())
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", mode, err)
+		}
+		if have != mode {
+			t.Errorf("%v: parsed as %v", mode, have)
+		}
+	}
+	if _, err := ParseGCMode("light"); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+}
+
+func TestBlockChain_GCModeFull(t *testing.T) {
+	db, err := ethdb.NewMemDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesis, err := WriteGenesisBlock(db, DefaultConfigMorden.Genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Generate the blocks against a separate database, which receives all of their states.
+	genDb, err := ethdb.NewMemDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := WriteGenesisBlock(genDb, DefaultConfigMorden.Genesis); err != nil {
+		t.Fatal(err)
+	}
+	blocks := makeBlockChain(MakeChainConfig(), genesis, 20, genDb, canonicalSeed)
+
+	cacheConfig := &CacheConfig{GCMode: GCModeFull, StateRetention: 4}
+	blockchain, err := NewBlockChain(db, MakeChainConfig(), FakePow{}, new(event.TypeMux), cacheConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blockchain.GCMode() != GCModeFull {
+		t.Fatalf("gc mode: want: %v, got: %v", GCModeFull, blockchain.GCMode())
+	}
+	if !IsStatePruned(db) {
+		t.Fatal("database not marked as pruned")
+	}
+	if res := blockchain.InsertChain(blocks); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+
+	// Blocks 17-20 are in the retention window and every 4th block was written.
+	if _, states := blockchain.gcDb.size(); states != 3 {
+		t.Errorf("states held in memory: want: %d, got: %d", 3, states)
+	}
+	for _, block := range blocks {
+		n := block.NumberU64()
+		_, err := blockchain.StateAt(block.Root())
+		if n%4 == 0 || n > 16 {
+			if err != nil {
+				t.Errorf("block #%d: unexpected error: %v", n, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrStateUnavailable) {
+			t.Errorf("block #%d: want: %v, got: %v", n, ErrStateUnavailable, err)
+		}
+	}
+
+	// The head state is written on shutdown and the database remains pruned when reopened.
+	blockchain.Stop()
+	blockchain, err = NewBlockChain(db, MakeChainConfig(), FakePow{}, new(event.TypeMux), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head := blockchain.CurrentBlock(); head.Hash() != blocks[19].Hash() {
+		t.Errorf("head block: want: #%d, got: #%d", blocks[19].NumberU64(), head.NumberU64())
+	}
+	if _, err := blockchain.StateAt(blocks[18].Root()); !errors.Is(err, ErrStateUnavailable) {
+		t.Errorf("block #%d: want: %v, got: %v", blocks[18].NumberU64(), ErrStateUnavailable, err)
+	}
+	if err := blockchain.blockIsInvalid(blockchain.CurrentBlock()); err != nil {
+		t.Errorf("head block invalid: %v", err)
+	}
+}
+
+func TestBlockChain_GCModeFullUncleanShutdown(t *testing.T) {
+	db, err := ethdb.NewMemDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesis, err := WriteGenesisBlock(db, DefaultConfigMorden.Genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	genDb, err := ethdb.NewMemDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := WriteGenesisBlock(genDb, DefaultConfigMorden.Genesis); err != nil {
+		t.Fatal(err)
+	}
+	blocks := makeBlockChain(MakeChainConfig(), genesis, 10, genDb, canonicalSeed)
+
+	cacheConfig := &CacheConfig{GCMode: GCModeFull, StateRetention: 4}
+	blockchain, err := NewBlockChain(db, MakeChainConfig(), FakePow{}, new(event.TypeMux), cacheConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := blockchain.InsertChain(blocks); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+
+	// Reopen without stopping: the chain resumes from the last written state, block #8.
+	blockchain, err = NewBlockChain(db, MakeChainConfig(), FakePow{}, new(event.TypeMux), cacheConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head := blockchain.CurrentBlock(); head.Hash() != blocks[7].Hash() {
+		t.Fatalf("head block: want: #%d, got: #%d", blocks[7].NumberU64(), head.NumberU64())
+	}
+	if res := blockchain.InsertChain(blocks[8:]); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if head := blockchain.CurrentBlock(); head.Hash() != blocks[9].Hash() {
+		t.Errorf("head block: want: #%d, got: #%d", blocks[9].NumberU64(), head.NumberU64())
+	}
+}
+
+func TestBlockChain_GCModeFullDeepFork(t *testing.T) {
+	db, err := ethdb.NewMemDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesis, err := WriteGenesisBlock(db, DefaultConfigMorden.Genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	genDb, err := ethdb.NewMemDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := WriteGenesisBlock(genDb, DefaultConfigMorden.Genesis); err != nil {
+		t.Fatal(err)
+	}
+	blocks := makeBlockChain(MakeChainConfig(), genesis, 20, genDb, canonicalSeed)
+
+	cacheConfig := &CacheConfig{GCMode: GCModeFull, StateRetention: 4}
+	blockchain, err := NewBlockChain(db, MakeChainConfig(), FakePow{}, new(event.TypeMux), cacheConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := blockchain.InsertChain(blocks); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+
+	// A fork off block #5, whose state was pruned, is refused.
+	fork := makeBlockChain(MakeChainConfig(), blocks[4], 20, genDb, forkSeed)
+	if res := blockchain.InsertChain(fork); !errors.Is(res.Error, ErrStateUnavailable) || res.Index != 0 {
+		t.Errorf("deep fork: want: %v at index 0, got: %v at index %d", ErrStateUnavailable, res.Error, res.Index)
+	}
+	// A fork off block #8, whose state was written, is accepted.
+	fork = makeBlockChain(MakeChainConfig(), blocks[7], 20, genDb, forkSeed)
+	if res := blockchain.InsertChain(fork); res.Error != nil {
+		t.Errorf("fork off written state: %v", res.Error)
+	}
+
+	// States committed outside of chain insertion are retained too.
+	statedb, err := blockchain.State()
+	if err != nil {
+		t.Fatal(err)
+	}
+	statedb.AddBalance(common.Address{1}, big1)
+	root, err := blockchain.CommitState(blockchain.CurrentBlock().NumberU64()+1, statedb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := blockchain.StateAt(root); err != nil {
+		t.Errorf("committed state: %v", err)
+	}
+	if _, ok := blockchain.gcDb.roots[root]; !ok {
+		t.Error("committed state not retained")
+	}
+}
@@ -0,0 +1,72 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/ethereumproject/go-ethereum/common"
+	"github.com/ethereumproject/go-ethereum/core/types"
+	"github.com/ethereumproject/go-ethereum/ethdb"
+)
+
+// GenesisSpec is the specification of the genesis block of a private network, in
+// the GenesisDump format with an optional chain configuration.
+type GenesisSpec struct {
+	*GenesisDump
+	Config *ChainConfig `json:"config,omitempty"`
+}
+
+// ReadGenesisSpecFromFile reads a genesis specification from a JSON file. An
+// "alloc_file" is resolved relative to the directory of the file.
+func ReadGenesisSpecFromFile(path string) (*GenesisSpec, error) {
+	path = filepath.Clean(path)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	spec := &GenesisSpec{GenesisDump: new(GenesisDump)}
+	if err := json.NewDecoder(f).Decode(spec); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	open := func(path string) (io.ReadCloser, error) { return os.Open(path) }
+	if err := parseAllocationFile(&SufficientChainConfig{Genesis: spec.GenesisDump}, open, path); err != nil {
+		return nil, err
+	}
+	if _, err := spec.Header(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if spec.Config != nil {
+		if len(spec.Config.Forks) == 0 {
+			return nil, fmt.Errorf("%s: chain configuration without forks", path)
+		}
+		spec.Config.SortForks()
+	}
+	return spec, nil
+}
+
+// SetupGenesisBlock writes the genesis block to the database like WriteGenesisBlock,
+// unless the database already holds a genesis block. If the stored block matches
+// it is returned without rewriting it, otherwise a GenesisMismatchErr is returned
+// and nothing is written.
+func SetupGenesisBlock(chainDb ethdb.Database, genesis *GenesisDump) (*types.Block, error) {
+	stored := GetCanonicalHash(chainDb, 0)
+	if stored == (common.Hash{}) {
+		return WriteGenesisBlock(chainDb, genesis)
+	}
+	block, _, err := genesisBlock(chainDb, genesis)
+	if err != nil {
+		return nil, err
+	}
+	if block.Hash() != stored {
+		return nil, &GenesisMismatchErr{Stored: stored, New: block.Hash()}
+	}
+	if b := GetBlock(chainDb, stored); b != nil {
+		return b, nil
+	}
+	return WriteGenesisBlock(chainDb, genesis)
+}
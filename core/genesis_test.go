@@ -0,0 +1,122 @@
+package core
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereumproject/go-ethereum/common"
+	"github.com/ethereumproject/go-ethereum/ethdb"
+)
+
+const testGenesisSpec = `{
+	"nonce": "0x0000000000000042",
+	"timestamp": "0x00",
+	"parentHash": "0x0000000000000000000000000000000000000000000000000000000000000000",
+	"extraData": "0x",
+	"gasLimit": "0x1388",
+	"difficulty": "0x020000",
+	"mixhash": "0x0000000000000000000000000000000000000000000000000000000000000000",
+	"coinbase": "0x0000000000000000000000000000000000000000",
+	"alloc": {
+		"000d836201318ec6899a67540690382780743280": {"balance": "200000000000000000000"}
+	},
+	"config": {
+		"forks": [
+			{"name": "Diehard", "block": 10},
+			{"name": "Homestead", "block": 0}
+		]
+	}
+}`
+
+func TestReadGenesisSpecFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "genesis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "genesis.json")
+	if err := ioutil.WriteFile(path, []byte(testGenesisSpec), 0600); err != nil {
+		t.Fatal(err)
+	}
+	spec, err := ReadGenesisSpecFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spec.Alloc) != 1 {
+		t.Errorf("alloc: got %d accounts, want 1", len(spec.Alloc))
+	}
+	if spec.Config == nil || len(spec.Config.Forks) != 2 || spec.Config.Forks[0].Name != "Homestead" {
+		t.Errorf("config: want forks sorted by block, got %v", spec.Config)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`{"config": {"forks": []}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadGenesisSpecFromFile(path); err == nil {
+		t.Error("invalid genesis specification accepted")
+	}
+}
+
+func TestSetupGenesisBlock(t *testing.T) {
+	db, _ := ethdb.NewMemDatabase()
+
+	genesis, err := SetupGenesisBlock(db, DefaultConfigMorden.Genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash := GetCanonicalHash(db, 0); hash != genesis.Hash() {
+		t.Fatalf("canonical genesis: got %x, want %x", hash, genesis.Hash())
+	}
+	// The same genesis may be set up again, without rewriting it
+	counter := &putCounter{Database: db}
+	if block, err := SetupGenesisBlock(counter, DefaultConfigMorden.Genesis); err != nil {
+		t.Errorf("same genesis: %v", err)
+	} else if block.Hash() != genesis.Hash() {
+		t.Errorf("same genesis: got %x, want %x", block.Hash(), genesis.Hash())
+	}
+	if counter.puts != 0 {
+		t.Errorf("same genesis: %d database writes, want none", counter.puts)
+	}
+
+	// A different genesis is refused and not written
+	_, err = SetupGenesisBlock(db, DefaultConfigMainnet.Genesis)
+	if !errors.Is(err, ErrGenesisMismatch) {
+		t.Fatalf("different genesis: got %v, want %v", err, ErrGenesisMismatch)
+	}
+	var mismatch *GenesisMismatchErr
+	if !errors.As(err, &mismatch) || mismatch.Stored != genesis.Hash() || mismatch.New == (common.Hash{}) {
+		t.Errorf("different genesis: got %v", err)
+	}
+	if hash := GetCanonicalHash(db, 0); hash != genesis.Hash() {
+		t.Errorf("canonical genesis overwritten: got %x, want %x", hash, genesis.Hash())
+	}
+}
+
+// putCounter counts the writes to the wrapped database.
+type putCounter struct {
+	ethdb.Database
+	puts int
+}
+
+func (c *putCounter) Put(key []byte, value []byte) error {
+	c.puts++
+	return c.Database.Put(key, value)
+}
+
+func (c *putCounter) NewBatch() ethdb.Batch {
+	return &putCounterBatch{Batch: c.Database.NewBatch(), c: c}
+}
+
+type putCounterBatch struct {
+	ethdb.Batch
+	c *putCounter
+}
+
+func (b *putCounterBatch) Put(key []byte, value []byte) error {
+	b.c.puts++
+	return b.Batch.Put(key, value)
+}
@@ -55,9 +55,17 @@ type HeaderChain struct {
 
 	procInterrupt func() bool
 
+	tieBreak int32 // TieBreakPolicy for headers of equal total difficulty, accessed atomically
+
+	checkpoints atomic.Value // map[uint64]common.Hash of trusted checkpoints, see BlockChain.SetCheckpoints
+
 	rand         *mrand.Rand
 	getValidator getHeaderValidatorFn
 	eventMux     *event.TypeMux
+
+	badForkMu      sync.Mutex             // protects badForks and postingBadFork
+	badForks       []BadForkDetectedEvent // events queued by postBadFork
+	postingBadFork bool                   // whether a goroutine is posting the queued events
 }
 
 // getHeaderValidatorFn returns a HeaderValidator interface
@@ -67,9 +75,11 @@ type getHeaderValidatorFn func() HeaderValidator
 //  getValidator should return the parent's validator
 //  procInterrupt points to the parent's interrupt semaphore
 //  wg points to the parent's shutdown wait group
-func NewHeaderChain(chainDb ethdb.Database, config *ChainConfig, mux *event.TypeMux, getValidator getHeaderValidatorFn, procInterrupt func() bool) (*HeaderChain, error) {
-	headerCache, _ := lru.New(headerCacheLimit)
-	tdCache, _ := lru.New(tdCacheLimit)
+//  cacheConfig sets the header and total difficulty cache sizes, nil uses the defaults
+func NewHeaderChain(chainDb ethdb.Database, config *ChainConfig, mux *event.TypeMux, getValidator getHeaderValidatorFn, procInterrupt func() bool, cacheConfig *CacheConfig) (*HeaderChain, error) {
+	cacheConfig = cacheConfig.withDefaults()
+	headerCache, _ := lru.New(cacheConfig.HeaderCacheLimit)
+	tdCache, _ := lru.New(cacheConfig.TdCacheLimit)
 
 	// Seed a fast but crypto originating random generator
 	seed, err := crand.Int(crand.Reader, big.NewInt(math.MaxInt64))
@@ -185,7 +195,15 @@ func (hc *HeaderChain) WriteHeader(header *types.Header) (status WriteStatus, er
 	// If the total difficulty is higher than our known, add it to the canonical chain
 	// Second clause in the if statement reduces the vulnerability to selfish mining.
 	// Please refer to http://www.cs.cornell.edu/~ie53/publications/btcProcFC.pdf
-	if externTd.Cmp(localTd) > 0 || (externTd.Cmp(localTd) == 0 && mrand.Float64() < 0.5) {
+	reorg := externTd.Cmp(localTd) > 0
+	if externTd.Cmp(localTd) == 0 {
+		if policy := hc.TieBreakPolicy(); policy == TieBreakRandom {
+			reorg = mrand.Float64() < 0.5
+		} else {
+			reorg = policy.replaceHead(number, hc.currentHeader.Number.Uint64())
+		}
+	}
+	if reorg {
 		// Delete any canonical number assignments above the new head
 		for i := number + 1; GetCanonicalHash(hc.chainDb, i) != (common.Hash{}); i++ {
 			DeleteCanonicalHash(hc.chainDb, i)
@@ -232,22 +250,21 @@ func (hc *HeaderChain) WriteHeader(header *types.Header) (status WriteStatus, er
 // header writes should be protected by the parent chain mutex individually.
 type WhCallback func(*types.Header) error
 
-// InsertHeaderChain attempts to insert the given header chain in to the local
-// chain, possibly creating a reorg. If an error is returned, it will return the
-// index number of the failing header as well an error describing what went wrong.
-//
-// The verify parameter can be used to fine tune whether nonce verification
-// should be done or not. The reason behind the optional check is because some
-// of the header retrieval mechanisms already need to verfy nonces, as well as
-// because nonces can be verified sparsely, not needing to check each.
-func (hc *HeaderChain) InsertHeaderChain(chain []*types.Header, checkFreq int, writeHeader WhCallback) (res *HeaderChainInsertResult) {
-	res = &HeaderChainInsertResult{}
-
-	// Collect some import statistics to report on
-	var events []interface{}
-	stats := struct{ processed, ignored int }{}
-	start := time.Now()
+// ValidateHeaderChain verifies the given header chain against the local chain
+// without writing anything to the database. Headers are checked against the
+// configured bad hashes and validated against their parents, verifying the PoW
+// of every checkFreq'th header on average and always of the last one. Headers
+// already known are skipped. If a header is invalid, its index is returned
+// along with an error describing what went wrong. If the validation is
+// interrupted, the index of the first header not validated is returned along
+// with ErrInterrupted.
+func (hc *HeaderChain) ValidateHeaderChain(chain []*types.Header, checkFreq int) (int, error) {
+	return hc.validateHeaderChain(chain, checkFreq, true)
+}
 
+// validateHeaderChain is ValidateHeaderChain, reporting rejected headers with
+// postBadFork only if postEvents is set.
+func (hc *HeaderChain) validateHeaderChain(chain []*types.Header, checkFreq int, postEvents bool) (int, error) {
 	// Generate the list of headers that should be POW verified
 	verify := make([]bool, len(chain))
 	for i := 0; i < len(verify)/checkFreq; i++ {
@@ -267,6 +284,7 @@ func (hc *HeaderChain) InsertHeaderChain(chain []*types.Header, checkFreq int, w
 	close(tasks)
 
 	errs, failed := make([]error, len(tasks)), int32(0)
+	done := make([]bool, len(tasks))
 	process := func(worker int) {
 		for index := range tasks {
 			header, hash := chain[index], chain[index].Hash()
@@ -280,12 +298,16 @@ func (hc *HeaderChain) InsertHeaderChain(chain []*types.Header, checkFreq int, w
 			}
 
 			// Short circuit if the header is bad or already known
-			if err := hc.config.HeaderCheck(header); err != nil {
+			if err := hc.headerCheck(header); err != nil {
+				if postEvents {
+					hc.postBadFork(header, err)
+				}
 				errs[index] = err
 				atomic.AddInt32(&failed, 1)
 				return
 			}
 			if hc.HasHeader(hash) {
+				done[index] = true
 				continue
 			}
 			// Verify that the header honors the chain parameters
@@ -302,6 +324,7 @@ func (hc *HeaderChain) InsertHeaderChain(chain []*types.Header, checkFreq int, w
 				atomic.AddInt32(&failed, 1)
 				return
 			}
+			done[index] = true
 		}
 	}
 
@@ -320,17 +343,51 @@ func (hc *HeaderChain) InsertHeaderChain(chain []*types.Header, checkFreq int, w
 	if failed > 0 {
 		for i, err := range errs {
 			if err != nil {
-				res.Index = i
-				res.Error = err
-				return
+				return i, err
 			}
 		}
 	}
+	for i := range done {
+		if !done[i] {
+			return i, ErrInterrupted
+		}
+	}
+	return 0, nil
+}
+
+// InsertHeaderChain attempts to insert the given header chain in to the local
+// chain, possibly creating a reorg. If an error is returned, it will return the
+// index number of the failing header as well an error describing what went wrong.
+//
+// The verify parameter can be used to fine tune whether nonce verification
+// should be done or not. The reason behind the optional check is because some
+// of the header retrieval mechanisms already need to verfy nonces, as well as
+// because nonces can be verified sparsely, not needing to check each.
+func (hc *HeaderChain) InsertHeaderChain(chain []*types.Header, checkFreq int, writeHeader WhCallback) (res *HeaderChainInsertResult) {
+	res = &HeaderChainInsertResult{}
+
+	// Collect some import statistics to report on
+	var events []interface{}
+	stats := struct{ processed, ignored int }{}
+	start := time.Now()
+
+	if i, err := hc.ValidateHeaderChain(chain, checkFreq); err == ErrInterrupted {
+		glog.V(logger.Debug).Infoln("premature abort during header chain verification")
+		res.Index = i
+		res.Aborted = true
+		return
+	} else if err != nil {
+		res.Index = i
+		res.Error = err
+		return
+	}
 	// All headers passed verification, import them into the database
 	for i, header := range chain {
 		// Short circuit insertion if shutting down
 		if hc.procInterrupt() {
 			glog.V(logger.Debug).Infoln("premature abort during header chain processing")
+			res.Index = i
+			res.Aborted = true
 			break
 		}
 		hash := header.Hash()
@@ -382,18 +439,52 @@ func (hc *HeaderChain) InsertHeaderChain(chain []*types.Header, checkFreq int, w
 // GetBlockHashesFromHash retrieves a number of block hashes starting at a given
 // hash, fetching towards the genesis block.
 func (hc *HeaderChain) GetBlockHashesFromHash(hash common.Hash, max uint64) []common.Hash {
+	return hc.GetBlockHashesFromHashStep(hash, max, 1, false)
+}
+
+// GetBlockHashesFromHashStep retrieves up to max block hashes starting at a given
+// hash (exclusive), taking every step'th block. If forward is false, it fetches
+// ancestors towards the genesis block. If forward is true, it fetches descendants
+// along the canonical chain, and the origin hash must itself be canonical.
+// A step of 0 is treated as 1.
+func (hc *HeaderChain) GetBlockHashesFromHashStep(hash common.Hash, max, step uint64, forward bool) []common.Hash {
 	// Get the origin header from which to fetch
 	header := hc.GetHeader(hash)
 	if header == nil {
 		return nil
 	}
-	// Iterate the headers until enough is collected or the genesis reached
+	if step == 0 {
+		step = 1
+	}
 	chain := make([]common.Hash, 0, max)
+	if forward {
+		number := header.Number.Uint64()
+		if GetCanonicalHash(hc.chainDb, number) != hash {
+			return nil
+		}
+		// Iterate the canonical numbers until enough is collected or the head reached
+		for i := uint64(0); i < max; i++ {
+			number += step
+			next := GetCanonicalHash(hc.chainDb, number)
+			if next == (common.Hash{}) {
+				break
+			}
+			chain = append(chain, next)
+		}
+		return chain
+	}
+	// Iterate the headers until enough is collected or the genesis reached
 	for i := uint64(0); i < max; i++ {
-		next := header.ParentHash
-		if header = hc.GetHeader(next); header == nil {
+		if header.Number.Uint64() < step {
 			break
 		}
+		var next common.Hash
+		for j := uint64(0); j < step; j++ {
+			next = header.ParentHash
+			if header = hc.GetHeader(next); header == nil {
+				return chain
+			}
+		}
 		chain = append(chain, next)
 		if header.Number.Sign() == 0 {
 			break
@@ -418,6 +509,17 @@ func (hc *HeaderChain) GetTd(hash common.Hash) *big.Int {
 	return td
 }
 
+// GetTdByNumber retrieves the total difficulty of the canonical block with the
+// given number, caching it if found. It returns nil if no canonical block or
+// total difficulty is known for the number.
+func (hc *HeaderChain) GetTdByNumber(number uint64) *big.Int {
+	hash := GetCanonicalHash(hc.chainDb, number)
+	if hash == (common.Hash{}) {
+		return nil
+	}
+	return hc.GetTd(hash)
+}
+
 // WriteTd stores a block's total difficulty into the database, also caching it
 // along the way.
 func (hc *HeaderChain) WriteTd(hash common.Hash, td *big.Int) error {
@@ -460,6 +562,16 @@ func (hc *HeaderChain) GetHeaderByNumber(number uint64) *types.Header {
 	return hc.GetHeader(hash)
 }
 
+// SetTieBreakPolicy sets how headers with the same total difficulty as the head header are chosen.
+func (hc *HeaderChain) SetTieBreakPolicy(policy TieBreakPolicy) {
+	atomic.StoreInt32(&hc.tieBreak, int32(policy))
+}
+
+// TieBreakPolicy returns how headers with the same total difficulty as the head header are chosen.
+func (hc *HeaderChain) TieBreakPolicy() TieBreakPolicy {
+	return TieBreakPolicy(atomic.LoadInt32(&hc.tieBreak))
+}
+
 // CurrentHeader retrieves the current head header of the canonical chain. The
 // header is retrieved from the HeaderChain's internal cache.
 func (hc *HeaderChain) CurrentHeader() *types.Header {
@@ -565,6 +677,41 @@ func (hc *HeaderChain) postChainEvents(events []interface{}) {
 	}
 }
 
+// postBadFork logs a block or header rejected by ChainConfig.HeaderCheck and queues a
+// BadForkDetectedEvent for it. Queued events are posted in order by a single goroutine,
+// as callers may hold chain locks which subscribers need.
+func (hc *HeaderChain) postBadFork(header *types.Header, err error) {
+	glog.V(logger.Warn).Warnf("Bad fork detected: block #%d [%x…]: %v", header.Number, header.Hash().Bytes()[:4], err)
+	if hc.eventMux == nil {
+		return
+	}
+	hc.badForkMu.Lock()
+	defer hc.badForkMu.Unlock()
+
+	hc.badForks = append(hc.badForks, BadForkDetectedEvent{Number: header.Number.Uint64(), Hash: header.Hash(), Err: err})
+	if !hc.postingBadFork {
+		hc.postingBadFork = true
+		go hc.postBadForks()
+	}
+}
+
+// postBadForks posts the events queued by postBadFork until the queue is empty.
+func (hc *HeaderChain) postBadForks() {
+	for {
+		hc.badForkMu.Lock()
+		if len(hc.badForks) == 0 {
+			hc.postingBadFork = false
+			hc.badForkMu.Unlock()
+			return
+		}
+		ev := hc.badForks[0]
+		hc.badForks = hc.badForks[1:]
+		hc.badForkMu.Unlock()
+
+		hc.eventMux.Post(ev)
+	}
+}
+
 // headerValidator is responsible for validating block headers
 //
 // headerValidator implements HeaderValidator.
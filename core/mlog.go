@@ -61,6 +61,7 @@ var mlogBlockchainInsertBlocks = &logger.MLogT{
 		{Owner: "BLOCKS", Key: "QUEUED", Value: "INT"},
 		{Owner: "BLOCKS", Key: "IGNORED", Value: "INT"},
 		{Owner: "BLOCKS", Key: "TRANSACTIONS_COUNT", Value: "INT"},
+		{Owner: "BLOCKS", Key: "GAS_USED", Value: "BIGINT"},
 		{Owner: "BLOCKS", Key: "LAST_NUMBER", Value: "BIGINT"},
 		{Owner: "BLOCKS", Key: "FIRST_HASH", Value: "STRING"},
 		{Owner: "BLOCKS", Key: "LAST_HASH", Value: "STRING"},
@@ -76,6 +77,8 @@ var mlogBlockchainReorgBlocks = &logger.MLogT{
 	Details: []logger.MLogDetailT{
 		{Owner: "REORG", Key: "LAST_COMMON_HASH", Value: "STRING"},
 		{Owner: "REORG", Key: "SPLIT_NUMBER", Value: "BIGINT"},
+		{Owner: "REORG", Key: "COMMON_NUMBER", Value: "BIGINT"},
+		{Owner: "REORG", Key: "DEPTH", Value: "INT"},
 		{Owner: "BLOCKS", Key: "OLD_START_HASH", Value: "STRING"},
 		{Owner: "BLOCKS", Key: "NEW_START_HASH", Value: "STRING"},
 	},
@@ -404,6 +404,12 @@ func (self *StateObject) Nonce() uint64 {
 	return self.data.Nonce
 }
 
+// Root returns the storage trie root of the account as of the last time its storage
+// was hashed or committed.
+func (self *StateObject) Root() common.Hash {
+	return self.data.Root
+}
+
 // Never called, but must be present to allow StateObject to be used
 // as a vm.Account interface that also satisfies the vm.ContractRef
 // interface. Interfaces are awesome.
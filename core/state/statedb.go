@@ -91,7 +91,8 @@ type StateDB struct {
 	validRevisions []revision
 	nextRevisionId int
 
-	preimages map[common.Hash][]byte
+	preimages       map[common.Hash][]byte
+	recordPreimages bool // whether AddPreimage keeps the submitted preimages
 
 	lock sync.Mutex
 }
@@ -120,6 +121,22 @@ func (self *StateDB) setError(err error) {
 	}
 }
 
+// EnablePreimageRecording sets whether SHA3 preimages submitted with AddPreimage are recorded.
+func (self *StateDB) EnablePreimageRecording(enabled bool) {
+	self.recordPreimages = enabled
+}
+
+// AddPreimage records a SHA3 preimage seen by the VM, if preimage recording is enabled.
+func (self *StateDB) AddPreimage(hash common.Hash, preimage []byte) {
+	if !self.recordPreimages {
+		return
+	}
+	if _, ok := self.preimages[hash]; !ok {
+		self.journal = append(self.journal, addPreimageChange{hash: hash})
+		self.preimages[hash] = common.CopyBytes(preimage)
+	}
+}
+
 // Preimages returns a list of SHA3 preimages that have been submitted.
 func (self *StateDB) Preimages() map[common.Hash][]byte {
 	return self.preimages
@@ -256,6 +273,16 @@ func (self *StateDB) GetCodeHash(addr common.Address) common.Hash {
 	return common.BytesToHash(stateObject.CodeHash())
 }
 
+// GetStorageRoot returns the storage trie root of an account as of the last time the
+// state was hashed or committed. It returns the zero hash if the account does not exist.
+func (self *StateDB) GetStorageRoot(addr common.Address) common.Hash {
+	stateObject := self.getStateObject(addr)
+	if stateObject == nil {
+		return common.Hash{}
+	}
+	return stateObject.Root()
+}
+
 func (self *StateDB) GetState(a common.Address, b common.Hash) common.Hash {
 	stateObject := self.getStateObject(a)
 	if stateObject != nil {
@@ -467,6 +494,7 @@ func (self *StateDB) Copy() *StateDB {
 		logs:              make(map[common.Hash]vm.Logs, len(self.logs)),
 		logSize:           self.logSize,
 		preimages:         make(map[common.Hash][]byte),
+		recordPreimages:   self.recordPreimages,
 	}
 	// Copy the dirty states, logs, and preimages
 	for addr := range self.stateObjectsDirty {
@@ -610,6 +638,51 @@ func (s *StateDB) CommitTo(dbw trie.DatabaseWriter, deleteEmptyObjects bool) (ro
 	return root, err
 }
 
+// StorageTrie returns a copy of the storage trie of an account, including uncommitted
+// storage changes. It returns nil if the account does not exist.
+func (self *StateDB) StorageTrie(addr common.Address) Trie {
+	stateObject := self.getStateObject(addr)
+	if stateObject == nil {
+		return nil
+	}
+	cpy := stateObject.deepCopy(self, nil)
+	return cpy.updateTrie(self.db)
+}
+
+// proofList collects the nodes of a merkle proof in path order, root first.
+type proofList [][]byte
+
+func (n *proofList) Put(key []byte, value []byte) error {
+	*n = append(*n, common.CopyBytes(value))
+	return nil
+}
+
+// GetProof returns the merkle proof of the account with the given address in the
+// account trie. The proof is also valid for absent accounts, proving their absence.
+func (self *StateDB) GetProof(addr common.Address) ([][]byte, error) {
+	var proof proofList
+	err := self.trie.Prove(addr[:], 0, &proof)
+	return proof, err
+}
+
+// GetStorageProof returns the merkle proof of the given storage key in the storage
+// trie of an account. It returns an error if the account does not exist.
+func (self *StateDB) GetStorageProof(addr common.Address, key common.Hash) ([][]byte, error) {
+	tr := self.StorageTrie(addr)
+	if tr == nil {
+		return nil, fmt.Errorf("account %x does not exist", addr)
+	}
+	return GetTrieProof(tr, key[:])
+}
+
+// GetTrieProof returns the merkle proof of the given key in a trie, eg. a storage
+// trie returned by StorageTrie, to prove several keys of the same trie.
+func GetTrieProof(tr Trie, key []byte) ([][]byte, error) {
+	var proof proofList
+	err := tr.Prove(key, 0, &proof)
+	return proof, err
+}
+
 func (db *StateDB) ForEachStorage(addr common.Address, cb func(key, value common.Hash) bool) {
 	so := db.getStateObject(addr)
 	if so == nil {
@@ -30,7 +30,9 @@ import (
 
 	"github.com/ethereumproject/go-ethereum/common"
 	"github.com/ethereumproject/go-ethereum/core/vm"
+	"github.com/ethereumproject/go-ethereum/crypto"
 	"github.com/ethereumproject/go-ethereum/ethdb"
+	"github.com/ethereumproject/go-ethereum/trie"
 	"gopkg.in/check.v1"
 )
 
@@ -422,3 +424,71 @@ func (s *StateSuite) TestTouchDelete(c *check.C) {
 		c.Fatal("expected no dirty state object")
 	}
 }
+
+// proofDatabase returns a database holding the given proof nodes keyed by their hash.
+func proofDatabase(proof [][]byte) *ethdb.MemDatabase {
+	db, _ := ethdb.NewMemDatabase()
+	for _, node := range proof {
+		db.Put(crypto.Keccak256(node), node)
+	}
+	return db
+}
+
+func TestGetProof(t *testing.T) {
+	db, _ := ethdb.NewMemDatabase()
+	state, _ := New(common.Hash{}, NewDatabase(db))
+	addr, absent := common.Address{0x01}, common.Address{0x02}
+	key, absentKey := common.Hash{0x01}, common.Hash{0x02}
+	state.AddBalance(addr, big.NewInt(42))
+	state.SetState(addr, key, common.Hash{0xff})
+	for i := byte(0); i < 16; i++ {
+		state.AddBalance(common.Address{0x10, i}, big.NewInt(1))
+	}
+	root, _ := state.CommitTo(db, false)
+	state, _ = New(root, NewDatabase(db))
+
+	proof, err := state.GetProof(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	val, err, _ := trie.VerifyProof(root, crypto.Keccak256(addr[:]), proofDatabase(proof))
+	if err != nil {
+		t.Fatalf("account proof failed to verify: %v", err)
+	}
+	if len(val) == 0 {
+		t.Fatal("account proof does not contain the account")
+	}
+	proof, err = state.GetProof(absent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val, err, _ := trie.VerifyProof(root, crypto.Keccak256(absent[:]), proofDatabase(proof)); err != nil || val != nil {
+		t.Fatalf("absent account proof: have value %x, err %v", val, err)
+	}
+
+	storageRoot := state.StorageTrie(addr).Hash()
+	if have := state.GetStorageRoot(addr); have != storageRoot {
+		t.Errorf("storage root mismatch: have %x, want %x", have, storageRoot)
+	}
+	proof, err = state.GetStorageProof(addr, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	val, err, _ = trie.VerifyProof(storageRoot, crypto.Keccak256(key[:]), proofDatabase(proof))
+	if err != nil {
+		t.Fatalf("storage proof failed to verify: %v", err)
+	}
+	if len(val) == 0 {
+		t.Fatal("storage proof does not contain the slot")
+	}
+	proof, err = state.GetStorageProof(addr, absentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val, err, _ := trie.VerifyProof(storageRoot, crypto.Keccak256(absentKey[:]), proofDatabase(proof)); err != nil || val != nil {
+		t.Fatalf("absent slot proof: have value %x, err %v", val, err)
+	}
+	if _, err := state.GetStorageProof(absent, key); err == nil {
+		t.Error("expected error for storage proof of absent account")
+	}
+}
@@ -37,6 +37,10 @@ var (
 	DisinflationRateDivisor  = big.NewInt(5)
 
 	ErrConfiguration = errors.New("invalid configuration")
+
+	// ErrSputnikVMUnavailable is returned when SputnikVM is requested from a geth
+	// built without the sputnikvm build tag.
+	ErrSputnikVMUnavailable = errors.New("SputnikVM not available: geth built without the sputnikvm build tag")
 )
 
 // StateProcessor is a basic Processor, which takes care of transitioning
@@ -44,8 +48,9 @@ var (
 //
 // StateProcessor implements Processor.
 type StateProcessor struct {
-	config *ChainConfig
-	bc     *BlockChain
+	config  *ChainConfig
+	bc      *BlockChain
+	multiVm bool // always use SputnikVM, regardless of UseSputnikVM
 }
 
 // NewStateProcessor initialises a new StateProcessor.
@@ -56,6 +61,22 @@ func NewStateProcessor(config *ChainConfig, bc *BlockChain) *StateProcessor {
 	}
 }
 
+// NewMultiVmStateProcessor initialises a new StateProcessor which always applies
+// transactions with SputnikVM, regardless of UseSputnikVM. It can be used with a
+// ProcessorFactory to run SputnikVM for selected block ranges only.
+// It returns ErrSputnikVMUnavailable unless geth was built with the sputnikvm build
+// tag, see SputnikVMExists.
+func NewMultiVmStateProcessor(config *ChainConfig, bc *BlockChain) (*StateProcessor, error) {
+	if !SputnikVMExists {
+		return nil, ErrSputnikVMUnavailable
+	}
+	return &StateProcessor{
+		config:  config,
+		bc:      bc,
+		multiVm: true,
+	}, nil
+}
+
 // Process processes the state changes according to the Ethereum rules by running
 // the transaction messages using the statedb and applying any rewards to both
 // the processor (coinbase) and any included uncles.
@@ -84,7 +105,7 @@ func (p *StateProcessor) Process(block *types.Block, statedb *state.StateDB) (ty
 			}
 		}
 		statedb.StartRecord(tx.Hash(), block.Hash(), i)
-		if UseSputnikVM != "true" {
+		if !p.multiVm && UseSputnikVM != "true" {
 			receipt, logs, _, err := ApplyTransaction(p.config, p.bc, gp, statedb, header, tx, totalUsedGas)
 			if err != nil {
 				return nil, nil, totalUsedGas, err
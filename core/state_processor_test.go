@@ -20,6 +20,22 @@ var (
 
 // Unit tests.
 
+func TestNewMultiVmStateProcessor(t *testing.T) {
+	p, err := NewMultiVmStateProcessor(DefaultConfigMainnet.ChainConfig, nil)
+	if SputnikVMExists {
+		if err != nil || p == nil {
+			t.Fatalf("want: processor, got: %v, %v", p, err)
+		}
+		return
+	}
+	if err != ErrSputnikVMUnavailable {
+		t.Errorf("want: %v, got: %v", ErrSputnikVMUnavailable, err)
+	}
+	if p != nil {
+		t.Error("want: no processor without SputnikVM")
+	}
+}
+
 // Use default era length 5,000,000
 func TestGetBlockEra1(t *testing.T) {
 	cases := map[*big.Int]*big.Int{
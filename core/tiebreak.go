@@ -0,0 +1,65 @@
+package core
+
+import (
+	"fmt"
+	mrand "math/rand"
+	"strings"
+)
+
+// TieBreakPolicy selects whether a block (or header) with the same total difficulty
+// as the current head replaces it.
+//
+// On proof-of-work networks the choice between equally heavy chains must not be
+// predictable: if miners knew the first seen block always wins, a selfish miner with
+// good connectivity could withhold blocks and still win every race against honest
+// blocks of equal weight. Picking at random halves such a miner's advantage (see
+// http://www.cs.cornell.edu/~ie53/publications/btcProcFC.pdf), which is why
+// TieBreakRandom is the default. The deterministic policies are intended for private
+// networks and tests, where reproducibility matters more than selfish mining.
+type TieBreakPolicy int32
+
+const (
+	// TieBreakRandom prefers the lower block number and otherwise picks at random.
+	TieBreakRandom TieBreakPolicy = iota
+	// TieBreakLowerNumber prefers the lower block number and otherwise keeps the current head.
+	TieBreakLowerNumber
+	// TieBreakFirstSeen always keeps the current head.
+	TieBreakFirstSeen
+)
+
+var tieBreakPolicyNames = map[TieBreakPolicy]string{
+	TieBreakRandom:      "random",
+	TieBreakLowerNumber: "lower-number",
+	TieBreakFirstSeen:   "first-seen",
+}
+
+func (p TieBreakPolicy) String() string {
+	if name, ok := tieBreakPolicyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", int32(p))
+}
+
+// ParseTieBreakPolicy returns the policy with the given name, one of
+// "random", "lower-number" or "first-seen".
+func ParseTieBreakPolicy(name string) (TieBreakPolicy, error) {
+	for p, n := range tieBreakPolicyNames {
+		if strings.EqualFold(n, name) {
+			return p, nil
+		}
+	}
+	return TieBreakRandom, fmt.Errorf("unknown tie-break policy %q, want \"random\", \"lower-number\" or \"first-seen\"", name)
+}
+
+// replaceHead reports whether a new block numbered number, with the same total difficulty
+// as the current head numbered head, replaces the head.
+func (p TieBreakPolicy) replaceHead(number, head uint64) bool {
+	switch p {
+	case TieBreakFirstSeen:
+		return false
+	case TieBreakLowerNumber:
+		return number < head
+	default:
+		return number < head || (number == head && mrand.Float64() < 0.5)
+	}
+}
@@ -0,0 +1,62 @@
+package core
+
+import "testing"
+
+func TestTieBreakPolicyReplaceHead(t *testing.T) {
+	tests := []struct {
+		policy       TieBreakPolicy
+		number, head uint64
+		want         bool
+	}{
+		{TieBreakRandom, 4, 5, true},
+		{TieBreakRandom, 6, 5, false},
+		{TieBreakLowerNumber, 4, 5, true},
+		{TieBreakLowerNumber, 5, 5, false},
+		{TieBreakLowerNumber, 6, 5, false},
+		{TieBreakFirstSeen, 4, 5, false},
+		{TieBreakFirstSeen, 5, 5, false},
+	}
+	for i, tt := range tests {
+		if have := tt.policy.replaceHead(tt.number, tt.head); have != tt.want {
+			t.Errorf("test %d (%v, #%d vs head #%d): have %v, want %v", i, tt.policy, tt.number, tt.head, have, tt.want)
+		}
+	}
+}
+
+func TestParseTieBreakPolicy(t *testing.T) {
+	for _, policy := range []TieBreakPolicy{TieBreakRandom, TieBreakLowerNumber, TieBreakFirstSeen} {
+		have, err := ParseTieBreakPolicy(policy.String())
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", policy, err)
+		}
+		if have != policy {
+			t.Errorf("%v: parsed as %v", policy, have)
+		}
+	}
+	if _, err := ParseTieBreakPolicy("latest"); err == nil {
+		t.Error("expected error for unknown policy")
+	}
+}
+
+func TestBlockChain_TieBreakFirstSeen(t *testing.T) {
+	db, bc, err := newCanonical(testChainConfig(), 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc.SetTieBreakPolicy(TieBreakFirstSeen)
+
+	first := makeBlockChain(bc.config, bc.genesisBlock, 3, db, canonicalSeed)
+	if res := bc.InsertChain(first); res.Error != nil {
+		t.Fatalf("failed to insert first chain: %v", res.Error)
+	}
+	second := makeBlockChain(bc.config, bc.genesisBlock, 3, db, forkSeed)
+	if res := bc.InsertChain(second); res.Error != nil {
+		t.Fatalf("failed to insert second chain: %v", res.Error)
+	}
+	if bc.GetTd(first[2].Hash()).Cmp(bc.GetTd(second[2].Hash())) != 0 {
+		t.Fatal("test chains do not have equal total difficulty")
+	}
+	if head := bc.CurrentBlock().Hash(); head != first[2].Hash() {
+		t.Errorf("head replaced by equal difficulty chain: have %x, want %x", head, first[2].Hash())
+	}
+}
@@ -43,6 +43,7 @@ var (
 	ErrIntrinsicGas       = errors.New("Intrinsic gas too low")
 	ErrGasLimit           = errors.New("Exceeds block gas limit")
 	ErrNegativeValue      = errors.New("Negative value")
+	ErrUnprotected        = errors.New("Transaction is not replay-protected (EIP-155)")
 )
 
 const (
@@ -72,9 +73,14 @@ type TxPool struct {
 	pending      map[common.Hash]*types.Transaction // processable transactions
 	queue        map[common.Address]map[common.Hash]*types.Transaction
 
+	pendingBySender map[common.Address]map[common.Hash]*types.Transaction // pending, indexed by sender
+
 	wg sync.WaitGroup // for shutdown sync
 
 	homestead bool
+
+	requireProtected bool // Reject transactions which are not EIP-155 replay-protected
+	accountLimit     int  // Max pending and queued transactions per sender, 0 if unlimited
 }
 
 func NewTxPool(config *ChainConfig, eventMux *event.TypeMux, currentStateFn stateFn, gasLimitFn func() *big.Int) *TxPool {
@@ -90,6 +96,8 @@ func NewTxPool(config *ChainConfig, eventMux *event.TypeMux, currentStateFn stat
 		pendingState: nil,
 		localTx:      newTxSet(),
 		events:       eventMux.Subscribe(ChainHeadEvent{}, GasPriceChanged{}, RemovedTransactionEvent{}),
+
+		pendingBySender: make(map[common.Address]map[common.Hash]*types.Transaction),
 	}
 
 	pool.wg.Add(1)
@@ -221,6 +229,50 @@ func (pool *TxPool) SetLocal(tx *types.Transaction) {
 	pool.localTx.add(tx.Hash())
 }
 
+// RequireReplayProtection sets whether the pool rejects transactions which are
+// not EIP-155 replay-protected, including local ones.
+func (pool *TxPool) RequireReplayProtection(require bool) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	pool.requireProtected = require
+}
+
+// SetAccountLimit sets the maximum number of pending and queued transactions per sender.
+// Transactions beyond it are rejected with an AccountLimitErr, unless they replace a
+// transaction with the same nonce. A limit of 0 disables the check.
+func (pool *TxPool) SetAccountLimit(limit int) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	pool.accountLimit = limit
+}
+
+// checkAccountLimit returns an AccountLimitErr if adding the given transaction of the
+// given sender exceeds the account limit.
+func (pool *TxPool) checkAccountLimit(from common.Address, tx *types.Transaction) error {
+	if pool.accountLimit <= 0 {
+		return nil
+	}
+	count := 0
+	for _, ptx := range pool.pendingBySender[from] {
+		if ptx.Nonce() == tx.Nonce() {
+			return nil
+		}
+		count++
+	}
+	for _, qtx := range pool.queue[from] {
+		if qtx.Nonce() == tx.Nonce() {
+			return nil
+		}
+		count++
+	}
+	if count >= pool.accountLimit {
+		return &AccountLimitErr{Account: from, Limit: pool.accountLimit}
+	}
+	return nil
+}
+
 // validateTx checks whether a transaction is valid according
 // to the consensus rules.
 func (pool *TxPool) validateTx(tx *types.Transaction) (e error) {
@@ -237,6 +289,11 @@ func (pool *TxPool) validateTx(tx *types.Transaction) (e error) {
 			e,
 		).Send(mlogTxPool)
 	}()
+	// Drop unprotected transactions if replay protection is enforced
+	if pool.requireProtected && !tx.Protected() {
+		e = ErrUnprotected
+		return
+	}
 	// Drop transactions under our own minimal accepted gas price
 	if !local && pool.minGasPrice.Cmp(tx.GasPrice()) > 0 {
 		e = ErrCheap
@@ -295,7 +352,9 @@ func (pool *TxPool) validateTx(tx *types.Transaction) (e error) {
 		e = ErrIntrinsicGas
 		return
 	}
-	return // e=nil
+
+	e = pool.checkAccountLimit(from, tx)
+	return
 }
 
 // validate and queue transactions.
@@ -356,7 +415,7 @@ func (pool *TxPool) addTx(hash common.Hash, addr common.Address, tx *types.Trans
 	}
 
 	if _, ok := pool.pending[hash]; !ok {
-		pool.pending[hash] = tx
+		pool.addPending(hash, addr, tx)
 
 		// Increment the nonce on the pending state. This can only happen if
 		// the nonce is +1 to the previous one.
@@ -368,6 +427,32 @@ func (pool *TxPool) addTx(hash common.Hash, addr common.Address, tx *types.Trans
 	}
 }
 
+// addPending adds the transaction of the given sender to the pending transactions.
+func (pool *TxPool) addPending(hash common.Hash, from common.Address, tx *types.Transaction) {
+	pool.pending[hash] = tx
+	if pool.pendingBySender[from] == nil {
+		pool.pendingBySender[from] = make(map[common.Hash]*types.Transaction)
+	}
+	pool.pendingBySender[from][hash] = tx
+}
+
+// removePending removes the transaction with the given hash from the pending transactions.
+func (pool *TxPool) removePending(hash common.Hash) {
+	tx, ok := pool.pending[hash]
+	if !ok {
+		return
+	}
+	delete(pool.pending, hash)
+
+	from, _ := types.Sender(pool.signer, tx) // already validated
+	if txs := pool.pendingBySender[from]; txs != nil {
+		delete(txs, hash)
+		if len(txs) == 0 {
+			delete(pool.pendingBySender, from)
+		}
+	}
+}
+
 // Add queues a single transaction in the pool if it is valid.
 func (self *TxPool) Add(tx *types.Transaction) error {
 	self.mu.Lock()
@@ -470,7 +555,7 @@ func (pool *TxPool) RemoveTx(hash common.Hash) {
 
 func (pool *TxPool) removeTx(hash common.Hash) {
 	// delete from pending pool
-	delete(pool.pending, hash)
+	pool.removePending(hash)
 	// delete from queue
 	for address, txs := range pool.queue {
 		if _, ok := txs[hash]; ok {
@@ -579,7 +664,7 @@ func (pool *TxPool) validatePool() {
 			if glog.V(logger.Core) {
 				glog.Infof("removed tx (%v) from pool: low tx nonce or out of funds\n", tx)
 			}
-			delete(pool.pending, hash)
+			pool.removePending(hash)
 
 			// Track the smallest invalid nonce to postpone subsequent transactions
 			if !past {
@@ -598,7 +683,7 @@ func (pool *TxPool) validatePool() {
 					glog.Infof("postponed tx (%v) due to introduced gap\n", tx)
 				}
 				pool.queueTx(hash, tx)
-				delete(pool.pending, hash)
+				pool.removePending(hash)
 			}
 		}
 	}
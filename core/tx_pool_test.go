@@ -18,6 +18,7 @@ package core
 
 import (
 	"crypto/ecdsa"
+	"errors"
 	"math/big"
 	"testing"
 
@@ -89,6 +90,77 @@ func TestInvalidTransactions(t *testing.T) {
 	}
 }
 
+func TestRequireReplayProtection(t *testing.T) {
+	pool, key := setupTxPool()
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	currentState, _ := pool.currentState()
+	currentState.AddBalance(from, big.NewInt(0xffffffffffffff))
+
+	pool.RequireReplayProtection(true)
+
+	tx := transaction(0, big.NewInt(100000), key)
+	if err := pool.Add(tx); err != ErrUnprotected {
+		t.Error("expected", ErrUnprotected, "got", err)
+	}
+	tx, _ = types.NewTransaction(0, common.Address{}, big.NewInt(100), big.NewInt(100000), big.NewInt(1), nil).WithSigner(pool.signer).SignECDSA(key)
+	if err := pool.Add(tx); err != nil {
+		t.Error("expected", nil, "got", err)
+	}
+
+	pool.RequireReplayProtection(false)
+	tx = transaction(1, big.NewInt(100000), key)
+	if err := pool.Add(tx); err != nil {
+		t.Error("expected", nil, "got", err)
+	}
+}
+
+func TestTransactionAccountLimit(t *testing.T) {
+	pool, key := setupTxPool()
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	currentState, _ := pool.currentState()
+	currentState.AddBalance(from, big.NewInt(0xffffffffffffff))
+
+	pool.SetAccountLimit(3)
+
+	// Two pending transactions and one queued one.
+	for _, nonce := range []uint64{0, 1, 5} {
+		if err := pool.Add(transaction(nonce, big.NewInt(100000), key)); err != nil {
+			t.Fatalf("nonce %d: expected %v, got %v", nonce, nil, err)
+		}
+	}
+	err := pool.Add(transaction(6, big.NewInt(100000), key))
+	if !errors.Is(err, ErrAccountLimit) {
+		t.Fatalf("expected %v, got %v", ErrAccountLimit, err)
+	}
+	if lerr := err.(*AccountLimitErr); lerr.Account != from || lerr.Limit != 3 {
+		t.Errorf("error fields mismatch: %+v", lerr)
+	}
+	// Removing a pending transaction makes room for another one.
+	pending := transaction(1, big.NewInt(100000), key)
+	pool.RemoveTx(pending.Hash())
+	if len(pool.pendingBySender[from]) != 1 {
+		t.Errorf("pending transactions of sender: expected %d, got %d", 1, len(pool.pendingBySender[from]))
+	}
+	if err := pool.Add(transaction(6, big.NewInt(100000), key)); err != nil {
+		t.Error("expected", nil, "got", err)
+	}
+	// Replacing a transaction is allowed at the limit.
+	if err := pool.Add(transaction(5, big.NewInt(100001), key)); err != nil {
+		t.Error("expected", nil, "got", err)
+	}
+	// Other accounts are not affected.
+	other, _ := crypto.GenerateKey()
+	currentState.AddBalance(crypto.PubkeyToAddress(other.PublicKey), big.NewInt(0xffffffffffffff))
+	if err := pool.Add(transaction(0, big.NewInt(100000), other)); err != nil {
+		t.Error("expected", nil, "got", err)
+	}
+
+	pool.SetAccountLimit(0)
+	if err := pool.Add(transaction(7, big.NewInt(100000), key)); err != nil {
+		t.Error("expected", nil, "got", err)
+	}
+}
+
 func TestTransactionQueue(t *testing.T) {
 	pool, key := setupTxPool()
 	tx := transaction(0, big.NewInt(100), key)
@@ -68,6 +68,10 @@ type Processor interface {
 	Process(block *types.Block, statedb *state.StateDB) (types.Receipts, vm.Logs, *big.Int, error)
 }
 
+// ProcessorFactory returns the Processor to use for processing the block with
+// the given number. A nil Processor selects the blockchain's default processor.
+type ProcessorFactory func(blockNumber *big.Int) Processor
+
 // Backend is an interface defining the basic functionality for an operable node
 // with all the functionality to be a functional, valid Ethereum operator.
 //
@@ -93,6 +93,12 @@ type Vm interface {
 	Run(c *Contract, in []byte, readOnly bool) ([]byte, error)
 }
 
+// preimageRecorder is implemented by databases which can record the preimages
+// of values hashed with the SHA3 instruction.
+type preimageRecorder interface {
+	AddPreimage(hash common.Hash, preimage []byte)
+}
+
 // Database is a EVM database for full state querying.
 type Database interface {
 	GetAccount(common.Address) Account
@@ -275,7 +275,12 @@ func opMulmod(pc *uint64, env Environment, contract *Contract, memory *Memory, s
 
 func opSha3(pc *uint64, env Environment, contract *Contract, memory *Memory, stack *stack) ([]byte, error) {
 	offset, size := stack.pop(), stack.pop()
-	hash := crypto.Keccak256(memory.Get(offset.Int64(), size.Int64()))
+	data := memory.Get(offset.Int64(), size.Int64())
+	hash := crypto.Keccak256(data)
+
+	if recorder, ok := env.Db().(preimageRecorder); ok {
+		recorder.AddPreimage(common.BytesToHash(hash), data)
+	}
 
 	stack.push(new(big.Int).SetBytes(hash))
 	return nil, nil
@@ -58,6 +58,7 @@ func NewEnv(cfg *Config, state *state.StateDB) vm.Environment {
 		gasLimit:   cfg.GasLimit,
 	}
 	env.evm = vm.New(env)
+	env.evm.SetOpHook(cfg.OpHook)
 
 	return env
 }
@@ -60,6 +60,7 @@ type Config struct {
 	Value       *big.Int
 	DisableJit  bool // "disable" so it's enabled by default
 	Debug       bool
+	OpHook      vm.OpHook // Called for every executed instruction, may be nil
 
 	State     *state.StateDB
 	GetHashFn func(n uint64) common.Hash
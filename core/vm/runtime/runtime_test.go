@@ -93,6 +93,39 @@ func TestExecute(t *testing.T) {
 	}
 }
 
+func TestExecuteOpHook(t *testing.T) {
+	var (
+		ops []vm.OpCode
+		gas = new(big.Int)
+	)
+	hook := func(op vm.OpCode, cost *big.Int) {
+		ops = append(ops, op)
+		gas.Add(gas, cost)
+	}
+	_, _, err := Execute([]byte{
+		byte(vm.PUSH1), 1,
+		byte(vm.PUSH1), 2,
+		byte(vm.ADD),
+		byte(vm.STOP),
+	}, nil, &Config{OpHook: hook})
+	if err != nil {
+		t.Fatal("didn't expect error", err)
+	}
+
+	want := []vm.OpCode{vm.PUSH1, vm.PUSH1, vm.ADD, vm.STOP}
+	if len(ops) != len(want) {
+		t.Fatalf("hook called %d times, want %d", len(ops), len(want))
+	}
+	for i, op := range want {
+		if ops[i] != op {
+			t.Errorf("op %d: got %v, want %v", i, ops[i], op)
+		}
+	}
+	if gas.Cmp(big.NewInt(9)) != 0 {
+		t.Errorf("gas: got %v, want 9", gas)
+	}
+}
+
 func TestCall(t *testing.T) {
 	db, _ := ethdb.NewMemDatabase()
 	state, _ := state.New(common.Hash{}, state.NewDatabase(db))
@@ -20,6 +20,7 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"sync/atomic"
 	"time"
 
 	"github.com/ethereumproject/go-ethereum/common"
@@ -32,6 +33,7 @@ var (
 	OutOfGasError          = errors.New("Out of gas")
 	CodeStoreOutOfGasError = errors.New("Contract creation code storage out of gas")
 	ErrRevert              = errors.New("Execution reverted")
+	ErrCancelled           = errors.New("Execution cancelled")
 )
 
 // VirtualMachine is an EVM interface
@@ -48,8 +50,15 @@ type EVM struct {
 	jumpTable vmJumpTable
 	gasTable  GasTable
 	readOnly  bool
+	abort     int32  // set to non-zero by Cancel, read atomically
+	opHook    OpHook // called for every executed instruction, may be nil
 }
 
+// OpHook is called for every instruction executed by the EVM with its opcode and
+// the gas charged for it. For the CALL and CREATE family of instructions, the
+// cost includes the gas made available to the callee.
+type OpHook func(op OpCode, cost *big.Int)
+
 // New returns a new instance of the EVM.
 func New(env Environment) *EVM {
 	return &EVM{
@@ -59,6 +68,23 @@ func New(env Environment) *EVM {
 	}
 }
 
+// Cancel aborts any running or future execution of the EVM. It is safe to call
+// concurrently with Run.
+func (evm *EVM) Cancel() {
+	atomic.StoreInt32(&evm.abort, 1)
+}
+
+// SetOpHook sets a function to be called for every executed instruction, once its
+// gas has been charged. It must not be called concurrently with Run.
+func (evm *EVM) SetOpHook(hook OpHook) {
+	evm.opHook = hook
+}
+
+// Cancelled returns whether Cancel has been called.
+func (evm *EVM) Cancelled() bool {
+	return atomic.LoadInt32(&evm.abort) == 1
+}
+
 // Run loops and evaluates the contract's code with the given input data
 func (evm *EVM) Run(contract *Contract, input []byte, readOnly bool) (ret []byte, err error) {
 	evm.env.SetDepth(evm.env.Depth() + 1)
@@ -126,6 +152,9 @@ func (evm *EVM) Run(contract *Contract, input []byte, readOnly bool) (ret []byte
 	}
 
 	for ; ; instrCount++ {
+		if evm.Cancelled() {
+			return nil, ErrCancelled
+		}
 		// Get the memory location of pc
 		op = contract.GetOp(pc)
 		operation := evm.jumpTable[op]
@@ -152,6 +181,9 @@ func (evm *EVM) Run(contract *Contract, input []byte, readOnly bool) (ret []byte
 		if !contract.UseGas(cost) {
 			return nil, OutOfGasError
 		}
+		if evm.opHook != nil {
+			evm.opHook(op, cost)
+		}
 
 		// Resize the memory calculated previously
 		mem.Resize(newMemSize.Uint64())
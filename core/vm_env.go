@@ -85,6 +85,12 @@ func (self *VMEnv) GetHash(n uint64) common.Hash {
 	return self.getHashFn(n)
 }
 
+// Cancel aborts the EVM execution running in this environment.
+func (self *VMEnv) Cancel() { self.evm.Cancel() }
+
+// Cancelled returns whether the EVM execution in this environment was cancelled.
+func (self *VMEnv) Cancelled() bool { return self.evm.Cancelled() }
+
 func (self *VMEnv) AddLog(log *vm.Log) {
 	self.state.AddLog(*log)
 }
@@ -28,6 +28,8 @@ import (
 	"math/big"
 	"os"
 	"runtime"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -41,6 +43,7 @@ import (
 	"github.com/ethereumproject/go-ethereum/core/types"
 	"github.com/ethereumproject/go-ethereum/core/vm"
 	"github.com/ethereumproject/go-ethereum/crypto"
+	"github.com/ethereumproject/go-ethereum/eth/downloader"
 	"github.com/ethereumproject/go-ethereum/ethdb"
 	"github.com/ethereumproject/go-ethereum/event"
 	"github.com/ethereumproject/go-ethereum/logger"
@@ -50,46 +53,86 @@ import (
 	"github.com/ethereumproject/go-ethereum/p2p"
 	"github.com/ethereumproject/go-ethereum/rlp"
 	"github.com/ethereumproject/go-ethereum/rpc"
+	"github.com/ethereumproject/go-ethereum/trie"
+	"github.com/hashicorp/golang-lru"
 )
 
 const defaultGas = uint64(90000)
 
-// blockByNumber is a commonly used helper function which retrieves and returns
-// the block for the given block number, capable of handling two special blocks:
-// rpc.LatestBlockNumber and rpc.PendingBlockNumber. It returns nil when no block
-// could be found.
-func blockByNumber(m *miner.Miner, bc *core.BlockChain, blockNr rpc.BlockNumber) *types.Block {
-	// Pending block is only known by the miner
-	if blockNr == rpc.PendingBlockNumber {
-		block, _ := m.Pending()
-		return block
-	}
-	// Otherwise resolve and return the block
-	if blockNr == rpc.LatestBlockNumber {
-		return bc.CurrentBlock()
-	}
-	return bc.GetBlockByNumber(uint64(blockNr))
-}
+// DefaultCallTimeout is the default maximum duration of the EVM execution of a
+// single call, eg. eth_call, eth_estimateGas or eth_traceCall.
+const DefaultCallTimeout = 5 * time.Second
 
 // stateAndBlockByNumber is a commonly used helper function which retrieves and
 // returns the state and containing block for the given block number, capable of
 // handling two special states: rpc.LatestBlockNumber and rpc.PendingBlockNumber.
 // It returns nil when no block or state could be found.
-func stateAndBlockByNumber(m *miner.Miner, bc *core.BlockChain, blockNr rpc.BlockNumber, chainDb ethdb.Database) (*state.StateDB, *types.Block, error) {
+func stateAndBlockByNumber(m *miner.Miner, bc *core.BlockChain, blockNr rpc.BlockNumber) (*state.StateDB, *types.Block, error) {
 	// Pending state is only known by the miner
 	if blockNr == rpc.PendingBlockNumber {
 		block, state := m.Pending()
 		return state, block, nil
 	}
 	// Otherwise resolve the block number and return its state
-	block := blockByNumber(m, bc, blockNr)
-	if block == nil {
-		return nil, nil, nil
+	block, err := blockByNumberOrHash(m, bc, rpc.BlockNumberOrHashWithNumber(blockNr))
+	if block == nil || err != nil {
+		return nil, nil, err
+	}
+	stateDb, err := bc.StateAt(block.Root())
+	return stateDb, block, err
+}
+
+// stateAndBlockByHash retrieves and returns the state and block for the given block hash.
+// If requireCanonical is set, an error is returned when the block is not part of the
+// canonical chain. It returns nil when no block could be found.
+func stateAndBlockByHash(bc *core.BlockChain, hash common.Hash, requireCanonical bool) (*state.StateDB, *types.Block, error) {
+	block, err := blockByNumberOrHash(nil, bc, rpc.BlockNumberOrHashWithHash(hash, requireCanonical))
+	if block == nil || err != nil {
+		return nil, nil, err
 	}
-	stateDb, err := state.New(block.Root(), state.NewDatabase(chainDb))
+	stateDb, err := bc.StateAt(block.Root())
 	return stateDb, block, err
 }
 
+// stateAndBlockByNumberOrHash retrieves and returns the state and block identified
+// either by number or by hash, see stateAndBlockByNumber and stateAndBlockByHash.
+func stateAndBlockByNumberOrHash(m *miner.Miner, bc *core.BlockChain, blockNrOrHash rpc.BlockNumberOrHash) (*state.StateDB, *types.Block, error) {
+	if blockNrOrHash.BlockHash != nil {
+		return stateAndBlockByHash(bc, *blockNrOrHash.BlockHash, blockNrOrHash.RequireCanonical)
+	}
+	if blockNrOrHash.BlockNumber != nil {
+		return stateAndBlockByNumber(m, bc, *blockNrOrHash.BlockNumber)
+	}
+	return nil, nil, errors.New("invalid block number or hash")
+}
+
+// blockByNumberOrHash returns the block identified either by number, including the
+// "latest", "earliest" and "pending" tags, or by hash, see BlockChain.GetBlockByNumberOrHash.
+// The pending block is the one being mined, or the current block if there is none.
+func blockByNumberOrHash(m *miner.Miner, bc *core.BlockChain, blockNrOrHash rpc.BlockNumberOrHash) (*types.Block, error) {
+	if hash := blockNrOrHash.BlockHash; hash != nil {
+		return bc.GetBlockByNumberOrHash(0, hash, blockNrOrHash.RequireCanonical)
+	}
+	if blockNrOrHash.BlockNumber == nil {
+		return nil, errors.New("invalid block number or hash")
+	}
+	switch blockNr := *blockNrOrHash.BlockNumber; {
+	case blockNr == rpc.PendingBlockNumber:
+		if m != nil {
+			if block, _ := m.Pending(); block != nil {
+				return block, nil
+			}
+		}
+		return bc.CurrentBlock(), nil
+	case blockNr == rpc.LatestBlockNumber:
+		return bc.CurrentBlock(), nil
+	case blockNr < 0:
+		return nil, fmt.Errorf("invalid block number %d", blockNr)
+	default:
+		return bc.GetBlockByNumberOrHash(uint64(blockNr), nil, false)
+	}
+}
+
 // PublicEthereumAPI provides an API to access Ethereum related information.
 // It offers only methods that operate on public data that is freely available to anyone.
 type PublicEthereumAPI struct {
@@ -110,6 +153,46 @@ func (s *PublicEthereumAPI) GasPrice() *big.Int {
 	return s.gpo.SuggestPrice()
 }
 
+// MaxPriorityFeePerGas returns the suggested gas price, for clients of EIP-1559 networks.
+// Ethereum Classic uses legacy gas pricing: blocks have no base fee, so the whole gas price
+// of a transaction is paid to the miner and acts as its priority fee.
+func (s *PublicEthereumAPI) MaxPriorityFeePerGas() *rpc.HexNumber {
+	return rpc.NewHexNumber(s.gpo.SuggestPrice())
+}
+
+// GasPriceHistoryEntry holds the gas prices paid by transactions included in a block.
+// Min, Median and Max are nil for blocks without transactions.
+type GasPriceHistoryEntry struct {
+	Number  *rpc.HexNumber `json:"number"`
+	TxCount *rpc.HexNumber `json:"txCount"`
+	Min     *rpc.HexNumber `json:"min"`
+	Median  *rpc.HexNumber `json:"median"`
+	Max     *rpc.HexNumber `json:"max"`
+}
+
+// GasPriceHistory returns the minimum, median and maximum gas prices of included transactions
+// for each of the last n blocks, ordered by ascending block number. At most 1024 blocks are returned.
+func (s *PublicEthereumAPI) GasPriceHistory(n int) ([]*GasPriceHistoryEntry, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("invalid number of blocks: %d", n)
+	}
+	history := gasPriceHistory(s.e.BlockChain(), n)
+	entries := make([]*GasPriceHistoryEntry, len(history))
+	for i, h := range history {
+		entry := &GasPriceHistoryEntry{
+			Number:  rpc.NewHexNumber(h.number),
+			TxCount: rpc.NewHexNumber(h.txCount),
+		}
+		if h.txCount > 0 {
+			entry.Min = rpc.NewHexNumber(h.min)
+			entry.Median = rpc.NewHexNumber(h.median)
+			entry.Max = rpc.NewHexNumber(h.max)
+		}
+		entries[i] = entry
+	}
+	return entries, nil
+}
+
 // GetCompilers returns the collection of available smart contract compilers
 func (s *PublicEthereumAPI) GetCompilers() ([]string, error) {
 	solc, err := s.e.Solc()
@@ -357,6 +440,102 @@ func (s *PublicTxPoolAPI) Content() map[string]map[string]map[string][]*RPCTrans
 	return content
 }
 
+// ContentFrom returns the pending and queued transactions of a single account
+// contained within the transaction pool, keyed by nonce.
+func (s *PublicTxPoolAPI) ContentFrom(address common.Address) map[string]map[string][]*RPCTransaction {
+	content := map[string]map[string][]*RPCTransaction{
+		"pending": make(map[string][]*RPCTransaction),
+		"queued":  make(map[string][]*RPCTransaction),
+	}
+	pending, queue := s.e.TxPool().Content()
+
+	// Flatten the pending transactions
+	for nonce, txs := range pending[address] {
+		nonce := fmt.Sprintf("%d", nonce)
+		for _, tx := range txs {
+			content["pending"][nonce] = append(content["pending"][nonce], newRPCPendingTransaction(tx))
+		}
+	}
+	// Flatten the queued transactions
+	for nonce, txs := range queue[address] {
+		nonce := fmt.Sprintf("%d", nonce)
+		for _, tx := range txs {
+			content["queued"][nonce] = append(content["queued"][nonce], newRPCPendingTransaction(tx))
+		}
+	}
+	return content
+}
+
+// maxNonceGaps is the maximum number of missing nonces reported by NonceGaps.
+const maxNonceGaps = 1024
+
+// NonceGapsResult describes why the queued transactions of an account are not
+// promoted to pending.
+type NonceGapsResult struct {
+	PoolNonce    *rpc.HexNumber   `json:"poolNonce"`    // Next nonce expected by the pool, after its pending transactions
+	LowestQueued *rpc.HexNumber   `json:"lowestQueued"` // Lowest queued nonce, nil if nothing is queued
+	Missing      []*rpc.HexNumber `json:"missing"`      // Nonces missing before the highest queued one
+	Truncated    bool             `json:"truncated"`    // Whether the list of missing nonces was cut short
+}
+
+// NonceGaps returns the nonces an account is missing for its queued transactions
+// to be promoted to pending.
+func (s *PublicTxPoolAPI) NonceGaps(address common.Address) (*NonceGapsResult, error) {
+	managed := s.e.TxPool().State()
+	if managed == nil {
+		return nil, errors.New("transaction pool state not available")
+	}
+	next := managed.GetNonce(address)
+	pending, queue := s.e.TxPool().Content()
+
+	missing, lowest, truncated := nonceGaps(next, pending[address], queue[address], maxNonceGaps)
+	res := &NonceGapsResult{
+		PoolNonce: rpc.NewHexNumber(next),
+		Missing:   make([]*rpc.HexNumber, len(missing)),
+		Truncated: truncated,
+	}
+	if lowest != nil {
+		res.LowestQueued = rpc.NewHexNumber(*lowest)
+	}
+	for i, nonce := range missing {
+		res.Missing[i] = rpc.NewHexNumber(nonce)
+	}
+	return res, nil
+}
+
+// nonceGaps returns up to max nonces, starting at next, that are neither pending
+// nor queued but lower than the highest queued nonce, along with the lowest queued
+// nonce (nil if none is queued) and whether the list of gaps was truncated.
+func nonceGaps(next uint64, pending, queued map[uint64][]*types.Transaction, max int) ([]uint64, *uint64, bool) {
+	var lowest, highest *uint64
+	for nonce := range queued {
+		nonce := nonce
+		if lowest == nil || nonce < *lowest {
+			lowest = &nonce
+		}
+		if highest == nil || nonce > *highest {
+			highest = &nonce
+		}
+	}
+	missing := []uint64{}
+	if highest == nil {
+		return missing, nil, false
+	}
+	for nonce := next; nonce < *highest; nonce++ {
+		if _, ok := pending[nonce]; ok {
+			continue
+		}
+		if _, ok := queued[nonce]; ok {
+			continue
+		}
+		if len(missing) == max {
+			return missing, lowest, true
+		}
+		missing = append(missing, nonce)
+	}
+	return missing, lowest, false
+}
+
 // Status returns the number of pending and queued transaction in the pool.
 func (s *PublicTxPoolAPI) Status() map[string]*rpc.HexNumber {
 	pending, queue := s.e.TxPool().Stats()
@@ -432,6 +611,9 @@ type PrivateAccountAPI struct {
 	txPool *core.TxPool
 	txMu   *sync.Mutex
 	gpo    *GasPriceOracle
+
+	exportMu   sync.Mutex
+	lastExport time.Time // time of the last ExportAccount call
 }
 
 // NewPrivateAccountAPI create a new PrivateAccountAPI.
@@ -476,6 +658,36 @@ func (s *PrivateAccountAPI) ImportRawKey(privkey string, password string) (commo
 	return acc.Address, err
 }
 
+// accountExportInterval is the minimum time between two ExportAccount calls, which slows
+// down guessing of passwords through the API.
+const accountExportInterval = time.Second
+
+// ExportAccount returns the key of the account associated with the given address as
+// keystore JSON, re-encrypted with newPassword, so that it can be imported on another node.
+// The key is decrypted with the given password. The raw private key is never returned,
+// so newPassword must not be empty. Every call is logged, and calls made less than
+// accountExportInterval after the previous one are rejected.
+func (s *PrivateAccountAPI) ExportAccount(addr common.Address, password, newPassword string) (json.RawMessage, error) {
+	if newPassword == "" {
+		return nil, errors.New("new password must not be empty")
+	}
+	s.exportMu.Lock()
+	if since := time.Since(s.lastExport); since < accountExportInterval {
+		s.exportMu.Unlock()
+		return nil, fmt.Errorf("account export rate limited, retry in %v", accountExportInterval-since)
+	}
+	s.lastExport = time.Now()
+	s.exportMu.Unlock()
+
+	keyJSON, err := s.am.Export(accounts.Account{Address: addr}, password, newPassword)
+	if err != nil {
+		glog.D(logger.Warn).Warnf("Account export of %x failed: %v", addr, err)
+		return nil, err
+	}
+	glog.D(logger.Warn).Warnf("Account %x exported", addr)
+	return keyJSON, nil
+}
+
 // UnlockAccount will unlock the account associated with the given address with
 // the given password for duration seconds. If duration is nil it will use a
 // default of 300 seconds. It returns an indication if the account was unlocked.
@@ -514,6 +726,25 @@ func (s *PrivateAccountAPI) Sign(data hexutil.Bytes, addr common.Address, passwd
 	return signature, nil
 }
 
+// SignTypedData calculates an EIP-712 signature of the given typed data, as
+// eth_signTypedData_v4 does. The signed digest is
+// keccak256("\x19\x01" ‖ hashStruct(domain) ‖ hashStruct(message)).
+//
+// The key used to calculate the signature is decrypted with the given password. Like
+// Sign, the V value of the signature is 27 or 28.
+func (s *PrivateAccountAPI) SignTypedData(addr common.Address, typedData TypedData, passwd string) (hexutil.Bytes, error) {
+	hash, err := typedData.Hash()
+	if err != nil {
+		return nil, err
+	}
+	signature, err := s.am.SignWithPassphrase(addr, passwd, hash.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	signature[64] += 27 // Transform V from 0/1 to 27/28 according to the yellow paper
+	return signature, nil
+}
+
 // SendTransaction will create a transaction from the given arguments and
 // tries to sign it with the key associated with args.To. If the given passwd isn't
 // able to decrypt the key it fails.
@@ -534,14 +765,18 @@ func (s *PrivateAccountAPI) SendTransaction(args SendTxArgs, passwd string) (com
 		tx = types.NewTransaction(args.Nonce.Uint64(), *args.To, args.Value.BigInt(), args.Gas.BigInt(), args.GasPrice.BigInt(), common.FromHex(args.Data))
 	}
 
-	tx.SetSigner(s.bc.Config().GetSigner(s.bc.CurrentBlock().Number()))
+	signer, err := sendTxSigner(s.bc, args)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	tx.SetSigner(signer)
 
 	signature, err := s.am.SignWithPassphrase(args.From, passwd, tx.SigHash().Bytes())
 	if err != nil {
 		return common.Hash{}, err
 	}
 
-	return submitTransaction(s.bc, s.txPool, tx, signature)
+	return submitTransaction(s.txPool, tx, signer, signature)
 }
 
 // SignAndSendTransaction was renamed to SendTransaction. This method is deprecated
@@ -563,11 +798,21 @@ type PublicBlockChainAPI struct {
 	am                      *accounts.Manager
 	miner                   *miner.Miner
 	gpo                     *GasPriceOracle
+	gasCap                  uint64        // maximum gas of calls, 0 for unlimited, see setGasCap
+	gasCapReject            bool          // reject calls requesting more than gasCap instead of capping them
+	callTimeout             time.Duration // maximum EVM execution time of calls, 0 for unlimited, see setCallTimeout
+	senders                 *lru.Cache    // recovered transaction senders by transaction hash, see sender
 }
 
+// senderCacheLimit is the number of recovered transaction senders kept for
+// returning full transactions with blocks.
+const senderCacheLimit = 16384
+
 // NewPublicBlockChainAPI creates a new Etheruem blockchain API.
 func NewPublicBlockChainAPI(config *core.ChainConfig, bc *core.BlockChain, m *miner.Miner, chainDb ethdb.Database, gpo *GasPriceOracle, eventMux *event.TypeMux, am *accounts.Manager) *PublicBlockChainAPI {
+	senders, _ := lru.New(senderCacheLimit)
 	api := &PublicBlockChainAPI{
+		senders:  senders,
 		config:   config,
 		bc:       bc,
 		miner:    m,
@@ -584,56 +829,195 @@ func NewPublicBlockChainAPI(config *core.ChainConfig, bc *core.BlockChain, m *mi
 }
 
 // subscriptionLoop reads events from the global event mux and creates notifications for the matched subscriptions.
+// Senders of transactions dropped from the canonical chain by a reorg are evicted from the sender cache.
 func (s *PublicBlockChainAPI) subscriptionLoop() {
-	sub := s.eventMux.Subscribe(core.ChainEvent{})
+	sub := s.eventMux.Subscribe(core.ChainEvent{}, core.RemovedTransactionEvent{})
 	for event := range sub.Chan() {
-		if chainEvent, ok := event.Data.(core.ChainEvent); ok {
+		switch ev := event.Data.(type) {
+		case core.ChainEvent:
 			s.muNewBlockSubscriptions.Lock()
 			for id, notifyOf := range s.newBlockSubscriptions {
-				if notifyOf(chainEvent) == rpc.ErrNotificationNotFound {
+				if notifyOf(ev) == rpc.ErrNotificationNotFound {
 					delete(s.newBlockSubscriptions, id)
 				}
 			}
 			s.muNewBlockSubscriptions.Unlock()
+		case core.RemovedTransactionEvent:
+			s.forgetSenders(ev.Txs)
 		}
 	}
 }
 
+// sender returns the sender of a canonical transaction, recovering it only if
+// it is not in the sender cache.
+func (s *PublicBlockChainAPI) sender(tx *types.Transaction) common.Address {
+	if s.senders == nil {
+		return rpcTransactionSender(tx)
+	}
+	hash := tx.Hash()
+	if from, ok := s.senders.Get(hash); ok {
+		return from.(common.Address)
+	}
+	from := rpcTransactionSender(tx)
+	s.senders.Add(hash, from)
+	return from
+}
+
+// forgetSenders evicts the senders of the given transactions from the sender cache.
+func (s *PublicBlockChainAPI) forgetSenders(txs types.Transactions) {
+	if s.senders == nil {
+		return
+	}
+	for _, tx := range txs {
+		s.senders.Remove(tx.Hash())
+	}
+}
+
 // BlockNumber returns the block number of the chain head.
 func (s *PublicBlockChainAPI) BlockNumber() *big.Int {
 	return s.bc.CurrentHeader().Number
 }
 
 // GetBalance returns the amount of wei for the given address in the state of the
-// given block number. The rpc.LatestBlockNumber and rpc.PendingBlockNumber meta
+// given block number or hash. The rpc.LatestBlockNumber and rpc.PendingBlockNumber meta
 // block numbers are also allowed.
-func (s *PublicBlockChainAPI) GetBalance(address common.Address, blockNr rpc.BlockNumber) (*big.Int, error) {
-	state, _, err := stateAndBlockByNumber(s.miner, s.bc, blockNr, s.chainDb)
+func (s *PublicBlockChainAPI) GetBalance(address common.Address, blockNrOrHash rpc.BlockNumberOrHash) (*big.Int, error) {
+	state, _, err := stateAndBlockByNumberOrHash(s.miner, s.bc, blockNrOrHash)
 	if state == nil || err != nil {
 		return nil, err
 	}
 	return state.GetBalance(address), nil
 }
 
+// maxBalanceAddresses is the maximum number of addresses accepted by GetBalances.
+const maxBalanceAddresses = 1024
+
+// GetBalances returns the amount of wei for each of the given addresses, in the same
+// order, all read from the state of the given block number or hash.
+func (s *PublicBlockChainAPI) GetBalances(addresses []common.Address, blockNrOrHash rpc.BlockNumberOrHash) ([]*big.Int, error) {
+	if len(addresses) > maxBalanceAddresses {
+		return nil, fmt.Errorf("too many addresses: %d (max %d)", len(addresses), maxBalanceAddresses)
+	}
+	state, _, err := stateAndBlockByNumberOrHash(s.miner, s.bc, blockNrOrHash)
+	if state == nil || err != nil {
+		return nil, err
+	}
+	balances := make([]*big.Int, len(addresses))
+	for i, address := range addresses {
+		balances[i] = state.GetBalance(address)
+	}
+	return balances, nil
+}
+
+// GetStorageRoot returns the root hash of the storage trie of the given account in the state
+// of the given block number or hash. The empty trie hash is returned for accounts without
+// storage, including accounts which do not exist.
+func (s *PublicBlockChainAPI) GetStorageRoot(address common.Address, blockNrOrHash rpc.BlockNumberOrHash) (common.Hash, error) {
+	state, _, err := stateAndBlockByNumberOrHash(s.miner, s.bc, blockNrOrHash)
+	if state == nil || err != nil {
+		return common.Hash{}, err
+	}
+	if root := state.GetStorageRoot(address); root != (common.Hash{}) {
+		return root, nil
+	}
+	return types.EmptyRootHash, nil
+}
+
+// AccountResult is the result of an eth_getProof API call, in the shape defined by EIP-1186.
+type AccountResult struct {
+	Address      common.Address  `json:"address"`
+	AccountProof []hexutil.Bytes `json:"accountProof"`
+	Balance      *hexutil.Big    `json:"balance"`
+	CodeHash     common.Hash     `json:"codeHash"`
+	Nonce        hexutil.Uint64  `json:"nonce"`
+	StorageHash  common.Hash     `json:"storageHash"`
+	StorageProof []StorageResult `json:"storageProof"`
+}
+
+// StorageResult is the merkle proof of a single storage slot in an AccountResult.
+type StorageResult struct {
+	Key   string          `json:"key"`
+	Value *hexutil.Big    `json:"value"`
+	Proof []hexutil.Bytes `json:"proof"`
+}
+
+// maxProofStorageKeys is the maximum number of storage keys proved by a GetProof call.
+const maxProofStorageKeys = 1024
+
+// GetProof returns the merkle proof of the given account, and of each of the given storage
+// keys of that account, in the state of the given block number or hash. Proofs list the
+// encoded trie nodes on the path from the state (or storage) root to the value, root first.
+// Proofs of absent accounts and slots prove their absence. At most maxProofStorageKeys
+// storage keys may be given.
+func (s *PublicBlockChainAPI) GetProof(address common.Address, storageKeys []string, blockNrOrHash rpc.BlockNumberOrHash) (*AccountResult, error) {
+	if len(storageKeys) > maxProofStorageKeys {
+		return nil, fmt.Errorf("too many storage keys: %d, the maximum is %d", len(storageKeys), maxProofStorageKeys)
+	}
+	statedb, _, err := stateAndBlockByNumberOrHash(s.miner, s.bc, blockNrOrHash)
+	if statedb == nil || err != nil {
+		return nil, err
+	}
+	accountProof, err := statedb.GetProof(address)
+	if err != nil {
+		return nil, err
+	}
+	result := &AccountResult{
+		Address:      address,
+		AccountProof: toHexSlice(accountProof),
+		Balance:      (*hexutil.Big)(statedb.GetBalance(address)),
+		CodeHash:     statedb.GetCodeHash(address),
+		Nonce:        hexutil.Uint64(statedb.GetNonce(address)),
+		StorageHash:  types.EmptyRootHash,
+		StorageProof: make([]StorageResult, len(storageKeys)),
+	}
+	// All storage proofs are built from the same copy of the storage trie
+	storageTrie := statedb.StorageTrie(address)
+	if storageTrie != nil {
+		result.StorageHash = storageTrie.Hash()
+	}
+	for i, key := range storageKeys {
+		result.StorageProof[i] = StorageResult{Key: key, Value: new(hexutil.Big), Proof: []hexutil.Bytes{}}
+		if storageTrie == nil {
+			continue
+		}
+		hash := common.HexToHash(key)
+		proof, err := state.GetTrieProof(storageTrie, hash[:])
+		if err != nil {
+			return nil, err
+		}
+		result.StorageProof[i].Value = (*hexutil.Big)(statedb.GetState(address, hash).Big())
+		result.StorageProof[i].Proof = toHexSlice(proof)
+	}
+	return result, nil
+}
+
+// toHexSlice converts a list of byte slices to their hex-encoded JSON form.
+func toHexSlice(b [][]byte) []hexutil.Bytes {
+	r := make([]hexutil.Bytes, len(b))
+	for i := range b {
+		r[i] = b[i]
+	}
+	return r
+}
+
 // GetBlockByNumber returns the requested block. When blockNr is -1 the chain head is returned. When fullTx is true all
 // transactions in the block are returned in full detail, otherwise only the transaction hash is returned.
-func (s *PublicBlockChainAPI) GetBlockByNumber(blockNr rpc.BlockNumber, fullTx bool) (map[string]interface{}, error) {
-	if block := blockByNumber(s.miner, s.bc, blockNr); block != nil {
-		response, err := s.rpcOutputBlock(block, true, fullTx)
-		if err == nil && blockNr == rpc.PendingBlockNumber {
-			// Pending blocks need to nil out a few fields
-			for _, field := range []string{"hash", "nonce", "miner"} {
-				response[field] = nil
-			}
-		}
-		return response, err
+func (s *PublicBlockChainAPI) GetBlockByNumber(blockNr rpc.BlockNumber, fullTx bool) (*RPCBlock, error) {
+	block, err := blockByNumberOrHash(s.miner, s.bc, rpc.BlockNumberOrHashWithNumber(blockNr))
+	if block == nil || err != nil {
+		return nil, err
 	}
-	return nil, nil
+	response, err := s.rpcOutputBlock(block, true, fullTx)
+	if err == nil && blockNr == rpc.PendingBlockNumber {
+		// Pending blocks need to nil out a few fields
+		response.Hash, response.Nonce, response.Miner = nil, nil, nil
+	}
+	return response, err
 }
 
 // GetBlockByHash returns the requested block. When fullTx is true all transactions in the block are returned in full
 // detail, otherwise only the transaction hash is returned.
-func (s *PublicBlockChainAPI) GetBlockByHash(blockHash common.Hash, fullTx bool) (map[string]interface{}, error) {
+func (s *PublicBlockChainAPI) GetBlockByHash(blockHash common.Hash, fullTx bool) (*RPCBlock, error) {
 	if block := s.bc.GetBlock(blockHash); block != nil {
 		return s.rpcOutputBlock(block, true, fullTx)
 	}
@@ -642,8 +1026,8 @@ func (s *PublicBlockChainAPI) GetBlockByHash(blockHash common.Hash, fullTx bool)
 
 // GetUncleByBlockNumberAndIndex returns the uncle block for the given block hash and index. When fullTx is true
 // all transactions in the block are returned in full detail, otherwise only the transaction hash is returned.
-func (s *PublicBlockChainAPI) GetUncleByBlockNumberAndIndex(blockNr rpc.BlockNumber, index rpc.HexNumber) (map[string]interface{}, error) {
-	if block := blockByNumber(s.miner, s.bc, blockNr); block != nil {
+func (s *PublicBlockChainAPI) GetUncleByBlockNumberAndIndex(blockNr rpc.BlockNumber, index rpc.HexNumber) (*RPCBlock, error) {
+	if block, _ := blockByNumberOrHash(s.miner, s.bc, rpc.BlockNumberOrHashWithNumber(blockNr)); block != nil {
 		uncles := block.Uncles()
 		if index.Int() < 0 || index.Int() >= len(uncles) {
 			glog.V(logger.Debug).Infof("uncle block on index %d not found for block #%d", index.Int(), blockNr)
@@ -657,7 +1041,7 @@ func (s *PublicBlockChainAPI) GetUncleByBlockNumberAndIndex(blockNr rpc.BlockNum
 
 // GetUncleByBlockHashAndIndex returns the uncle block for the given block hash and index. When fullTx is true
 // all transactions in the block are returned in full detail, otherwise only the transaction hash is returned.
-func (s *PublicBlockChainAPI) GetUncleByBlockHashAndIndex(blockHash common.Hash, index rpc.HexNumber) (map[string]interface{}, error) {
+func (s *PublicBlockChainAPI) GetUncleByBlockHashAndIndex(blockHash common.Hash, index rpc.HexNumber) (*RPCBlock, error) {
 	if block := s.bc.GetBlock(blockHash); block != nil {
 		uncles := block.Uncles()
 		if index.Int() < 0 || index.Int() >= len(uncles) {
@@ -670,9 +1054,27 @@ func (s *PublicBlockChainAPI) GetUncleByBlockHashAndIndex(blockHash common.Hash,
 	return nil, nil
 }
 
+// GetUnclesByBlockHash returns all uncles of the block with the given hash, formatted as blocks
+// without transactions, in the order they are included. It returns nil for an unknown block.
+func (s *PublicBlockChainAPI) GetUnclesByBlockHash(blockHash common.Hash) ([]*RPCBlock, error) {
+	block := s.bc.GetBlock(blockHash)
+	if block == nil {
+		return nil, nil
+	}
+	uncles := make([]*RPCBlock, 0, len(block.Uncles()))
+	for _, uncle := range block.Uncles() {
+		out, err := s.rpcOutputBlock(types.NewBlockWithHeader(uncle), false, false)
+		if err != nil {
+			return nil, err
+		}
+		uncles = append(uncles, out)
+	}
+	return uncles, nil
+}
+
 // GetUncleCountByBlockNumber returns number of uncles in the block for the given block number
 func (s *PublicBlockChainAPI) GetUncleCountByBlockNumber(blockNr rpc.BlockNumber) *rpc.HexNumber {
-	if block := blockByNumber(s.miner, s.bc, blockNr); block != nil {
+	if block, _ := blockByNumberOrHash(s.miner, s.bc, rpc.BlockNumberOrHashWithNumber(blockNr)); block != nil {
 		return rpc.NewHexNumber(len(block.Uncles()))
 	}
 	return nil
@@ -725,9 +1127,38 @@ func (s *PublicBlockChainAPI) NewBlocks(ctx context.Context, args NewBlocksArgs)
 	return subscription, nil
 }
 
-// GetCode returns the code stored at the given address in the state for the given block number.
-func (s *PublicBlockChainAPI) GetCode(address common.Address, blockNr rpc.BlockNumber) (string, error) {
-	state, _, err := stateAndBlockByNumber(s.miner, s.bc, blockNr, s.chainDb)
+// NewHeads triggers a new head event each time a block is appended to the chain. Unlike NewBlocks, it only
+// contains header-level fields and does not assemble transactions or uncles, which makes it a lightweight
+// way to track the chain tip.
+func (s *PublicBlockChainAPI) NewHeads(ctx context.Context) (rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+
+	// create a subscription that will remove itself when unsubscribed/cancelled
+	subscription, err := notifier.NewSubscription(func(subId string) {
+		s.muNewBlockSubscriptions.Lock()
+		delete(s.newBlockSubscriptions, subId)
+		s.muNewBlockSubscriptions.Unlock()
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	// add a callback that is called on chain events which will format the header and notify the client
+	s.muNewBlockSubscriptions.Lock()
+	s.newBlockSubscriptions[subscription.ID()] = func(e core.ChainEvent) error {
+		return subscription.Notify(rpcOutputHeader(e.Block.Header()))
+	}
+	s.muNewBlockSubscriptions.Unlock()
+	return subscription, nil
+}
+
+// GetCode returns the code stored at the given address in the state for the given block number or hash.
+func (s *PublicBlockChainAPI) GetCode(address common.Address, blockNrOrHash rpc.BlockNumberOrHash) (string, error) {
+	state, _, err := stateAndBlockByNumberOrHash(s.miner, s.bc, blockNrOrHash)
 	if state == nil || err != nil {
 		return "", err
 	}
@@ -739,10 +1170,10 @@ func (s *PublicBlockChainAPI) GetCode(address common.Address, blockNr rpc.BlockN
 }
 
 // GetStorageAt returns the storage from the state at the given address, key and
-// block number. The rpc.LatestBlockNumber and rpc.PendingBlockNumber meta block
+// block number or hash. The rpc.LatestBlockNumber and rpc.PendingBlockNumber meta block
 // numbers are also allowed.
-func (s *PublicBlockChainAPI) GetStorageAt(address common.Address, key string, blockNr rpc.BlockNumber) (string, error) {
-	state, _, err := stateAndBlockByNumber(s.miner, s.bc, blockNr, s.chainDb)
+func (s *PublicBlockChainAPI) GetStorageAt(address common.Address, key string, blockNrOrHash rpc.BlockNumberOrHash) (string, error) {
+	state, _, err := stateAndBlockByNumberOrHash(s.miner, s.bc, blockNrOrHash)
 	if state == nil || err != nil {
 		return "0x", err
 	}
@@ -778,11 +1209,55 @@ type CallArgs struct {
 	Data     string          `json:"data"`
 }
 
-func (s *PublicBlockChainAPI) doCall(args CallArgs, blockNr rpc.BlockNumber) (string, *big.Int, error) {
-	// Fetch the state associated with the block number
-	stateDb, block, err := stateAndBlockByNumber(s.miner, s.bc, blockNr, s.chainDb)
+// abortOnDone aborts the EVM execution in vmenv once ctx is done or timeout has elapsed,
+// 0 for no timeout. The returned context reports why the execution was aborted, the returned
+// function must be called once the execution has completed.
+func abortOnDone(ctx context.Context, vmenv *core.VMEnv, timeout time.Duration) (context.Context, context.CancelFunc) {
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	go func() {
+		<-ctx.Done()
+		vmenv.Cancel()
+	}()
+	return ctx, cancel
+}
+
+// setCallTimeout bounds the EVM execution time of Call, EstimateGas and TraceCall, 0 for unlimited.
+func (s *PublicBlockChainAPI) setCallTimeout(timeout time.Duration) {
+	s.callTimeout = timeout
+}
+
+// setGasCap bounds the gas used by Call, EstimateGas and TraceCall to gasCap, 0 for unlimited.
+// If reject is set, calls requesting more gas fail, otherwise their gas is lowered to the cap.
+func (s *PublicBlockChainAPI) setGasCap(gasCap uint64, reject bool) {
+	s.gasCap = gasCap
+	s.gasCapReject = reject
+}
+
+// capGas returns the gas to execute a call with, given the requested gas or the default gas
+// if none was requested. Default gas is always lowered to the gas cap.
+func (s *PublicBlockChainAPI) capGas(gas *big.Int, requested bool) (*big.Int, error) {
+	if s.gasCap == 0 || gas.Cmp(new(big.Int).SetUint64(s.gasCap)) <= 0 {
+		return gas, nil
+	}
+	if requested && s.gasCapReject {
+		return nil, fmt.Errorf("gas %v exceeds the RPC gas cap %d", gas, s.gasCap)
+	}
+	return new(big.Int).SetUint64(s.gasCap), nil
+}
+
+// doCall executes the given call on the state for the given block number or hash.
+// It returns the call result, the gas used, and whether the EVM execution failed.
+// The execution is aborted with an error when ctx is done or after the call timeout.
+func (s *PublicBlockChainAPI) doCall(ctx context.Context, args CallArgs, blockNrOrHash rpc.BlockNumberOrHash) (string, *big.Int, bool, error) {
+	// Fetch the state associated with the block number or hash
+	stateDb, block, err := stateAndBlockByNumberOrHash(s.miner, s.bc, blockNrOrHash)
 	if stateDb == nil || err != nil {
-		return "0x", nil, err
+		return "0x", nil, false, err
 	}
 	stateDb = stateDb.Copy()
 
@@ -809,9 +1284,13 @@ func (s *PublicBlockChainAPI) doCall(args CallArgs, blockNr rpc.BlockNumber) (st
 		value:    args.Value.BigInt(),
 		data:     common.FromHex(args.Data),
 	}
-	if msg.gas == nil {
+	requested := msg.gas != nil
+	if !requested {
 		msg.gas = big.NewInt(50000000)
 	}
+	if msg.gas, err = s.capGas(msg.gas, requested); err != nil {
+		return "0x", nil, false, err
+	}
 	if msg.gasPrice == nil {
 		msg.gasPrice = s.gpo.SuggestPrice()
 	}
@@ -820,84 +1299,212 @@ func (s *PublicBlockChainAPI) doCall(args CallArgs, blockNr rpc.BlockNumber) (st
 	vmenv := core.NewEnv(stateDb, s.config, s.bc, msg, block.Header())
 	gp := new(core.GasPool).AddGas(common.MaxBig)
 
-	res, requiredGas, _, err := core.NewStateTransition(vmenv, msg, gp).TransitionDb()
+	ctx, cancel := abortOnDone(ctx, vmenv, s.callTimeout)
+	res, requiredGas, failed, err := core.NewStateTransition(vmenv, msg, gp).TransitionDb()
+	aborted := vmenv.Cancelled()
+	cancel()
+	if aborted {
+		return "0x", nil, false, fmt.Errorf("execution aborted: %v", ctx.Err())
+	}
 	if len(res) == 0 { // backwards compatibility
-		return "0x", requiredGas, err
+		return "0x", requiredGas, failed, err
 	}
-	return common.ToHex(res), requiredGas, err
+	return common.ToHex(res), requiredGas, failed, err
 }
 
-// Call executes the given transaction on the state for the given block number.
+// Call executes the given transaction on the state for the given block number or hash.
 // It doesn't make and changes in the state/blockchain and is useful to execute and retrieve values.
-func (s *PublicBlockChainAPI) Call(args CallArgs, blockNr rpc.BlockNumber) (string, error) {
-	result, _, err := s.doCall(args, blockNr)
+func (s *PublicBlockChainAPI) Call(ctx context.Context, args CallArgs, blockNrOrHash rpc.BlockNumberOrHash) (string, error) {
+	result, _, _, err := s.doCall(ctx, args, blockNrOrHash)
 	return result, err
 }
 
+// estimateGasBuffer is the percentage added on top of the lowest executable gas found by EstimateGas.
+const estimateGasBuffer = 10
+
 // EstimateGas returns an estimate of the amount of gas needed to execute the given transaction.
-func (s *PublicBlockChainAPI) EstimateGas(args CallArgs) (*rpc.HexNumber, error) {
-	_, gas, err := s.doCall(args, rpc.PendingBlockNumber)
-	return rpc.NewHexNumber(gas), err
+// It binary searches for the lowest gas limit between the intrinsic transaction gas and the
+// given gas (or the pending block gas limit) for which the transaction executes without failing,
+// and adds a small buffer to it.
+func (s *PublicBlockChainAPI) EstimateGas(ctx context.Context, args CallArgs) (*rpc.HexNumber, error) {
+	var hi uint64
+	requested := args.Gas != nil && args.Gas.Uint64() >= core.TxGas.Uint64()
+	if requested {
+		hi = args.Gas.Uint64()
+	} else if block, _ := blockByNumberOrHash(s.miner, s.bc, rpc.BlockNumberOrHashWithNumber(rpc.PendingBlockNumber)); block != nil {
+		hi = block.GasLimit().Uint64()
+	} else {
+		hi = s.bc.CurrentBlock().GasLimit().Uint64()
+	}
+	capped, err := s.capGas(new(big.Int).SetUint64(hi), requested)
+	if err != nil {
+		return nil, err
+	}
+	hi = capped.Uint64()
+	executable := func(gas uint64) error {
+		args.Gas = rpc.NewHexNumber(gas)
+		_, _, failed, err := s.doCall(ctx, args, rpc.BlockNumberOrHashWithNumber(rpc.PendingBlockNumber))
+		if err != nil {
+			return err
+		}
+		if failed {
+			return errors.New("execution failed")
+		}
+		return nil
+	}
+	gas, err := searchGas(core.TxGas.Uint64()-1, hi, executable)
+	if err != nil {
+		return nil, err
+	}
+	return rpc.NewHexNumber(withGasBuffer(gas, hi)), nil
+}
+
+// searchGas binary searches for the lowest gas in (lo, hi] for which executable succeeds.
+// It returns an error if executable fails even with hi gas.
+func searchGas(lo, hi uint64, executable func(gas uint64) error) (uint64, error) {
+	if err := executable(hi); err != nil {
+		return 0, fmt.Errorf("gas required exceeds allowance (%d) or always failing transaction: %v", hi, err)
+	}
+	for lo+1 < hi {
+		mid := lo + (hi-lo)/2
+		if executable(mid) != nil {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return hi, nil
+}
+
+// withGasBuffer adds estimateGasBuffer percent to gas, capped at max.
+func withGasBuffer(gas, max uint64) uint64 {
+	buffered := gas + gas*estimateGasBuffer/100
+	if buffered > max || buffered < gas {
+		return max
+	}
+	return buffered
+}
+
+// RPCBlock represents a block that will serialize to the RPC representation of a block.
+// Transactions is either a list of transaction hashes or a list of *RPCTransaction,
+// and is omitted when transactions are not requested.
+type RPCBlock struct {
+	Difficulty       *rpc.HexNumber    `json:"difficulty"`
+	ExtraData        string            `json:"extraData"`
+	GasLimit         *rpc.HexNumber    `json:"gasLimit"`
+	GasUsed          *rpc.HexNumber    `json:"gasUsed"`
+	Hash             *common.Hash      `json:"hash"`
+	LogsBloom        types.Bloom       `json:"logsBloom"`
+	Miner            *common.Address   `json:"miner"`
+	MixHash          common.Hash       `json:"mixHash"`
+	Nonce            *types.BlockNonce `json:"nonce"`
+	Number           *rpc.HexNumber    `json:"number"`
+	ParentHash       common.Hash       `json:"parentHash"`
+	ReceiptsRoot     common.Hash       `json:"receiptsRoot"`
+	Sha3Uncles       common.Hash       `json:"sha3Uncles"`
+	Size             *rpc.HexNumber    `json:"size"`
+	StateRoot        common.Hash       `json:"stateRoot"`
+	Timestamp        *rpc.HexNumber    `json:"timestamp"`
+	TotalDifficulty  *rpc.HexNumber    `json:"totalDifficulty"`
+	Transactions     interface{}       `json:"transactions,omitempty"`
+	TransactionsRoot common.Hash       `json:"transactionsRoot"`
+	Uncles           []common.Hash     `json:"uncles"`
 }
 
 // rpcOutputBlock converts the given block to the RPC output which depends on fullTx. If inclTx is true transactions are
 // returned. When fullTx is true the returned block contains full transaction details, otherwise it will only contain
 // transaction hashes.
-func (s *PublicBlockChainAPI) rpcOutputBlock(b *types.Block, inclTx bool, fullTx bool) (map[string]interface{}, error) {
-	fields := map[string]interface{}{
-		"number":           rpc.NewHexNumber(b.Number()),
-		"hash":             b.Hash(),
-		"parentHash":       b.ParentHash(),
-		"nonce":            b.Header().Nonce,
-		"mixHash":          b.Header().MixDigest,
-		"sha3Uncles":       b.UncleHash(),
-		"logsBloom":        b.Bloom(),
-		"stateRoot":        b.Root(),
-		"miner":            b.Coinbase(),
-		"difficulty":       rpc.NewHexNumber(b.Difficulty()),
-		"totalDifficulty":  rpc.NewHexNumber(s.bc.GetTd(b.Hash())),
-		"extraData":        fmt.Sprintf("0x%x", b.Extra()),
-		"size":             rpc.NewHexNumber(b.Size().Int64()),
-		"gasLimit":         rpc.NewHexNumber(b.GasLimit()),
-		"gasUsed":          rpc.NewHexNumber(b.GasUsed()),
-		"timestamp":        rpc.NewHexNumber(b.Time()),
-		"transactionsRoot": b.TxHash(),
-		"receiptsRoot":     b.ReceiptHash(),
+func (s *PublicBlockChainAPI) rpcOutputBlock(b *types.Block, inclTx bool, fullTx bool) (*RPCBlock, error) {
+	head := b.Header()
+	hash := b.Hash()
+
+	block := &RPCBlock{
+		Number:           rpc.NewHexNumber(head.Number),
+		Hash:             &hash,
+		ParentHash:       head.ParentHash,
+		Nonce:            &head.Nonce,
+		MixHash:          head.MixDigest,
+		Sha3Uncles:       head.UncleHash,
+		LogsBloom:        head.Bloom,
+		StateRoot:        head.Root,
+		Miner:            &head.Coinbase,
+		Difficulty:       rpc.NewHexNumber(head.Difficulty),
+		TotalDifficulty:  rpc.NewHexNumber(s.bc.GetTd(hash)),
+		ExtraData:        fmt.Sprintf("0x%x", head.Extra),
+		Size:             rpc.NewHexNumber(b.Size().Int64()),
+		GasLimit:         rpc.NewHexNumber(head.GasLimit),
+		GasUsed:          rpc.NewHexNumber(head.GasUsed),
+		Timestamp:        rpc.NewHexNumber(head.Time),
+		TransactionsRoot: head.TxHash,
+		ReceiptsRoot:     head.ReceiptHash,
 	}
 
 	if inclTx {
-		formatTx := func(tx *types.Transaction) (interface{}, error) {
-			return tx.Hash(), nil
-		}
-
+		txs := b.Transactions()
 		if fullTx {
-			formatTx = func(tx *types.Transaction) (interface{}, error) {
+			transactions := make([]*RPCTransaction, len(txs))
+			for i, tx := range txs {
 				if tx.Protected() {
 					tx.SetSigner(types.NewChainIdSigner(s.bc.Config().GetChainID()))
 				}
-				return newRPCTransaction(b, tx.Hash())
+				transactions[i] = newRPCTransactionWithSender(b, i, s.sender(tx))
 			}
-		}
-
-		txs := b.Transactions()
-		transactions := make([]interface{}, len(txs))
-		var err error
-		for i, tx := range b.Transactions() {
-			if transactions[i], err = formatTx(tx); err != nil {
-				return nil, err
+			block.Transactions = transactions
+		} else {
+			hashes := make([]common.Hash, len(txs))
+			for i, tx := range txs {
+				hashes[i] = tx.Hash()
 			}
+			block.Transactions = hashes
 		}
-		fields["transactions"] = transactions
 	}
 
 	uncles := b.Uncles()
-	uncleHashes := make([]common.Hash, len(uncles))
+	block.Uncles = make([]common.Hash, len(uncles))
 	for i, uncle := range uncles {
-		uncleHashes[i] = uncle.Hash()
+		block.Uncles[i] = uncle.Hash()
 	}
-	fields["uncles"] = uncleHashes
 
-	return fields, nil
+	return block, nil
+}
+
+// ExportJSONN writes the canonical blocks first through last to w as newline
+// delimited JSON, in the RPC representation with full transaction details.
+// Blocks are encoded one at a time, so large ranges are streamed.
+func ExportJSONN(bc *core.BlockChain, w io.Writer, first, last uint64) error {
+	if first > last {
+		return fmt.Errorf("export failed: first (%d) is greater than last (%d)", first, last)
+	}
+	glog.V(logger.Info).Infof("exporting %d blocks as JSON...\n", last-first+1)
+
+	api := &PublicBlockChainAPI{config: bc.Config(), bc: bc}
+	enc := json.NewEncoder(w)
+	for nr := first; nr <= last; nr++ {
+		block := bc.GetBlockByNumber(nr)
+		if block == nil {
+			return fmt.Errorf("export failed on #%d: not found", nr)
+		}
+		out, err := api.rpcOutputBlock(block, true, true)
+		if err != nil {
+			return fmt.Errorf("export failed on #%d: %v", nr, err)
+		}
+		if err := enc.Encode(out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rpcOutputHeader converts the given header to the minimal RPC output used by the new heads subscription.
+func rpcOutputHeader(h *types.Header) map[string]interface{} {
+	return map[string]interface{}{
+		"number":     rpc.NewHexNumber(h.Number),
+		"hash":       h.Hash(),
+		"parentHash": h.ParentHash,
+		"timestamp":  rpc.NewHexNumber(h.Time),
+		"gasUsed":    rpc.NewHexNumber(h.GasUsed),
+		"difficulty": rpc.NewHexNumber(h.Difficulty),
+	}
 }
 
 // RPCTransaction represents a transaction that will serialize to the RPC representation of a transaction
@@ -948,42 +1555,57 @@ func newRPCPendingTransaction(tx *types.Transaction) *RPCTransaction {
 // newRPCTransaction returns a transaction that will serialize to the RPC representation.
 func newRPCTransactionFromBlockIndex(b *types.Block, txIndex int) (*RPCTransaction, error) {
 	if txIndex >= 0 && txIndex < len(b.Transactions()) {
-		tx := b.Transactions()[txIndex]
-		var signer types.Signer = types.BasicSigner{}
-		var protected bool
-		var chainId *big.Int
-		if tx.Protected() {
-			signer = types.NewChainIdSigner(tx.ChainId())
-			protected = true
-			chainId = tx.ChainId()
-		}
-		from, _ := types.Sender(signer, tx)
-
-		v, r, s := tx.RawSignatureValues()
-
-		return &RPCTransaction{
-			BlockHash:        b.Hash(),
-			BlockNumber:      rpc.NewHexNumber(b.Number()),
-			From:             from,
-			Gas:              rpc.NewHexNumber(tx.Gas()),
-			GasPrice:         rpc.NewHexNumber(tx.GasPrice()),
-			Hash:             tx.Hash(),
-			Input:            fmt.Sprintf("0x%x", tx.Data()),
-			Nonce:            rpc.NewHexNumber(tx.Nonce()),
-			To:               tx.To(),
-			TransactionIndex: rpc.NewHexNumber(txIndex),
-			Value:            rpc.NewHexNumber(tx.Value()),
-			ReplayProtected:  protected,
-			ChainId:          chainId,
-			V:                rpc.NewHexNumber(v),
-			R:                rpc.NewHexNumber(r),
-			S:                rpc.NewHexNumber(s),
-		}, nil
+		from := rpcTransactionSender(b.Transactions()[txIndex])
+		return newRPCTransactionWithSender(b, txIndex, from), nil
 	}
 
 	return nil, nil
 }
 
+// rpcTransactionSender recovers the sender of a transaction, using the chain id signer for
+// replay protected transactions. The zero address is returned if recovery fails.
+func rpcTransactionSender(tx *types.Transaction) common.Address {
+	var signer types.Signer = types.BasicSigner{}
+	if tx.Protected() {
+		signer = types.NewChainIdSigner(tx.ChainId())
+	}
+	from, _ := types.Sender(signer, tx)
+	return from
+}
+
+// newRPCTransactionWithSender returns the RPC representation of the transaction at the given
+// index of a block, with its sender already recovered.
+func newRPCTransactionWithSender(b *types.Block, txIndex int, from common.Address) *RPCTransaction {
+	tx := b.Transactions()[txIndex]
+	var protected bool
+	var chainId *big.Int
+	if tx.Protected() {
+		protected = true
+		chainId = tx.ChainId()
+	}
+
+	v, r, s := tx.RawSignatureValues()
+
+	return &RPCTransaction{
+		BlockHash:        b.Hash(),
+		BlockNumber:      rpc.NewHexNumber(b.Number()),
+		From:             from,
+		Gas:              rpc.NewHexNumber(tx.Gas()),
+		GasPrice:         rpc.NewHexNumber(tx.GasPrice()),
+		Hash:             tx.Hash(),
+		Input:            fmt.Sprintf("0x%x", tx.Data()),
+		Nonce:            rpc.NewHexNumber(tx.Nonce()),
+		To:               tx.To(),
+		TransactionIndex: rpc.NewHexNumber(txIndex),
+		Value:            rpc.NewHexNumber(tx.Value()),
+		ReplayProtected:  protected,
+		ChainId:          chainId,
+		V:                rpc.NewHexNumber(v),
+		R:                rpc.NewHexNumber(r),
+		S:                rpc.NewHexNumber(s),
+	}
+}
+
 // newRPCTransaction returns a transaction that will serialize to the RPC representation.
 func newRPCTransaction(b *types.Block, txHash common.Hash) (*RPCTransaction, error) {
 	for idx, tx := range b.Transactions() {
@@ -997,30 +1619,32 @@ func newRPCTransaction(b *types.Block, txHash common.Hash) (*RPCTransaction, err
 
 // PublicTransactionPoolAPI exposes methods for the RPC interface
 type PublicTransactionPoolAPI struct {
-	eventMux        *event.TypeMux
-	chainDb         ethdb.Database
-	gpo             *GasPriceOracle
-	bc              *core.BlockChain
-	miner           *miner.Miner
-	am              *accounts.Manager
-	txPool          *core.TxPool
-	txMu            *sync.Mutex
-	muPendingTxSubs sync.Mutex
-	pendingTxSubs   map[string]rpc.Subscription
+	eventMux         *event.TypeMux
+	chainDb          ethdb.Database
+	gpo              *GasPriceOracle
+	bc               *core.BlockChain
+	miner            *miner.Miner
+	am               *accounts.Manager
+	txPool           *core.TxPool
+	txMu             *sync.Mutex
+	muPendingTxSubs  sync.Mutex
+	pendingTxSubs    map[string]func(*types.Transaction) error // transactions sent from managed accounts
+	allPendingTxSubs map[string]rpc.Subscription               // all transactions, see NewPendingTransactionHashes
 }
 
 // NewPublicTransactionPoolAPI creates a new RPC service with methods specific for the transaction pool.
 func NewPublicTransactionPoolAPI(e *Ethereum) *PublicTransactionPoolAPI {
 	api := &PublicTransactionPoolAPI{
-		eventMux:      e.eventMux,
-		gpo:           e.gpo,
-		chainDb:       e.chainDb,
-		bc:            e.blockchain,
-		am:            e.accountManager,
-		txPool:        e.txPool,
-		txMu:          &e.txMu,
-		miner:         e.miner,
-		pendingTxSubs: make(map[string]rpc.Subscription),
+		eventMux:         e.eventMux,
+		gpo:              e.gpo,
+		chainDb:          e.chainDb,
+		bc:               e.blockchain,
+		am:               e.accountManager,
+		txPool:           e.txPool,
+		txMu:             &e.txMu,
+		miner:            e.miner,
+		pendingTxSubs:    make(map[string]func(*types.Transaction) error),
+		allPendingTxSubs: make(map[string]rpc.Subscription),
 	}
 	go api.subscriptionLoop()
 
@@ -1032,11 +1656,18 @@ func (s *PublicTransactionPoolAPI) subscriptionLoop() {
 	sub := s.eventMux.Subscribe(core.TxPreEvent{})
 	for event := range sub.Chan() {
 		tx := event.Data.(core.TxPreEvent)
+		s.muPendingTxSubs.Lock()
+		for id, sub := range s.allPendingTxSubs {
+			if sub.Notify(tx.Tx.Hash()) == rpc.ErrNotificationNotFound {
+				delete(s.allPendingTxSubs, id)
+			}
+		}
+		s.muPendingTxSubs.Unlock()
 		if from, err := tx.Tx.From(); err == nil {
 			if s.am.HasAddress(from) {
 				s.muPendingTxSubs.Lock()
-				for id, sub := range s.pendingTxSubs {
-					if sub.Notify(tx.Tx.Hash()) == rpc.ErrNotificationNotFound {
+				for id, notify := range s.pendingTxSubs {
+					if notify(tx.Tx) == rpc.ErrNotificationNotFound {
 						delete(s.pendingTxSubs, id)
 					}
 				}
@@ -1066,7 +1697,7 @@ func getTransaction(chainDb ethdb.Database, txPool *core.TxPool, txHash common.H
 
 // GetBlockTransactionCountByNumber returns the number of transactions in the block with the given block number.
 func (s *PublicTransactionPoolAPI) GetBlockTransactionCountByNumber(blockNr rpc.BlockNumber) *rpc.HexNumber {
-	if block := blockByNumber(s.miner, s.bc, blockNr); block != nil {
+	if block, _ := blockByNumberOrHash(s.miner, s.bc, rpc.BlockNumberOrHashWithNumber(blockNr)); block != nil {
 		return rpc.NewHexNumber(len(block.Transactions()))
 	}
 	return nil
@@ -1082,7 +1713,7 @@ func (s *PublicTransactionPoolAPI) GetBlockTransactionCountByHash(blockHash comm
 
 // GetTransactionByBlockNumberAndIndex returns the transaction for the given block number and index.
 func (s *PublicTransactionPoolAPI) GetTransactionByBlockNumberAndIndex(blockNr rpc.BlockNumber, index rpc.HexNumber) (*RPCTransaction, error) {
-	if block := blockByNumber(s.miner, s.bc, blockNr); block != nil {
+	if block, _ := blockByNumberOrHash(s.miner, s.bc, rpc.BlockNumberOrHashWithNumber(blockNr)); block != nil {
 		return newRPCTransactionFromBlockIndex(block, index.Int())
 	}
 	return nil, nil
@@ -1098,7 +1729,7 @@ func (s *PublicTransactionPoolAPI) GetTransactionByBlockHashAndIndex(blockHash c
 
 // GetTransactionCount returns the number of transactions the given address has sent for the given block number
 func (s *PublicTransactionPoolAPI) GetTransactionCount(address common.Address, blockNr rpc.BlockNumber) (*rpc.HexNumber, error) {
-	state, _, err := stateAndBlockByNumber(s.miner, s.bc, blockNr, s.chainDb)
+	state, _, err := stateAndBlockByNumber(s.miner, s.bc, blockNr)
 	if state == nil || err != nil {
 		return nil, err
 	}
@@ -1157,8 +1788,35 @@ func (s *PublicTransactionPoolAPI) GetTransactionByHash(txHash common.Hash) (*RP
 	return nil, nil
 }
 
+// GetRawTransactionByHash returns the RLP encoding of the transaction for the given hash, as found
+// in the chain database or the transaction pool.
+func (s *PublicTransactionPoolAPI) GetRawTransactionByHash(txHash common.Hash) (hexutil.Bytes, error) {
+	tx, _, err := getTransaction(s.chainDb, s.txPool, txHash)
+	if err != nil {
+		glog.V(logger.Debug).Infof("%v\n", err)
+		return nil, nil
+	} else if tx == nil {
+		return nil, nil
+	}
+	return rlp.EncodeToBytes(tx)
+}
+
+// GetRawTransactionByBlockNumberAndIndex returns the RLP encoding of the transaction for the given block number and index.
+func (s *PublicTransactionPoolAPI) GetRawTransactionByBlockNumberAndIndex(blockNr rpc.BlockNumber, index rpc.HexNumber) (hexutil.Bytes, error) {
+	if block, _ := blockByNumberOrHash(s.miner, s.bc, rpc.BlockNumberOrHashWithNumber(blockNr)); block != nil {
+		txs := block.Transactions()
+		if index.Int() < 0 || index.Int() >= len(txs) {
+			return nil, nil
+		}
+		return rlp.EncodeToBytes(txs[index.Int()])
+	}
+	return nil, nil
+}
+
 // GetTransactionReceipt returns the transaction receipt for the given transaction hash.
-func (s *PublicTransactionPoolAPI) GetTransactionReceipt(txHash common.Hash) (map[string]interface{}, error) {
+// If minConfirmations is given, nil is returned until the including block has at least
+// that many confirmations, counting the including block itself as the first.
+func (s *PublicTransactionPoolAPI) GetTransactionReceipt(txHash common.Hash, minConfirmations *rpc.HexNumber) (map[string]interface{}, error) {
 	receipt := core.GetReceipt(s.chainDb, txHash)
 	if receipt == nil {
 		glog.V(logger.Debug).Infof("receipt not found for transaction %s", txHash.Hex())
@@ -1174,34 +1832,125 @@ func (s *PublicTransactionPoolAPI) GetTransactionReceipt(txHash common.Hash) (ma
 	if err != nil {
 		return nil, err
 	}
+	if minConfirmations != nil && confirmations(s.bc.CurrentBlock().NumberU64(), blockIndex) < minConfirmations.Uint64() {
+		return nil, nil
+	}
 
 	if receipt.Status == types.TxStatusUnknown {
-		// To be able to get the proper state for n-th transaction in a block,
-		// all previous transactions has to be executed. Because of that, it is
-		// reasonable to reprocess entire block and update all receipts from
-		// given block.
-		proc := s.bc.Processor()
-		block := s.bc.GetBlock(txBlock)
-		parent := s.bc.GetBlock(block.ParentHash())
-		statedb, err := s.bc.StateAt(parent.Root())
-		if err != nil {
-			return nil, fmt.Errorf("state not found - transaction status is not available for fast synced block: %v", err)
-		}
-
-		receipts, _, _, err := proc.Process(block, statedb)
+		receipts, err := s.reprocessReceipts(s.bc.GetBlock(txBlock))
 		if err != nil {
 			return nil, err
 		}
+		receipt = receipts[index]
+	}
+
+	return rpcOutputReceipt(tx, txBlock, blockIndex, index, receipt), nil
+}
+
+// Transaction statuses reported by GetTransactionStatus.
+const (
+	txStatusPending  = "pending"
+	txStatusIncluded = "included"
+	txStatusUnknown  = "unknown"
+)
 
-		if err := core.WriteReceipts(s.chainDb, receipts); err != nil {
-			glog.V(logger.Warn).Infof("cannot save updated receipts: %v", err)
+// TransactionStatus is the inclusion status of a transaction. The block fields are only
+// set for transactions included in the canonical chain.
+type TransactionStatus struct {
+	Status           string         `json:"status"`
+	BlockHash        *common.Hash   `json:"blockHash"`
+	BlockNumber      *rpc.HexNumber `json:"blockNumber"`
+	TransactionIndex *rpc.HexNumber `json:"transactionIndex"`
+	Confirmations    *rpc.HexNumber `json:"confirmations"`
+}
+
+// GetTransactionStatus returns whether the transaction with the given hash is included in
+// the canonical chain, waiting in the transaction pool ("pending"), or not known ("unknown").
+// It replaces polling both eth_getTransactionByHash and eth_getTransactionReceipt.
+func (s *PublicTransactionPoolAPI) GetTransactionStatus(txHash common.Hash) *TransactionStatus {
+	blockHash, number, index, err := getTransactionBlockData(s.chainDb, txHash)
+	if err == nil && s.bc.GetCanonicalHash(number) == blockHash {
+		return &TransactionStatus{
+			Status:           txStatusIncluded,
+			BlockHash:        &blockHash,
+			BlockNumber:      rpc.NewHexNumber(number),
+			TransactionIndex: rpc.NewHexNumber(index),
+			Confirmations:    rpc.NewHexNumber(confirmations(s.bc.CurrentBlock().NumberU64(), number)),
 		}
-		if err := core.WriteBlockReceipts(s.chainDb, block.Hash(), receipts); err != nil {
-			glog.V(logger.Warn).Infof("cannot save updated block receipts: %v", err)
+	}
+	if s.txPool.GetTransaction(txHash) != nil {
+		return &TransactionStatus{Status: txStatusPending}
+	}
+	return &TransactionStatus{Status: txStatusUnknown}
+}
+
+// confirmations returns the number of confirmations of the block with the given number,
+// which is 1 for the head block and 0 for blocks above the head.
+func confirmations(head, number uint64) uint64 {
+	if number > head {
+		return 0
+	}
+	return head - number + 1
+}
+
+// GetBlockReceipts returns the receipts of all transactions in the block with the given number or hash.
+func (s *PublicTransactionPoolAPI) GetBlockReceipts(blockNrOrHash rpc.BlockNumberOrHash) ([]map[string]interface{}, error) {
+	block, err := blockByNumberOrHash(s.miner, s.bc, blockNrOrHash)
+	if block == nil || err != nil {
+		return nil, err
+	}
+	receipts := s.bc.GetReceiptsByBlockHash(block.Hash())
+	if receipts == nil {
+		glog.V(logger.Debug).Infof("receipts not found for block %s", block.Hash().Hex())
+		return nil, nil
+	}
+	for _, receipt := range receipts {
+		if receipt.Status == types.TxStatusUnknown {
+			if receipts, err = s.reprocessReceipts(block); err != nil {
+				return nil, err
+			}
+			break
 		}
-		receipt = receipts[index]
 	}
 
+	txs := block.Transactions()
+	fields := make([]map[string]interface{}, len(receipts))
+	for i, receipt := range receipts {
+		fields[i] = rpcOutputReceipt(txs[i], block.Hash(), block.NumberU64(), uint64(i), receipt)
+	}
+	return fields, nil
+}
+
+// reprocessReceipts re-executes the given block to recover receipt fields which
+// were not stored, such as the transaction status, and saves the updated receipts.
+func (s *PublicTransactionPoolAPI) reprocessReceipts(block *types.Block) (types.Receipts, error) {
+	// To be able to get the proper state for n-th transaction in a block,
+	// all previous transactions has to be executed. Because of that, it is
+	// reasonable to reprocess entire block and update all receipts from
+	// given block.
+	proc := s.bc.Processor()
+	parent := s.bc.GetBlock(block.ParentHash())
+	statedb, err := s.bc.StateAt(parent.Root())
+	if err != nil {
+		return nil, fmt.Errorf("state not found - transaction status is not available for fast synced block: %v", err)
+	}
+
+	receipts, _, _, err := proc.Process(block, statedb)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := core.WriteReceipts(s.chainDb, receipts); err != nil {
+		glog.V(logger.Warn).Infof("cannot save updated receipts: %v", err)
+	}
+	if err := core.WriteBlockReceipts(s.chainDb, block.Hash(), receipts); err != nil {
+		glog.V(logger.Warn).Infof("cannot save updated block receipts: %v", err)
+	}
+	return receipts, nil
+}
+
+// rpcOutputReceipt converts the receipt of the transaction at the given position in a block into the RPC output format.
+func rpcOutputReceipt(tx *types.Transaction, blockHash common.Hash, blockNumber, index uint64, receipt *types.Receipt) map[string]interface{} {
 	var signer types.Signer = types.BasicSigner{}
 	if tx.Protected() {
 		signer = types.NewChainIdSigner(tx.ChainId())
@@ -1210,9 +1959,9 @@ func (s *PublicTransactionPoolAPI) GetTransactionReceipt(txHash common.Hash) (ma
 
 	fields := map[string]interface{}{
 		"root":              common.Bytes2Hex(receipt.PostState),
-		"blockHash":         txBlock,
-		"blockNumber":       rpc.NewHexNumber(blockIndex),
-		"transactionHash":   txHash,
+		"blockHash":         blockHash,
+		"blockNumber":       rpc.NewHexNumber(blockNumber),
+		"transactionHash":   tx.Hash(),
 		"transactionIndex":  rpc.NewHexNumber(index),
 		"from":              from,
 		"to":                tx.To(),
@@ -1237,7 +1986,7 @@ func (s *PublicTransactionPoolAPI) GetTransactionReceipt(txHash common.Hash) (ma
 		fields["status"] = rpc.NewHexNumber(receipt.Status)
 	}
 
-	return fields, nil
+	return fields
 }
 
 // sign is a helper function that signs a transaction with the private key of the given address.
@@ -1260,6 +2009,7 @@ type SendTxArgs struct {
 	Value    *rpc.HexNumber  `json:"value"`
 	Data     string          `json:"data"`
 	Nonce    *rpc.HexNumber  `json:"nonce"`
+	ChainID  *rpc.HexNumber  `json:"chainId"`
 }
 
 // prepareSendTxArgs is a helper function that fills in default values for unspecified tx fields.
@@ -1276,10 +2026,29 @@ func prepareSendTxArgs(args SendTxArgs, gpo *GasPriceOracle) SendTxArgs {
 	return args
 }
 
-// submitTransaction is a helper function that submits tx to txPool and creates a log entry.
-func submitTransaction(bc *core.BlockChain, txPool *core.TxPool, tx *types.Transaction, signature []byte) (common.Hash, error) {
-	signer := bc.Config().GetSigner(bc.CurrentBlock().Number())
+// sendTxSigner returns the signer to use for a transaction sent with the given args.
+// If args.ChainID is set, an EIP-155 signer for that chain id is used instead of the one
+// derived from the chain configuration, eg. to protect transactions before the EIP-155 fork.
+// The transaction pool recovers senders with the configured EIP-155 chain id, 0 if none is
+// configured, and drops a protected transaction with any other chain id as having an invalid
+// sender. Such a chain id is rejected here, before anything is signed.
+func sendTxSigner(bc *core.BlockChain, args SendTxArgs) (types.Signer, error) {
+	if args.ChainID == nil {
+		return bc.Config().GetSigner(bc.CurrentBlock().Number()), nil
+	}
+	chainId := args.ChainID.BigInt()
+	if chainId.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid chainId: %v", chainId)
+	}
+	if configured := bc.Config().GetChainID(); configured.Cmp(chainId) != 0 {
+		return nil, fmt.Errorf("chainId %v differs from the configured chain id %v, the transaction pool would not recover the sender", chainId, configured)
+	}
+	return types.NewChainIdSigner(chainId), nil
+}
 
+// submitTransaction is a helper function that signs tx with the given signer and signature,
+// submits it to txPool and creates a log entry.
+func submitTransaction(txPool *core.TxPool, tx *types.Transaction, signer types.Signer, signature []byte) (common.Hash, error) {
 	signedTx, err := tx.WithSigner(signer).WithSignature(signature)
 	if err != nil {
 		return common.Hash{}, err
@@ -1320,7 +2089,10 @@ func (s *PublicTransactionPoolAPI) SendTransaction(args SendTxArgs) (common.Hash
 		tx = types.NewTransaction(args.Nonce.Uint64(), *args.To, args.Value.BigInt(), args.Gas.BigInt(), args.GasPrice.BigInt(), common.FromHex(args.Data))
 	}
 
-	signer := s.bc.Config().GetSigner(s.bc.CurrentBlock().Number())
+	signer, err := sendTxSigner(s.bc, args)
+	if err != nil {
+		return common.Hash{}, err
+	}
 	tx.SetSigner(signer)
 
 	signature, err := s.am.Sign(args.From, signer.Hash(tx).Bytes())
@@ -1328,7 +2100,7 @@ func (s *PublicTransactionPoolAPI) SendTransaction(args SendTxArgs) (common.Hash
 		return common.Hash{}, err
 	}
 
-	return submitTransaction(s.bc, s.txPool, tx, signature)
+	return submitTransaction(s.txPool, tx, signer, signature)
 }
 
 // SendRawTransaction will add the signed transaction to the transaction pool.
@@ -1571,9 +2343,16 @@ func (s *PublicTransactionPoolAPI) PendingTransactions() []*RPCTransaction {
 	return transactions
 }
 
+// NewPendingTransactionsArgs allows the user to specify if the pending transaction notifications carry the
+// full transaction instead of its hash.
+type NewPendingTransactionsArgs struct {
+	TransactionDetails bool `json:"transactionDetails"`
+}
+
 // NewPendingTransactions creates a subscription that is triggered each time a transaction enters the transaction pool
-// and is send from one of the transactions this nodes manages.
-func (s *PublicTransactionPoolAPI) NewPendingTransactions(ctx context.Context) (rpc.Subscription, error) {
+// and is send from one of the transactions this nodes manages. It notifies the transaction hash, or the full
+// transaction if args.TransactionDetails is set.
+func (s *PublicTransactionPoolAPI) NewPendingTransactions(ctx context.Context, args *NewPendingTransactionsArgs) (rpc.Subscription, error) {
 	notifier, supported := rpc.NotifierFromContext(ctx)
 	if !supported {
 		return nil, rpc.ErrNotificationsUnsupported
@@ -1589,8 +2368,40 @@ func (s *PublicTransactionPoolAPI) NewPendingTransactions(ctx context.Context) (
 		return nil, err
 	}
 
+	details := args != nil && args.TransactionDetails
 	s.muPendingTxSubs.Lock()
-	s.pendingTxSubs[subscription.ID()] = subscription
+	s.pendingTxSubs[subscription.ID()] = func(tx *types.Transaction) error {
+		if details {
+			return subscription.Notify(newRPCPendingTransaction(tx))
+		}
+		return subscription.Notify(tx.Hash())
+	}
+	s.muPendingTxSubs.Unlock()
+
+	return subscription, nil
+}
+
+// NewPendingTransactionHashes creates a subscription that is triggered with the hash of each
+// transaction entering the transaction pool, whoever sent it. Unlike NewPendingTransactions it
+// is not limited to the accounts this node manages, so on a well connected node it delivers
+// every transaction propagated on the network, many per second.
+func (s *PublicTransactionPoolAPI) NewPendingTransactionHashes(ctx context.Context) (rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+
+	subscription, err := notifier.NewSubscription(func(id string) {
+		s.muPendingTxSubs.Lock()
+		delete(s.allPendingTxSubs, id)
+		s.muPendingTxSubs.Unlock()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.muPendingTxSubs.Lock()
+	s.allPendingTxSubs[subscription.ID()] = subscription
 	s.muPendingTxSubs.Unlock()
 
 	return subscription, nil
@@ -1660,7 +2471,8 @@ func (api *PrivateAdminAPI) SetSolc(path string) (string, error) {
 	return solc.Info(), nil
 }
 
-// ExportChain exports the current blockchain into a local file.
+// ExportChain exports the current blockchain into a local file. The export is
+// gzip compressed if the file name ends in ".gz".
 func (api *PrivateAdminAPI) ExportChain(file string) (bool, error) {
 	// Make sure we can create the file to export into
 	out, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.ModePerm)
@@ -1670,13 +2482,24 @@ func (api *PrivateAdminAPI) ExportChain(file string) (bool, error) {
 	defer out.Close()
 
 	// Export the blockchain
-	if err := api.eth.BlockChain().Export(out); err != nil {
+	if strings.HasSuffix(file, ".gz") {
+		err = api.eth.BlockChain().ExportGzip(out)
+	} else {
+		err = api.eth.BlockChain().Export(out)
+	}
+	if err != nil {
 		return false, err
 	}
 	return true, nil
 }
 
+// hasAllBlocks reports whether an import batch is already present. Batches on the
+// canonical chain below the current block need only a lookup of their last block.
 func hasAllBlocks(chain *core.BlockChain, bs []*types.Block) bool {
+	last := bs[len(bs)-1]
+	if chain.HasCanonicalBlock(last.Hash(), last.NumberU64()) {
+		return true
+	}
 	for _, b := range bs {
 		if !chain.HasBlock(b.Hash()) {
 			return false
@@ -1686,8 +2509,20 @@ func hasAllBlocks(chain *core.BlockChain, bs []*types.Block) bool {
 	return true
 }
 
-// ImportChain imports a blockchain from a local file.
-func (api *PrivateAdminAPI) ImportChain(file string) (bool, error) {
+// DefaultImportBatchSize is the number of blocks ImportChain inserts at once,
+// unless the caller gives its own batch size.
+const DefaultImportBatchSize = 2500
+
+// ImportChain imports a blockchain from a local file, inserting batchSize blocks
+// at a time. A missing or zero batch size means DefaultImportBatchSize.
+func (api *PrivateAdminAPI) ImportChain(file string, batchSize *int) (bool, error) {
+	size := DefaultImportBatchSize
+	if batchSize != nil && *batchSize != 0 {
+		if *batchSize < 0 {
+			return false, fmt.Errorf("invalid batch size %d, must be positive", *batchSize)
+		}
+		size = *batchSize
+	}
 	// Make sure the can access the file to import
 	in, err := os.Open(file)
 	if err != nil {
@@ -1695,10 +2530,15 @@ func (api *PrivateAdminAPI) ImportChain(file string) (bool, error) {
 	}
 	defer in.Close()
 
+	// Decompress gzip exports transparently
+	r, err := core.NewChainReader(in)
+	if err != nil {
+		return false, err
+	}
 	// Run actual the import in pre-configured batches
-	stream := rlp.NewStream(in, 0)
+	stream := rlp.NewStream(r, 0)
 
-	blocks, index := make([]*types.Block, 0, 2500), 0
+	blocks, index := make([]*types.Block, 0, size), 0
 	for batch := 0; ; batch++ {
 		// Load a batch of blocks from the input file
 		for len(blocks) < cap(blocks) {
@@ -1728,6 +2568,176 @@ func (api *PrivateAdminAPI) ImportChain(file string) (bool, error) {
 	return true, nil
 }
 
+// SetSyncMode sets the mode, either "full" or "fast", used for subsequent
+// synchronisation cycles.
+func (api *PrivateAdminAPI) SetSyncMode(mode string) (bool, error) {
+	var m downloader.SyncMode
+	switch strings.ToLower(mode) {
+	case "full":
+		m = downloader.FullSync
+	case "fast":
+		m = downloader.FastSync
+	default:
+		return false, fmt.Errorf("unknown sync mode %q, want \"full\" or \"fast\"", mode)
+	}
+	if err := api.eth.protocolManager.SetDefaultSyncMode(m); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PruneSideChains deletes the stored bodies of non-canonical blocks numbered below
+// the given block number and returns the number of bodies deleted.
+func (api *PrivateAdminAPI) PruneSideChains(belowNumber uint64) (int, error) {
+	return api.eth.BlockChain().PruneSideChains(belowNumber)
+}
+
+// DownloaderPeerStats holds the downloader's current quality of service estimates
+// alongside the download statistics of each connected peer.
+type DownloaderPeerStats struct {
+	RTT        time.Duration           `json:"rtt"`
+	TTL        time.Duration           `json:"ttl"`
+	Confidence float64                 `json:"confidence"`
+	Peers      []*downloader.PeerStats `json:"peers"`
+}
+
+// DownloaderPeerStats returns the downloader's measured header, body and receipt capacities and
+// idle state for each connected peer, along with the current round trip time, request timeout
+// and confidence estimates. This helps identify slow peers holding back a sync.
+func (api *PrivateAdminAPI) DownloaderPeerStats() *DownloaderPeerStats {
+	d := api.eth.Downloader()
+	rtt, ttl, conf := d.Qos()
+	return &DownloaderPeerStats{
+		RTT:        rtt,
+		TTL:        ttl,
+		Confidence: conf,
+		Peers:      d.PeerStats(),
+	}
+}
+
+// PeerTableEntry describes a peer connected over the Ethereum sub-protocol.
+type PeerTableEntry struct {
+	ID            string      `json:"id"`
+	Name          string      `json:"name"`
+	RemoteAddress string      `json:"remoteAddress"`
+	Version       int         `json:"version"`    // Ethereum protocol version negotiated
+	Head          common.Hash `json:"head"`       // head block hash reported by the peer, as used by the downloader
+	Difficulty    *big.Int    `json:"difficulty"` // total difficulty reported by the peer, as used by the downloader
+	Downloader    bool        `json:"downloader"` // whether the peer is registered with the downloader to sync from
+}
+
+// PeerTable returns the id, name, remote address, negotiated protocol version and
+// reported head of every peer connected over the Ethereum sub-protocol, ordered by id.
+// Peers not registered with the downloader, such as those on protocols older than
+// eth/62, are never synced from.
+func (api *PrivateAdminAPI) PeerTable() []*PeerTableEntry {
+	return peerTable(api.eth.protocolManager)
+}
+
+func peerTable(pm *ProtocolManager) []*PeerTableEntry {
+	syncing := make(map[string]bool)
+	for _, stats := range pm.downloader.PeerStats() {
+		syncing[stats.ID] = true
+	}
+	peers := pm.peers.Peers()
+	table := make([]*PeerTableEntry, len(peers))
+	for i, p := range peers {
+		head, td := p.Head()
+		table[i] = &PeerTableEntry{
+			ID:            p.id,
+			Name:          p.Name(),
+			RemoteAddress: p.RemoteAddr().String(),
+			Version:       p.version,
+			Head:          head,
+			Difficulty:    td,
+			Downloader:    syncing[p.id],
+		}
+	}
+	sort.Slice(table, func(i, j int) bool { return table[i].ID < table[j].ID })
+	return table
+}
+
+// DefaultHealthMaxBlockAge is the age of the head block above which Health reports the
+// node as unhealthy, unless the caller gives its own limit.
+const DefaultHealthMaxBlockAge = 5 * time.Minute
+
+// PublicHealthAPI provides the node health check over the public net endpoint, which
+// is offered over HTTP by default, so load balancers need no administrative APIs.
+type PublicHealthAPI struct {
+	eth *Ethereum
+}
+
+// NewPublicHealthAPI creates a new API definition for the node health check of the
+// Ethereum service.
+func NewPublicHealthAPI(eth *Ethereum) *PublicHealthAPI {
+	return &PublicHealthAPI{eth: eth}
+}
+
+// NodeHealth reports whether the node is synced and connected, see PublicHealthAPI.Health.
+type NodeHealth struct {
+	Healthy      bool     `json:"healthy"`
+	P2PRunning   bool     `json:"p2pRunning"` // the p2p server of the Ethereum service is running
+	Syncing      bool     `json:"syncing"`
+	CurrentBlock uint64   `json:"currentBlock"`
+	HighestBlock uint64   `json:"highestBlock"`
+	Peers        int      `json:"peers"`
+	BlockAge     uint64   `json:"blockAge"` // seconds since the head block's timestamp
+	Problems     []string `json:"problems,omitempty"`
+}
+
+// Health reports whether the node is fully synced and connected, so that load balancers can
+// use a single call to decide whether to route requests to it. The node is healthy if its
+// p2p server is running, it is not syncing, has at least one peer, and its head block is no
+// older than maxBlockAge seconds (default DefaultHealthMaxBlockAge). Problems lists the
+// reasons an unhealthy node fails.
+func (api *PublicHealthAPI) Health(maxBlockAge *uint64) *NodeHealth {
+	maxAge := DefaultHealthMaxBlockAge
+	if maxBlockAge != nil {
+		maxAge = time.Duration(*maxBlockAge) * time.Second
+	}
+	_, current, highest, _, _ := api.eth.Downloader().Progress()
+
+	var (
+		running bool
+		peers   int
+	)
+	if net := api.eth.netRPCService; net != nil && net.net.Running() {
+		running = true
+		peers = net.net.PeerCount()
+	}
+	head := time.Unix(api.eth.BlockChain().CurrentBlock().Time().Int64(), 0)
+	return nodeHealth(running, current, highest, peers, time.Since(head), maxAge)
+}
+
+// nodeHealth evaluates the health checks of PublicHealthAPI.Health.
+func nodeHealth(p2pRunning bool, current, highest uint64, peers int, blockAge, maxBlockAge time.Duration) *NodeHealth {
+	if blockAge < 0 {
+		blockAge = 0
+	}
+	h := &NodeHealth{
+		P2PRunning:   p2pRunning,
+		Syncing:      current < highest,
+		CurrentBlock: current,
+		HighestBlock: highest,
+		Peers:        peers,
+		BlockAge:     uint64(blockAge / time.Second),
+	}
+	if !h.P2PRunning {
+		h.Problems = append(h.Problems, "p2p server not running")
+	}
+	if h.Syncing {
+		h.Problems = append(h.Problems, fmt.Sprintf("syncing: at block #%d of #%d", current, highest))
+	}
+	if peers == 0 {
+		h.Problems = append(h.Problems, "no peers")
+	}
+	if blockAge > maxBlockAge {
+		h.Problems = append(h.Problems, fmt.Sprintf("head block is %v old, more than %v", blockAge/time.Second*time.Second, maxBlockAge))
+	}
+	h.Healthy = len(h.Problems) == 0
+	return h
+}
+
 // PublicDebugAPI is the collection of Etheruem APIs exposed over the public
 // debugging endpoint.
 type PublicGethAPI struct {
@@ -1784,6 +2794,53 @@ func (api *PublicGethAPI) GetAddressTransactions(address common.Address, blockSt
 	return list, nil
 }
 
+// AddressTransaction is an indexed transaction of an address.
+type AddressTransaction struct {
+	Hash        common.Hash    `json:"hash"`
+	BlockNumber *rpc.HexNumber `json:"blockNumber"`
+}
+
+// AddressTransactionsPage is a page of indexed transactions of an address.
+// NextOffset is the offset of the following page, or nil if there are no more transactions.
+type AddressTransactionsPage struct {
+	Transactions []*AddressTransaction `json:"transactions"`
+	NextOffset   *int                  `json:"nextOffset"`
+}
+
+// GetAddressTransactionsPage gets a page of transactions for a given address, in index order, along with
+// their block numbers. toOrFrom filters by the tx/address relation (to, from, or both).
+// At most core.MaxAddrTxsPageSize transactions are returned; a non-positive limit returns that many.
+func (api *PublicGethAPI) GetAddressTransactionsPage(address common.Address, fromBlock, toBlock rpc.BlockNumber, offset, limit int, toOrFrom string) (*AddressTransactionsPage, error) {
+	glog.V(logger.Debug).Infof("RPC call: geth_getAddressTransactionsPage %s %d %d %d %d %s", address, fromBlock, toBlock, offset, limit, toOrFrom)
+
+	atxi := api.eth.BlockChain().GetAtxi()
+	if atxi == nil {
+		return nil, errors.New("addr-tx indexing not enabled")
+	}
+	if toOrFrom == "tf" || toOrFrom == "ft" {
+		toOrFrom = "b"
+	}
+	if fromBlock < 0 {
+		fromBlock = 0
+	}
+	if toBlock == rpc.LatestBlockNumber || toBlock == rpc.PendingBlockNumber {
+		toBlock = 0
+	}
+
+	txs, next, err := core.GetAddrTxsPage(atxi.Db, address, uint64(fromBlock), uint64(toBlock), toOrFrom, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	page := &AddressTransactionsPage{Transactions: make([]*AddressTransaction, len(txs))}
+	for i, tx := range txs {
+		page.Transactions[i] = &AddressTransaction{Hash: tx.Hash, BlockNumber: rpc.NewHexNumber(tx.BlockNumber)}
+	}
+	if next >= 0 {
+		page.NextOffset = &next
+	}
+	return page, nil
+}
+
 func (api *PublicGethAPI) BuildATXI(start, stop, step rpc.BlockNumber) (bool, error) {
 	glog.V(logger.Debug).Infof("RPC call: geth_buildATXI %v %v %v", start, stop, step)
 
@@ -1888,6 +2945,62 @@ func (api *PublicDebugAPI) GetBlockRlp(number uint64) (string, error) {
 	return fmt.Sprintf("%x", encoded), nil
 }
 
+// BadBlockArgs represents a block hash this node considers bad.
+type BadBlockArgs struct {
+	Hash   common.Hash    `json:"hash"`
+	Number *rpc.HexNumber `json:"number"`
+	Reason string         `json:"reason"`
+}
+
+// BadBlocks returns the bad block hashes configured for the chain, followed by the
+// most recent blocks this node rejected during block import since it was started.
+func (api *PublicDebugAPI) BadBlocks() []*BadBlockArgs {
+	var bad []*BadBlockArgs
+	for _, b := range api.eth.chainConfig.BadHashes {
+		bad = append(bad, &BadBlockArgs{
+			Hash:   b.Hash,
+			Number: rpc.NewHexNumber(b.Block),
+			Reason: "configured bad hash",
+		})
+	}
+	for _, b := range api.eth.BlockChain().BadBlocks() {
+		bad = append(bad, &BadBlockArgs{
+			Hash:   b.Header.Hash(),
+			Number: rpc.NewHexNumber(b.Header.Number),
+			Reason: b.Reason.Error(),
+		})
+	}
+	return bad
+}
+
+// TotalGasUsedInRange returns the sum of the gas used by the canonical blocks
+// from first to last, inclusive. The range may span at most core.MaxGasUsedRange blocks.
+func (api *PublicDebugAPI) TotalGasUsedInRange(first, last uint64) (*big.Int, error) {
+	return api.eth.BlockChain().TotalGasUsedInRange(first, last)
+}
+
+// GetHeaderRlp retrieves the RLP encoded form of a single block header.
+func (api *PublicDebugAPI) GetHeaderRlp(number uint64) (string, error) {
+	header := api.eth.BlockChain().GetHeaderByNumber(number)
+	if header == nil {
+		return "", fmt.Errorf("header #%d not found", number)
+	}
+	encoded, err := rlp.EncodeToBytes(header)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", encoded), nil
+}
+
+// Preimage returns the SHA3 preimage of the given hash, if it has been recorded.
+// Preimages of values hashed by contracts are only recorded with the --preimages flag.
+func (api *PublicDebugAPI) Preimage(hash common.Hash) (hexutil.Bytes, error) {
+	if preimage := core.GetPreimage(api.eth.ChainDb(), hash); preimage != nil {
+		return preimage, nil
+	}
+	return nil, errors.New("unknown preimage")
+}
+
 // PrintBlock retrieves a block and returns its pretty printed form.
 func (api *PublicDebugAPI) PrintBlock(number uint64) (string, error) {
 	block := api.eth.BlockChain().GetBlockByNumber(number)
@@ -1968,6 +3081,12 @@ func (api *PublicDebugAPI) Metrics(raw bool) (map[string]interface{}, error) {
 						"mean.rate": hmr,
 						"count":     fmt.Sprintf("%v", m["count"]),
 					}
+					// Timers also record durations, in nanoseconds.
+					if _, ok := m["mean"]; ok {
+						for _, q := range []string{"mean", "95%", "99%"} {
+							rout[k].(map[string]interface{})[q] = time.Duration(m[q].(float64)).String()
+						}
+					}
 				} else if _, ok := m["value"]; ok {
 					rout[k] = map[string]interface{}{
 						"value": fmt.Sprintf("%v", m["value"]),
@@ -2032,9 +3151,9 @@ type ExecutionResult struct {
 }
 
 // TraceCall executes a call and returns the amount of gas and optionally returned values.
-func (s *PublicBlockChainAPI) TraceCall(args CallArgs, blockNr rpc.BlockNumber) (*ExecutionResult, error) {
+func (s *PublicBlockChainAPI) TraceCall(ctx context.Context, args CallArgs, blockNr rpc.BlockNumber) (*ExecutionResult, error) {
 	// Fetch the state associated with the block number
-	stateDb, block, err := stateAndBlockByNumber(s.miner, s.bc, blockNr, s.chainDb)
+	stateDb, block, err := stateAndBlockByNumber(s.miner, s.bc, blockNr)
 	if stateDb == nil || err != nil {
 		return nil, err
 	}
@@ -2063,9 +3182,13 @@ func (s *PublicBlockChainAPI) TraceCall(args CallArgs, blockNr rpc.BlockNumber)
 		value:    args.Value.BigInt(),
 		data:     common.FromHex(args.Data),
 	}
-	if msg.gas.Sign() == 0 {
+	requested := msg.gas.Sign() != 0
+	if !requested {
 		msg.gas = big.NewInt(50000000)
 	}
+	if msg.gas, err = s.capGas(msg.gas, requested); err != nil {
+		return nil, err
+	}
 	if msg.gasPrice.Sign() == 0 {
 		msg.gasPrice = new(big.Int).Mul(big.NewInt(50), common.Shannon)
 	}
@@ -2074,7 +3197,13 @@ func (s *PublicBlockChainAPI) TraceCall(args CallArgs, blockNr rpc.BlockNumber)
 	vmenv := core.NewEnv(stateDb, s.config, s.bc, msg, block.Header())
 	gp := new(core.GasPool).AddGas(common.MaxBig)
 
+	ctx, cancel := abortOnDone(ctx, vmenv, s.callTimeout)
 	ret, gas, _, err := core.ApplyMessage(vmenv, msg, gp)
+	aborted := vmenv.Cancelled()
+	cancel()
+	if aborted {
+		return nil, fmt.Errorf("execution aborted: %v", ctx.Err())
+	}
 	return &ExecutionResult{
 		Gas:         gas,
 		ReturnValue: fmt.Sprintf("%x", ret),
@@ -2102,6 +3231,123 @@ func (s *PublicDebugAPI) TraceTransaction(txHash common.Hash) (*ExecutionResult,
 	}, nil
 }
 
+// TraceBlockByNumber replays all transactions of the canonical block with the given number
+// on the state of its parent and returns the amount of gas used and execution result of
+// each, in block order. Every transaction is executed once, on the state left by the ones
+// before it, whereas tracing them one by one replays all preceding transactions each time.
+func (s *PublicDebugAPI) TraceBlockByNumber(number uint64) ([]*ExecutionResult, error) {
+	block := s.eth.BlockChain().GetBlockByNumber(number)
+	if block == nil {
+		return nil, fmt.Errorf("block #%d not found", number)
+	}
+	return traceBlock(s.eth.chainConfig, s.eth.BlockChain(), block)
+}
+
+// traceBlock executes the transactions of the given block in order on the state of its parent.
+func traceBlock(config *core.ChainConfig, bc *core.BlockChain, block *types.Block) ([]*ExecutionResult, error) {
+	parent := bc.GetBlock(block.ParentHash())
+	if parent == nil {
+		return nil, fmt.Errorf("block parent %x not found", block.ParentHash())
+	}
+	statedb, err := bc.StateAt(parent.Root())
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*ExecutionResult, len(block.Transactions()))
+	for i, tx := range block.Transactions() {
+		msg, err := txMessage(statedb, tx)
+		if err != nil {
+			return nil, err
+		}
+		vmenv := core.NewEnv(statedb, config, bc, msg, block.Header())
+		gp := new(core.GasPool).AddGas(tx.Gas())
+		ret, gas, _, err := core.ApplyMessage(vmenv, msg, gp)
+		if err != nil {
+			return nil, fmt.Errorf("tx %x failed: %v", tx.Hash(), err)
+		}
+		statedb.DeleteSuicides()
+		results[i] = &ExecutionResult{
+			Gas:         gas,
+			ReturnValue: fmt.Sprintf("%x", ret),
+		}
+	}
+	return results, nil
+}
+
+// txMessage assembles the call message of a transaction executed on the given state.
+func txMessage(statedb *state.StateDB, tx *types.Transaction) (callmsg, error) {
+	fromAddress, err := tx.From()
+	if err != nil {
+		return callmsg{}, err
+	}
+	return callmsg{
+		from:     statedb.GetOrNewStateObject(fromAddress),
+		to:       tx.To(),
+		gas:      tx.Gas(),
+		gasPrice: tx.GasPrice(),
+		value:    tx.Value(),
+		data:     tx.Data(),
+	}, nil
+}
+
+// StorageRangeResult is the result of a debug_storageRangeAt API call.
+type StorageRangeResult struct {
+	Storage storageMap   `json:"storage"`
+	NextKey *common.Hash `json:"nextKey"` // nil if Storage includes the last key in the trie
+}
+
+type storageMap map[common.Hash]storageEntry
+
+type storageEntry struct {
+	Key   *common.Hash `json:"key"` // nil if the preimage of the hashed key is unknown
+	Value common.Hash  `json:"value"`
+}
+
+// maxStorageRange is the maximum number of storage slots returned by StorageRangeAt.
+const maxStorageRange = 1024
+
+// StorageRangeAt returns up to maxResult storage slots of the given contract, in the state
+// in which the transaction with the given index in the block is executed. Slots are keyed
+// by the hash of their storage key and iterated in hash order starting at keyStart.
+// maxResult is lowered to maxStorageRange.
+func (s *PublicDebugAPI) StorageRangeAt(blockHash common.Hash, txIndex int, contractAddress common.Address, keyStart hexutil.Bytes, maxResult int) (StorageRangeResult, error) {
+	_, vmenv, err := s.computeTxEnv(blockHash, txIndex)
+	if err != nil {
+		return StorageRangeResult{}, err
+	}
+	st := vmenv.Db().(*state.StateDB).StorageTrie(contractAddress)
+	if st == nil {
+		return StorageRangeResult{}, fmt.Errorf("account %x doesn't exist", contractAddress)
+	}
+	return storageRangeAt(st, keyStart, maxResult)
+}
+
+func storageRangeAt(st state.Trie, start []byte, maxResult int) (StorageRangeResult, error) {
+	if maxResult > maxStorageRange {
+		maxResult = maxStorageRange
+	}
+	it := trie.NewIterator(st.NodeIterator(start))
+	result := StorageRangeResult{Storage: storageMap{}}
+	for i := 0; i < maxResult && it.Next(); i++ {
+		_, content, _, err := rlp.Split(it.Value)
+		if err != nil {
+			return StorageRangeResult{}, err
+		}
+		e := storageEntry{Value: common.BytesToHash(content)}
+		if preimage := st.GetKey(it.Key); preimage != nil {
+			preimage := common.BytesToHash(preimage)
+			e.Key = &preimage
+		}
+		result.Storage[common.BytesToHash(it.Key)] = e
+	}
+	// Add the 'next key' so clients can continue downloading.
+	if it.Next() {
+		next := common.BytesToHash(it.Key)
+		result.NextKey = &next
+	}
+	return result, nil
+}
+
 // computeTxEnv returns the execution environment of a certain transaction.
 func (s *PublicDebugAPI) computeTxEnv(blockHash common.Hash, txIndex int) (core.Message, *core.VMEnv, error) {
 
@@ -2122,36 +3368,17 @@ func (s *PublicDebugAPI) computeTxEnv(blockHash common.Hash, txIndex int) (core.
 
 	// Recompute transactions up to the target index.
 	for idx, tx := range txs {
-		// Assemble the transaction call message
-		// Retrieve the account state object to interact with
-		var from *state.StateObject
-		fromAddress, e := tx.From()
-		if e != nil {
-			return nil, nil, e
-		}
-		if fromAddress == (common.Address{}) {
-			from = statedb.GetOrNewStateObject(common.Address{})
-		} else {
-			from = statedb.GetOrNewStateObject(fromAddress)
-		}
-
-		msg := callmsg{
-			from:     from,
-			to:       tx.To(),
-			gas:      tx.Gas(),
-			gasPrice: tx.GasPrice(),
-			value:    tx.Value(),
-			data:     tx.Data(),
+		msg, err := txMessage(statedb, tx)
+		if err != nil {
+			return nil, nil, err
 		}
-
 		vmenv := core.NewEnv(statedb, s.eth.chainConfig, s.eth.BlockChain(), msg, block.Header())
 		if idx == txIndex {
 			return msg, vmenv, nil
 		}
 
 		gp := new(core.GasPool).AddGas(tx.Gas())
-		_, _, _, err := core.ApplyMessage(vmenv, msg, gp)
-		if err != nil {
+		if _, _, _, err := core.ApplyMessage(vmenv, msg, gp); err != nil {
 			return nil, nil, fmt.Errorf("tx %x failed: %v", tx.Hash(), err)
 		}
 		statedb.DeleteSuicides()
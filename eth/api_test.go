@@ -0,0 +1,890 @@
+package eth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereumproject/go-ethereum/accounts"
+	"github.com/ethereumproject/go-ethereum/common"
+	"github.com/ethereumproject/go-ethereum/core"
+	"github.com/ethereumproject/go-ethereum/core/state"
+	"github.com/ethereumproject/go-ethereum/core/types"
+	"github.com/ethereumproject/go-ethereum/crypto"
+	"github.com/ethereumproject/go-ethereum/eth/downloader"
+	"github.com/ethereumproject/go-ethereum/ethdb"
+	"github.com/ethereumproject/go-ethereum/event"
+	"github.com/ethereumproject/go-ethereum/rpc"
+	"github.com/ethereumproject/go-ethereum/trie"
+	"github.com/hashicorp/golang-lru"
+)
+
+func TestStateAndBlockByNumberOrHash(t *testing.T) {
+	pm, db := newTestProtocolManagerMust(t, downloader.FullSync, 4, nil, nil)
+	defer pm.Stop()
+	bc := pm.blockchain
+
+	// Insert a shorter side chain forking off the genesis block.
+	fork, _ := core.GenerateChain(core.DefaultConfigMorden.ChainConfig, bc.Genesis(), db, 2, func(i int, b *core.BlockGen) {
+		b.SetCoinbase(common.Address{0x01})
+	})
+	if res := bc.InsertChain(fork); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	canonical := bc.GetBlockByNumber(2)
+	if canonical.Hash() == fork[1].Hash() {
+		t.Fatal("side chain became canonical")
+	}
+
+	tests := []struct {
+		arg     rpc.BlockNumberOrHash
+		want    common.Hash
+		wantErr bool
+	}{
+		{rpc.BlockNumberOrHashWithNumber(2), canonical.Hash(), false},
+		{rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber), bc.CurrentBlock().Hash(), false},
+		{rpc.BlockNumberOrHashWithHash(canonical.Hash(), true), canonical.Hash(), false},
+		{rpc.BlockNumberOrHashWithHash(fork[1].Hash(), false), fork[1].Hash(), false},
+		{rpc.BlockNumberOrHashWithHash(fork[1].Hash(), true), common.Hash{}, true},
+		{rpc.BlockNumberOrHashWithHash(common.Hash{0x01}, true), common.Hash{}, false},
+	}
+	for i, tt := range tests {
+		state, block, err := stateAndBlockByNumberOrHash(nil, bc, tt.arg)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("test %d (%v): want error: %v, got: %v", i, tt.arg, tt.wantErr, err)
+			continue
+		}
+		var got common.Hash
+		if block != nil {
+			got = block.Hash()
+			if state == nil {
+				t.Errorf("test %d (%v): missing state", i, tt.arg)
+			}
+		}
+		if got != tt.want {
+			t.Errorf("test %d (%v): want block: %x, got: %x", i, tt.arg, tt.want, got)
+		}
+	}
+}
+
+func TestBlockByNumberOrHash(t *testing.T) {
+	pm, db := newTestProtocolManagerMust(t, downloader.FullSync, 3, nil, nil)
+	defer pm.Stop()
+	bc := pm.blockchain
+
+	fork, _ := core.GenerateChain(core.DefaultConfigMorden.ChainConfig, bc.Genesis(), db, 1, func(i int, b *core.BlockGen) {
+		b.SetCoinbase(common.Address{0x01})
+	})
+	if res := bc.InsertChain(fork); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	head := bc.CurrentBlock()
+	if head.NumberU64() != 3 {
+		t.Fatal("side chain became canonical")
+	}
+
+	tests := []struct {
+		arg     rpc.BlockNumberOrHash
+		want    common.Hash
+		wantErr bool
+	}{
+		{rpc.BlockNumberOrHashWithNumber(0), bc.Genesis().Hash(), false},
+		{rpc.BlockNumberOrHashWithNumber(2), bc.GetCanonicalHash(2), false},
+		{rpc.BlockNumberOrHashWithNumber(4), common.Hash{}, false},
+		{rpc.BlockNumberOrHashWithNumber(-3), common.Hash{}, true},
+		{rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber), head.Hash(), false},
+		{rpc.BlockNumberOrHashWithNumber(rpc.PendingBlockNumber), head.Hash(), false},
+		{rpc.BlockNumberOrHashWithHash(head.Hash(), true), head.Hash(), false},
+		{rpc.BlockNumberOrHashWithHash(fork[0].Hash(), false), fork[0].Hash(), false},
+		{rpc.BlockNumberOrHashWithHash(fork[0].Hash(), true), common.Hash{}, true},
+		{rpc.BlockNumberOrHashWithHash(common.Hash{0x01}, true), common.Hash{}, false},
+		{rpc.BlockNumberOrHash{}, common.Hash{}, true},
+	}
+	for i, tt := range tests {
+		block, err := blockByNumberOrHash(nil, bc, tt.arg)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("test %d (%v): want error: %v, got: %v", i, tt.arg, tt.wantErr, err)
+			continue
+		}
+		var got common.Hash
+		if block != nil {
+			got = block.Hash()
+		}
+		if got != tt.want {
+			t.Errorf("test %d (%v): want block: %x, got: %x", i, tt.arg, tt.want, got)
+		}
+	}
+}
+
+func TestSendTxSigner(t *testing.T) {
+	db, _ := ethdb.NewMemDatabase()
+	core.WriteGenesisBlockForTesting(db)
+	bc, err := core.NewBlockChain(db, core.DefaultConfigMorden.ChainConfig, core.FakePow{}, new(event.TypeMux), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configured := bc.Config().GetChainID()
+
+	signer, err := sendTxSigner(bc, SendTxArgs{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := bc.Config().GetSigner(bc.CurrentBlock().Number()); !reflect.DeepEqual(signer, want) {
+		t.Errorf("default signer: want: %v, got: %v", want, signer)
+	}
+
+	if _, err := sendTxSigner(bc, SendTxArgs{ChainID: rpc.NewHexNumber(0)}); err == nil {
+		t.Error("chainId 0: expected error")
+	}
+
+	// A chain id is accepted exactly when the transaction pool recovers the sender
+	// of a transaction signed with it.
+	poolSigner := types.NewChainIdSigner(configured)
+	for _, chainId := range []*big.Int{configured, new(big.Int).Add(configured, common.Big1), big.NewInt(1)} {
+		signer, err := sendTxSigner(bc, SendTxArgs{ChainID: rpc.NewHexNumber(chainId)})
+		tx, signErr := types.NewTransaction(0, common.Address{}, new(big.Int), core.TxGas, new(big.Int), nil).WithSigner(types.NewChainIdSigner(chainId)).SignECDSA(testBankKey)
+		if signErr != nil {
+			t.Fatal(signErr)
+		}
+		_, poolErr := types.Sender(poolSigner, tx)
+		if (err == nil) != (poolErr == nil) {
+			t.Errorf("chainId %v: sendTxSigner error %v, pool sender error %v", chainId, err, poolErr)
+		}
+		if err == nil && !signer.Equal(types.NewChainIdSigner(chainId)) {
+			t.Errorf("chainId %v: want chain id signer, got: %v", chainId, signer)
+		}
+	}
+}
+
+func TestSearchGas(t *testing.T) {
+	const need = 53017
+	executable := func(gas uint64) error {
+		if gas < need {
+			return errors.New("out of gas")
+		}
+		return nil
+	}
+	gas, err := searchGas(core.TxGas.Uint64()-1, 4712388, executable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gas != need {
+		t.Errorf("gas: want: %d, got: %d", need, gas)
+	}
+	if gas, err := searchGas(core.TxGas.Uint64()-1, 4712388, func(uint64) error { return nil }); err != nil || gas != core.TxGas.Uint64() {
+		t.Errorf("always executable: want: %d, got: %d (%v)", core.TxGas.Uint64(), gas, err)
+	}
+	if _, err := searchGas(core.TxGas.Uint64()-1, need-1, executable); err == nil {
+		t.Error("expected error when the cap is too low")
+	}
+
+	if got := withGasBuffer(50000, 100000); got != 55000 {
+		t.Errorf("buffered gas: want: %d, got: %d", 55000, got)
+	}
+	if got := withGasBuffer(95000, 100000); got != 100000 {
+		t.Errorf("capped buffered gas: want: %d, got: %d", 100000, got)
+	}
+}
+
+func TestDoCallCancel(t *testing.T) {
+	pm, db := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
+	defer pm.Stop()
+	api := &PublicBlockChainAPI{config: pm.blockchain.Config(), bc: pm.blockchain, chainDb: db}
+
+	// Contract creation with init code looping forever: JUMPDEST PUSH1 0 JUMP
+	args := CallArgs{
+		From:     testBank.Address,
+		Gas:      rpc.NewHexNumber(uint64(1) << 50),
+		GasPrice: rpc.NewHexNumber(1),
+		Data:     "0x5b600056",
+	}
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, test := range []struct {
+		ctx     context.Context
+		timeout time.Duration
+		want    error
+	}{
+		{cancelled, 0, context.Canceled},
+		{context.Background(), 10 * time.Millisecond, context.DeadlineExceeded},
+	} {
+		api.setCallTimeout(test.timeout)
+		done := make(chan error, 1)
+		go func() {
+			_, _, _, err := api.doCall(test.ctx, args, rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber))
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if err == nil || !strings.Contains(err.Error(), "aborted: "+test.want.Error()) {
+				t.Errorf("want: execution aborted error with %q, got: %v", test.want, err)
+			}
+		case <-time.After(DefaultCallTimeout):
+			t.Fatal("call not aborted")
+		}
+	}
+}
+
+func TestDoCallGasCap(t *testing.T) {
+	pm, db := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
+	defer pm.Stop()
+	api := &PublicBlockChainAPI{config: pm.blockchain.Config(), bc: pm.blockchain, chainDb: db}
+	api.setGasCap(100000, false)
+
+	// Contract creation with init code looping forever: JUMPDEST PUSH1 0 JUMP
+	args := CallArgs{
+		From:     testBank.Address,
+		Gas:      rpc.NewHexNumber(uint64(1) << 50),
+		GasPrice: rpc.NewHexNumber(1),
+		Data:     "0x5b600056",
+	}
+	latest := rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber)
+	_, gas, failed, err := api.doCall(context.Background(), args, latest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !failed || gas.Uint64() != 100000 {
+		t.Errorf("want: failed call using %d gas, got: failed: %v, gas: %v", 100000, failed, gas)
+	}
+
+	api.setGasCap(100000, true)
+	if _, _, _, err := api.doCall(context.Background(), args, latest); err == nil {
+		t.Error("expected error for gas above the cap")
+	}
+	args.Gas = nil
+	if _, gas, _, err := api.doCall(context.Background(), args, latest); err != nil || gas.Uint64() != 100000 {
+		t.Errorf("default gas: want: %d, got: %v (%v)", 100000, gas, err)
+	}
+}
+
+func TestNonceGaps(t *testing.T) {
+	txs := func(nonces ...uint64) map[uint64][]*types.Transaction {
+		m := make(map[uint64][]*types.Transaction)
+		for _, nonce := range nonces {
+			m[nonce] = []*types.Transaction{types.NewTransaction(nonce, common.Address{}, new(big.Int), new(big.Int), new(big.Int), nil)}
+		}
+		return m
+	}
+	tests := []struct {
+		next      uint64
+		pending   map[uint64][]*types.Transaction
+		queued    map[uint64][]*types.Transaction
+		max       int
+		missing   []uint64
+		lowest    int64 // -1 if nothing queued
+		truncated bool
+	}{
+		{5, nil, nil, 10, []uint64{}, -1, false},
+		{5, txs(5, 6), txs(9), 10, []uint64{7, 8}, 9, false},
+		{5, nil, txs(6, 8), 10, []uint64{5, 7}, 6, false},
+		{0, nil, txs(100), 3, []uint64{0, 1, 2}, 100, true},
+	}
+	for i, tt := range tests {
+		missing, lowest, truncated := nonceGaps(tt.next, tt.pending, tt.queued, tt.max)
+		if !reflect.DeepEqual(missing, tt.missing) {
+			t.Errorf("test %d: missing mismatch: have %v, want %v", i, missing, tt.missing)
+		}
+		if (lowest == nil) != (tt.lowest < 0) || (lowest != nil && *lowest != uint64(tt.lowest)) {
+			t.Errorf("test %d: lowest queued mismatch: have %v, want %d", i, lowest, tt.lowest)
+		}
+		if truncated != tt.truncated {
+			t.Errorf("test %d: truncated mismatch: have %v, want %v", i, truncated, tt.truncated)
+		}
+	}
+}
+
+func TestGetUnclesByBlockHash(t *testing.T) {
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 3, func(i int, block *core.BlockGen) {
+		if i == 2 {
+			for n := 0; n < 2; n++ {
+				uncle := block.PrevBlock(n).Header()
+				uncle.Extra = []byte("uncle")
+				block.AddUncle(uncle)
+			}
+		}
+	}, nil)
+	defer pm.Stop()
+	api := &PublicBlockChainAPI{config: pm.blockchain.Config(), bc: pm.blockchain}
+
+	block := pm.blockchain.GetBlockByNumber(3)
+	uncles, err := api.GetUnclesByBlockHash(block.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uncles) != 2 {
+		t.Fatalf("uncle count mismatch: have %d, want %d", len(uncles), 2)
+	}
+	for i, uncle := range uncles {
+		if want := block.Uncles()[i].Hash(); *uncle.Hash != want {
+			t.Errorf("uncle %d: hash mismatch: have %x, want %x", i, *uncle.Hash, want)
+		}
+		if uncle.Transactions != nil {
+			t.Errorf("uncle %d: transactions included", i)
+		}
+	}
+	if uncles, err := api.GetUnclesByBlockHash(pm.blockchain.GetBlockByNumber(1).Hash()); err != nil || uncles == nil || len(uncles) != 0 {
+		t.Errorf("block without uncles: have %v (%v), want empty list", uncles, err)
+	}
+	if uncles, err := api.GetUnclesByBlockHash(common.Hash{0x01}); err != nil || uncles != nil {
+		t.Errorf("unknown block: have %v (%v), want nil", uncles, err)
+	}
+}
+
+func TestRPCOutputBlockFields(t *testing.T) {
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 1, nil, nil)
+	defer pm.Stop()
+	api := &PublicBlockChainAPI{config: pm.blockchain.Config(), bc: pm.blockchain}
+	block := pm.blockchain.GetBlockByNumber(1)
+
+	fields := []string{
+		"difficulty", "extraData", "gasLimit", "gasUsed", "hash", "logsBloom", "miner", "mixHash", "nonce", "number",
+		"parentHash", "receiptsRoot", "sha3Uncles", "size", "stateRoot", "timestamp", "totalDifficulty",
+		"transactionsRoot", "uncles",
+	}
+	for _, inclTx := range []bool{false, true} {
+		out, err := api.rpcOutputBlock(block, inclTx, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, err := json.Marshal(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded map[string]interface{}
+		if err := json.Unmarshal(raw, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		want := fields
+		if inclTx {
+			want = append(append([]string{}, fields...), "transactions")
+		}
+		if len(decoded) != len(want) {
+			t.Errorf("inclTx=%v: field count mismatch: have %d, want %d", inclTx, len(decoded), len(want))
+		}
+		for _, field := range want {
+			if _, ok := decoded[field]; !ok {
+				t.Errorf("inclTx=%v: missing field %q", inclTx, field)
+			}
+		}
+		if have, want := decoded["number"], "0x1"; have != want {
+			t.Errorf("inclTx=%v: number mismatch: have %v, want %v", inclTx, have, want)
+		}
+		if inclTx {
+			if txs, ok := decoded["transactions"].([]interface{}); !ok || len(txs) != 0 {
+				t.Errorf("transactions mismatch: have %v, want empty list", decoded["transactions"])
+			}
+		}
+	}
+}
+
+func TestExportJSONN(t *testing.T) {
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 4, nil, nil)
+	defer pm.Stop()
+
+	var buf bytes.Buffer
+	if err := ExportJSONN(pm.blockchain, &buf, 1, 3); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("exported line count mismatch: have %d, want 3", len(lines))
+	}
+	for i, line := range lines {
+		var block map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &block); err != nil {
+			t.Fatalf("line %d: %v", i, err)
+		}
+		if have, want := block["hash"], pm.blockchain.GetBlockByNumber(uint64(i+1)).Hash().Hex(); have != want {
+			t.Errorf("line %d: hash mismatch: have %v, want %v", i, have, want)
+		}
+		if _, ok := block["transactions"]; !ok {
+			t.Errorf("line %d: missing transactions", i)
+		}
+	}
+	if err := ExportJSONN(pm.blockchain, &buf, 3, 1); err == nil {
+		t.Error("expected error for inverted range")
+	}
+}
+
+func TestGetTransactionReceiptConfirmations(t *testing.T) {
+	var tx *types.Transaction
+	pm, db := newTestProtocolManagerMust(t, downloader.FullSync, 4, func(i int, block *core.BlockGen) {
+		if i == 1 {
+			tx, _ = types.NewTransaction(block.TxNonce(testBank.Address), common.Address{}, big.NewInt(1), core.TxGas, nil, nil).SignECDSA(testBankKey)
+			block.AddTx(tx)
+		}
+	}, nil)
+	defer pm.Stop()
+
+	api := &PublicTransactionPoolAPI{chainDb: db, bc: pm.blockchain}
+
+	// The transaction is included in block 2 of 4, so it has 3 confirmations.
+	tests := []struct {
+		min  *rpc.HexNumber
+		want bool
+	}{
+		{nil, true},
+		{rpc.NewHexNumber(0), true},
+		{rpc.NewHexNumber(3), true},
+		{rpc.NewHexNumber(4), false},
+	}
+	for i, tt := range tests {
+		receipt, err := api.GetTransactionReceipt(tx.Hash(), tt.min)
+		if err != nil {
+			t.Fatalf("test %d: unexpected error: %v", i, err)
+		}
+		if (receipt != nil) != tt.want {
+			t.Errorf("test %d (min %v): want receipt: %v, got: %v", i, tt.min, tt.want, receipt != nil)
+		}
+	}
+}
+
+func TestTraceBlock(t *testing.T) {
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 2, func(i int, block *core.BlockGen) {
+		if i != 1 {
+			return
+		}
+		for j := 0; j < 2; j++ {
+			tx, _ := types.NewTransaction(block.TxNonce(testBank.Address), common.Address{0x01}, big.NewInt(1), core.TxGas, nil, nil).SignECDSA(testBankKey)
+			block.AddTx(tx)
+		}
+		// Call of the identity precompile, returning its input.
+		tx, _ := types.NewTransaction(block.TxNonce(testBank.Address), common.BytesToAddress([]byte{0x04}), new(big.Int), big.NewInt(50000), nil, []byte{0x01}).SignECDSA(testBankKey)
+		block.AddTx(tx)
+	}, nil)
+	defer pm.Stop()
+
+	block := pm.blockchain.GetBlockByNumber(2)
+	results, err := traceBlock(pm.blockchain.Config(), pm.blockchain, block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("want %d results, got %d", 3, len(results))
+	}
+	receipts := pm.blockchain.GetReceiptsByBlockHash(block.Hash())
+	for i, result := range results {
+		if result.Gas.Cmp(receipts[i].GasUsed) != 0 {
+			t.Errorf("tx %d: gas mismatch: want %v, got %v", i, receipts[i].GasUsed, result.Gas)
+		}
+	}
+	if results[2].ReturnValue != "01" {
+		t.Errorf("return value: want %q, got %q", "01", results[2].ReturnValue)
+	}
+}
+
+func TestGetTransactionStatus(t *testing.T) {
+	var included *types.Transaction
+	pm, db := newTestProtocolManagerMust(t, downloader.FullSync, 3, func(i int, block *core.BlockGen) {
+		if i == 1 {
+			included, _ = types.NewTransaction(block.TxNonce(testBank.Address), common.Address{}, big.NewInt(1), core.TxGas, nil, nil).SignECDSA(testBankKey)
+			block.AddTx(included)
+		}
+	}, nil)
+	defer pm.Stop()
+
+	txPool := core.NewTxPool(pm.blockchain.Config(), new(event.TypeMux), pm.blockchain.State, pm.blockchain.GasLimit)
+	defer txPool.Stop()
+	pending, _ := types.NewTransaction(1, common.Address{}, big.NewInt(1), core.TxGas, big.NewInt(1), nil).SignECDSA(testBankKey)
+	if err := txPool.Add(pending); err != nil {
+		t.Fatal(err)
+	}
+	api := &PublicTransactionPoolAPI{chainDb: db, bc: pm.blockchain, txPool: txPool}
+
+	status := api.GetTransactionStatus(included.Hash())
+	if status.Status != txStatusIncluded {
+		t.Fatalf("included tx: want status %q, got %q", txStatusIncluded, status.Status)
+	}
+	block := pm.blockchain.GetBlockByNumber(2)
+	if *status.BlockHash != block.Hash() || status.BlockNumber.Uint64() != 2 || status.TransactionIndex.Uint64() != 0 {
+		t.Errorf("included tx: block fields mismatch: %x #%v index %v", status.BlockHash, status.BlockNumber, status.TransactionIndex)
+	}
+	if status.Confirmations.Uint64() != 2 {
+		t.Errorf("included tx: want %d confirmations, got %v", 2, status.Confirmations)
+	}
+	if status := api.GetTransactionStatus(pending.Hash()); status.Status != txStatusPending || status.BlockHash != nil {
+		t.Errorf("pending tx: want status %q, got %+v", txStatusPending, status)
+	}
+	if status := api.GetTransactionStatus(common.Hash{0x01}); status.Status != txStatusUnknown {
+		t.Errorf("unknown tx: want status %q, got %q", txStatusUnknown, status.Status)
+	}
+}
+
+func TestExportAccount(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eth-export-account")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	am, err := accounts.NewManager(dir, accounts.LightScryptN, accounts.LightScryptP, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc, err := am.NewAccount("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := &PrivateAccountAPI{am: am}
+
+	if _, err := api.ExportAccount(acc.Address, "foo", ""); err == nil {
+		t.Error("expected error for empty new password")
+	}
+	if _, err := api.ExportAccount(acc.Address, "bar", "baz"); err == nil {
+		t.Error("expected error for wrong password")
+	}
+	if _, err := api.ExportAccount(acc.Address, "foo", "baz"); err == nil || !strings.Contains(err.Error(), "rate limited") {
+		t.Errorf("want rate limit error, got %v", err)
+	}
+
+	api.lastExport = time.Time{}
+	keyJSON, err := api.ExportAccount(acc.Address, "foo", "baz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := am.DeleteAccount(acc, "foo"); err != nil {
+		t.Fatal(err)
+	}
+	imported, err := am.Import(keyJSON, "baz", "qux")
+	if err != nil {
+		t.Fatalf("exported key not decryptable with the new password: %v", err)
+	}
+	if imported.Address != acc.Address {
+		t.Errorf("imported address: want %x, got %x", acc.Address, imported.Address)
+	}
+}
+
+func TestSignTypedData(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eth-sign-typed-data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	am, err := accounts.NewManager(dir, accounts.LightScryptN, accounts.LightScryptP, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The example of the EIP-712 specification is signed by Cow, whose key is keccak256("cow").
+	key := crypto.ToECDSA(crypto.Keccak256([]byte("cow")))
+	acc, err := am.ImportECDSA(key, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := &PrivateAccountAPI{am: am}
+
+	var td TypedData
+	if err := json.Unmarshal([]byte(typedDataMail), &td); err != nil {
+		t.Fatal(err)
+	}
+	sig, err := api.SignTypedData(acc.Address, td, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The signed digest must be the reference of the specification.
+	digest := common.HexToHash("0xbe609aee343fb3c4b28e1df9e632fca64fcfaede20f02e86244efddf30957bd2")
+	want, err := crypto.Sign(digest.Bytes(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want[64] += 27
+	if !bytes.Equal(sig, want) {
+		t.Errorf("signature: want %x, got %x", want, []byte(sig))
+	}
+	recSig := common.CopyBytes(sig)
+	recSig[64] -= 27
+	pub, err := crypto.Ecrecover(digest.Bytes(), recSig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer := crypto.PubkeyToAddress(*crypto.ToECDSAPub(pub)); signer != acc.Address {
+		t.Errorf("signer: want %x, got %x", acc.Address, signer)
+	}
+
+	if _, err := api.SignTypedData(acc.Address, td, "bar"); err == nil {
+		t.Error("expected error for wrong password")
+	}
+}
+
+func TestGetBalances(t *testing.T) {
+	pm, db := newTestProtocolManagerMust(t, downloader.FullSync, 2, nil, nil)
+	defer pm.Stop()
+
+	api := &PublicBlockChainAPI{config: pm.blockchain.Config(), bc: pm.blockchain, chainDb: db}
+	latest := rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber)
+
+	addresses := []common.Address{{0x01}, testBank.Address}
+	balances, err := api.GetBalances(addresses, latest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(balances) != len(addresses) {
+		t.Fatalf("got %d balances, want %d", len(balances), len(addresses))
+	}
+	for i, addr := range addresses {
+		want, err := api.GetBalance(addr, latest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if balances[i].Cmp(want) != 0 {
+			t.Errorf("balance %d (%x): got %v, want %v", i, addr, balances[i], want)
+		}
+	}
+	if balances[1].Sign() == 0 {
+		t.Error("expected non-zero balance for the test bank")
+	}
+
+	if _, err := api.GetBalances(make([]common.Address, maxBalanceAddresses+1), latest); err == nil {
+		t.Error("expected error for too many addresses")
+	}
+}
+
+func TestGetProof(t *testing.T) {
+	pm, db := newTestProtocolManagerMust(t, downloader.FullSync, 2, nil, nil)
+	defer pm.Stop()
+
+	api := &PublicBlockChainAPI{config: pm.blockchain.Config(), bc: pm.blockchain, chainDb: db}
+	latest := rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber)
+
+	result, err := api.GetProof(testBank.Address, []string{"0x01"}, latest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := api.GetBalance(testBank.Address, latest); (*big.Int)(result.Balance).Cmp(want) != 0 {
+		t.Errorf("balance mismatch: have %v, want %v", (*big.Int)(result.Balance), want)
+	}
+	if result.StorageHash != types.EmptyRootHash {
+		t.Errorf("storage hash mismatch: have %x, want %x", result.StorageHash, types.EmptyRootHash)
+	}
+	if len(result.StorageProof) != 1 || result.StorageProof[0].Key != "0x01" || (*big.Int)(result.StorageProof[0].Value).Sign() != 0 {
+		t.Errorf("unexpected storage proof: %+v", result.StorageProof)
+	}
+
+	proofDb, _ := ethdb.NewMemDatabase()
+	for _, node := range result.AccountProof {
+		proofDb.Put(crypto.Keccak256(node), node)
+	}
+	root := pm.blockchain.CurrentBlock().Root()
+	val, err, _ := trie.VerifyProof(root, crypto.Keccak256(testBank.Address[:]), proofDb)
+	if err != nil {
+		t.Fatalf("account proof failed to verify: %v", err)
+	}
+	if len(val) == 0 {
+		t.Error("account proof does not contain the account")
+	}
+
+	if _, err := api.GetProof(testBank.Address, make([]string, maxProofStorageKeys+1), latest); err == nil {
+		t.Error("expected error for too many storage keys")
+	}
+}
+
+func TestGetStorageRoot(t *testing.T) {
+	pm, db := newTestProtocolManagerMust(t, downloader.FullSync, 2, nil, nil)
+	defer pm.Stop()
+
+	api := &PublicBlockChainAPI{config: pm.blockchain.Config(), bc: pm.blockchain, chainDb: db}
+	latest := rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber)
+
+	for _, addr := range []common.Address{testBank.Address, {0x01}} {
+		root, err := api.GetStorageRoot(addr, latest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if root != types.EmptyRootHash {
+			t.Errorf("%x: storage root mismatch: have %x, want %x", addr, root, types.EmptyRootHash)
+		}
+	}
+}
+
+func TestStorageRangeAt(t *testing.T) {
+	db, _ := ethdb.NewMemDatabase()
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
+	addr := common.Address{0x01}
+	empty := common.Address{0x02}
+	keys := []common.Hash{{0x01}, {0x02}, {0x03}, {0x04}}
+	for i, key := range keys {
+		statedb.SetState(addr, key, common.Hash{byte(i + 1)})
+	}
+	statedb.SetNonce(empty, 1)
+
+	// Page through the storage two slots at a time.
+	seen := make(map[common.Hash]common.Hash)
+	var start []byte
+	for pages := 0; ; pages++ {
+		if pages > len(keys) {
+			t.Fatal("storage range did not terminate")
+		}
+		result, err := storageRangeAt(statedb.StorageTrie(addr), start, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for hash, e := range result.Storage {
+			if e.Key == nil {
+				t.Fatalf("missing preimage for %x", hash)
+			}
+			if hash != crypto.Keccak256Hash(e.Key[:]) {
+				t.Errorf("key %x does not match hash %x", *e.Key, hash)
+			}
+			seen[*e.Key] = e.Value
+		}
+		if result.NextKey == nil {
+			break
+		}
+		if len(result.Storage) != 2 {
+			t.Errorf("got %d slots in non-final page, want 2", len(result.Storage))
+		}
+		start = result.NextKey[:]
+	}
+	if len(seen) != len(keys) {
+		t.Fatalf("got %d slots, want %d", len(seen), len(keys))
+	}
+	for i, key := range keys {
+		if want := (common.Hash{byte(i + 1)}); seen[key] != want {
+			t.Errorf("slot %x: got %x, want %x", key, seen[key], want)
+		}
+	}
+
+	result, err := storageRangeAt(statedb.StorageTrie(empty), nil, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Storage) != 0 || result.NextKey != nil {
+		t.Errorf("expected empty result for account without storage, got %+v", result)
+	}
+	if statedb.StorageTrie(common.Address{0x03}) != nil {
+		t.Error("expected no storage trie for non-existent account")
+	}
+
+	// Larger requests are lowered to the server maximum
+	large := common.Address{0x04}
+	for i := 0; i <= maxStorageRange; i++ {
+		statedb.SetState(large, common.BigToHash(big.NewInt(int64(i))), common.Hash{0x01})
+	}
+	result, err = storageRangeAt(statedb.StorageTrie(large), nil, maxStorageRange*2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Storage) != maxStorageRange || result.NextKey == nil {
+		t.Errorf("got %d slots (next key %v), want %d and a next key", len(result.Storage), result.NextKey, maxStorageRange)
+	}
+}
+
+func TestNodeHealth(t *testing.T) {
+	tests := []struct {
+		p2pRunning      bool
+		current, height uint64
+		peers           int
+		age             time.Duration
+		problems        int
+	}{
+		{true, 100, 100, 5, time.Minute, 0},
+		{true, 100, 0, 5, time.Minute, 0},
+		{true, 100, 101, 5, time.Minute, 1},
+		{true, 100, 100, 0, time.Minute, 1},
+		{true, 100, 100, 5, time.Hour, 1},
+		{false, 100, 100, 5, -time.Minute, 1},
+		{false, 50, 100, 0, time.Hour, 4},
+	}
+	for i, tt := range tests {
+		h := nodeHealth(tt.p2pRunning, tt.current, tt.height, tt.peers, tt.age, DefaultHealthMaxBlockAge)
+		if len(h.Problems) != tt.problems {
+			t.Errorf("test %d: want %d problems, got %q", i, tt.problems, h.Problems)
+		}
+		if h.Healthy != (tt.problems == 0) {
+			t.Errorf("test %d: healthy: %v, problems: %q", i, h.Healthy, h.Problems)
+		}
+		if h.Syncing != (tt.current < tt.height) {
+			t.Errorf("test %d: syncing: %v", i, h.Syncing)
+		}
+	}
+}
+
+func TestPeerTable(t *testing.T) {
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 4, nil, nil)
+	defer pm.Stop()
+
+	peer62, _ := newTestPeer("peer62", 62, pm, true)
+	defer peer62.close()
+	peer63, _ := newTestPeer("peer63", 63, pm, true)
+	defer peer63.close()
+
+	// Wait for both peers to be registered with the downloader
+	var table []*PeerTableEntry
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if table = peerTable(pm); len(table) == 2 && table[0].Downloader && table[1].Downloader {
+			break
+		}
+	}
+	if len(table) != 2 {
+		t.Fatalf("want 2 peers, got %d", len(table))
+	}
+	if table[0].ID > table[1].ID {
+		t.Errorf("peers not ordered by id: %s, %s", table[0].ID, table[1].ID)
+	}
+	td, head, _ := pm.blockchain.Status()
+	versions := map[string]int{"peer62": 62, "peer63": 63}
+	for _, entry := range table {
+		if version, ok := versions[entry.Name]; !ok || entry.Version != version {
+			t.Errorf("peer %s: unexpected version %d", entry.Name, entry.Version)
+		}
+		if entry.Head != head || entry.Difficulty.Cmp(td) != 0 {
+			t.Errorf("peer %s: head %x td %v, want %x td %v", entry.Name, entry.Head, entry.Difficulty, head, td)
+		}
+		if entry.RemoteAddress == "" {
+			t.Errorf("peer %s: missing remote address", entry.Name)
+		}
+		if !entry.Downloader {
+			t.Errorf("peer %s: not registered with the downloader", entry.Name)
+		}
+	}
+}
+
+func TestRPCOutputBlockSenderCache(t *testing.T) {
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 1, func(i int, block *core.BlockGen) {
+		for n := 0; n < 2; n++ {
+			tx, _ := types.NewTransaction(block.TxNonce(testBank.Address), common.Address{0x01}, big.NewInt(1), core.TxGas, nil, nil).SignECDSA(testBankKey)
+			block.AddTx(tx)
+		}
+	}, nil)
+	defer pm.Stop()
+	senders, _ := lru.New(senderCacheLimit)
+	api := &PublicBlockChainAPI{config: pm.blockchain.Config(), bc: pm.blockchain, senders: senders}
+	block := pm.blockchain.GetBlockByNumber(1)
+
+	senderOf := func(i int) common.Address {
+		out, err := api.rpcOutputBlock(block, true, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out.Transactions.([]*RPCTransaction)[i].From
+	}
+	for i := range block.Transactions() {
+		if from := senderOf(i); from != testBank.Address {
+			t.Errorf("tx %d: sender mismatch: have %x, want %x", i, from, testBank.Address)
+		}
+	}
+	if senders.Len() != 2 {
+		t.Fatalf("cached senders: have %d, want 2", senders.Len())
+	}
+	// Cached senders are returned without recovery, until evicted by a reorg
+	hash := block.Transactions()[0].Hash()
+	senders.Add(hash, common.Address{0xff})
+	if from := senderOf(0); from != (common.Address{0xff}) {
+		t.Errorf("cached sender not used: have %x", from)
+	}
+	api.forgetSenders(block.Transactions())
+	if senders.Len() != 0 {
+		t.Errorf("senders left after eviction: %d", senders.Len())
+	}
+	if from := senderOf(0); from != testBank.Address {
+		t.Errorf("sender mismatch after eviction: have %x, want %x", from, testBank.Address)
+	}
+}
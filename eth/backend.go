@@ -65,6 +65,14 @@ type Config struct {
 	SyncMode  downloader.SyncMode // Enables the state download based fast synchronisation algorithm
 	MaxPeers  int
 
+	MaxFetchPeers int // Maximum number of peers concurrently used for fetching block parts (0 = unlimited)
+
+	SyncRTTMin time.Duration // Minimum round-trip time targeted by download requests (0 = default)
+	SyncRTTMax time.Duration // Maximum round-trip time targeted by download requests (0 = default)
+	SyncTTLMax time.Duration // Maximum timeout allowance of a single download request (0 = default)
+
+	SyncPeerTDMargin *big.Int // Margin below the local header chain TD under which peers are not synced from (nil = any)
+
 	BlockChainVersion  int
 	SkipBcVersionCheck bool // e.g. blockchain export
 	DatabaseCache      int
@@ -84,6 +92,36 @@ type Config struct {
 
 	UseAddrTxIndex bool
 
+	MaxTimeFutureBlocks *int64 // Seconds a block may be ahead of local time before being rejected (nil = core default)
+	NoFutureBlocks      bool   // Reject blocks ahead of local time instead of queueing them for later import
+	Preimages           bool   // Record SHA3 preimages seen during block import
+	VerifyStateCommits  bool   // Reopen the committed state of each imported block (debugging aid)
+	VerifyBodies        bool   // Check block bodies read from the database against their header (debugging aid)
+
+	TieBreak       core.TieBreakPolicy // Choice between chains of equal total difficulty (default random)
+	GCMode         core.GCMode         // Which block states are kept in the database (default archive)
+	StateRetention int                 // Most recent block states kept in full GC mode (0 = core default)
+
+	HeaderCacheLimit int // Most recent block headers cached in memory (0 = core default)
+	BodyCacheLimit   int // Most recent block bodies cached in memory (0 = core default)
+	TdCacheLimit     int // Most recent block total difficulties cached in memory (0 = core default)
+	BlockCacheLimit  int // Most recent entire blocks cached in memory (0 = core default)
+
+	MipmapLevels []uint64 // Block ranges of the log bloom bins, coarsest first; changing them reindexes the chain (nil = levels of the database)
+
+	Checkpoints []core.Checkpoint // Trusted blocks imported headers are cross-checked against; must match the local canonical chain
+
+	HeaderCheckFrequency int // Average interval between PoW-verified headers during fast sync (0 = core default)
+	HeaderForceVerify    int // Number of headers before the fast sync pivot which are always verified (0 = core default)
+
+	RequireReplayProtection bool // Reject transactions which are not EIP-155 replay-protected from the tx pool
+	TxPoolAccountLimit      int  // Max pending and queued transactions per sender in the tx pool (0 = unlimited)
+
+	RPCGasCap       uint64        // Maximum gas of eth_call, eth_estimateGas and eth_traceCall (0 = unlimited)
+	RPCGasCapReject bool          // Reject calls requesting more gas than RPCGasCap instead of lowering their gas
+	RPCMaxLogs      int           // Maximum number of logs returned by eth_getLogs and eth_getFilterLogs (0 = unlimited)
+	RPCCallTimeout  time.Duration // Maximum EVM execution time of eth_call, eth_estimateGas and eth_traceCall (0 = unlimited)
+
 	GpoMinGasPrice          *big.Int
 	GpoMaxGasPrice          *big.Int
 	GpoFullBlockRatio       int
@@ -149,7 +187,18 @@ func New(ctx *node.ServiceContext, config *Config) (*Ethereum, error) {
 	if err := upgradeChainDatabase(chainDb); err != nil {
 		return nil, err
 	}
-	if err := addMipmapBloomBins(chainDb); err != nil {
+	// Use the configured log bloom levels, or else those the database was indexed with.
+	mipmapLevels := config.MipmapLevels
+	if mipmapLevels == nil {
+		mipmapLevels = core.GetMipmapLevels(chainDb)
+	}
+	if mipmapLevels == nil {
+		mipmapLevels = core.DefaultMIPMapLevels
+	}
+	if err := core.ValidateMipmapLevels(mipmapLevels); err != nil {
+		return nil, err
+	}
+	if err := addMipmapBloomBins(chainDb, mipmapLevels); err != nil {
 		return nil, err
 	}
 
@@ -169,7 +218,7 @@ func New(ctx *node.ServiceContext, config *Config) (*Ethereum, error) {
 
 	// Load up any custom genesis block if requested
 	if config.Genesis != nil {
-		_, err := core.WriteGenesisBlock(chainDb, config.Genesis)
+		_, err := core.SetupGenesisBlock(chainDb, config.Genesis)
 		if err != nil {
 			return nil, err
 		}
@@ -280,13 +329,46 @@ func New(ctx *node.ServiceContext, config *Config) (*Ethereum, error) {
 
 	eth.chainConfig = config.ChainConfig
 
-	eth.blockchain, err = core.NewBlockChain(chainDb, eth.chainConfig, eth.pow, eth.EventMux())
+	eth.blockchain, err = core.NewBlockChain(chainDb, eth.chainConfig, eth.pow, eth.EventMux(), &core.CacheConfig{
+		HeaderCacheLimit: config.HeaderCacheLimit,
+		BodyCacheLimit:   config.BodyCacheLimit,
+		TdCacheLimit:     config.TdCacheLimit,
+		BlockCacheLimit:  config.BlockCacheLimit,
+		GCMode:           config.GCMode,
+		StateRetention:   config.StateRetention,
+	})
 	if err != nil {
 		if err == core.ErrNoGenesis {
 			return nil, fmt.Errorf(`No chain found. Please initialise a new chain using the "init" subcommand.`)
 		}
 		return nil, err
 	}
+	if config.MaxTimeFutureBlocks != nil {
+		if err := eth.blockchain.SetMaxTimeFutureBlocks(*config.MaxTimeFutureBlocks); err != nil {
+			return nil, err
+		}
+	}
+	if err := eth.blockchain.SetMipmapLevels(mipmapLevels); err != nil {
+		return nil, err
+	}
+	eth.blockchain.SetFutureBlocks(!config.NoFutureBlocks)
+	eth.blockchain.SetPreimageRecording(config.Preimages)
+	eth.blockchain.SetStateCommitVerification(config.VerifyStateCommits)
+	eth.blockchain.SetBodyVerification(config.VerifyBodies)
+	eth.blockchain.SetTieBreakPolicy(config.TieBreak)
+	if err := eth.blockchain.SetCheckpoints(config.Checkpoints); err != nil {
+		return nil, fmt.Errorf("invalid checkpoints: %v", err)
+	}
+	checkFreq, forceVerify := config.HeaderCheckFrequency, config.HeaderForceVerify
+	if checkFreq == 0 {
+		checkFreq = core.DefaultHeaderCheckFrequency
+	}
+	if forceVerify == 0 {
+		forceVerify = core.DefaultHeaderForceVerify
+	}
+	if err := eth.blockchain.SetHeaderVerification(checkFreq, forceVerify); err != nil {
+		return nil, err
+	}
 	// Configure enabled atxi for blockchain
 	if config.UseAddrTxIndex {
 		eth.blockchain.SetAtxi(&core.AtxiT{
@@ -297,11 +379,18 @@ func New(ctx *node.ServiceContext, config *Config) (*Ethereum, error) {
 	eth.gpo = NewGasPriceOracle(eth)
 
 	newPool := core.NewTxPool(eth.chainConfig, eth.EventMux(), eth.blockchain.State, eth.blockchain.GasLimit)
+	newPool.RequireReplayProtection(config.RequireReplayProtection)
+	newPool.SetAccountLimit(config.TxPoolAccountLimit)
 	eth.txPool = newPool
 
 	if eth.protocolManager, err = NewProtocolManager(eth.chainConfig, config.SyncMode, uint64(config.NetworkId), eth.eventMux, eth.txPool, eth.pow, eth.blockchain, chainDb); err != nil {
 		return nil, err
 	}
+	eth.protocolManager.downloader.SetMaxFetchPeers(config.MaxFetchPeers)
+	if err := eth.protocolManager.downloader.SetQoSBounds(config.SyncRTTMin, config.SyncRTTMax, config.SyncTTLMax); err != nil {
+		return nil, err
+	}
+	eth.protocolManager.SetPeerTDMargin(config.SyncPeerTDMargin)
 	eth.miner = miner.New(eth, eth.chainConfig, eth.EventMux(), eth.pow)
 	if err = eth.miner.SetGasPrice(config.GasPrice); err != nil {
 		return nil, err
@@ -313,6 +402,13 @@ func New(ctx *node.ServiceContext, config *Config) (*Ethereum, error) {
 // APIs returns the collection of RPC services the ethereum package offers.
 // NOTE, some of these services probably need to be moved to somewhere else.
 func (s *Ethereum) APIs() []rpc.API {
+	blockChainAPI := NewPublicBlockChainAPI(s.chainConfig, s.blockchain, s.miner, s.chainDb, s.gpo, s.eventMux, s.accountManager)
+	blockChainAPI.setGasCap(s.config.RPCGasCap, s.config.RPCGasCapReject)
+	blockChainAPI.setCallTimeout(s.config.RPCCallTimeout)
+	filterAPI := filters.NewPublicFilterAPI(s.chainDb, s.eventMux)
+	filterAPI.SetMaxLogs(s.config.RPCMaxLogs)
+	filterAPI.SetMipmapLevels(s.blockchain.MipmapLevels())
+
 	return []rpc.API{
 		{
 			Namespace: "eth",
@@ -332,7 +428,7 @@ func (s *Ethereum) APIs() []rpc.API {
 		}, {
 			Namespace: "eth",
 			Version:   "1.0",
-			Service:   NewPublicBlockChainAPI(s.chainConfig, s.blockchain, s.miner, s.chainDb, s.gpo, s.eventMux, s.accountManager),
+			Service:   blockChainAPI,
 			Public:    true,
 		}, {
 			Namespace: "eth",
@@ -362,7 +458,7 @@ func (s *Ethereum) APIs() []rpc.API {
 		}, {
 			Namespace: "eth",
 			Version:   "1.0",
-			Service:   filters.NewPublicFilterAPI(s.chainDb, s.eventMux),
+			Service:   filterAPI,
 			Public:    true,
 		}, {
 			Namespace: "admin",
@@ -378,6 +474,11 @@ func (s *Ethereum) APIs() []rpc.API {
 			Version:   "1.0",
 			Service:   s.netRPCService,
 			Public:    true,
+		}, {
+			Namespace: "net",
+			Version:   "1.0",
+			Service:   NewPublicHealthAPI(s),
+			Public:    true,
 		}, {
 			Namespace: "admin",
 			Version:   "1.0",
@@ -620,7 +721,11 @@ func upgradeChainDatabase(db ethdb.Database) error {
 	return nil
 }
 
-func addMipmapBloomBins(db ethdb.Database) (err error) {
+// addMipmapBloomBins writes the log bloom bins of all canonical blocks if the database
+// has not been indexed with the current version and the given levels. Reindexing a large
+// chain after changing the levels takes long; the bins of the previous levels are left in
+// the database.
+func addMipmapBloomBins(db ethdb.Database, mipmapLevels []uint64) (err error) {
 	const mipmapVersion uint = 2
 
 	// check if the version is set. We ignore data for now since there's
@@ -630,7 +735,14 @@ func addMipmapBloomBins(db ethdb.Database) (err error) {
 	if len(data) > 0 {
 		var version uint
 		if err := rlp.DecodeBytes(data, &version); err == nil && version == mipmapVersion {
-			return nil
+			// databases indexed before the levels were recorded use the defaults
+			levels := core.GetMipmapLevels(db)
+			if levels == nil {
+				levels = core.DefaultMIPMapLevels
+			}
+			if equalMipmapLevels(levels, mipmapLevels) {
+				return nil
+			}
 		}
 	}
 
@@ -641,6 +753,9 @@ func addMipmapBloomBins(db ethdb.Database) (err error) {
 			if err == nil {
 				err = db.Put([]byte("setting-mipmap-version"), val)
 			}
+			if err == nil {
+				err = core.WriteMipmapLevels(db, mipmapLevels)
+			}
 			return
 		}
 	}()
@@ -656,7 +771,7 @@ func addMipmapBloomBins(db ethdb.Database) (err error) {
 		if (hash == common.Hash{}) {
 			return fmt.Errorf("chain db corrupted. Could not find block %d.", i)
 		}
-		err := core.WriteMipmapBloom(db, i, core.GetBlockReceipts(db, hash))
+		err := core.WriteMipmapBloom(db, mipmapLevels, i, core.GetBlockReceipts(db, hash))
 		if err != nil {
 			return err
 		}
@@ -664,3 +779,15 @@ func addMipmapBloomBins(db ethdb.Database) (err error) {
 	glog.V(logger.Info).Infoln("upgrade completed in", time.Since(tstart))
 	return nil
 }
+
+func equalMipmapLevels(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
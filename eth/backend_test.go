@@ -66,12 +66,12 @@ func TestMipmapUpgrade(t *testing.T) {
 		}
 	}
 
-	err := addMipmapBloomBins(db)
+	err := addMipmapBloomBins(db, core.DefaultMIPMapLevels)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	bloom := core.GetMipmapBloom(db, 1, core.MIPMapLevels[0])
+	bloom := core.GetMipmapBloom(db, 1, core.DefaultMIPMapLevels[0])
 	if (bloom == types.Bloom{}) {
 		t.Error("got empty bloom filter")
 	}
@@ -81,3 +81,54 @@ func TestMipmapUpgrade(t *testing.T) {
 		t.Error("setting-mipmap-version not written to database")
 	}
 }
+
+func TestMipmapLevelsReindex(t *testing.T) {
+	db, _ := ethdb.NewMemDatabase()
+	addr := common.HexToAddress("0xCD2a3d9F938E13CD947Ec05AbC7FE734Df8DD826")
+	genesis := core.WriteGenesisBlockForTesting(db)
+
+	chain, receipts := core.GenerateChain(core.DefaultConfigMorden.ChainConfig, genesis, db, 5, func(i int, gen *core.BlockGen) {
+		var receipts types.Receipts
+		if i == 3 {
+			receipt := types.NewReceipt(nil, new(big.Int))
+			receipt.Logs = vm.Logs{&vm.Log{Address: addr}}
+			gen.AddUncheckedReceipt(receipt)
+			receipts = types.Receipts{receipt}
+		}
+		if err := core.WriteReceipts(db, receipts); err != nil {
+			t.Fatal(err)
+		}
+	})
+	for i, block := range chain {
+		core.WriteBlock(db, block)
+		if err := core.WriteCanonicalHash(db, block.Hash(), block.NumberU64()); err != nil {
+			t.Fatal(err)
+		}
+		if err := core.WriteHeadBlockHash(db, block.Hash()); err != nil {
+			t.Fatal(err)
+		}
+		if err := core.WriteBlockReceipts(db, block.Hash(), receipts[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := addMipmapBloomBins(db, core.DefaultMIPMapLevels); err != nil {
+		t.Fatal(err)
+	}
+	if levels := core.GetMipmapLevels(db); len(levels) != len(core.DefaultMIPMapLevels) {
+		t.Errorf("recorded levels: want %v, got %v", core.DefaultMIPMapLevels, levels)
+	}
+
+	// Indexing again with other levels writes the bins of the new levels.
+	if err := addMipmapBloomBins(db, []uint64{2}); err != nil {
+		t.Fatal(err)
+	}
+	if bloom := core.GetMipmapBloom(db, 4, 2); !types.BloomLookup(bloom, addr[:]) {
+		t.Error("address not in reindexed bloom")
+	}
+	if bloom := core.GetMipmapBloom(db, 2, 2); types.BloomLookup(bloom, addr[:]) {
+		t.Error("address in bloom of other range")
+	}
+	if levels := core.GetMipmapLevels(db); len(levels) != 1 || levels[0] != 2 {
+		t.Errorf("recorded levels: want [2], got %v", levels)
+	}
+}
@@ -17,6 +17,7 @@
 package eth
 
 import (
+	"context"
 	"math/big"
 
 	"github.com/ethereumproject/go-ethereum/common"
@@ -55,7 +56,7 @@ func (b *ContractBackend) HasCode(contract common.Address, pending bool) (bool,
 	if pending {
 		block = rpc.PendingBlockNumber
 	}
-	out, err := b.bcapi.GetCode(contract, block)
+	out, err := b.bcapi.GetCode(contract, rpc.BlockNumberOrHashWithNumber(block))
 	return len(common.FromHex(out)) > 0, err
 }
 
@@ -73,7 +74,7 @@ func (b *ContractBackend) ContractCall(contract common.Address, data []byte, pen
 		block = rpc.PendingBlockNumber
 	}
 	// Execute the call and convert the output back to Go types
-	out, err := b.bcapi.Call(args, block)
+	out, err := b.bcapi.Call(context.Background(), args, rpc.BlockNumberOrHashWithNumber(block))
 	return common.FromHex(out), err
 }
 
@@ -96,7 +97,7 @@ func (b *ContractBackend) SuggestGasPrice() (*big.Int, error) {
 // requirement as other transactions may be added or removed by miners, but it
 // should provide a basis for setting a reasonable default.
 func (b *ContractBackend) EstimateGasLimit(sender common.Address, contract *common.Address, value *big.Int, data []byte) (*big.Int, error) {
-	out, err := b.bcapi.EstimateGas(CallArgs{
+	out, err := b.bcapi.EstimateGas(context.Background(), CallArgs{
 		From:  sender,
 		To:    contract,
 		Value: *rpc.NewHexNumber(value),
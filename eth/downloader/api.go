@@ -42,6 +42,18 @@ type FailedEvent struct {
 	Err  error
 }
 
+// AncestorFoundEvent is posted when a sync has located the common ancestor of the
+// local chain and the chain of the peer, from which the sync proceeds. As the search
+// samples every 16th recent block first, the ancestor may be up to 15 blocks below
+// the actual fork point. Depth is the number of local blocks above the ancestor.
+// Hash is empty if the search ended at its lower bound without requesting it.
+type AncestorFoundEvent struct {
+	Peer   *peer
+	Number uint64
+	Hash   common.Hash
+	Depth  uint64
+}
+
 // PublicDownloaderAPI provides an API which gives information about the current synchronisation status.
 // It offers only methods that operates on data that can be available to anyone without security risks.
 type PublicDownloaderAPI struct {
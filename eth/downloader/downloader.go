@@ -54,6 +54,9 @@ var (
 	ttlScaling              = 3                 // Constant scaling factor for RTT -> TTL conversion
 	ttlLimit                = time.Minute       // Maximum TTL allowance to prevent reaching crazy timeouts
 
+	fetchHeightRetries = 2                      // Number of times the head header is requested again after a timeout
+	fetchHeightBackoff = 500 * time.Millisecond // Wait before the first head header retry, doubled for each further one
+
 	qosTuningPeers   = 5    // Number of peers to tune based on (best peers)
 	qosConfidenceCap = 10   // Number of peers above which not to modify RTT confidence
 	qosTuningImpact  = 0.25 // Impact that a new tuning target has on the previous value
@@ -62,11 +65,11 @@ var (
 	maxHeadersProcess = 2048      // Number of header download results to import at once into the chain
 	maxResultsProcess = 2048      // Number of content download results to import at once into the chain
 
-	fsHeaderCheckFrequency = 100             // Verification frequency of the downloaded headers during fast sync
-	fsHeaderSafetyNet      = 2048            // Number of headers to discard in case a chain violation is detected
-	fsHeaderForceVerify    = 24              // Number of headers to verify before and after the pivot to accept it
-	fsHeaderContCheck      = 3 * time.Second // Time interval to check for header continuations during state download
-	fsMinFullBlocks        = 64              // Number of blocks to retrieve fully even in fast sync
+	fsHeaderCheckFrequency = core.DefaultHeaderCheckFrequency // Verification frequency of the downloaded headers during fast sync
+	fsHeaderSafetyNet      = 2048                             // Number of headers to discard in case a chain violation is detected
+	fsHeaderForceVerify    = core.DefaultHeaderForceVerify    // Number of headers to verify before and after the pivot to accept it
+	fsHeaderContCheck      = 3 * time.Second                  // Time interval to check for header continuations during state download
+	fsMinFullBlocks        = 64                               // Number of blocks to retrieve fully even in fast sync
 )
 
 var (
@@ -147,6 +150,9 @@ type Downloader struct {
 	rttEstimate   uint64 // Round trip time to target for download requests
 	rttConfidence uint64 // Confidence in the estimated RTT (unit: millionths to allow atomic ops)
 
+	maxFetchPeers int32 // Maximum number of peers concurrently fetching block parts (0 = unlimited)
+	ttlLimit      int64 // Maximum TTL allowance of a single download request (unit: nanoseconds to allow atomic ops)
+
 	// Statistics
 	syncStatsChainOrigin uint64 // Origin block number where syncing started at
 	syncStatsChainHeight uint64 // Highest block number known when syncing started
@@ -257,6 +263,7 @@ func New(mode SyncMode, stateDb ethdb.Database, mux *event.TypeMux, chain BlockC
 		peers:          newPeerSet(),
 		rttEstimate:    uint64(rttMaxEstimate),
 		rttConfidence:  uint64(1000000),
+		ttlLimit:       int64(ttlLimit),
 		blockchain:     chain,
 		lightchain:     lightchain,
 		dropPeer:       dropPeer,
@@ -276,6 +283,18 @@ func New(mode SyncMode, stateDb ethdb.Database, mux *event.TypeMux, chain BlockC
 	return dl
 }
 
+// headerVerification returns the header verification frequency and the number of headers
+// before the pivot to verify fully during fast sync. Chains may override the defaults by
+// implementing HeaderVerification, as core.BlockChain does.
+func (d *Downloader) headerVerification() (checkFreq, forceVerify int) {
+	if hv, ok := d.lightchain.(interface {
+		HeaderVerification() (int, int)
+	}); ok {
+		return hv.HeaderVerification()
+	}
+	return fsHeaderCheckFrequency, fsHeaderForceVerify
+}
+
 func (d *Downloader) currentLocalChainHeight() (current uint64) {
 	current = d.lightchain.CurrentHeader().Number.Uint64() // "LightSync"
 	switch d.mode {
@@ -305,10 +324,22 @@ func (d *Downloader) Progress() (uint64, uint64, uint64, uint64, uint64) {
 func (d *Downloader) Qos() (rtt time.Duration, ttl time.Duration, conf float64) {
 	rtt = d.requestRTT()
 	ttl = d.requestTTL()
-	conf = float64(d.rttConfidence) / 1000000.0
+	conf = float64(atomic.LoadUint64(&d.rttConfidence)) / 1000000.0
 	return
 }
 
+// PeerStats retrieves a snapshot of the download statistics of all registered peers,
+// with capacities calculated against the current target round trip time.
+func (d *Downloader) PeerStats() []*PeerStats {
+	rtt := d.requestRTT()
+	peers := d.peers.AllPeers()
+	stats := make([]*PeerStats, len(peers))
+	for i, p := range peers {
+		stats[i] = p.Stats(rtt)
+	}
+	return stats
+}
+
 func (d *Downloader) GetMode() SyncMode {
 	return d.mode
 }
@@ -317,6 +348,82 @@ func (d *Downloader) GetPeers() *peerSet {
 	return d.peers
 }
 
+// SetMaxFetchPeers limits the number of peers concurrently used for fetching
+// block parts (skeleton headers, bodies and receipts). The highest capacity idle peers are preferred.
+// A value of zero (the default) removes the limit.
+func (d *Downloader) SetMaxFetchPeers(n int) {
+	if n < 0 {
+		n = 0
+	}
+	atomic.StoreInt32(&d.maxFetchPeers, int32(n))
+}
+
+// MaxFetchPeers returns the limit on the number of peers concurrently used for
+// fetching block parts, zero meaning unlimited.
+func (d *Downloader) MaxFetchPeers() int {
+	return int(atomic.LoadInt32(&d.maxFetchPeers))
+}
+
+// SetQoSBounds sets the window the estimated request round trip time is kept
+// in, and the maximum timeout allowance of a single request. Widening them
+// helps peers on high latency links not to be dropped for timeouts. Zero values
+// leave the respective default in place.
+func (d *Downloader) SetQoSBounds(rttMin, rttMax, ttlMax time.Duration) error {
+	if rttMin == 0 {
+		rttMin = rttMinEstimate
+	}
+	if rttMax == 0 {
+		rttMax = rttMaxEstimate
+	}
+	if ttlMax == 0 {
+		ttlMax = ttlLimit
+	}
+	if rttMin < 0 || rttMin >= rttMax {
+		return fmt.Errorf("minimum RTT %v must be positive and below maximum RTT %v", rttMin, rttMax)
+	}
+	if ttlMax < rttMax {
+		return fmt.Errorf("TTL limit %v must not be below maximum RTT %v", ttlMax, rttMax)
+	}
+	d.peers.setRTTBounds(rttMin, rttMax)
+	atomic.StoreInt64(&d.ttlLimit, int64(ttlMax))
+
+	// Keep the current estimate within the new window
+	rtt := time.Duration(atomic.LoadUint64(&d.rttEstimate))
+	if rtt < rttMin {
+		rtt = rttMin
+	}
+	if rtt > rttMax {
+		rtt = rttMax
+	}
+	atomic.StoreUint64(&d.rttEstimate, uint64(rtt))
+	return nil
+}
+
+// QoSBounds returns the window the estimated request round trip time is kept
+// in, and the maximum timeout allowance of a single request.
+func (d *Downloader) QoSBounds() (rttMin, rttMax, ttlMax time.Duration) {
+	rttMin, rttMax = d.peers.rttBounds()
+	return rttMin, rttMax, time.Duration(atomic.LoadInt64(&d.ttlLimit))
+}
+
+// fetchSlots returns how many of the given idle peers may be assigned a new
+// fetch request, given the total number of eligible peers and the configured
+// concurrency limit. Peers which are not idle are considered busy fetching.
+func (d *Downloader) fetchSlots(idle, total int) int {
+	max := d.MaxFetchPeers()
+	if max <= 0 {
+		return idle
+	}
+	slots := max - (total - idle)
+	if slots < 0 {
+		return 0
+	}
+	if slots > idle {
+		return idle
+	}
+	return slots
+}
+
 // Synchronising returns whether the downloader is currently retrieving blocks.
 func (d *Downloader) Synchronising() bool {
 	return atomic.LoadInt32(&d.synchronising) > 0
@@ -527,10 +634,19 @@ func (d *Downloader) syncWithPeer(p *peer, hash common.Hash, td *big.Int) (err e
 	}
 	height := latest.Number.Uint64()
 
-	origin, err := d.findAncestor(p, height)
+	origin, originHash, err := d.findAncestor(p, height)
 	if err != nil {
 		return err
 	}
+	var depth uint64
+	if local := d.currentLocalChainHeight(); local > origin {
+		depth = local - origin
+	}
+	if depth > 0 {
+		glog.V(logger.Info).Infof("%v: common ancestor #%d [%x…] is %d blocks below the local head", p, origin, originHash[:4], depth)
+	}
+	d.mux.Post(AncestorFoundEvent{p, origin, originHash, depth})
+
 	d.syncStatsLock.Lock()
 	if d.syncStatsChainHeight <= origin || d.syncStatsChainOrigin > origin {
 		d.syncStatsChainOrigin = origin
@@ -538,6 +654,18 @@ func (d *Downloader) syncWithPeer(p *peer, hash common.Hash, td *big.Int) (err e
 	d.syncStatsChainHeight = height
 	d.syncStatsLock.Unlock()
 
+	// Never fast sync below the current full head if the remote chain extends it,
+	// committing the pivot would rewind the head; a full sync continues from it
+	if d.mode == FastSync {
+		var limit uint64
+		if height > uint64(fsMinFullBlocks) {
+			limit = height - uint64(fsMinFullBlocks)
+		}
+		if head := d.blockchain.CurrentBlock().NumberU64(); head > 0 && origin >= head && limit <= head {
+			glog.V(logger.Info).Infof("Fast sync pivot #%d not above full head #%d, falling back to full sync", limit, head)
+			d.mode = FullSync
+		}
+	}
 	// Ensure our origin point is below any fast sync pivot point
 	if d.mode == FastSync {
 		if height <= uint64(fsMinFullBlocks) {
@@ -642,7 +770,28 @@ func (d *Downloader) Terminate() {
 
 // fetchHeight retrieves the head header of the remote peer to aid in estimating
 // the total time a pending synchronisation would take.
+//
+// A request that times out is retried up to fetchHeightRetries times, waiting
+// longer before each retry, so that a briefly lagging peer does not abort the sync.
 func (d *Downloader) fetchHeight(p *peer) (*types.Header, error) {
+	backoff := fetchHeightBackoff
+	for attempt := 0; ; attempt++ {
+		header, err := d.requestHeight(p)
+		if err != errTimeout || attempt == fetchHeightRetries {
+			return header, err
+		}
+		glog.V(logger.Debug).Infof("%v: retrying head header request in %v", p, backoff)
+		select {
+		case <-d.cancelCh:
+			return nil, errCancelBlockFetch
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// requestHeight requests the head header of a peer once and waits for the response.
+func (d *Downloader) requestHeight(p *peer) (*types.Header, error) {
 	glog.V(logger.Debug).Infof("%v: retrieving remote chain height", p)
 
 	// Request the advertised remote head block and wait for the response
@@ -691,7 +840,8 @@ func (d *Downloader) fetchHeight(p *peer) (*types.Header, error) {
 // on the correct chain, checking the top N links should already get us a match.
 // In the rare scenario when we ended up on a long reorganisation (i.e. none of
 // the head links match), we do a binary search to find the common ancestor.
-func (d *Downloader) findAncestor(p *peer, height uint64) (uint64, error) {
+// The hash of the ancestor is empty if the search ends at its lower bound.
+func (d *Downloader) findAncestor(p *peer, height uint64) (uint64, common.Hash, error) {
 	glog.V(logger.Debug).Infof("%v: looking for common ancestor (remote height %d)", p, height)
 	// Figure out the valid ancestor range to prevent rewrite attacks
 	floor, ceil := int64(-1), d.currentLocalChainHeight()
@@ -725,7 +875,7 @@ func (d *Downloader) findAncestor(p *peer, height uint64) (uint64, error) {
 	for finished := false; !finished; {
 		select {
 		case <-d.cancelCh:
-			return 0, errCancelHeaderFetch
+			return 0, common.Hash{}, errCancelHeaderFetch
 
 		case packet := <-d.headerCh:
 			// Discard anything not from the origin peer
@@ -737,13 +887,13 @@ func (d *Downloader) findAncestor(p *peer, height uint64) (uint64, error) {
 			headers := packet.(*headerPack).headers
 			if len(headers) == 0 {
 				glog.V(logger.Debug).Warnln("Empty head header set")
-				return 0, errEmptyHeaderSet
+				return 0, common.Hash{}, errEmptyHeaderSet
 			}
 			// Make sure the peer's reply conforms to the request
 			for i := 0; i < len(headers); i++ {
 				if number := headers[i].Number.Int64(); number != from+int64(i)*16 {
 					glog.V(logger.Debug).Warnln("Head headers broke chain ordering", "index", i, "requested", from+int64(i)*16, "received", number)
-					return 0, errInvalidChain
+					return 0, common.Hash{}, errInvalidChain
 				}
 			}
 			// Check if a common ancestor was found
@@ -760,7 +910,7 @@ func (d *Downloader) findAncestor(p *peer, height uint64) (uint64, error) {
 					// If every header is known, even future ones, the peer straight out lied about its head
 					if number > height && i == limit-1 {
 						glog.V(logger.Debug).Warnln("Lied about chain head", "reported", height, "found", number)
-						return 0, errStallingPeer
+						return 0, common.Hash{}, errStallingPeer
 					}
 					break
 				}
@@ -768,7 +918,7 @@ func (d *Downloader) findAncestor(p *peer, height uint64) (uint64, error) {
 
 		case <-timeout:
 			glog.V(logger.Debug).Warnln("Waiting for head header timed out", "elapsed", ttl)
-			return 0, errTimeout
+			return 0, common.Hash{}, errTimeout
 
 		case <-d.bodyCh:
 		case <-d.receiptCh:
@@ -779,10 +929,10 @@ func (d *Downloader) findAncestor(p *peer, height uint64) (uint64, error) {
 	if !common.EmptyHash(hash) {
 		if int64(number) <= floor {
 			glog.V(logger.Debug).Warnln("Ancestor below allowance", "number", number, "hash", hash, "allowance", floor)
-			return 0, errInvalidAncestor
+			return 0, common.Hash{}, errInvalidAncestor
 		}
 		glog.V(logger.Debug).Warnln("Found common ancestor", "number", number, "hash", hash)
-		return number, nil
+		return number, hash, nil
 	}
 	// Ancestor not found, we need to binary search over our chain
 	start, end := uint64(0), head
@@ -802,7 +952,7 @@ func (d *Downloader) findAncestor(p *peer, height uint64) (uint64, error) {
 		for arrived := false; !arrived; {
 			select {
 			case <-d.cancelCh:
-				return 0, errCancelHeaderFetch
+				return 0, common.Hash{}, errCancelHeaderFetch
 
 			case packer := <-d.headerCh:
 				// Discard anything not from the origin peer
@@ -814,7 +964,7 @@ func (d *Downloader) findAncestor(p *peer, height uint64) (uint64, error) {
 				headers := packer.(*headerPack).headers
 				if len(headers) != 1 {
 					glog.V(logger.Debug).Warnln("Multiple headers for single request", "headers", len(headers))
-					return 0, errBadPeer
+					return 0, common.Hash{}, errBadPeer
 				}
 				arrived = true
 
@@ -826,13 +976,13 @@ func (d *Downloader) findAncestor(p *peer, height uint64) (uint64, error) {
 				header := d.lightchain.GetHeaderByHash(headers[0].Hash()) // Independent of sync mode, header surely exists
 				if header.Number.Uint64() != check {
 					glog.V(logger.Debug).Warnln("Received non requested header", "number", header.Number, "hash", header.Hash(), "request", check)
-					return 0, errBadPeer
+					return 0, common.Hash{}, errBadPeer
 				}
-				start = check
+				start, hash = check, header.Hash()
 
 			case <-timeout:
 				glog.V(logger.Debug).Warnln("Waiting for search header timed out", "elapsed", ttl)
-				return 0, errTimeout
+				return 0, common.Hash{}, errTimeout
 
 			case <-d.bodyCh:
 			case <-d.receiptCh:
@@ -843,10 +993,10 @@ func (d *Downloader) findAncestor(p *peer, height uint64) (uint64, error) {
 	// Ensure valid ancestry and return
 	if int64(start) <= floor {
 		glog.V(logger.Debug).Infoln("Ancestor below allowance", "number", start, "hash", hash, "allowance", floor)
-		return 0, errInvalidAncestor
+		return 0, common.Hash{}, errInvalidAncestor
 	}
 	glog.V(logger.Debug).Infoln("Found common ancestor", "number", start, "hash", hash)
-	return start, nil
+	return start, hash, nil
 }
 
 // fetchHeaders keeps retrieving headers concurrently from the number
@@ -1186,8 +1336,15 @@ func (d *Downloader) fetchParts(errCancel error, deliveryCh chan dataPack, deliv
 			// Send a download request to all idle peers, until throttled
 			progressed, throttled, running := false, false, inFlight()
 			idles, total := idle()
+			slots := d.fetchSlots(len(idles), total)
 
 			for _, peer := range idles {
+				// Short circuit if the concurrent peer limit is reached, the idle
+				// peers are sorted by throughput so the best ones were used
+				if slots == 0 {
+					throttled = true
+					break
+				}
 				// Short circuit if throttling activated
 				if throttle() {
 					throttled = true
@@ -1228,6 +1385,7 @@ func (d *Downloader) fetchParts(errCancel error, deliveryCh chan dataPack, deliv
 					panic(fmt.Sprintf("%v: %s fetch assignment failed", peer, kind))
 				}
 				running = true
+				slots--
 			}
 			// Make sure that we have peers available for fetching. If all peers have been tried
 			// and all failed throw an error
@@ -1349,11 +1507,14 @@ func (d *Downloader) processHeaders(origin uint64, pivot uint64, td *big.Int) er
 						}
 					}
 					// If we're importing pure headers, verify based on their recentness
-					frequency := fsHeaderCheckFrequency
-					if chunk[len(chunk)-1].Number.Uint64()+uint64(fsHeaderForceVerify) > pivot {
+					frequency, forceVerify := d.headerVerification()
+					if chunk[len(chunk)-1].Number.Uint64()+uint64(forceVerify) > pivot {
 						frequency = 1
 					}
 					res := d.lightchain.InsertHeaderChain(chunk, frequency)
+					if res.Aborted {
+						return errCancelHeaderProcessing
+					}
 					// TODO(whilei): again, send error to events
 					if res.Error != nil {
 						// If some headers were inserted, add them too to the rollback list
@@ -1447,6 +1608,9 @@ func (d *Downloader) importBlockResults(results []*fetchResult) error {
 	}
 
 	res := d.blockchain.InsertChain(blocks)
+	if res.Aborted {
+		return errCancelContentProcessing
+	}
 	if res.Error != nil {
 		glog.V(logger.Debug).Infoln("Downloaded item processing failed", "number", results[res.Index].Header.Number, "hash", results[res.Index].Header.Hash(), "err", res.Error)
 		return errInvalidChain
@@ -1592,6 +1756,9 @@ func (d *Downloader) commitFastSyncData(results []*fetchResult, stateSync *state
 		receipts[i] = result.Receipts
 	}
 	res := d.blockchain.InsertReceiptChain(blocks, receipts)
+	if res.Aborted {
+		return errCancelContentProcessing
+	}
 	if res.Error != nil {
 		glog.V(logger.Debug).Infoln("Downloaded item processing failed", "number", results[res.Index].Header.Number, "hash", results[res.Index].Header.Hash(), "err", res.Error)
 		return errInvalidChain
@@ -1605,6 +1772,9 @@ func (d *Downloader) commitPivotBlock(result *fetchResult) error {
 	block := types.NewBlockWithHeader(result.Header).WithBody(result.Transactions, result.Uncles)
 	glog.V(logger.Debug).Infoln("Committing fast sync pivot as new head", "number", block.Number(), "hash", block.Hash())
 	res := d.blockchain.InsertReceiptChain([]*types.Block{block}, []types.Receipts{result.Receipts})
+	if res.Aborted {
+		return errCancelContentProcessing
+	}
 	if res.Error != nil {
 		return res.Error
 	}
@@ -1739,8 +1909,8 @@ func (d *Downloader) requestTTL() time.Duration {
 		conf = float64(atomic.LoadUint64(&d.rttConfidence)) / 1000000.0
 	)
 	ttl := time.Duration(ttlScaling) * time.Duration(float64(rtt)/conf)
-	if ttl > ttlLimit {
-		ttl = ttlLimit
+	if limit := time.Duration(atomic.LoadInt64(&d.ttlLimit)); ttl > limit {
+		ttl = limit
 	}
 	return ttl
 }
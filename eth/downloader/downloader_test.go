@@ -1065,6 +1065,57 @@ func testMultiSynchronisation(t *testing.T, protocol int, mode SyncMode) {
 	assertOwnChain(t, tester, targetBlocks+1)
 }
 
+// Tests that synchronisation from multiple peers works when the number of peers
+// concurrently fetching block parts is limited.
+func TestMaxFetchPeersSynchronisation62(t *testing.T)     { testMaxFetchPeersSynchronisation(t, 62, FullSync) }
+func TestMaxFetchPeersSynchronisation63Full(t *testing.T) { testMaxFetchPeersSynchronisation(t, 63, FullSync) }
+func TestMaxFetchPeersSynchronisation63Fast(t *testing.T) { testMaxFetchPeersSynchronisation(t, 63, FastSync) }
+func TestMaxFetchPeersSynchronisation64Full(t *testing.T) { testMaxFetchPeersSynchronisation(t, 64, FullSync) }
+func TestMaxFetchPeersSynchronisation64Fast(t *testing.T) { testMaxFetchPeersSynchronisation(t, 64, FastSync) }
+
+func testMaxFetchPeersSynchronisation(t *testing.T, protocol int, mode SyncMode) {
+	t.Parallel()
+
+	tester := newTester()
+	defer tester.terminate()
+
+	tester.downloader.SetMaxFetchPeers(2)
+
+	targetPeers := 8
+	targetBlocks := 4*blockCacheItems - 15
+	hashes, headers, blocks, receipts := tester.makeChain(targetBlocks, 0, tester.genesis, nil, false)
+
+	for i := 0; i < targetPeers; i++ {
+		tester.newPeer(fmt.Sprintf("peer #%d", i), protocol, hashes, headers, blocks, receipts)
+	}
+	if err := tester.sync("peer #0", nil, mode); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	assertOwnChain(t, tester, targetBlocks+1)
+}
+
+// Tests the number of peers allowed a new fetch under the concurrency limit.
+func TestFetchSlots(t *testing.T) {
+	tester := newTester()
+	defer tester.terminate()
+
+	tests := []struct {
+		max, idle, total, want int
+	}{
+		{0, 5, 10, 5},
+		{3, 5, 5, 3},
+		{3, 5, 6, 2},
+		{3, 2, 10, 0},
+		{10, 4, 6, 4},
+	}
+	for i, tt := range tests {
+		tester.downloader.SetMaxFetchPeers(tt.max)
+		if got := tester.downloader.fetchSlots(tt.idle, tt.total); got != tt.want {
+			t.Errorf("test %d: fetch slots mismatch: have %d, want %d", i, got, tt.want)
+		}
+	}
+}
+
 // Tests that synchronisations behave well in multi-version protocol environments
 // and not wreak havoc on other nodes in the network.
 func TestMultiProtoSynchronisation62(t *testing.T)      { testMultiProtoSync(t, 62, FullSync) }
@@ -1801,3 +1852,194 @@ func testDeliverHeadersHang(t *testing.T, protocol int, mode SyncMode) {
 		//tester.downloader.peers.peers["peer"]
 	}
 }
+
+// Tests that peer download statistics are reported for registered peers, and
+// reflect the throughput measured after a successful sync.
+func TestPeerStats(t *testing.T) {
+	t.Parallel()
+
+	tester := newTester()
+	defer tester.terminate()
+
+	targetBlocks := blockCacheItems - 15
+	hashes, headers, blocks, receipts := tester.makeChain(targetBlocks, 0, tester.genesis, nil, false)
+	tester.newPeer("peer", 63, hashes, headers, blocks, receipts)
+
+	stats := tester.downloader.PeerStats()
+	if len(stats) != 1 {
+		t.Fatalf("peer stats count mismatch: have %d, want %d", len(stats), 1)
+	}
+	if s := stats[0]; s.ID != "peer" || s.Version != 63 || !s.HeaderIdle || !s.BlockIdle || !s.ReceiptIdle || !s.StateIdle {
+		t.Errorf("unexpected initial peer stats: %+v", s)
+	}
+
+	if err := tester.sync("peer", nil, FullSync); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	stats = tester.downloader.PeerStats()
+	if len(stats) != 1 {
+		t.Fatalf("peer stats count mismatch: have %d, want %d", len(stats), 1)
+	}
+	if s := stats[0]; s.HeaderThroughput == 0 || s.BlockThroughput == 0 || s.HeaderCapacity < 1 || s.BlockCapacity < 1 {
+		t.Errorf("expected measured throughput after sync: %+v", s)
+	}
+}
+
+// Tests that a fast sync whose pivot would be below the current full head falls
+// back to full sync instead of rewinding the head.
+func TestFastSyncBelowFullHeadFallback63(t *testing.T) { testFastSyncBelowFullHeadFallback(t, 63) }
+func TestFastSyncBelowFullHeadFallback64(t *testing.T) { testFastSyncBelowFullHeadFallback(t, 64) }
+
+func testFastSyncBelowFullHeadFallback(t *testing.T, protocol int) {
+	t.Parallel()
+
+	tester := newTester()
+	defer tester.terminate()
+
+	targetBlocks := blockCacheItems - 15
+	hashes, headers, blocks, receipts := tester.makeChain(targetBlocks, 0, tester.genesis, nil, false)
+
+	// Full sync most of the chain, leaving less than a pivot distance behind
+	tester.newPeer("partial", protocol, hashes[fsMinFullBlocks/2:], headers, blocks, receipts)
+	if err := tester.sync("partial", nil, FullSync); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	assertOwnChain(t, tester, targetBlocks-fsMinFullBlocks/2+1)
+
+	tester.newPeer("full", protocol, hashes, headers, blocks, receipts)
+	if err := tester.sync("full", nil, FastSync); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	if tester.downloader.mode != FullSync {
+		t.Errorf("sync mode mismatch: have %v, want %v", tester.downloader.mode, FullSync)
+	}
+	assertOwnChain(t, tester, targetBlocks+1)
+}
+
+// Tests that the head header request is retried after a timeout, and that the
+// retrieval fails with a timeout once the retries are used up.
+func TestFetchHeightRetry(t *testing.T) {
+	// Shorten the request timeouts to keep the test fast
+	defer func(min, max, backoff time.Duration) {
+		rttMinEstimate, rttMaxEstimate, fetchHeightBackoff = min, max, backoff
+	}(rttMinEstimate, rttMaxEstimate, fetchHeightBackoff)
+	rttMinEstimate, rttMaxEstimate, fetchHeightBackoff = 20*time.Millisecond, 20*time.Millisecond, 10*time.Millisecond
+
+	for lost := 0; lost <= fetchHeightRetries+1; lost++ {
+		tester := newTester()
+		tester.downloader.cancelLock.Lock()
+		tester.downloader.cancelCh = make(chan struct{})
+		tester.downloader.cancelLock.Unlock()
+
+		hashes, headers, blocks, receipts := tester.makeChain(5, 0, tester.genesis, nil, false)
+		tester.newPeer("peer", 63, hashes, headers, blocks, receipts)
+
+		// Ignore the first requests for the head header
+		peer := tester.downloader.peers.Peer("peer")
+		getRelHeaders, requests := peer.getRelHeaders, int32(0)
+		peer.getRelHeaders = func(origin common.Hash, amount int, skip int, reverse bool) error {
+			if atomic.AddInt32(&requests, 1) <= int32(lost) {
+				return nil
+			}
+			return getRelHeaders(origin, amount, skip, reverse)
+		}
+		head, err := tester.downloader.fetchHeight(peer)
+		if lost > fetchHeightRetries {
+			if err != errTimeout {
+				t.Errorf("%d lost: error mismatch: have %v, want %v", lost, err, errTimeout)
+			}
+		} else if err != nil {
+			t.Errorf("%d lost: failed to fetch height: %v", lost, err)
+		} else if head.Hash() != hashes[0] {
+			t.Errorf("%d lost: head mismatch: have %x, want %x", lost, head.Hash(), hashes[0])
+		}
+		if have := int(atomic.LoadInt32(&requests)); have != lost+1 && lost <= fetchHeightRetries {
+			t.Errorf("%d lost: request count mismatch: have %d, want %d", lost, have, lost+1)
+		}
+		tester.terminate()
+	}
+}
+
+// Tests that the common ancestor of a fork is announced with its distance from the
+// local head.
+func TestAncestorFoundEvent(t *testing.T) {
+	tester := newTester()
+	defer tester.terminate()
+
+	// The ancestor search samples every 16th block, let the fork point be one
+	common, fork := 32, 20
+	hashesA, hashesB, headersA, headersB, blocksA, blocksB, receiptsA, receiptsB := tester.makeChainFork(common+fork, fork, tester.genesis, nil, true)
+	tester.newPeer("fork A", 63, hashesA, headersA, blocksA, receiptsA)
+	tester.newPeer("fork B", 63, hashesB, headersB, blocksB, receiptsB)
+
+	if err := tester.sync("fork A", nil, FullSync); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	sub := tester.downloader.mux.Subscribe(AncestorFoundEvent{})
+	defer sub.Unsubscribe()
+	events := make(chan AncestorFoundEvent, 1)
+	go func() {
+		if ev, ok := <-sub.Chan(); ok {
+			events <- ev.Data.(AncestorFoundEvent)
+		}
+	}()
+	if err := tester.sync("fork B", nil, FullSync); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	select {
+	case ev := <-events:
+		if ev.Number != uint64(common) || ev.Hash != hashesA[fork] || ev.Depth != uint64(fork) {
+			t.Errorf("ancestor mismatch: have #%d [%x] depth %d, want #%d [%x] depth %d", ev.Number, ev.Hash, ev.Depth, common, hashesA[fork], fork)
+		}
+		if ev.Peer.id != "fork B" {
+			t.Errorf("peer mismatch: have %s, want %s", ev.Peer.id, "fork B")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no ancestor event posted")
+	}
+}
+
+// Tests that the QoS bounds are validated, and that the estimated RTT and the
+// request TTL are kept within them.
+func TestQoSBounds(t *testing.T) {
+	tester := newTester()
+	defer tester.terminate()
+
+	invalid := [][3]time.Duration{
+		{30 * time.Second, 0, 0},                         // minimum above default maximum
+		{time.Second, time.Second, 2 * time.Second},      // empty window
+		{-time.Second, 0, 0},                             // negative minimum
+		{0, 2 * time.Minute, 0},                          // maximum above default TTL limit
+		{time.Second, 10 * time.Second, 5 * time.Second}, // TTL limit below maximum
+	}
+	for i, tt := range invalid {
+		if err := tester.downloader.SetQoSBounds(tt[0], tt[1], tt[2]); err == nil {
+			t.Errorf("test %d: bounds %v accepted", i, tt)
+		}
+	}
+	if min, max, ttl := tester.downloader.QoSBounds(); min != rttMinEstimate || max != rttMaxEstimate || ttl != ttlLimit {
+		t.Fatalf("bounds changed by invalid values: have %v/%v/%v, want %v/%v/%v", min, max, ttl, rttMinEstimate, rttMaxEstimate, ttlLimit)
+	}
+	// Widen the window and ensure the median RTT and the TTL follow it
+	if err := tester.downloader.SetQoSBounds(5*time.Second, time.Minute, 5*time.Minute); err != nil {
+		t.Fatalf("failed to set bounds: %v", err)
+	}
+	if rtt := tester.downloader.peers.medianRTT(); rtt != time.Minute {
+		t.Errorf("median RTT mismatch: have %v, want %v", rtt, time.Minute)
+	}
+	atomic.StoreUint64(&tester.downloader.rttEstimate, uint64(time.Minute))
+	atomic.StoreUint64(&tester.downloader.rttConfidence, 1000000)
+	if ttl := tester.downloader.requestTTL(); ttl != 3*time.Minute {
+		t.Errorf("request TTL mismatch: have %v, want %v", ttl, 3*time.Minute)
+	}
+	// Narrow the window and ensure the estimate is pulled into it
+	if err := tester.downloader.SetQoSBounds(time.Second, 10*time.Second, 15*time.Second); err != nil {
+		t.Fatalf("failed to set bounds: %v", err)
+	}
+	if rtt := time.Duration(atomic.LoadUint64(&tester.downloader.rttEstimate)); rtt != 10*time.Second {
+		t.Errorf("RTT estimate mismatch: have %v, want %v", rtt, 10*time.Second)
+	}
+	if ttl := tester.downloader.requestTTL(); ttl != 15*time.Second {
+		t.Errorf("request TTL mismatch: have %v, want %v", ttl, 15*time.Second)
+	}
+}
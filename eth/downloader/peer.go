@@ -308,6 +308,62 @@ func (p *peer) NodeDataCapacity(targetRTT time.Duration) int {
 	return int(math.Min(1+math.Max(1, p.stateThroughput*float64(targetRTT)/float64(time.Second)), float64(MaxStateFetch)))
 }
 
+// PeerStats is a snapshot of a download peer's measured activity and throughput.
+// Capacities are the number of items which would be requested from the peer in a
+// single fetch at the downloader's current target round trip time.
+type PeerStats struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Version int    `json:"version"`
+
+	HeaderIdle  bool `json:"headerIdle"`
+	BlockIdle   bool `json:"blockIdle"`
+	ReceiptIdle bool `json:"receiptIdle"`
+	StateIdle   bool `json:"stateIdle"`
+
+	HeaderCapacity  int `json:"headerCapacity"`
+	BlockCapacity   int `json:"blockCapacity"`
+	ReceiptCapacity int `json:"receiptCapacity"`
+	StateCapacity   int `json:"stateCapacity"`
+
+	HeaderThroughput  float64 `json:"headerThroughput"`
+	BlockThroughput   float64 `json:"blockThroughput"`
+	ReceiptThroughput float64 `json:"receiptThroughput"`
+	StateThroughput   float64 `json:"stateThroughput"`
+
+	RTT time.Duration `json:"rtt"`
+}
+
+// Stats retrieves a snapshot of the peer's download statistics, with capacities
+// calculated against the given target round trip time.
+func (p *peer) Stats(targetRTT time.Duration) *PeerStats {
+	stats := &PeerStats{
+		HeaderIdle:  atomic.LoadInt32(&p.headerIdle) == 0,
+		BlockIdle:   atomic.LoadInt32(&p.blockIdle) == 0,
+		ReceiptIdle: atomic.LoadInt32(&p.receiptIdle) == 0,
+		StateIdle:   atomic.LoadInt32(&p.stateIdle) == 0,
+
+		HeaderCapacity:  p.HeaderCapacity(targetRTT),
+		BlockCapacity:   p.BlockCapacity(targetRTT),
+		ReceiptCapacity: p.ReceiptCapacity(targetRTT),
+		StateCapacity:   p.NodeDataCapacity(targetRTT),
+	}
+
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	stats.ID = p.id
+	stats.Name = p.name
+	stats.Version = p.version
+	stats.HeaderThroughput = p.headerThroughput
+	stats.BlockThroughput = p.blockThroughput
+	stats.ReceiptThroughput = p.receiptThroughput
+	stats.StateThroughput = p.stateThroughput
+	stats.RTT = p.rtt
+
+	return stats
+}
+
 // MarkLacking appends a new entity to the set of items (blocks, receipts, states)
 // that a peer is known not to have (i.e. have been requested before). If the
 // set reaches its maximum allowed capacity, items are randomly dropped off.
@@ -357,16 +413,36 @@ type peerSet struct {
 	peers        map[string]*peer
 	newPeerFeed  event.Feed
 	peerDropFeed event.Feed
+	rttMin       time.Duration // Lower bound of the median RTT used as a QoS target
+	rttMax       time.Duration // Upper bound of the median RTT used as a QoS target
 	lock         sync.RWMutex
 }
 
 // newPeerSet creates a new peer set top track the active download sources.
 func newPeerSet() *peerSet {
 	return &peerSet{
-		peers: make(map[string]*peer),
+		peers:  make(map[string]*peer),
+		rttMin: rttMinEstimate,
+		rttMax: rttMaxEstimate,
 	}
 }
 
+// setRTTBounds sets the window the median RTT of the peer set is restricted to.
+func (ps *peerSet) setRTTBounds(min, max time.Duration) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	ps.rttMin, ps.rttMax = min, max
+}
+
+// rttBounds returns the window the median RTT of the peer set is restricted to.
+func (ps *peerSet) rttBounds() (min, max time.Duration) {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	return ps.rttMin, ps.rttMax
+}
+
 // SubscribeNewPeers subscribes to peer arrival events.
 func (ps *peerSet) SubscribeNewPeers(ch chan<- *peer) event.Subscription {
 	return ps.newPeerFeed.Subscribe(ch)
@@ -581,18 +657,18 @@ func (ps *peerSet) medianRTT() time.Duration {
 	}
 	sort.Float64s(rtts)
 
-	median := rttMaxEstimate
+	median := ps.rttMax
 	if qosTuningPeers <= len(rtts) {
 		median = time.Duration(rtts[qosTuningPeers/2]) // Median of our tuning peers
 	} else if len(rtts) > 0 {
 		median = time.Duration(rtts[len(rtts)/2]) // Median of our connected peers (maintain even like this some baseline qos)
 	}
 	// Restrict the RTT into some QoS defaults, irrelevant of true RTT
-	if median < rttMinEstimate {
-		median = rttMinEstimate
+	if median < ps.rttMin {
+		median = ps.rttMin
 	}
-	if median > rttMaxEstimate {
-		median = rttMaxEstimate
+	if median > ps.rttMax {
+		median = ps.rttMax
 	}
 	return median
 }
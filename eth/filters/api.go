@@ -27,6 +27,7 @@ import (
 	"time"
 
 	"github.com/ethereumproject/go-ethereum/common"
+	"github.com/ethereumproject/go-ethereum/core"
 	"github.com/ethereumproject/go-ethereum/core/types"
 	"github.com/ethereumproject/go-ethereum/core/vm"
 	"github.com/ethereumproject/go-ethereum/ethdb"
@@ -38,6 +39,10 @@ var (
 	filterTickerTime = 5 * time.Minute
 )
 
+// DefaultMaxLogs is the default maximum number of logs returned by eth_getLogs and
+// eth_getFilterLogs.
+const DefaultMaxLogs = 10000
+
 // byte will be inferred
 const (
 	unknownFilterTy = iota
@@ -67,6 +72,9 @@ type PublicFilterAPI struct {
 
 	transactionMu    sync.RWMutex
 	transactionQueue map[int]*hashQueue
+
+	maxLogs      int      // Maximum number of logs returned by a log query (0 = unlimited)
+	mipmapLevels []uint64 // Levels of the log bloom bins in chainDb
 }
 
 // NewPublicFilterAPI returns a new PublicFilterAPI instance.
@@ -79,11 +87,31 @@ func NewPublicFilterAPI(chainDb ethdb.Database, mux *event.TypeMux) *PublicFilte
 		logQueue:         make(map[int]*logQueue),
 		blockQueue:       make(map[int]*hashQueue),
 		transactionQueue: make(map[int]*hashQueue),
+		mipmapLevels:     core.DefaultMIPMapLevels,
 	}
 	go svc.start()
 	return svc
 }
 
+// SetMaxLogs limits the number of logs GetLogs and GetFilterLogs return, zero for unlimited.
+// Queries matching more logs fail with a *LogLimitError.
+func (s *PublicFilterAPI) SetMaxLogs(n int) {
+	s.maxLogs = n
+}
+
+// SetMipmapLevels sets the levels the log bloom bins in the chain database were written
+// with, see core.BlockChain.MipmapLevels.
+func (s *PublicFilterAPI) SetMipmapLevels(levels []uint64) {
+	s.mipmapLevels = levels
+}
+
+// newFilter creates a filter on the chain database.
+func (s *PublicFilterAPI) newFilter() *Filter {
+	filter := New(s.chainDb)
+	filter.SetMipmapLevels(s.mipmapLevels)
+	return filter
+}
+
 // Stop quits the work loop.
 func (s *PublicFilterAPI) Stop() {
 	close(s.quit)
@@ -143,7 +171,7 @@ func (s *PublicFilterAPI) NewBlockFilter() (string, error) {
 		return "", err
 	}
 
-	filter := New(s.chainDb)
+	filter := s.newFilter()
 	id, err := s.filterManager.Add(filter, ChainFilter)
 	if err != nil {
 		return "", err
@@ -180,7 +208,7 @@ func (s *PublicFilterAPI) NewPendingTransactionFilter() (string, error) {
 		return "", err
 	}
 
-	filter := New(s.chainDb)
+	filter := s.newFilter()
 	id, err := s.filterManager.Add(filter, PendingTxFilter)
 	if err != nil {
 		return "", err
@@ -212,7 +240,7 @@ func (s *PublicFilterAPI) newLogFilter(earliest, latest int64, addresses []commo
 	s.filterManager.Lock()
 	defer s.filterManager.Unlock()
 
-	filter := New(s.chainDb)
+	filter := s.newFilter()
 	id, err := s.filterManager.Add(filter, LogFilter)
 	if err != nil {
 		return 0, err
@@ -439,14 +467,18 @@ func (s *PublicFilterAPI) NewFilter(args NewFilterArgs) (string, error) {
 }
 
 // GetLogs returns the logs matching the given argument.
-func (s *PublicFilterAPI) GetLogs(args NewFilterArgs) []vmlog {
-	filter := New(s.chainDb)
+func (s *PublicFilterAPI) GetLogs(args NewFilterArgs) ([]vmlog, error) {
+	filter := s.newFilter()
 	filter.SetBeginBlock(args.FromBlock.Int64())
 	filter.SetEndBlock(args.ToBlock.Int64())
 	filter.SetAddresses(args.Addresses)
 	filter.SetTopics(args.Topics)
 
-	return toRPCLogs(filter.Find(), false)
+	logs, err := filter.FindLimited(s.maxLogs)
+	if err != nil {
+		return nil, err
+	}
+	return toRPCLogs(logs, false), nil
 }
 
 // UninstallFilter removes the filter with the given filter id.
@@ -540,19 +572,23 @@ func (s *PublicFilterAPI) logFilterChanged(id int) []vmlog {
 }
 
 // GetFilterLogs returns the logs for the filter with the given id.
-func (s *PublicFilterAPI) GetFilterLogs(filterId string) []vmlog {
+func (s *PublicFilterAPI) GetFilterLogs(filterId string) ([]vmlog, error) {
 	s.filterMapMu.RLock()
 	id, ok := s.filterMapping[filterId]
 	s.filterMapMu.RUnlock()
 	if !ok {
-		return toRPCLogs(nil, false)
+		return toRPCLogs(nil, false), nil
 	}
 
 	if filter := s.filterManager.Get(id); filter != nil {
-		return toRPCLogs(filter.Find(), false)
+		logs, err := filter.FindLimited(s.maxLogs)
+		if err != nil {
+			return nil, err
+		}
+		return toRPCLogs(logs, false), nil
 	}
 
-	return toRPCLogs(nil, false)
+	return toRPCLogs(nil, false), nil
 }
 
 // GetFilterChanges returns the logs for the filter with the given id since last time is was called.
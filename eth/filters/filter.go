@@ -17,6 +17,7 @@
 package filters
 
 import (
+	"fmt"
 	"math"
 	"time"
 
@@ -27,6 +28,15 @@ import (
 	"github.com/ethereumproject/go-ethereum/ethdb"
 )
 
+// LogLimitError is returned by FindLimited if more logs than the limit match.
+type LogLimitError struct {
+	Limit int
+}
+
+func (e *LogLimitError) Error() string {
+	return fmt.Sprintf("query returned more than %d results", e.Limit)
+}
+
 type AccountChange struct {
 	Address, StateAddress []byte
 }
@@ -36,6 +46,7 @@ type Filter struct {
 	created time.Time
 
 	db         ethdb.Database
+	levels     []uint64 // levels of the log bloom bins in db
 	begin, end int64
 	addresses  []common.Address
 	topics     [][]common.Hash
@@ -48,7 +59,13 @@ type Filter struct {
 // Create a new filter which uses a bloom filter on blocks to figure out whether a particular block
 // is interesting or not.
 func New(db ethdb.Database) *Filter {
-	return &Filter{db: db}
+	return &Filter{db: db, levels: core.DefaultMIPMapLevels}
+}
+
+// SetMipmapLevels sets the levels the log bloom bins in the database were written
+// with, see core.BlockChain.MipmapLevels.
+func (self *Filter) SetMipmapLevels(levels []uint64) {
+	self.levels = levels
 }
 
 // Set the earliest and latest block for filtering.
@@ -72,6 +89,25 @@ func (self *Filter) SetTopics(topics [][]common.Hash) {
 
 // Run filters logs with the current parameters set
 func (self *Filter) Find() vm.Logs {
+	return self.find(-1)
+}
+
+// FindLimited filters logs like Find, but returns a *LogLimitError as soon as more
+// than limit logs match. Zero means unlimited.
+func (self *Filter) FindLimited(limit int) (vm.Logs, error) {
+	if limit <= 0 {
+		return self.find(-1), nil
+	}
+	logs := self.find(limit)
+	if limitExceeded(logs, limit) {
+		return nil, &LogLimitError{Limit: limit}
+	}
+	return logs, nil
+}
+
+// find returns the matching logs, stopping once more than limit were found
+// unless limit is negative.
+func (self *Filter) find(limit int) vm.Logs {
 	latestBlock := core.GetBlock(self.db, core.GetHeadBlockHash(self.db))
 	if latestBlock == nil {
 		return vm.Logs{}
@@ -89,16 +125,31 @@ func (self *Filter) Find() vm.Logs {
 	// uses the mipmap bloom filters to check for fast inclusion and uses
 	// higher range probability in order to ensure at least a false positive
 	if len(self.addresses) == 0 {
-		return self.getLogs(beginBlockNo, endBlockNo)
+		return self.getLogs(beginBlockNo, endBlockNo, limit)
+	}
+	return self.mipFind(beginBlockNo, endBlockNo, mipmapDepth(self.levels, beginBlockNo, endBlockNo), limit)
+}
+
+// mipmapDepth returns the index of the level to start a bloom bin search of the given
+// range at: the coarsest level not wider than the range, so that few bins are checked
+// at each level.
+func mipmapDepth(levels []uint64, start, end uint64) int {
+	if end < start {
+		return len(levels) - 1
+	}
+	for i, level := range levels {
+		if level <= end-start+1 {
+			return i
+		}
 	}
-	return self.mipFind(beginBlockNo, endBlockNo, 0)
+	return len(levels) - 1
 }
 
-func (self *Filter) mipFind(start, end uint64, depth int) (logs vm.Logs) {
-	level := core.MIPMapLevels[depth]
+func (self *Filter) mipFind(start, end uint64, depth int, limit int) (logs vm.Logs) {
+	level := self.levels[depth]
 	// normalise numerator so we can work in level specific batches and
 	// work with the proper range checks
-	for num := start / level * level; num <= end; num += level {
+	for num := start / level * level; num <= end && !limitExceeded(logs, limit); num += level {
 		// find addresses in bloom filters
 		bloom := core.GetMipmapBloom(self.db, num, level)
 		for _, addr := range self.addresses {
@@ -108,10 +159,10 @@ func (self *Filter) mipFind(start, end uint64, depth int) (logs vm.Logs) {
 				// normalised values.
 				start := uint64(math.Max(float64(num), float64(start)))
 				end := uint64(math.Min(float64(num+level-1), float64(end)))
-				if depth+1 == len(core.MIPMapLevels) {
-					logs = append(logs, self.getLogs(start, end)...)
+				if depth+1 == len(self.levels) {
+					logs = append(logs, self.getLogs(start, end, remaining(logs, limit))...)
 				} else {
-					logs = append(logs, self.mipFind(start, end, depth+1)...)
+					logs = append(logs, self.mipFind(start, end, depth+1, remaining(logs, limit))...)
 				}
 				// break so we don't check the same range for each
 				// possible address. Checks on multiple addresses
@@ -124,8 +175,8 @@ func (self *Filter) mipFind(start, end uint64, depth int) (logs vm.Logs) {
 	return logs
 }
 
-func (self *Filter) getLogs(start, end uint64) (logs vm.Logs) {
-	for i := start; i <= end; i++ {
+func (self *Filter) getLogs(start, end uint64, limit int) (logs vm.Logs) {
+	for i := start; i <= end && !limitExceeded(logs, limit); i++ {
 		var block *types.Block
 		hash := core.GetCanonicalHash(self.db, i)
 		if hash != (common.Hash{}) {
@@ -153,6 +204,20 @@ func (self *Filter) getLogs(start, end uint64) (logs vm.Logs) {
 	return logs
 }
 
+// limitExceeded reports whether more than limit logs were found, a negative limit
+// meaning unlimited.
+func limitExceeded(logs vm.Logs, limit int) bool {
+	return limit >= 0 && len(logs) > limit
+}
+
+// remaining returns the limit left for further logs after the ones found.
+func remaining(logs vm.Logs, limit int) int {
+	if limit < 0 {
+		return limit
+	}
+	return limit - len(logs)
+}
+
 func includes(addresses []common.Address, a common.Address) bool {
 	for _, addr := range addresses {
 		if addr == a {
@@ -90,7 +90,7 @@ func BenchmarkMipmaps(b *testing.B) {
 		if err != nil {
 			b.Fatal(err)
 		}
-		core.WriteMipmapBloom(db, uint64(i+1), receipts)
+		core.WriteMipmapBloom(db, core.DefaultMIPMapLevels, uint64(i+1), receipts)
 	})
 	for i, block := range chain {
 		core.WriteBlock(db, block)
@@ -192,7 +192,7 @@ func TestFilters(t *testing.T) {
 		// i is used as block number for the writes but since the i
 		// starts at 0 and block 0 (genesis) is already present increment
 		// by one
-		core.WriteMipmapBloom(db, uint64(i+1), receipts)
+		core.WriteMipmapBloom(db, core.DefaultMIPMapLevels, uint64(i+1), receipts)
 	})
 	for i, block := range chain {
 		core.WriteBlock(db, block)
@@ -285,4 +285,45 @@ func TestFilters(t *testing.T) {
 	if len(logs) != 0 {
 		t.Error("expected 0 log, got", len(logs))
 	}
+
+	// Limit the number of results, with and without mipmap bloom search
+	for _, addresses := range [][]common.Address{{addr}, nil} {
+		filter = New(db)
+		filter.SetAddresses(addresses)
+		filter.SetTopics([][]common.Hash{{hash1, hash2, hash3, hash4}})
+		filter.SetBeginBlock(0)
+		filter.SetEndBlock(-1)
+
+		for _, limit := range []int{0, 4, 5} {
+			if logs, err := filter.FindLimited(limit); err != nil || len(logs) != 4 {
+				t.Errorf("addresses %v, limit %d: expected 4 logs, got %d (%v)", addresses, limit, len(logs), err)
+			}
+		}
+		for _, limit := range []int{1, 3} {
+			if _, err := filter.FindLimited(limit); err == nil {
+				t.Errorf("addresses %v, limit %d: expected error", addresses, limit)
+			} else if lerr, ok := err.(*LogLimitError); !ok || lerr.Limit != limit {
+				t.Errorf("addresses %v, limit %d: unexpected error %v", addresses, limit, err)
+			}
+		}
+	}
+}
+
+func TestMipmapDepth(t *testing.T) {
+	tests := []struct {
+		start, end uint64
+		depth      int
+	}{
+		{0, 5000000, 0},
+		{0, 999998, 1},
+		{10, 200009, 2},
+		{500, 1499, 4},
+		{500, 700, 4},
+		{700, 500, 4},
+	}
+	for _, tt := range tests {
+		if depth := mipmapDepth(core.DefaultMIPMapLevels, tt.start, tt.end); depth != tt.depth {
+			t.Errorf("%d-%d: want depth %d, got %d", tt.start, tt.end, tt.depth, depth)
+		}
+	}
 }
@@ -19,6 +19,7 @@ package eth
 import (
 	"math/big"
 	"math/rand"
+	"sort"
 	"sync"
 
 	"github.com/ethereumproject/go-ethereum/core"
@@ -30,6 +31,9 @@ import (
 const (
 	gpoProcessPastBlocks = 100
 
+	// gpoMaxHistoryBlocks caps the number of blocks examined by a gas price history query.
+	gpoMaxHistoryBlocks = 1024
+
 	gpoDefaultMinGasPrice = 10000000000000
 )
 
@@ -208,3 +212,52 @@ func (self *GasPriceOracle) SuggestPrice() *big.Int {
 	}
 	return price
 }
+
+// blockGasPrices holds the minimum, median and maximum gas prices of the transactions
+// included in a single block. Prices are nil for blocks without transactions.
+type blockGasPrices struct {
+	number  uint64
+	txCount int
+	min     *big.Int
+	median  *big.Int
+	max     *big.Int
+}
+
+// gasPriceHistory walks the canonical chain backward from the current block, collecting
+// the gas prices of included transactions for at most n blocks (capped at gpoMaxHistoryBlocks).
+// Entries are returned in ascending block number order.
+func gasPriceHistory(chain *core.BlockChain, n int) []*blockGasPrices {
+	if n > gpoMaxHistoryBlocks {
+		n = gpoMaxHistoryBlocks
+	}
+	cblock := chain.CurrentBlock()
+	if cblock == nil || n <= 0 {
+		return []*blockGasPrices{}
+	}
+	last := cblock.NumberU64()
+	if uint64(n) > last+1 {
+		n = int(last + 1)
+	}
+
+	history := make([]*blockGasPrices, n)
+	for i := 0; i < n; i++ {
+		number := last - uint64(i)
+		entry := &blockGasPrices{number: number}
+		if block := chain.GetBlockByNumber(number); block != nil {
+			txs := block.Transactions()
+			entry.txCount = len(txs)
+			if len(txs) > 0 {
+				prices := make([]*big.Int, len(txs))
+				for j, tx := range txs {
+					prices[j] = tx.GasPrice()
+				}
+				sort.Slice(prices, func(a, b int) bool { return prices[a].Cmp(prices[b]) < 0 })
+				entry.min = prices[0]
+				entry.median = prices[len(prices)/2]
+				entry.max = prices[len(prices)-1]
+			}
+		}
+		history[n-1-i] = entry
+	}
+	return history
+}
@@ -0,0 +1,46 @@
+package eth
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereumproject/go-ethereum/common"
+	"github.com/ethereumproject/go-ethereum/core"
+	"github.com/ethereumproject/go-ethereum/core/types"
+	"github.com/ethereumproject/go-ethereum/eth/downloader"
+)
+
+func TestGasPriceHistory(t *testing.T) {
+	// Block 1 includes transactions with gas prices 3, 1 and 2; block 2 is empty.
+	generator := func(i int, block *core.BlockGen) {
+		if i != 0 {
+			return
+		}
+		for _, price := range []int64{3, 1, 2} {
+			tx, _ := types.NewTransaction(block.TxNonce(testBank.Address), common.Address{}, big.NewInt(1), core.TxGas, big.NewInt(price), nil).SignECDSA(testBankKey)
+			block.AddTx(tx)
+		}
+	}
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 2, generator, nil)
+	defer pm.Stop()
+
+	history := gasPriceHistory(pm.blockchain, 10)
+	if len(history) != 3 {
+		t.Fatalf("history length: want: 3, got: %d", len(history))
+	}
+	for i, h := range history {
+		if h.number != uint64(i) {
+			t.Errorf("entry %d: want number: %d, got: %d", i, i, h.number)
+		}
+	}
+	if h := history[1]; h.txCount != 3 || h.min.Int64() != 1 || h.median.Int64() != 2 || h.max.Int64() != 3 {
+		t.Errorf("block 1: want count=3 min=1 median=2 max=3, got count=%d min=%v median=%v max=%v", h.txCount, h.min, h.median, h.max)
+	}
+	if h := history[2]; h.txCount != 0 || h.min != nil || h.median != nil || h.max != nil {
+		t.Errorf("block 2: want empty prices, got count=%d min=%v median=%v max=%v", h.txCount, h.min, h.median, h.max)
+	}
+
+	if history := gasPriceHistory(pm.blockchain, 1); len(history) != 1 || history[0].number != 2 {
+		t.Errorf("want only the latest block, got %v", history)
+	}
+}
@@ -68,6 +68,8 @@ type ProtocolManager struct {
 	fastSync   uint32 // Flag whether fast sync is enabled (gets disabled if we already have blocks)
 	acceptsTxs uint32 // Flag whether we're considered synchronised (enables transaction processing)
 
+	peerTdMargin atomic.Value // Margin below the local header chain TD under which peers are not synced from (*big.Int, nil = any)
+
 	txpool      txPool
 	blockchain  *core.BlockChain
 	chaindb     ethdb.Database
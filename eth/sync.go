@@ -17,6 +17,8 @@
 package eth
 
 import (
+	"fmt"
+	"math/big"
 	"math/rand"
 	"sync/atomic"
 	"time"
@@ -161,6 +163,68 @@ func (pm *ProtocolManager) syncer() {
 	}
 }
 
+// SetDefaultSyncMode sets the mode, either full or fast, used for subsequent
+// synchronisation cycles; any other mode is rejected.
+// Fast sync may be re-enabled after blocks were imported, e.g. to catch up after
+// falling far behind; the downloader falls back to full sync for any cycle which
+// extends the local chain with a pivot below the current full head. As on startup,
+// fast sync disables itself once a cycle completes.
+func (pm *ProtocolManager) SetDefaultSyncMode(mode downloader.SyncMode) error {
+	switch mode {
+	case downloader.FullSync:
+		atomic.StoreUint32(&pm.fastSync, 0)
+	case downloader.FastSync:
+		atomic.StoreUint32(&pm.fastSync, 1)
+	default:
+		return fmt.Errorf("unsupported sync mode: %v", mode)
+	}
+	glog.V(logger.Info).Infof("Sync mode for subsequent cycles set to %v", mode)
+	return nil
+}
+
+// DefaultSyncMode returns the mode used for the next synchronisation cycle.
+func (pm *ProtocolManager) DefaultSyncMode() downloader.SyncMode {
+	if atomic.LoadUint32(&pm.fastSync) == 1 {
+		return downloader.FastSync
+	}
+	return downloader.FullSync
+}
+
+// SetPeerTDMargin sets how far the total difficulty advertised by a peer may lie
+// below that of the local header chain for a sync cycle to still be started with
+// it. The header chain runs ahead of the block chain during and after fast sync,
+// so such a peer may well be ahead of the local blocks but would only drag the
+// chain back. A nil margin disables the check.
+func (pm *ProtocolManager) SetPeerTDMargin(margin *big.Int) {
+	if margin != nil {
+		margin = new(big.Int).Set(margin)
+	}
+	pm.peerTdMargin.Store(margin)
+}
+
+// PeerTDMargin returns the margin set by SetPeerTDMargin, nil if unset.
+func (pm *ProtocolManager) PeerTDMargin() *big.Int {
+	margin, _ := pm.peerTdMargin.Load().(*big.Int)
+	if margin == nil {
+		return nil
+	}
+	return new(big.Int).Set(margin)
+}
+
+// peerTDTooLow reports whether the total difficulty advertised by a peer is more
+// than the configured margin below that of the local header chain.
+func (pm *ProtocolManager) peerTDTooLow(td *big.Int) bool {
+	margin, _ := pm.peerTdMargin.Load().(*big.Int)
+	if margin == nil {
+		return false
+	}
+	local := pm.blockchain.GetTd(pm.blockchain.CurrentHeader().Hash())
+	if local == nil {
+		return false
+	}
+	return td.Cmp(new(big.Int).Sub(local, margin)) < 0
+}
+
 // synchronise tries to sync up our local block chain with a remote peer.
 func (pm *ProtocolManager) synchronise(peer *peer) {
 	// Short circuit if no peers are available
@@ -179,6 +243,11 @@ func (pm *ProtocolManager) synchronise(peer *peer) {
 	if pTd.Cmp(td) <= 0 {
 		return
 	}
+	// Skip peers too far behind our header chain to bring us anything but a pointless reorg attempt
+	if pm.peerTDTooLow(pTd) {
+		glog.V(logger.Debug).Infof("Not synchronising with %v: total difficulty %v more than %v below our header chain", peer, pTd, pm.PeerTDMargin())
+		return
+	}
 
 	// Otherwise try to sync with the downloader
 	mode := downloader.FullSync
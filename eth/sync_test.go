@@ -17,10 +17,14 @@
 package eth
 
 import (
+	"math/big"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/ethereumproject/go-ethereum/common"
+	"github.com/ethereumproject/go-ethereum/core"
+	"github.com/ethereumproject/go-ethereum/core/types"
 	"github.com/ethereumproject/go-ethereum/eth/downloader"
 	"github.com/ethereumproject/go-ethereum/logger/glog"
 	"github.com/ethereumproject/go-ethereum/p2p"
@@ -59,3 +63,105 @@ func TestFastSyncDisabling(t *testing.T) {
 		t.Fatalf("fast sync not disabled after successful synchronisation")
 	}
 }
+
+// Tests that the sync mode for subsequent cycles can be switched at runtime.
+func TestSetDefaultSyncMode(t *testing.T) {
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 1, nil, nil)
+	defer pm.Stop()
+
+	if mode := pm.DefaultSyncMode(); mode != downloader.FullSync {
+		t.Fatalf("initial sync mode mismatch: have %v, want %v", mode, downloader.FullSync)
+	}
+	if err := pm.SetDefaultSyncMode(downloader.FastSync); err != nil {
+		t.Fatal(err)
+	}
+	if mode := pm.DefaultSyncMode(); mode != downloader.FastSync {
+		t.Errorf("sync mode mismatch: have %v, want %v", mode, downloader.FastSync)
+	}
+	for _, mode := range []downloader.SyncMode{downloader.ForceFullSync, downloader.LightSync, downloader.SyncMode(42)} {
+		if err := pm.SetDefaultSyncMode(mode); err == nil {
+			t.Errorf("expected error for sync mode %d", mode)
+		}
+	}
+	if mode := pm.DefaultSyncMode(); mode != downloader.FastSync {
+		t.Errorf("sync mode changed by rejected modes: have %v, want %v", mode, downloader.FastSync)
+	}
+	if err := pm.SetDefaultSyncMode(downloader.FullSync); err != nil {
+		t.Fatal(err)
+	}
+	if mode := pm.DefaultSyncMode(); mode != downloader.FullSync {
+		t.Errorf("sync mode mismatch: have %v, want %v", mode, downloader.FullSync)
+	}
+}
+
+// Tests that no sync cycle is started with a peer whose total difficulty lies
+// too far below that of the local header chain, even if it is ahead of the local
+// block chain.
+func TestSyncPeerTDMargin(t *testing.T) {
+	pm, db := newTestProtocolManagerMust(t, downloader.FullSync, 10, nil, nil)
+	defer pm.Stop()
+
+	// Extend the header chain past the block chain
+	blocks, _ := core.GenerateChain(core.DefaultConfigMorden.ChainConfig, pm.blockchain.CurrentBlock(), db, 10, nil)
+	headers := make([]*types.Header, len(blocks))
+	for i, block := range blocks {
+		headers[i] = block.Header()
+	}
+	if res := pm.blockchain.InsertHeaderChain(headers, 1); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	td, head, genesis := pm.blockchain.Status()
+
+	// A peer just ahead of the local blocks is refused with a margin
+	p, _ := newTestPeer("peer", 63, pm, false)
+	defer p.close()
+	status := &statusData{
+		ProtocolVersion: uint32(p.version),
+		NetworkId:       uint32(NetworkId),
+		TD:              td,
+		CurrentBlock:    head,
+		GenesisBlock:    genesis,
+	}
+	if err := p2p.ExpectMsg(p.app, StatusMsg, status); err != nil {
+		t.Fatalf("status recv: %v", err)
+	}
+	status.TD, status.CurrentBlock = new(big.Int).Add(td, big.NewInt(1)), common.Hash{1}
+	if _, err := p2p.Send(p.app, StatusMsg, status); err != nil {
+		t.Fatalf("status send: %v", err)
+	}
+	for i := 0; pm.peers.Peer(p.id) == nil; i++ {
+		if i == 100 {
+			t.Fatal("peer not registered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	sync := func() <-chan struct{} {
+		done := make(chan struct{})
+		go func() {
+			pm.synchronise(p.peer)
+			close(done)
+		}()
+		return done
+	}
+
+	pm.SetPeerTDMargin(big.NewInt(1000))
+	select {
+	case <-sync():
+	case <-time.After(time.Second):
+		t.Fatal("sync cycle started with peer below the margin")
+	}
+
+	// Without a margin the peer is synced from, starting with a header request
+	pm.SetPeerTDMargin(nil)
+	done := sync()
+	msg, err := p.app.ReadMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.Discard()
+	if msg.Code != GetBlockHeadersMsg {
+		t.Errorf("message code mismatch: have %x, want %x", msg.Code, GetBlockHeadersMsg)
+	}
+	p.close()
+	<-done
+}
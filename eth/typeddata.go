@@ -0,0 +1,341 @@
+package eth
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ethereumproject/go-ethereum/common"
+	"github.com/ethereumproject/go-ethereum/common/hexutil"
+	"github.com/ethereumproject/go-ethereum/crypto"
+)
+
+// TypedData is structured data signed according to EIP-712, in the JSON format of
+// eth_signTypedData_v4.
+type TypedData struct {
+	Types       TypedDataTypes   `json:"types"`
+	PrimaryType string           `json:"primaryType"`
+	Domain      TypedDataMessage `json:"domain"`
+	Message     TypedDataMessage `json:"message"`
+}
+
+// TypedDataTypes holds the struct types of typed data by name. It must include the
+// EIP712Domain type, which describes the domain.
+type TypedDataTypes map[string][]TypedDataField
+
+// TypedDataField is a member of a struct type.
+type TypedDataField struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// TypedDataMessage holds the values of a struct by member name.
+type TypedDataMessage map[string]interface{}
+
+// UnmarshalJSON keeps numbers as json.Number, so that integer values above 2^53
+// are not rounded.
+func (m *TypedDataMessage) UnmarshalJSON(input []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(input))
+	dec.UseNumber()
+	var values map[string]interface{}
+	if err := dec.Decode(&values); err != nil {
+		return err
+	}
+	*m = values
+	return nil
+}
+
+const typedDataDomainType = "EIP712Domain"
+
+var (
+	errTypedDataNoDomain = errors.New("typed data: missing " + typedDataDomainType + " type")
+
+	typedDataTypeName = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+	typedDataArray    = regexp.MustCompile(`^(.+)\[([0-9]*)\]$`)
+	typedDataInt      = regexp.MustCompile(`^(u?)int([0-9]*)$`)
+	typedDataBytes    = regexp.MustCompile(`^bytes([0-9]+)$`)
+)
+
+// Hash returns the digest signed for the typed data,
+// keccak256("\x19\x01" ‖ domainSeparator ‖ hashStruct(message)).
+func (td *TypedData) Hash() (common.Hash, error) {
+	if err := td.validate(); err != nil {
+		return common.Hash{}, err
+	}
+	domain, err := td.hashStruct(typedDataDomainType, td.Domain)
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("typed data: domain: %v", err)
+	}
+	message, err := td.hashStruct(td.PrimaryType, td.Message)
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("typed data: message: %v", err)
+	}
+	return crypto.Keccak256Hash([]byte("\x19\x01"), domain, message), nil
+}
+
+// validate checks that the primary type and all member types are declared.
+func (td *TypedData) validate() error {
+	if _, ok := td.Types[typedDataDomainType]; !ok {
+		return errTypedDataNoDomain
+	}
+	if _, ok := td.Types[td.PrimaryType]; !ok {
+		return fmt.Errorf("typed data: primary type %q not declared", td.PrimaryType)
+	}
+	for name, fields := range td.Types {
+		if !typedDataTypeName.MatchString(name) {
+			return fmt.Errorf("typed data: invalid type name %q", name)
+		}
+		seen := make(map[string]bool, len(fields))
+		for _, field := range fields {
+			if field.Name == "" {
+				return fmt.Errorf("typed data: type %s: member without name", name)
+			}
+			if seen[field.Name] {
+				return fmt.Errorf("typed data: type %s: duplicate member %q", name, field.Name)
+			}
+			seen[field.Name] = true
+			if !td.validType(field.Type) {
+				return fmt.Errorf("typed data: type %s: member %s has invalid type %q", name, field.Name, field.Type)
+			}
+		}
+	}
+	return nil
+}
+
+func (td *TypedData) validType(typ string) bool {
+	if m := typedDataArray.FindStringSubmatch(typ); m != nil {
+		return td.validType(m[1])
+	}
+	if _, ok := td.Types[typ]; ok {
+		return true
+	}
+	switch typ {
+	case "address", "bool", "string", "bytes":
+		return true
+	}
+	if m := typedDataInt.FindStringSubmatch(typ); m != nil {
+		if m[2] == "" {
+			return true
+		}
+		bits, err := strconv.Atoi(m[2])
+		return err == nil && bits > 0 && bits <= 256 && bits%8 == 0
+	}
+	if m := typedDataBytes.FindStringSubmatch(typ); m != nil {
+		n, err := strconv.Atoi(m[1])
+		return err == nil && n > 0 && n <= 32
+	}
+	return false
+}
+
+// encodeType returns the encoding of the given struct type followed by those of the struct
+// types it references, in alphabetical order, e.g. "Mail(Person from,string contents)Person(...)".
+func (td *TypedData) encodeType(primaryType string) string {
+	deps := td.dependencies(primaryType, map[string]bool{})
+	sort.Strings(deps[1:])
+
+	var buf bytes.Buffer
+	for _, dep := range deps {
+		buf.WriteString(dep)
+		buf.WriteByte('(')
+		for i, field := range td.Types[dep] {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(field.Type)
+			buf.WriteByte(' ')
+			buf.WriteString(field.Name)
+		}
+		buf.WriteByte(')')
+	}
+	return buf.String()
+}
+
+// dependencies returns the given struct type followed by all struct types it references.
+func (td *TypedData) dependencies(typ string, found map[string]bool) []string {
+	typ = typedDataBaseType(typ)
+	if _, ok := td.Types[typ]; !ok || found[typ] {
+		return nil
+	}
+	found[typ] = true
+	deps := []string{typ}
+	for _, field := range td.Types[typ] {
+		deps = append(deps, td.dependencies(field.Type, found)...)
+	}
+	return deps
+}
+
+func typedDataBaseType(typ string) string {
+	for {
+		m := typedDataArray.FindStringSubmatch(typ)
+		if m == nil {
+			return typ
+		}
+		typ = m[1]
+	}
+}
+
+// hashStruct returns keccak256(typeHash ‖ encodeData(data)) for a value of the given struct type.
+func (td *TypedData) hashStruct(typ string, data map[string]interface{}) ([]byte, error) {
+	fields := td.Types[typ]
+	if len(data) > len(fields) {
+		for name := range data {
+			if !hasTypedDataField(fields, name) {
+				return nil, fmt.Errorf("%s: undeclared member %q", typ, name)
+			}
+		}
+	}
+	enc := [][]byte{crypto.Keccak256([]byte(td.encodeType(typ)))}
+	for _, field := range fields {
+		value, ok := data[field.Name]
+		if !ok {
+			return nil, fmt.Errorf("%s: missing member %q", typ, field.Name)
+		}
+		b, err := td.encodeValue(field.Type, value)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %v", typ, field.Name, err)
+		}
+		enc = append(enc, b)
+	}
+	return crypto.Keccak256(enc...), nil
+}
+
+func hasTypedDataField(fields []TypedDataField, name string) bool {
+	for _, field := range fields {
+		if field.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeValue returns the 32 byte encoding of a member value of the given type.
+func (td *TypedData) encodeValue(typ string, value interface{}) ([]byte, error) {
+	if m := typedDataArray.FindStringSubmatch(typ); m != nil {
+		items, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("want array, got %T", value)
+		}
+		if m[2] != "" {
+			if n, _ := strconv.Atoi(m[2]); n != len(items) {
+				return nil, fmt.Errorf("want %d array items, got %d", n, len(items))
+			}
+		}
+		var enc [][]byte
+		for i, item := range items {
+			b, err := td.encodeValue(m[1], item)
+			if err != nil {
+				return nil, fmt.Errorf("item %d: %v", i, err)
+			}
+			enc = append(enc, b)
+		}
+		return crypto.Keccak256(enc...), nil
+	}
+	if _, ok := td.Types[typ]; ok {
+		switch data := value.(type) {
+		case map[string]interface{}:
+			return td.hashStruct(typ, data)
+		case TypedDataMessage:
+			return td.hashStruct(typ, data)
+		default:
+			return nil, fmt.Errorf("want object, got %T", value)
+		}
+	}
+
+	switch typ {
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("want string, got %T", value)
+		}
+		return crypto.Keccak256([]byte(s)), nil
+	case "bytes":
+		b, err := typedDataBytesValue(value)
+		if err != nil {
+			return nil, err
+		}
+		return crypto.Keccak256(b), nil
+	case "bool":
+		b, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("want bool, got %T", value)
+		}
+		if b {
+			return common.LeftPadBytes([]byte{1}, 32), nil
+		}
+		return make([]byte, 32), nil
+	case "address":
+		s, ok := value.(string)
+		if !ok || !common.IsHexAddress(s) {
+			return nil, fmt.Errorf("invalid address %v", value)
+		}
+		return common.LeftPadBytes(common.HexToAddress(s).Bytes(), 32), nil
+	}
+	if m := typedDataBytes.FindStringSubmatch(typ); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		b, err := typedDataBytesValue(value)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) != n {
+			return nil, fmt.Errorf("want %d bytes, got %d", n, len(b))
+		}
+		return common.RightPadBytes(b, 32), nil
+	}
+	if m := typedDataInt.FindStringSubmatch(typ); m != nil {
+		bits := 256
+		if m[2] != "" {
+			bits, _ = strconv.Atoi(m[2])
+		}
+		return typedDataIntValue(value, m[1] == "", bits)
+	}
+	return nil, fmt.Errorf("unknown type %q", typ)
+}
+
+func typedDataBytesValue(value interface{}) ([]byte, error) {
+	s, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("want hex string, got %T", value)
+	}
+	return hexutil.Decode(s)
+}
+
+// typedDataIntValue returns the 32 byte two's complement encoding of an integer given
+// as a JSON number or as a decimal or 0x-prefixed hex string.
+func typedDataIntValue(value interface{}, signed bool, bits int) ([]byte, error) {
+	var s string
+	switch v := value.(type) {
+	case json.Number:
+		s = v.String()
+	case string:
+		s = v
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return nil, fmt.Errorf("want integer, got %T", value)
+	}
+	n, ok := new(big.Int), false
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		n, ok = n.SetString(s[2:], 16)
+	} else {
+		n, ok = n.SetString(s, 10)
+	}
+	if !ok {
+		return nil, fmt.Errorf("invalid integer %q", s)
+	}
+
+	min, max := new(big.Int), new(big.Int).Lsh(common.Big1, uint(bits))
+	if signed {
+		max.Rsh(max, 1)
+		min.Neg(max)
+	}
+	if n.Cmp(min) < 0 || n.Cmp(max) >= 0 {
+		return nil, fmt.Errorf("integer %v out of range for %d bits", n, bits)
+	}
+	return common.LeftPadBytes(common.U256(n).Bytes(), 32), nil
+}
@@ -0,0 +1,109 @@
+package eth
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/ethereumproject/go-ethereum/common"
+	"github.com/ethereumproject/go-ethereum/crypto"
+)
+
+// The example of the EIP-712 specification.
+const typedDataMail = `{
+	"types": {
+		"EIP712Domain": [
+			{"name": "name", "type": "string"},
+			{"name": "version", "type": "string"},
+			{"name": "chainId", "type": "uint256"},
+			{"name": "verifyingContract", "type": "address"}
+		],
+		"Person": [
+			{"name": "name", "type": "string"},
+			{"name": "wallet", "type": "address"}
+		],
+		"Mail": [
+			{"name": "from", "type": "Person"},
+			{"name": "to", "type": "Person"},
+			{"name": "contents", "type": "string"}
+		]
+	},
+	"primaryType": "Mail",
+	"domain": {
+		"name": "Ether Mail",
+		"version": "1",
+		"chainId": 1,
+		"verifyingContract": "0xCcCCccccCCCCcCCCCCCcCcCccCcCCCcCcccccccC"
+	},
+	"message": {
+		"from": {"name": "Cow", "wallet": "0xCD2a3d9F938E13CD947Ec05AbC7FE734Df8DD826"},
+		"to": {"name": "Bob", "wallet": "0xbBbBBBBbbBBBbbbBbbBbbbbBBbBbbbbBbBbbBBbB"},
+		"contents": "Hello, Bob!"
+	}
+}`
+
+func TestTypedDataHash(t *testing.T) {
+	var td TypedData
+	if err := json.Unmarshal([]byte(typedDataMail), &td); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "Mail(Person from,Person to,string contents)Person(string name,address wallet)", td.encodeType("Mail"); have != want {
+		t.Errorf("encodeType: want %q, got %q", want, have)
+	}
+	domain, err := td.hashStruct(typedDataDomainType, td.Domain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := common.HexToHash("0xf2cee375fa42b42143804025fc449deafd50cc031ca257e0b194a650a912090f"); common.BytesToHash(domain) != want {
+		t.Errorf("domain separator: want %x, got %x", want, domain)
+	}
+	hash, err := td.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := common.HexToHash("0xbe609aee343fb3c4b28e1df9e632fca64fcfaede20f02e86244efddf30957bd2"); hash != want {
+		t.Errorf("hash: want %x, got %x", want, hash)
+	}
+
+	// The example is signed by Cow, whose key is keccak256("cow").
+	key := crypto.ToECDSA(crypto.Keccak256([]byte("cow")))
+	sig, err := crypto.Sign(hash.Bytes(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := crypto.Ecrecover(hash.Bytes(), sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := common.HexToAddress("0xCD2a3d9F938E13CD947Ec05AbC7FE734Df8DD826"), crypto.PubkeyToAddress(*crypto.ToECDSAPub(pub)); have != want {
+		t.Errorf("signer: want %x, got %x", want, have)
+	}
+}
+
+func TestTypedDataInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(td *TypedData)
+		err    string
+	}{
+		{"no domain type", func(td *TypedData) { delete(td.Types, typedDataDomainType) }, "missing EIP712Domain type"},
+		{"undeclared primary type", func(td *TypedData) { td.PrimaryType = "Letter" }, `primary type "Letter" not declared`},
+		{"invalid member type", func(td *TypedData) { td.Types["Person"][1].Type = "uint7" }, `invalid type "uint7"`},
+		{"missing member", func(td *TypedData) { delete(td.Message, "contents") }, `missing member "contents"`},
+		{"undeclared member", func(td *TypedData) { td.Message["subject"] = "Hi" }, `undeclared member "subject"`},
+		{"invalid address", func(td *TypedData) { td.Domain["verifyingContract"] = "0x01" }, "invalid address"},
+		{"negative uint", func(td *TypedData) { td.Domain["chainId"] = json.Number("-1") }, "out of range"},
+		{"wrong value type", func(td *TypedData) { td.Message["contents"] = json.Number("1") }, "want string"},
+	}
+	for _, tt := range tests {
+		var td TypedData
+		if err := json.Unmarshal([]byte(typedDataMail), &td); err != nil {
+			t.Fatal(err)
+		}
+		tt.modify(&td)
+		_, err := td.Hash()
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: want error containing %q, got %v", tt.name, tt.err, err)
+		}
+	}
+}
@@ -98,6 +98,34 @@ func NewLDBDatabase(file string, cache int, handles int) (*LDBDatabase, error) {
 	}, nil
 }
 
+// NewLDBDatabaseReadOnly opens an existing LevelDB database read-only. Several processes
+// may open the same database read-only at once, but not while it is open for writing.
+// No recovery is attempted for corrupted databases.
+func NewLDBDatabaseReadOnly(file string, cache int, handles int) (*LDBDatabase, error) {
+	cache = int(float64(cache) * cacheRatio[filepath.Base(file)])
+	if cache < 16 {
+		cache = 16
+	}
+	handles = int(float64(handles) * handleRatio[filepath.Base(file)])
+	if handles < 16 {
+		handles = 16
+	}
+	db, err := leveldb.OpenFile(file, &opt.Options{
+		OpenFilesCacheCapacity: handles,
+		BlockCacheCapacity:     cache / 2 * opt.MiB,
+		Filter:                 filter.NewBloomFilter(10),
+		ErrorIfMissing:         true,
+		ReadOnly:               true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &LDBDatabase{
+		file: file,
+		db:   db,
+	}, nil
+}
+
 // Path returns the path to the database directory.
 func (db *LDBDatabase) Path() string {
 	return db.file
@@ -0,0 +1,112 @@
+package ethdb
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/ethereumproject/go-ethereum/common"
+)
+
+// OverlayDatabase is an in-memory write layer on top of another database.
+// Writes and deletes are kept in memory and never reach the underlying database,
+// while reads fall through to it for keys which were not written or deleted in the overlay.
+// It is useful for speculative operations which must not mutate persisted data.
+type OverlayDatabase struct {
+	parent  Database
+	db      map[string][]byte
+	deleted map[string]struct{}
+	lock    sync.RWMutex
+}
+
+// NewOverlayDatabase returns a new overlay on top of the given database.
+func NewOverlayDatabase(parent Database) *OverlayDatabase {
+	return &OverlayDatabase{
+		parent:  parent,
+		db:      make(map[string][]byte),
+		deleted: make(map[string]struct{}),
+	}
+}
+
+func (db *OverlayDatabase) Put(key []byte, value []byte) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	db.db[string(key)] = common.CopyBytes(value)
+	delete(db.deleted, string(key))
+	return nil
+}
+
+func (db *OverlayDatabase) Get(key []byte) ([]byte, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	if entry, ok := db.db[string(key)]; ok {
+		return entry, nil
+	}
+	if _, ok := db.deleted[string(key)]; ok {
+		return nil, errors.New("not found")
+	}
+	return db.parent.Get(key)
+}
+
+func (db *OverlayDatabase) Has(key []byte) (bool, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	if _, ok := db.db[string(key)]; ok {
+		return true, nil
+	}
+	if _, ok := db.deleted[string(key)]; ok {
+		return false, nil
+	}
+	return db.parent.Has(key)
+}
+
+func (db *OverlayDatabase) Delete(key []byte) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	delete(db.db, string(key))
+	db.deleted[string(key)] = struct{}{}
+	return nil
+}
+
+// Close discards the overlay. The underlying database is left open.
+func (db *OverlayDatabase) Close() {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	db.db = make(map[string][]byte)
+	db.deleted = make(map[string]struct{})
+}
+
+func (db *OverlayDatabase) NewBatch() Batch {
+	return &overlayBatch{db: db}
+}
+
+type overlayBatch struct {
+	db     *OverlayDatabase
+	writes []kv
+	size   int
+}
+
+func (b *overlayBatch) Put(key, value []byte) error {
+	b.writes = append(b.writes, kv{common.CopyBytes(key), common.CopyBytes(value)})
+	b.size += len(value)
+	return nil
+}
+
+func (b *overlayBatch) Write() error {
+	b.db.lock.Lock()
+	defer b.db.lock.Unlock()
+
+	for _, kv := range b.writes {
+		b.db.db[string(kv.k)] = kv.v
+		delete(b.db.deleted, string(kv.k))
+	}
+	return nil
+}
+
+func (b *overlayBatch) ValueSize() int {
+	return b.size
+}
@@ -0,0 +1,60 @@
+package ethdb
+
+import "errors"
+
+// ErrReadOnly is returned when writing to a read-only database.
+var ErrReadOnly = errors.New("database is read-only")
+
+// ReadOnlyDatabase wraps a database and rejects all writes and deletes with ErrReadOnly,
+// while reads are passed through to the wrapped database.
+type ReadOnlyDatabase struct {
+	db Database
+}
+
+// NewReadOnlyDatabase returns a read-only view of the given database.
+func NewReadOnlyDatabase(db Database) *ReadOnlyDatabase {
+	return &ReadOnlyDatabase{db: db}
+}
+
+func (db *ReadOnlyDatabase) Put(key []byte, value []byte) error {
+	return ErrReadOnly
+}
+
+func (db *ReadOnlyDatabase) Get(key []byte) ([]byte, error) {
+	return db.db.Get(key)
+}
+
+func (db *ReadOnlyDatabase) Has(key []byte) (bool, error) {
+	return db.db.Has(key)
+}
+
+func (db *ReadOnlyDatabase) Delete(key []byte) error {
+	return ErrReadOnly
+}
+
+// Close closes the wrapped database.
+func (db *ReadOnlyDatabase) Close() {
+	db.db.Close()
+}
+
+func (db *ReadOnlyDatabase) NewBatch() Batch {
+	return &readOnlyBatch{}
+}
+
+// readOnlyBatch accepts writes but fails to commit them.
+type readOnlyBatch struct {
+	size int
+}
+
+func (b *readOnlyBatch) Put(key, value []byte) error {
+	b.size += len(value)
+	return nil
+}
+
+func (b *readOnlyBatch) Write() error {
+	return ErrReadOnly
+}
+
+func (b *readOnlyBatch) ValueSize() int {
+	return b.size
+}
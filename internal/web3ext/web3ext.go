@@ -49,7 +49,8 @@ web3._extend({
 		new web3._extend.Method({
 			name: 'importChain',
 			call: 'admin_importChain',
-			params: 1
+			params: 1,
+			inputFormatter: [null, web3._extend.formatters.inputOptionalNumberFormatter]
 		}),
 		new web3._extend.Method({
 			name: 'sleepBlocks',
@@ -130,6 +131,26 @@ web3._extend({
 			name: 'httpGet',
 			call: 'admin_httpGet',
 			params: 2
+		}),
+		new web3._extend.Method({
+			name: 'downloaderPeerStats',
+			call: 'admin_downloaderPeerStats',
+			params: 0
+		}),
+		new web3._extend.Method({
+			name: 'peerTable',
+			call: 'admin_peerTable',
+			params: 0
+		}),
+		new web3._extend.Method({
+			name: 'setSyncMode',
+			call: 'admin_setSyncMode',
+			params: 1
+		}),
+		new web3._extend.Method({
+			name: 'pruneSideChains',
+			call: 'admin_pruneSideChains',
+			params: 1
 		})
 	],
 	properties:
@@ -177,6 +198,12 @@ web3._extend({
 			name: 'getATXIBuildStatus',
 			call: 'geth_getATXIBuildStatus',
 			params: 0,
+		}),
+		new web3._extend.Method({
+			name: 'getAddressTransactionsPage',
+			call: 'geth_getAddressTransactionsPage',
+			params: 6,
+			inputFormatter: [web3._extend.formatters.inputAddressFormatter, web3._extend.formatters.inputDefaultBlockNumberFormatter, web3._extend.formatters.inputDefaultBlockNumberFormatter, null, null, null]
 		})
 	],
 	properties: []
@@ -198,6 +225,16 @@ web3._extend({
 			call: 'debug_getBlockRlp',
 			params: 1
 		}),
+		new web3._extend.Method({
+			name: 'getHeaderRlp',
+			call: 'debug_getHeaderRlp',
+			params: 1
+		}),
+		new web3._extend.Method({
+			name: 'badBlocks',
+			call: 'debug_badBlocks',
+			params: 0
+		}),
 		new web3._extend.Method({
 			name: 'setHead',
 			call: 'debug_setHead',
@@ -240,6 +277,26 @@ web3._extend({
 			name: 'accountExist',
 			call: 'debug_accountExist',
 			params: 2
+		}),
+		new web3._extend.Method({
+			name: 'preimage',
+			call: 'debug_preimage',
+			params: 1
+		}),
+		new web3._extend.Method({
+			name: 'storageRangeAt',
+			call: 'debug_storageRangeAt',
+			params: 5
+		}),
+		new web3._extend.Method({
+			name: 'totalGasUsedInRange',
+			call: 'debug_totalGasUsedInRange',
+			params: 2
+		}),
+		new web3._extend.Method({
+			name: 'traceBlockByNumber',
+			call: 'debug_traceBlockByNumber',
+			params: 1
 		})
 	],
 	properties: []
@@ -285,6 +342,56 @@ web3._extend({
 			name: 'chainId',
 			call: 'eth_chainId',
 			params: 0
+		}),
+		new web3._extend.Method({
+			name: 'getRawTransaction',
+			call: 'eth_getRawTransactionByHash',
+			params: 1
+		}),
+		new web3._extend.Method({
+			name: 'getRawTransactionFromBlock',
+			call: 'eth_getRawTransactionByBlockNumberAndIndex',
+			params: 2,
+			inputFormatter: [web3._extend.formatters.inputBlockNumberFormatter, web3._extend.utils.toHex]
+		}),
+		new web3._extend.Method({
+			name: 'gasPriceHistory',
+			call: 'eth_gasPriceHistory',
+			params: 1
+		}),
+		new web3._extend.Method({
+			name: 'getBlockReceipts',
+			call: 'eth_getBlockReceipts',
+			params: 1,
+			inputFormatter: [web3._extend.formatters.inputBlockNumberFormatter]
+		}),
+		new web3._extend.Method({
+			name: 'getBalances',
+			call: 'eth_getBalances',
+			params: 2,
+			inputFormatter: [null, web3._extend.formatters.inputDefaultBlockNumberFormatter]
+		}),
+		new web3._extend.Method({
+			name: 'getProof',
+			call: 'eth_getProof',
+			params: 3,
+			inputFormatter: [web3._extend.formatters.inputAddressFormatter, null, web3._extend.formatters.inputDefaultBlockNumberFormatter]
+		}),
+		new web3._extend.Method({
+			name: 'getStorageRoot',
+			call: 'eth_getStorageRoot',
+			params: 2,
+			inputFormatter: [web3._extend.formatters.inputAddressFormatter, web3._extend.formatters.inputDefaultBlockNumberFormatter]
+		}),
+		new web3._extend.Method({
+			name: 'getTransactionStatus',
+			call: 'eth_getTransactionStatus',
+			params: 1
+		}),
+		new web3._extend.Method({
+			name: 'getUnclesByBlockHash',
+			call: 'eth_getUnclesByBlockHash',
+			params: 1
 		})
 	],
 	properties:
@@ -361,7 +468,14 @@ web3._extend({
 const Net_JS = `
 web3._extend({
 	property: 'net',
-	methods: [],
+	methods:
+	[
+		new web3._extend.Method({
+			name: 'health',
+			call: 'net_health',
+			params: 0
+		})
+	],
 	properties:
 	[
 		new web3._extend.Property({
@@ -399,6 +513,18 @@ web3._extend({
 			call: 'personal_ecRecover',
 			params: 2,
 			inputFormatter: [null, null]
+		}),
+		new web3._extend.Method({
+			name: 'signTypedData',
+			call: 'personal_signTypedData',
+			params: 3,
+			inputFormatter: [web3._extend.formatters.inputAddressFormatter, null, null]
+		}),
+		new web3._extend.Method({
+			name: 'exportAccount',
+			call: 'personal_exportAccount',
+			params: 3,
+			inputFormatter: [web3._extend.formatters.inputAddressFormatter, null, null]
 		})
 	]
 });
@@ -436,7 +562,20 @@ web3._extend({
 const TxPool_JS = `
 web3._extend({
 	property: 'txpool',
-	methods: [],
+	methods:
+	[
+		new web3._extend.Method({
+			name: 'contentFrom',
+			call: 'txpool_contentFrom',
+			params: 1
+		}),
+		new web3._extend.Method({
+			name: 'nonceGaps',
+			call: 'txpool_nonceGaps',
+			params: 1,
+			inputFormatter: [web3._extend.formatters.inputAddressFormatter]
+		})
+	],
 	properties:
 	[
 		new web3._extend.Property({
@@ -113,6 +113,12 @@ var (
 	FetchBroadcastDOS   = metrics.NewRegisteredMeter("fetch/broadcast/dos", reg)
 )
 
+var (
+	ChainReorgs       = metrics.NewRegisteredMeter("chain/reorg", reg)
+	ChainReorgDepth   = metrics.NewRegisteredHistogram("chain/reorg/depth", reg, metrics.NewExpDecaySample(1028, 0.015))
+	ChainFutureBlocks = metrics.GetOrRegisterGauge("chain/future", reg)
+)
+
 var (
 	P2PIn       = metrics.NewRegisteredMeter("p2p/in", reg)
 	P2PInBytes  = metrics.NewRegisteredMeter("p2p/in/bytes", reg)
@@ -129,6 +135,13 @@ var (
 	NumGoRoutines = metrics.GetOrRegisterGauge("runtime/goroutines", reg)
 )
 
+// RPCCall records a call of the RPC method with the given name, eg. "eth_getBalance", which
+// took d to execute. Calls are counted in "rpc/<method>/count" and timed in "rpc/<method>/time".
+func RPCCall(method string, d time.Duration) {
+	metrics.GetOrRegisterCounter("rpc/"+method+"/count", reg).Inc(1)
+	metrics.GetOrRegisterTimer("rpc/"+method+"/time", reg).Update(d)
+}
+
 // diskStats is the per process disk I/O statistics.
 type diskStats struct {
 	ReadCount  int64 // Number of read operations executed
@@ -267,7 +267,10 @@ func (self *worker) wait() {
 				}
 				go self.mux.Post(core.NewMinedBlockEvent{Block: block})
 			} else {
-				work.state.CommitTo(self.chainDb, false)
+				if _, err := self.chain.CommitState(block.NumberU64(), work.state); err != nil {
+					glog.V(logger.Error).Infoln("error committing state of mined block", err)
+					continue
+				}
 				parent := self.chain.GetBlock(block.ParentHash())
 				if parent == nil {
 					glog.V(logger.Error).Infoln("Invalid block found during mining")
@@ -299,15 +302,28 @@ func (self *worker) wait() {
 				// check if canon block and write transactions
 				if stat == core.CanonStatTy {
 					// This puts transactions in a extra db for rpc
-					core.WriteTransactions(self.chainDb, block)
+					if err := core.WriteTransactions(self.chainDb, block); err != nil {
+						glog.V(logger.Error).Infoln("error writing mined block transactions:", err)
+						continue
+					}
 					// store the receipts
-					core.WriteReceipts(self.chainDb, work.receipts)
+					if err := core.WriteReceipts(self.chainDb, work.receipts); err != nil {
+						glog.V(logger.Error).Infoln("error writing mined block receipts:", err)
+						continue
+					}
 					// Write map map bloom filters
-					core.WriteMipmapBloom(self.chainDb, block.NumberU64(), work.receipts)
+					if err := core.WriteMipmapBloom(self.chainDb, self.chain.MipmapLevels(), block.NumberU64(), work.receipts); err != nil {
+						glog.V(logger.Error).Infoln("error writing mined block mipmap bloom:", err)
+						continue
+					}
+				}
+				if err := core.WriteBlockReceipts(self.chainDb, block.Hash(), work.receipts); err != nil {
+					glog.V(logger.Error).Infoln("error writing mined block receipts:", err)
+					continue
 				}
 
 				// broadcast before waiting for validation
-				go func(block *types.Block, logs vm.Logs, receipts []*types.Receipt) {
+				go func(block *types.Block, logs vm.Logs) {
 					self.mux.Post(core.NewMinedBlockEvent{Block: block})
 					self.mux.Post(core.ChainEvent{Block: block, Hash: block.Hash(), Logs: logs})
 
@@ -315,10 +331,7 @@ func (self *worker) wait() {
 						self.mux.Post(core.ChainHeadEvent{Block: block})
 						self.mux.Post(logs)
 					}
-					if err := core.WriteBlockReceipts(self.chainDb, block.Hash(), receipts); err != nil {
-						glog.V(logger.Warn).Infoln("error writing block receipts:", err)
-					}
-				}(block, work.state.Logs(), work.receipts)
+				}(block, work.state.Logs())
 			}
 
 			// check staleness and display confirmation
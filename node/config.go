@@ -25,6 +25,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/ethereumproject/go-ethereum/common"
 	"github.com/ethereumproject/go-ethereum/crypto"
@@ -148,6 +149,10 @@ type Config struct {
 	// If the module list is empty, all RPC API endpoints designated public will be
 	// exposed.
 	WSModules []string
+
+	// RPCSlowQueryThreshold is the duration above which RPC method calls are logged
+	// as slow, on every RPC endpoint. Zero disables the slow call log.
+	RPCSlowQueryThreshold time.Duration
 }
 
 // IPCEndpoint resolves an IPC endpoint based on a configured value, taking into
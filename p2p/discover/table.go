@@ -29,6 +29,7 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ethereumproject/go-ethereum/common"
@@ -77,6 +78,10 @@ func init() {
 }
 
 type Table struct {
+	// received packet counters, accessed atomically; kept first in the struct
+	// so that they are 64-bit aligned on 32-bit platforms
+	pings, pongs, findnodes, neighbors uint64
+
 	mutex   sync.Mutex        // protects buckets, their content, and nursery
 	buckets [nBuckets]*bucket // index of known nodes by distance
 	nursery []*Node           // bootstrap nodes
@@ -98,6 +103,18 @@ type Table struct {
 	self *Node // metadata of the local node
 }
 
+// Stats is a snapshot of discovery activity.
+type Stats struct {
+	TableSize int // number of nodes in the table
+
+	// Number of well-formed packets received, by type, counted before they
+	// are handled; packets later rejected (e.g. expired) are included
+	Pings     uint64
+	Pongs     uint64
+	Findnodes uint64
+	Neighbors uint64
+}
+
 type bondproc struct {
 	err  error
 	n    *Node
@@ -158,6 +175,21 @@ func (tab *Table) Self() *Node {
 	return tab.self
 }
 
+// Stats returns the current table size and counts of received packets.
+func (tab *Table) Stats() Stats {
+	tab.mutex.Lock()
+	size := tab.len()
+	tab.mutex.Unlock()
+
+	return Stats{
+		TableSize: size,
+		Pings:     atomic.LoadUint64(&tab.pings),
+		Pongs:     atomic.LoadUint64(&tab.pongs),
+		Findnodes: atomic.LoadUint64(&tab.findnodes),
+		Neighbors: atomic.LoadUint64(&tab.neighbors),
+	}
+}
+
 // ReadRandomNodes fills the given slice with random nodes from the
 // table. It will not write the same node more than once. The nodes in
 // the slice are copies and can be modified by the caller.
@@ -23,6 +23,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"sync/atomic"
 	"time"
 
 	"github.com/ethereumproject/go-ethereum/crypto"
@@ -608,6 +609,16 @@ func (t *udp) handlePacket(from *net.UDPAddr, buf []byte) error {
 		glog.V(logger.Debug).Infof("Bad packet from %v: %v\n", from, err)
 		return err
 	}
+	switch packet.(type) {
+	case *ping:
+		atomic.AddUint64(&t.pings, 1)
+	case *pong:
+		atomic.AddUint64(&t.pongs, 1)
+	case *findnode:
+		atomic.AddUint64(&t.findnodes, 1)
+	case *neighbors:
+		atomic.AddUint64(&t.neighbors, 1)
+	}
 	status := "ok"
 	if err = packet.handle(t, from, fromID, hash); err != nil {
 		status = err.Error()
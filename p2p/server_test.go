@@ -122,6 +122,17 @@ func TestServerListen(t *testing.T) {
 	}
 }
 
+func TestServerRunning(t *testing.T) {
+	srv := startTestServer(t, randomID(), nil)
+	if !srv.Running() {
+		t.Error("server not running after start")
+	}
+	srv.Stop()
+	if srv.Running() {
+		t.Error("server running after stop")
+	}
+}
+
 func TestServerDial(t *testing.T) {
 	// run a one-shot TCP server to handle the connection.
 	listener, err := net.Listen("tcp", "127.0.0.1:0")
@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"reflect"
 	"runtime"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -29,6 +30,7 @@ import (
 
 	"github.com/ethereumproject/go-ethereum/logger"
 	"github.com/ethereumproject/go-ethereum/logger/glog"
+	"github.com/ethereumproject/go-ethereum/metrics"
 )
 
 const (
@@ -84,6 +86,29 @@ func (s *RPCService) Modules() map[string]string {
 	return modules
 }
 
+// SetSlowQueryThreshold sets the duration above which method calls are logged at
+// warning level, with their elapsed time and parameter types. Parameter values are
+// not logged, as they may hold passphrases. Zero disables the log.
+func (s *Server) SetSlowQueryThreshold(threshold time.Duration) {
+	atomic.StoreInt64(&s.slowQuery, int64(threshold))
+}
+
+// logSlowCall logs a method call which took at least the slow query threshold.
+// It is a variable so that tests can intercept it.
+var logSlowCall = func(method string, args []reflect.Value, elapsed time.Duration) {
+	glog.V(logger.Warn).Warnf("Slow RPC call %s(%s) took %v", method, summarizeArgTypes(args), elapsed)
+}
+
+// summarizeArgTypes formats the number and types of the parameters of a method call
+// for logging, leaving out their values.
+func summarizeArgTypes(args []reflect.Value) string {
+	types := make([]string, len(args))
+	for i, arg := range args {
+		types[i] = arg.Type().String()
+	}
+	return fmt.Sprintf("%d params: %s", len(args), strings.Join(types, ", "))
+}
+
 // RegisterName will create an service for the given rcvr type under the given name. When no methods on the given rcvr
 // match the criteria to be either a RPC method or a subscription an error is returned. Otherwise a new service is
 // created and added to the service collection this server instance serves.
@@ -325,7 +350,14 @@ func (s *Server) handle(ctx context.Context, codec ServerCodec, req *serverReque
 	}
 
 	// execute RPC method and return result
+	start := time.Now()
 	reply := req.callb.method.Func.Call(arguments)
+	elapsed := time.Since(start)
+	method := req.svcname + serviceMethodSeparator + formatName(req.callb.method.Name)
+	metrics.RPCCall(method, elapsed)
+	if threshold := time.Duration(atomic.LoadInt64(&s.slowQuery)); threshold > 0 && elapsed >= threshold {
+		logSlowCall(method, req.args, elapsed)
+	}
 	if len(reply) == 0 {
 		return codec.CreateResponse(req.id, nil), nil
 	}
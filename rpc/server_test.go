@@ -22,8 +22,10 @@ import (
 	"net"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/ethereumproject/go-ethereum/logger/glog"
+	"github.com/ethereumproject/go-ethereum/metrics"
 )
 
 type Service struct{}
@@ -159,3 +161,73 @@ func TestServerMethodExecution(t *testing.T) {
 func TestServerMethodWithCtx(t *testing.T) {
 	testServerMethodExecution(t, "echoWithCtx")
 }
+
+func TestServerMethodMetrics(t *testing.T) {
+	testServerMethodExecution(t, "echo")
+
+	b, err := metrics.CollectToJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var registry map[string]map[string]interface{}
+	if err := json.Unmarshal(b, &registry); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"rpc/test_echo/count", "rpc/test_echo/time"} {
+		if count, _ := registry[name]["count"].(float64); count < 1 {
+			t.Errorf("%s: want count >= 1, got %v", name, registry[name])
+		}
+	}
+}
+
+type SlowService struct{}
+
+func (s *SlowService) Wait(ms int, args *Args) {
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+}
+
+func TestServerSlowCallLog(t *testing.T) {
+	var logged []string
+	defer func(f func(string, []reflect.Value, time.Duration)) { logSlowCall = f }(logSlowCall)
+	logSlowCall = func(method string, args []reflect.Value, elapsed time.Duration) {
+		logged = append(logged, method+"("+summarizeArgTypes(args)+")")
+	}
+
+	server := NewServer()
+	if err := server.RegisterName("test", new(SlowService)); err != nil {
+		t.Fatal(err)
+	}
+	server.SetSlowQueryThreshold(10 * time.Millisecond)
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	go server.ServeCodec(NewJSONCodec(serverConn), OptionMethodInvocation)
+	out := json.NewEncoder(clientConn)
+	in := json.NewDecoder(clientConn)
+
+	for i, test := range []struct {
+		ms   int
+		want []string
+	}{
+		{0, nil},
+		{20, []string{"test_wait(2 params: int, *rpc.Args)"}},
+	} {
+		logged = nil
+		request := map[string]interface{}{
+			"id":      i,
+			"method":  "test_wait",
+			"version": "2.0",
+			"params":  []interface{}{test.ms, &Args{"secret"}},
+		}
+		if err := out.Encode(request); err != nil {
+			t.Fatal(err)
+		}
+		var response JSONResponse
+		if err := in.Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(logged, test.want) {
+			t.Errorf("%d ms: logged %q, want %q", test.ms, logged, test.want)
+		}
+	}
+}
@@ -17,6 +17,7 @@
 package rpc
 
 import (
+	"encoding/json"
 	"fmt"
 	"math"
 	"math/big"
@@ -24,6 +25,7 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/ethereumproject/go-ethereum/common"
 	"gopkg.in/fatih/set.v0"
 )
 
@@ -76,6 +78,8 @@ type Server struct {
 	run      int32
 	codecsMu sync.Mutex
 	codecs   *set.Set
+
+	slowQuery int64 // Duration above which method calls are logged as slow (unit: nanoseconds to allow atomic ops, 0 = disabled)
 }
 
 // rpcRequest represents a raw incoming RPC request
@@ -271,6 +275,83 @@ func (bn *BlockNumber) Int64() int64 {
 	return (int64)(*bn)
 }
 
+// BlockNumberOrHash identifies a block either by number, including the special
+// "latest", "earliest" and "pending" tags, or by hash. Exactly one of BlockNumber
+// and BlockHash is set. RequireCanonical only applies to hashes and requests the
+// block to be part of the canonical chain (EIP-1898).
+type BlockNumberOrHash struct {
+	BlockNumber      *BlockNumber `json:"blockNumber,omitempty"`
+	BlockHash        *common.Hash `json:"blockHash,omitempty"`
+	RequireCanonical bool         `json:"requireCanonical,omitempty"`
+}
+
+// BlockNumberOrHashWithNumber returns a BlockNumberOrHash identifying the block with the given number.
+func BlockNumberOrHashWithNumber(blockNr BlockNumber) BlockNumberOrHash {
+	return BlockNumberOrHash{BlockNumber: &blockNr}
+}
+
+// BlockNumberOrHashWithHash returns a BlockNumberOrHash identifying the block with the given hash.
+func BlockNumberOrHashWithHash(hash common.Hash, canonical bool) BlockNumberOrHash {
+	return BlockNumberOrHash{BlockHash: &hash, RequireCanonical: canonical}
+}
+
+// UnmarshalJSON parses the given JSON fragment into a BlockNumberOrHash. It supports:
+// - an EIP-1898 object {"blockNumber": ...} or {"blockHash": ..., "requireCanonical": ...}
+// - a 32 byte hex string, interpreted as a block hash
+// - anything accepted by BlockNumber.UnmarshalJSON
+func (bnh *BlockNumberOrHash) UnmarshalJSON(data []byte) error {
+	input := strings.TrimSpace(string(data))
+	if len(input) > 0 && input[0] == '{' {
+		var obj struct {
+			BlockNumber      *BlockNumber `json:"blockNumber"`
+			BlockHash        *common.Hash `json:"blockHash"`
+			RequireCanonical bool         `json:"requireCanonical"`
+		}
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return err
+		}
+		switch {
+		case obj.BlockNumber != nil && obj.BlockHash != nil:
+			return fmt.Errorf("cannot specify both blockNumber and blockHash")
+		case obj.BlockNumber != nil:
+			if obj.RequireCanonical {
+				return fmt.Errorf("requireCanonical is only allowed with blockHash")
+			}
+			*bnh = BlockNumberOrHashWithNumber(*obj.BlockNumber)
+		case obj.BlockHash != nil:
+			*bnh = BlockNumberOrHashWithHash(*obj.BlockHash, obj.RequireCanonical)
+		default:
+			return fmt.Errorf("either blockNumber or blockHash must be specified")
+		}
+		return nil
+	}
+
+	if len(input) >= 2 && input[0] == '"' && input[len(input)-1] == '"' {
+		input = input[1 : len(input)-1]
+	}
+	if len(input) == 2+2*common.HashLength && strings.HasPrefix(input, "0x") {
+		*bnh = BlockNumberOrHashWithHash(common.HexToHash(input), false)
+		return nil
+	}
+	var bn BlockNumber
+	if err := bn.UnmarshalJSON(data); err != nil {
+		return err
+	}
+	*bnh = BlockNumberOrHashWithNumber(bn)
+	return nil
+}
+
+// String implements fmt.Stringer.
+func (bnh BlockNumberOrHash) String() string {
+	if bnh.BlockHash != nil {
+		return bnh.BlockHash.Hex()
+	}
+	if bnh.BlockNumber != nil {
+		return fmt.Sprintf("%d", *bnh.BlockNumber)
+	}
+	return "nil"
+}
+
 // Client defines the interface for go client that wants to connect to a geth RPC endpoint
 type Client interface {
 	// SupportedModules returns the collection of API's the server offers
@@ -71,3 +71,49 @@ func TestHexNumberMarshalJSON(t *testing.T) {
 		t.Fatalf("Invalid json.Marshal, expected '%s', got '%s'", exp, got)
 	}
 }
+
+func TestBlockNumberOrHashUnmarshalJSON(t *testing.T) {
+	hash := "0x1b3a4c3e2c7a0b4f1e9b4a9c6b1e8f1d3e5a7c9b2d4f6a8c0e2b4d6f8a0c2e4f"
+	var bnh BlockNumberOrHash
+	if err := json.Unmarshal([]byte(`"`+hash+`"`), &bnh); err != nil {
+		t.Fatal(err)
+	}
+	if bnh.BlockNumber != nil || bnh.BlockHash == nil || bnh.BlockHash.Hex() != hash {
+		t.Fatalf("expected hash %s, got %+v", hash, bnh)
+	}
+
+	tests := map[string]BlockNumber{`"0x4d2"`: 1234, "1234": 1234, `"latest"`: LatestBlockNumber, `"pending"`: PendingBlockNumber}
+	for in, want := range tests {
+		var bnh BlockNumberOrHash
+		if err := json.Unmarshal([]byte(in), &bnh); err != nil {
+			t.Fatalf("%s: %v", in, err)
+		}
+		if bnh.BlockHash != nil || bnh.BlockNumber == nil || *bnh.BlockNumber != want {
+			t.Fatalf("%s: expected block number %d, got %+v", in, want, bnh)
+		}
+	}
+
+	if err := json.Unmarshal([]byte(`"0xzz"`), &bnh); err == nil {
+		t.Fatal("expected error for invalid block number or hash")
+	}
+
+	bnh = BlockNumberOrHash{}
+	if err := json.Unmarshal([]byte(`{"blockHash": "`+hash+`", "requireCanonical": true}`), &bnh); err != nil {
+		t.Fatal(err)
+	}
+	if bnh.BlockHash == nil || bnh.BlockHash.Hex() != hash || !bnh.RequireCanonical {
+		t.Fatalf("expected canonical hash %s, got %+v", hash, bnh)
+	}
+	bnh = BlockNumberOrHash{}
+	if err := json.Unmarshal([]byte(`{"blockNumber": "0x4d2"}`), &bnh); err != nil {
+		t.Fatal(err)
+	}
+	if bnh.BlockNumber == nil || *bnh.BlockNumber != 1234 {
+		t.Fatalf("expected block number 1234, got %+v", bnh)
+	}
+	for _, in := range []string{`{}`, `{"blockNumber": "0x1", "blockHash": "` + hash + `"}`, `{"blockNumber": "0x1", "requireCanonical": true}`} {
+		if err := json.Unmarshal([]byte(in), &bnh); err == nil {
+			t.Errorf("%s: expected error", in)
+		}
+	}
+}
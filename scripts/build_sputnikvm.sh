@@ -60,9 +60,9 @@ esac
 
 
 if [ "$OUTPUT" == "install" ]; then
-	CGO_LDFLAGS=$LDFLAGS go install -ldflags '-X main.Version='$(git describe --tags) -tags="sputnikvm netgo" ./cmd/geth
+	CGO_LDFLAGS=$LDFLAGS go install -ldflags '-X main.Version='$(git describe --tags)' -X main.GitCommit='$(git rev-parse HEAD) -tags="sputnikvm netgo" ./cmd/geth
 elif [ "$OUTPUT" == "build" ]; then
 	mkdir -p "$geth_bindir"
-	CGO_LDFLAGS=$LDFLAGS go build -ldflags '-X main.Version='$(git describe --tags) -o $geth_bindir/geth -tags="sputnikvm netgo" ./cmd/geth
+	CGO_LDFLAGS=$LDFLAGS go build -ldflags '-X main.Version='$(git describe --tags)' -X main.GitCommit='$(git rev-parse HEAD) -o $geth_bindir/geth -tags="sputnikvm netgo" ./cmd/geth
 fi
 